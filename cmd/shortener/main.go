@@ -1,19 +1,58 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/ChristinaFomenko/shortener/configs"
+	"github.com/ChristinaFomenko/shortener/internal/app/blocklist"
 	"github.com/ChristinaFomenko/shortener/internal/app/generator"
 	"github.com/ChristinaFomenko/shortener/internal/app/hasher"
+	"github.com/ChristinaFomenko/shortener/internal/app/invalidation"
+	"github.com/ChristinaFomenko/shortener/internal/app/normalizer"
 	repositoryURL "github.com/ChristinaFomenko/shortener/internal/app/repository/urls"
+	_ "github.com/ChristinaFomenko/shortener/internal/app/repository/urls/bolt"
+	"github.com/ChristinaFomenko/shortener/internal/app/repository/urls/breaker"
+	"github.com/ChristinaFomenko/shortener/internal/app/repository/urls/database"
+	_ "github.com/ChristinaFomenko/shortener/internal/app/repository/urls/file"
+	"github.com/ChristinaFomenko/shortener/internal/app/repository/urls/invalidating"
+	_ "github.com/ChristinaFomenko/shortener/internal/app/repository/urls/memory"
+	"github.com/ChristinaFomenko/shortener/internal/app/repository/urls/metered"
+	"github.com/ChristinaFomenko/shortener/internal/app/repository/urls/sharded"
+	_ "github.com/ChristinaFomenko/shortener/internal/app/repository/urls/sqlite"
+	"github.com/ChristinaFomenko/shortener/internal/app/repository/urls/traced"
+	repositoryWebhooks "github.com/ChristinaFomenko/shortener/internal/app/repository/webhooks"
 	authService "github.com/ChristinaFomenko/shortener/internal/app/service/auth"
+	healthService "github.com/ChristinaFomenko/shortener/internal/app/service/health"
+	"github.com/ChristinaFomenko/shortener/internal/app/service/moderation"
 	pingService "github.com/ChristinaFomenko/shortener/internal/app/service/ping"
 	serviceURL "github.com/ChristinaFomenko/shortener/internal/app/service/urls"
+	webhooksService "github.com/ChristinaFomenko/shortener/internal/app/service/webhooks"
 	"github.com/ChristinaFomenko/shortener/internal/handlers"
 	"github.com/ChristinaFomenko/shortener/internal/middlewares"
+	"github.com/ChristinaFomenko/shortener/pkg/metrics"
+	"github.com/ChristinaFomenko/shortener/pkg/tracing"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	log "github.com/sirupsen/logrus"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+const (
+	serviceName = "shortener"
+
+	readinessTimeout = 2 * time.Second
+	shutdownTimeout  = 10 * time.Second
+	minFreeDiskSpace = 100 << 20
+
+	webhookWorkers    = 4
+	webhookBufferSize = 1024
+	webhookTimeout    = 10 * time.Second
 )
 
 func main() {
@@ -23,22 +62,149 @@ func main() {
 		log.Fatalf("failed to retrieve env variables, %v", err)
 	}
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Metrics
+	registry := metrics.NewRegistry()
+
+	// Tracing
+	exporter, err := newTraceExporter(cfg.TraceExporter, cfg.OTLPEndpoint)
+	if err != nil {
+		log.Fatalf("failed to create a trace exporter %v", err)
+	}
+	var (
+		provider  *tracing.Provider
+		processor *tracing.BatchProcessor
+	)
+	if exporter != nil {
+		processor = tracing.NewBatchProcessor(exporter)
+		provider = tracing.NewProvider(processor)
+		tracing.SetProvider(provider)
+	}
+
 	// Repositories
-	repository, err := repositoryURL.NewStorage(cfg.FileStoragePath, cfg.DatabaseDSN)
+	backend, err := repositoryURL.Backend(cfg.StorageURL)
+	if err != nil {
+		log.Fatalf("failed to select a storage %v", err)
+	}
+
+	// a database shared by the instances, the storage or one of its shards
+	databaseURL, shared := sharded.Database(cfg.StorageURL)
+	databaseOptions := database.Options{
+		MaxConns:         cfg.Database.MaxConns,
+		MaxConnLifetime:  cfg.Database.MaxConnLifetime,
+		MaxConnIdleTime:  cfg.Database.MaxConnIdleTime,
+		QueryTimeout:     cfg.Database.QueryTimeout,
+		MigrationTimeout: cfg.Database.MigrationTimeout,
+		RetryAttempts:    cfg.Database.RetryAttempts,
+		Replicas:         cfg.Database.Replicas,
+		ReadYourWrites:   cfg.Database.ReadYourWrites,
+	}
+
+	// Invalidation
+	bus, err := newInvalidationBus(databaseURL, shared, databaseOptions)
+	if err != nil {
+		log.Fatalf("failed to create an invalidation bus %v", err)
+	}
+	bus.Subscribe(func(event invalidation.Event) {
+		log.WithField("type", event.Type).WithField("urlID", event.URLID).Debug("link invalidated")
+	})
+	go bus.Run(ctx)
+
+	// a database may go away while we keep running, redirects are served from memory meanwhile.
+	// Every database shard has a breaker of its own, so one failing shard doesn't turn the others away.
+	circuits := make([]func() bool, 0)
+	guard := func(repo repositoryURL.Repo) repositoryURL.Repo {
+		circuit := breaker.NewRepo(repo, cfg.BreakerFailures, cfg.BreakerCooldown, cfg.SnapshotSize)
+		bus.Subscribe(circuit.Invalidate)
+		circuits = append(circuits, circuit.Open)
+		return circuit
+	}
+
+	var storage repositoryURL.Repo
+	switch backend {
+	case repositoryURL.BackendSharded:
+		// every database shard names its replicas in its url
+		partOptions := databaseOptions
+		partOptions.Replicas = nil
+
+		storage, err = sharded.NewStorage(cfg.StorageURL, func(storageURL string) (repositoryURL.Repo, error) {
+			if partBackend, _ := repositoryURL.Backend(storageURL); partBackend != repositoryURL.BackendDatabase {
+				return repositoryURL.NewStorage(storageURL)
+			}
+
+			part, err := database.NewRepo(storageURL, partOptions)
+			if err != nil {
+				return nil, err
+			}
+			return guard(part), nil
+		})
+	case repositoryURL.BackendDatabase:
+		storage, err = database.NewRepo(cfg.StorageURL, databaseOptions)
+	default:
+		storage, err = repositoryURL.NewStorage(cfg.StorageURL)
+	}
 	if err != nil {
 		log.Fatalf("failed to create a storage %v", err)
 	}
-	//defer func(repository repositoryURL.Repo) {
-	//	_ = repository.Close()
-	//}(repository)
 
-	// Services
+	var guarded repositoryURL.Repo = traced.NewRepo(metered.NewRepo(storage, backend, registry), backend)
+	if backend == repositoryURL.BackendDatabase {
+		guarded = guard(guarded)
+	}
+	repository := invalidating.NewRepo(guarded, bus)
+
+	// Blocklist
+	blocked, err := blocklist.NewBlocklist(cfg.BlocklistPath, cfg.BlocklistReload, cfg.BaseURL)
+	if err != nil {
+		log.Fatalf("failed to load the blocklist %v", err)
+	}
+	go blocked.Watch(ctx)
+
+	// Webhooks
 	helper := generator.NewGenerator()
+	webhookRepo, err := newWebhookRepo(databaseURL, shared, databaseOptions, cfg.WebhookPath)
+	if err != nil {
+		log.Fatalf("failed to create a webhook storage %v", err)
+	}
+	dispatcher := webhooksService.NewDispatcher(webhookRepo, webhooksService.NewClient(webhookTimeout),
+		webhookWorkers, cfg.WebhookAttempts, cfg.WebhookBackoff)
+	hooks := webhooksService.NewService(webhookRepo, repository, helper, dispatcher, webhookBufferSize)
+	// webhooks keep running while the servers drain, clicks answered then are still queued
+	webhooksCtx, stopWebhooks := context.WithCancel(context.Background())
+	webhooksDone := make(chan struct{})
+	go dispatcher.Run(webhooksCtx)
+	go func() {
+		hooks.Run(webhooksCtx)
+		close(webhooksDone)
+	}()
+
+	// Services
 	hash := hasher.NewHasher(cfg.SecretKey)
-	service := serviceURL.NewService(repository, helper, cfg.BaseURL)
-	authSrvc := authService.NewService(helper, hash)
+	service := serviceURL.NewMeteredService(
+		serviceURL.NewService(repository, helper, normalizer.NewNormalizer(cfg.TrackingParams), blocked, cfg.BaseURL, cfg.DefaultRedirect, hooks),
+		registry)
+	authSrvc := authService.NewMeteredService(authService.NewService(helper, hash), registry)
 	pingSrvc := pingService.NewService(repository)
 
+	healthSrvc := healthService.NewService(readinessTimeout)
+	switch backend {
+	case repositoryURL.BackendDatabase:
+		healthSrvc.Register("database", healthService.DatabaseCheck(repository, circuits...))
+	case repositoryURL.BackendSharded:
+		healthSrvc.Register("shards", healthService.DatabaseCheck(repository, circuits...))
+	case repositoryURL.BackendSQLite:
+		healthSrvc.Register("database", healthService.DatabaseCheck(repository))
+		fallthrough
+	case repositoryURL.BackendFile, repositoryURL.BackendBolt:
+		healthSrvc.Register("file_storage", healthService.FileStorageCheck(storageFile(cfg.StorageURL), minFreeDiskSpace))
+	}
+	healthSrvc.Register("webhook_events_queue", healthService.QueueCheck(hooks.Pending, hooks.Capacity))
+	if processor != nil {
+		healthSrvc.Register("trace_export_queue", healthService.QueueCheck(processor.QueueLen, processor.QueueCap))
+	}
+
 	// Route
 	router := chi.NewRouter()
 
@@ -47,26 +213,177 @@ func main() {
 		log.Fatalf("compressor failed %v", err)
 	}
 
+	decompress := middlewares.NewDecompressor(cfg.MaxBodySize, cfg.MaxBodyRatio)
 	auth := middlewares.NewAuthenticator(authSrvc)
 
+	router.Use(middlewares.Tracing)
 	router.Use(middleware.RequestID)
-	router.Use(middleware.Logger)
+	router.Use(middlewares.NewHTTPMetrics(registry).Measure)
+	router.Use(middlewares.TraceMiddleware("Logger", middleware.Logger))
 	router.Use(middleware.Recoverer)
-	router.Use(middleware.URLFormat)
-	router.Use(middlewares.Decompressing)
-	router.Use(compress.Compressing)
-	router.Use(auth.Auth)
-
-	//router.Route("/", func(r chi.Router) {
-	router.Post("/", handlers.New(service, auth, pingSrvc).Shorten)
-	router.Get("/{id}", handlers.New(service, auth, pingSrvc).Expand)
-	router.Post("/api/shorten", handlers.New(service, auth, pingSrvc).APIJSONShorten)
-	router.Get("/api/user/urls", handlers.New(service, auth, pingSrvc).FetchURLs)
-	router.Get("/ping", handlers.New(service, auth, pingSrvc).Ping)
-	router.Post("/api/shorten/batch", handlers.New(service, auth, pingSrvc).ShortenBatch)
-	//})
-
-	address := cfg.ServerAddress
-	log.WithField("address", address).Info("server starts")
-	log.Fatal(http.ListenAndServe(address, router))
+
+	// probes get no user and no cookies
+	healthHandler := handlers.NewHealth(healthSrvc)
+	router.Get("/healthz", healthHandler.Liveness)
+	router.Get("/readyz", healthHandler.Readiness)
+
+	api := router.With(
+		middleware.URLFormat,
+		middlewares.TraceMiddleware("Decompressing", decompress.Decompressing),
+		middlewares.TraceMiddleware("Compressing", compress.Compressing),
+		middlewares.TraceMiddleware("Auth", auth.Auth),
+		middlewares.TraceMiddleware("LastWrite", middlewares.LastWrite),
+	)
+
+	h := handlers.New(service, auth, pingSrvc)
+	api.Post("/", middlewares.TraceHandler("Shorten", h.Shorten))
+	api.Get("/{id}", middlewares.TraceHandler("Expand", h.Expand))
+	api.Get("/{id}/*", middlewares.TraceHandler("Expand", h.Expand))
+	api.Post("/api/shorten", middlewares.TraceHandler("APIJSONShorten", h.APIJSONShorten))
+	api.Get("/api/user/urls", middlewares.TraceHandler("FetchURLs", h.FetchURLs))
+	api.Get("/api/user/urls/{id}/rules", middlewares.TraceHandler("Rules", h.Rules))
+	api.Put("/api/user/urls/{id}/rules", middlewares.TraceHandler("SetRules", h.SetRules))
+	api.Get("/ping", middlewares.TraceHandler("Ping", h.Ping))
+	api.Post("/api/shorten/batch", middlewares.TraceHandler("ShortenBatch", h.ShortenBatch))
+	api.Get("/api/user/urls/export", middlewares.TraceHandler("ExportURLs", h.ExportURLs))
+	api.With(middlewares.BodyLimit(cfg.MaxImportSize)).
+		Post("/api/user/urls/import", middlewares.TraceHandler("ImportURLs", h.ImportURLs))
+
+	webhooks := handlers.NewWebhooks(hooks, auth)
+	api.Post("/api/user/webhooks", middlewares.TraceHandler("RegisterWebhook", webhooks.Register))
+	api.Get("/api/user/webhooks", middlewares.TraceHandler("Webhooks", webhooks.Webhooks))
+	api.Delete("/api/user/webhooks/{id}", middlewares.TraceHandler("DeleteWebhook", webhooks.Delete))
+	api.Get("/api/user/webhooks/dead", middlewares.TraceHandler("DeadLetters", webhooks.DeadLetters))
+	api.Post("/api/user/webhooks/dead/{id}/retry", middlewares.TraceHandler("RetryDelivery", webhooks.Retry))
+
+	if cfg.AdminToken != "" {
+		admin := handlers.NewAdmin(moderation.NewService(repository, blocked))
+		api.With(middlewares.AdminOnly(cfg.AdminToken)).
+			Post("/api/admin/blocklist/apply", middlewares.TraceHandler("DisableBlocked", admin.DisableBlocked))
+	}
+
+	servers := []*http.Server{{Addr: cfg.ServerAddress, Handler: router}}
+
+	if cfg.MetricsAddress == "" {
+		router.Handle("/metrics", registry.Handler())
+	} else {
+		adminRouter := chi.NewRouter()
+		adminRouter.Handle("/metrics", registry.Handler())
+		adminRouter.Get("/healthz", healthHandler.Liveness)
+		adminRouter.Get("/readyz", healthHandler.Readiness)
+
+		servers = append(servers, &http.Server{Addr: cfg.MetricsAddress, Handler: adminRouter})
+	}
+
+	for _, server := range servers {
+		go func(server *http.Server) {
+			log.WithField("address", server.Addr).Info("server starts")
+			if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatal(err)
+			}
+		}(server)
+	}
+
+	<-ctx.Done()
+	stop()
+
+	// keep serving while load balancers see /readyz failing and stop routing to us
+	log.WithField("delay", cfg.ShutdownDelay).Info("shutting down, draining traffic")
+	healthSrvc.SetShuttingDown()
+	time.Sleep(cfg.ShutdownDelay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	for _, server := range servers {
+		if err = server.Shutdown(shutdownCtx); err != nil {
+			log.WithError(err).WithField("address", server.Addr).Error("server shutdown error")
+		}
+	}
+
+	stopWebhooks()
+	<-webhooksDone
+
+	if err = provider.Shutdown(shutdownCtx); err != nil {
+		log.WithError(err).Error("tracing shutdown error")
+	}
+
+	if err = bus.Close(); err != nil {
+		log.WithError(err).Error("close invalidation bus error")
+	}
+
+	if err = webhookRepo.Close(); err != nil {
+		log.WithError(err).Error("close webhook repository error")
+	}
+
+	if err = repository.Close(); err != nil {
+		log.WithError(err).Error("close repository error")
+	}
+
+	log.Info("server stopped")
+}
+
+// newTraceExporter picks the span exporter, no exporter disables tracing
+func newTraceExporter(name, otlpEndpoint string) (tracing.Exporter, error) {
+	switch name {
+	case "":
+		return nil, nil
+	case "stdout":
+		return tracing.NewStdoutExporter(os.Stdout), nil
+	case "otlp":
+		return tracing.NewOTLPExporter(otlpEndpoint, serviceName, nil), nil
+	}
+
+	return nil, fmt.Errorf("unknown trace exporter %q", name)
+}
+
+// newInvalidationBus shares link changes through the database when the instances have one in common,
+// otherwise this instance is the only one to tell
+func newInvalidationBus(databaseURL string, shared bool, opts database.Options) (invalidation.Bus, error) {
+	if !shared {
+		return invalidation.NewMemoryBus(), nil
+	}
+
+	bus, err := database.NewBus(databaseURL, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return bus, nil
+}
+
+// newWebhookRepo keeps webhooks in the database when the instances have one in common, so any of them
+// sends what the others queued, otherwise in the file of this instance
+func newWebhookRepo(databaseURL string, shared bool, opts database.Options, filePath string) (repositoryWebhooks.Repo, error) {
+	if !shared {
+		if filePath == "" {
+			log.Info("webhooks are kept in memory, set WEBHOOK_STORAGE_PATH to keep queued deliveries over restarts")
+		}
+
+		repo, err := repositoryWebhooks.NewRepo(filePath)
+		if err != nil {
+			return nil, err
+		}
+
+		return repo, nil
+	}
+
+	repo, err := database.NewWebhookRepo(databaseURL, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return repo, nil
+}
+
+// storageFile returns the file of a file based storage, the url is already validated by then
+func storageFile(storageURL string) string {
+	u, err := url.Parse(storageURL)
+	if err != nil {
+		return ""
+	}
+
+	path, _ := repositoryURL.FilePath(u)
+
+	return path
 }
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/ChristinaFomenko/shortener/internal/app/models"
+	"github.com/ChristinaFomenko/shortener/internal/app/normalizer"
+	repositoryURL "github.com/ChristinaFomenko/shortener/internal/app/repository/urls"
+	maintenanceService "github.com/ChristinaFomenko/shortener/internal/app/service/maintenance"
+	migrationService "github.com/ChristinaFomenko/shortener/internal/app/service/migration"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var errUsage = errors.New("wrong usage")
+
+type service interface {
+	Lookup(ctx context.Context, urlID string) (models.Link, error)
+	LookupByURL(ctx context.Context, url string) (models.Link, error)
+	UserLinks(ctx context.Context, userID string) ([]models.Link, error)
+	Delete(ctx context.Context, urlID string) error
+	Restore(ctx context.Context, urlID string) error
+	Stats(ctx context.Context) (maintenanceService.Stats, error)
+	Verify(ctx context.Context) ([]maintenanceService.Problem, error)
+	Normalize(ctx context.Context, normalize func(url string) (string, error)) (maintenanceService.Normalization, error)
+}
+
+type commands struct {
+	service    service
+	repository repositoryURL.Repo
+	out        io.Writer
+}
+
+func (c *commands) run(ctx context.Context, name string, args []string) error {
+	switch name {
+	case "get":
+		return c.lookup(ctx, args, c.service.Lookup)
+	case "find":
+		return c.lookup(ctx, args, c.service.LookupByURL)
+	case "list":
+		return c.list(ctx, args)
+	case "delete":
+		return c.change(ctx, args, "deleted", c.service.Delete)
+	case "restore":
+		return c.change(ctx, args, "restored", c.service.Restore)
+	case "stats":
+		return c.stats(ctx)
+	case "verify":
+		return c.verify(ctx)
+	case "normalize":
+		return c.normalize(ctx, args)
+	case "migrate":
+		return c.migrate(ctx, args)
+	}
+
+	return errUsage
+}
+
+func (c *commands) lookup(ctx context.Context, keys []string, fn func(ctx context.Context, key string) (models.Link, error)) error {
+	if len(keys) == 0 {
+		return errUsage
+	}
+
+	links := make([]models.Link, 0, len(keys))
+	for _, key := range keys {
+		link, err := fn(ctx, key)
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		links = append(links, link)
+	}
+
+	return c.printLinks(links)
+}
+
+func (c *commands) list(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	links, err := c.service.UserLinks(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	return c.printLinks(links)
+}
+
+func (c *commands) change(ctx context.Context, urlIDs []string, done string, fn func(ctx context.Context, urlID string) error) error {
+	if len(urlIDs) == 0 {
+		return errUsage
+	}
+
+	for _, urlID := range urlIDs {
+		if err := fn(ctx, urlID); err != nil {
+			return fmt.Errorf("%s: %w", urlID, err)
+		}
+		_, _ = fmt.Fprintln(c.out, urlID, done)
+	}
+
+	return nil
+}
+
+func (c *commands) stats(ctx context.Context) error {
+	stats, err := c.service.Stats(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintf(w, "links\t%d\n", stats.Links)
+	_, _ = fmt.Fprintf(w, "active\t%d\n", stats.Active)
+	_, _ = fmt.Fprintf(w, "deleted\t%d\n", stats.Deleted)
+	_, _ = fmt.Fprintf(w, "users\t%d\n", stats.Users)
+
+	return w.Flush()
+}
+
+func (c *commands) verify(ctx context.Context) error {
+	problems, err := c.service.Verify(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(problems) == 0 {
+		_, _ = fmt.Fprintln(c.out, "ok")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "ID\tPROBLEM")
+	for _, problem := range problems {
+		_, _ = fmt.Fprintf(w, "%s\t%s\n", problem.URLID, problem.Description)
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+
+	return fmt.Errorf("%d %w", len(problems), errProblemsFound)
+}
+
+// normalize stores the canonical urls of links shortened before urls were normalized, the tracking
+// parameters must be the ones of the server
+func (c *commands) normalize(ctx context.Context, args []string) error {
+	params, ok := os.LookupEnv("TRACKING_PARAMS")
+	if !ok {
+		params = strings.Join(normalizer.DefaultTrackingParams, ",")
+	}
+
+	flags := flag.NewFlagSet("normalize", flag.ContinueOnError)
+	flags.SetOutput(c.out)
+	trackingParams := flags.String("tracking-params", params, "query parameters stripped from urls")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+		return errUsage
+	}
+
+	var stripped []string
+	for _, param := range strings.Split(*trackingParams, ",") {
+		if param = strings.TrimSpace(param); param != "" {
+			stripped = append(stripped, param)
+		}
+	}
+
+	result, err := c.service.Normalize(ctx, normalizer.NewNormalizer(stripped).Normalize)
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(c.out, "normalized %d\n", result.Normalized)
+	if len(result.Problems) == 0 {
+		return nil
+	}
+
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "ID\tLEFT AS IS")
+	for _, problem := range result.Problems {
+		_, _ = fmt.Fprintf(w, "%s\t%s\n", problem.URLID, problem.Description)
+	}
+
+	return w.Flush()
+}
+
+// migrate copies the storage selected by the global flags into the one given to the command
+func (c *commands) migrate(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	flags.SetOutput(c.out)
+	storageURL := flags.String("to", "", "destination storage url")
+	checkpointPath := flags.String("checkpoint", "migration.checkpoint", "file keeping the progress, empty to disable")
+	checkpointEvery := flags.Int("every", 100, "save the progress every n links")
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+
+	if *storageURL == "" {
+		return errors.New("migrate: destination not specified, use -to")
+	}
+
+	destination, err := repositoryURL.NewStorage(*storageURL)
+	if err != nil {
+		return fmt.Errorf("failed to open the destination storage: %w", err)
+	}
+
+	defer func(destination repositoryURL.Repo) {
+		_ = destination.Close()
+	}(destination)
+
+	checkpoint := migrationService.NewFileCheckpoint(*checkpointPath)
+	report, err := migrationService.NewService(c.repository, destination, checkpoint, *checkpointEvery).Migrate(ctx)
+
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	if report.ResumedAfter != "" {
+		_, _ = fmt.Fprintf(w, "resumed after\t%s\n", report.ResumedAfter)
+	}
+	_, _ = fmt.Fprintf(w, "migrated\t%d\n", report.Migrated)
+	_, _ = fmt.Fprintf(w, "source\t%d links, %d deleted\n", report.Source.Links, report.Source.Deleted)
+	_, _ = fmt.Fprintf(w, "destination\t%d links, %d deleted\n", report.Destination.Links, report.Destination.Deleted)
+	_ = w.Flush()
+
+	return err
+}
+
+func (c *commands) printLinks(links []models.Link) error {
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "ID\tUSER\tCREATED\tDELETED\tURL")
+
+	for _, link := range links {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			link.ID,
+			link.UserID,
+			formatTime(link.CreatedAt),
+			formatTime(link.DeletedAt),
+			link.OriginalURL)
+	}
+
+	return w.Flush()
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	repositoryURL "github.com/ChristinaFomenko/shortener/internal/app/repository/urls"
+	_ "github.com/ChristinaFomenko/shortener/internal/app/repository/urls/bolt"
+	"github.com/ChristinaFomenko/shortener/internal/app/repository/urls/database"
+	_ "github.com/ChristinaFomenko/shortener/internal/app/repository/urls/file"
+	"github.com/ChristinaFomenko/shortener/internal/app/repository/urls/invalidating"
+	_ "github.com/ChristinaFomenko/shortener/internal/app/repository/urls/memory"
+	"github.com/ChristinaFomenko/shortener/internal/app/repository/urls/sharded"
+	_ "github.com/ChristinaFomenko/shortener/internal/app/repository/urls/sqlite"
+	maintenanceService "github.com/ChristinaFomenko/shortener/internal/app/service/maintenance"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+const usage = `usage: shortenerctl [-s storage-url] <command> [arguments]
+
+Works directly against the storage of the shortener given by the same url the
+server takes in STORAGE_URL: file:///path, bolt:///path, sqlite:///path or
+postgres://...
+
+Stop a server using the file storage before changing it: the server keeps the
+links in memory and overwrites the file on its next write. A bolt file is
+locked while the server runs, commands fail to open it until it stops. Servers
+sharing a postgres storage are told about the links changed here.
+
+commands:
+  get <id>...        show links by short id, deleted links included
+  find <url>...      show links by original url
+  list <user-id>     list all links of a user
+  delete <id>...     mark links as deleted
+  restore <id>...    restore deleted links
+  stats              show link counters
+  verify             check storage integrity, exits with 1 on problems
+  normalize [-tracking-params list]
+                     store the canonical urls of links shortened before urls
+                     were normalized, TRACKING_PARAMS is taken like the server
+                     does; a url already shortened in its canonical form stays
+                     with the link holding it, the other link keeps redirecting
+  migrate -to storage-url [-checkpoint file] [-every n]
+                     copy every link with its owner and id into another storage;
+                     an interrupted migration resumes from the checkpoint file,
+                     remove it to start over
+
+flags:
+`
+
+var errProblemsFound = errors.New("integrity problems found")
+
+func main() {
+	flags := flag.NewFlagSet("shortenerctl", flag.ExitOnError)
+	flags.Usage = func() {
+		_, _ = fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+
+	storageURL := flags.String("s", os.Getenv("STORAGE_URL"), "storage url")
+	_ = flags.Parse(os.Args[1:])
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	repository, err := repositoryURL.NewStorage(*storageURL)
+	if err != nil {
+		fail(fmt.Errorf("failed to open a storage: %w", err))
+	}
+
+	// servers sharing the database hear about links deleted or restored here
+	if databaseURL, shared := sharded.Database(*storageURL); shared {
+		bus, err := database.NewBus(databaseURL, database.DefaultOptions())
+		if err != nil {
+			fail(fmt.Errorf("failed to create an invalidation bus: %w", err))
+		}
+		defer func() {
+			_ = bus.Close()
+		}()
+
+		repository = invalidating.NewRepo(repository, bus)
+	}
+
+	cmd := &commands{
+		service:    maintenanceService.NewService(repository),
+		repository: repository,
+		out:        os.Stdout,
+	}
+
+	err = cmd.run(ctx, flags.Arg(0), flags.Args()[1:])
+	_ = repository.Close()
+
+	if errors.Is(err, errUsage) {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fail(err)
+	}
+}
+
+func fail(err error) {
+	_, _ = fmt.Fprintln(os.Stderr, "shortenerctl:", err)
+	os.Exit(1)
+}
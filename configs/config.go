@@ -3,23 +3,87 @@ package configs
 import (
 	"errors"
 	"flag"
+	"fmt"
+	"github.com/ChristinaFomenko/shortener/internal/app/models"
+	"github.com/ChristinaFomenko/shortener/internal/app/normalizer"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// defaultStorageURL keeps the links when no storage is set
+const defaultStorageURL = "memory://"
+
 type appConfig struct {
-	ServerAddress   string `env:"SERVER_ADDRESS" envDefault:":8080"`
-	BaseURL         string `env:"BASE_URL" envDefault:"http://localhost:8080/"`
-	FileStoragePath string `env:"FILE_STORAGE_PATH" envDefault:"storage.dat"`
-	DatabaseDSN     string `env:"DATABASE_DSN"`
+	ServerAddress   string        `env:"SERVER_ADDRESS" envDefault:":8080"`
+	BaseURL         string        `env:"BASE_URL" envDefault:"http://localhost:8080/"`
+	StorageURL      string        `env:"STORAGE_URL"`
+	MetricsAddress  string        `env:"METRICS_ADDRESS"`
+	TraceExporter   string        `env:"TRACE_EXPORTER"`
+	OTLPEndpoint    string        `env:"OTLP_ENDPOINT" envDefault:"http://localhost:4318"`
+	ShutdownDelay   time.Duration `env:"SHUTDOWN_DELAY" envDefault:"5s"`
+	MaxBodySize     int64         `env:"MAX_BODY_SIZE" envDefault:"10485760"`
+	MaxBodyRatio    int64         `env:"MAX_BODY_RATIO" envDefault:"100"`
+	MaxImportSize   int64         `env:"MAX_IMPORT_SIZE" envDefault:"268435456"`
+	TrackingParams  []string      `env:"TRACKING_PARAMS" envDefault:"utm_*,gclid,fbclid,yclid,_openstat"`
+	BlocklistPath   string        `env:"BLOCKLIST_PATH"`
+	BlocklistReload time.Duration `env:"BLOCKLIST_RELOAD" envDefault:"30s"`
+	AdminToken      string        `env:"ADMIN_TOKEN"`
+	DefaultRedirect int           `env:"DEFAULT_REDIRECT" envDefault:"307"`
+	WebhookPath     string        `env:"WEBHOOK_STORAGE_PATH"`
+	WebhookAttempts int           `env:"WEBHOOK_MAX_ATTEMPTS" envDefault:"8"`
+	WebhookBackoff  time.Duration `env:"WEBHOOK_BACKOFF" envDefault:"30s"`
+	BreakerFailures int           `env:"BREAKER_FAILURES" envDefault:"5"`
+	BreakerCooldown time.Duration `env:"BREAKER_COOLDOWN" envDefault:"10s"`
+	SnapshotSize    int           `env:"SNAPSHOT_SIZE" envDefault:"10000"`
+	Database        DatabaseConfig
 	SecretKey       []byte
 }
 
+// DatabaseConfig sizes the pools of a postgres storage, its replicas, invalidation bus and webhook queue
+type DatabaseConfig struct {
+	MaxConns         int32         `env:"DATABASE_MAX_CONNS" envDefault:"20"`
+	MaxConnLifetime  time.Duration `env:"DATABASE_MAX_CONN_LIFETIME" envDefault:"2m"`
+	MaxConnIdleTime  time.Duration `env:"DATABASE_MAX_CONN_IDLE_TIME" envDefault:"30s"`
+	QueryTimeout     time.Duration `env:"DATABASE_QUERY_TIMEOUT" envDefault:"3s"`
+	MigrationTimeout time.Duration `env:"DATABASE_MIGRATION_TIMEOUT" envDefault:"10m"`
+	RetryAttempts    int           `env:"DATABASE_RETRY_ATTEMPTS" envDefault:"3"`
+	Replicas         []string      `env:"DATABASE_REPLICAS"`
+	ReadYourWrites   time.Duration `env:"DATABASE_READ_YOUR_WRITES" envDefault:"0s"`
+}
+
 func NewConfig() (*appConfig, error) {
 	serverAddress := getServerAddress()
 	baseURL := getBaseURL()
+	storageURL := getStorageURL()
 	fileStoragePath := getFileStoragePath()
 	secretKey := getSecretKey()
 	databaseDSN := getDatabaseDSN()
+	metricsAddress := getMetricsAddress()
+	traceExporter := getTraceExporter()
+	otlpEndpoint := getOTLPEndpoint()
+	shutdownDelay := getShutdownDelay()
+	maxBodySize := getMaxBodySize()
+	maxBodyRatio := getMaxBodyRatio()
+	maxImportSize := getMaxImportSize()
+	trackingParams := getTrackingParams()
+	blocklistPath := getBlocklistPath()
+	blocklistReload := getBlocklistReload()
+	adminToken := getAdminToken()
+	defaultRedirect := getDefaultRedirect()
+	webhookPath := getWebhookPath()
+	webhookAttempts := getWebhookAttempts()
+	webhookBackoff := getWebhookBackoff()
+	breakerFailures := getBreakerFailures()
+	breakerCooldown := getBreakerCooldown()
+	snapshotSize := getSnapshotSize()
+	database := getDatabaseConfig()
 	flag.Parse()
 
 	if serverAddress == nil {
@@ -30,12 +94,89 @@ func NewConfig() (*appConfig, error) {
 		return nil, errors.New("base url not specified")
 	}
 
-	if fileStoragePath == nil {
-		return nil, errors.New("file storage path not specified")
+	if storageURL == nil || fileStoragePath == nil || databaseDSN == nil {
+		return nil, errors.New("storage not specified")
+	}
+
+	storage, err := resolveStorageURL(*storageURL, *fileStoragePath, *databaseDSN)
+	if err != nil {
+		return nil, err
+	}
+
+	if metricsAddress == nil {
+		return nil, errors.New("metrics address not specified")
+	}
+
+	if traceExporter == nil {
+		return nil, errors.New("trace exporter not specified")
+	}
+
+	if otlpEndpoint == nil {
+		return nil, errors.New("otlp endpoint not specified")
+	}
+
+	if shutdownDelay == nil {
+		return nil, errors.New("shutdown delay not specified")
+	}
+
+	if maxBodySize == nil {
+		return nil, errors.New("max body size not specified")
+	}
+
+	if maxBodyRatio == nil {
+		return nil, errors.New("max body ratio not specified")
+	}
+
+	if trackingParams == nil {
+		return nil, errors.New("tracking params not specified")
+	}
+
+	if blocklistPath == nil {
+		return nil, errors.New("blocklist path not specified")
+	}
+
+	if blocklistReload == nil {
+		return nil, errors.New("blocklist reload interval not specified")
+	}
+
+	if adminToken == nil {
+		return nil, errors.New("admin token not specified")
+	}
+
+	if defaultRedirect == nil {
+		return nil, errors.New("default redirect code not specified")
+	}
+
+	if *defaultRedirect == 0 || !models.ValidRedirectCode(*defaultRedirect) {
+		return nil, errs.ErrInvalidRedirectCode
+	}
+
+	if webhookPath == nil {
+		return nil, errors.New("webhook storage path not specified")
+	}
+
+	if webhookAttempts == nil || *webhookAttempts < 1 {
+		return nil, errors.New("webhook max attempts must be positive")
+	}
+
+	if webhookBackoff == nil || *webhookBackoff <= 0 {
+		return nil, errors.New("webhook backoff must be positive")
 	}
 
-	if databaseDSN == nil {
-		return nil, errors.New("database dsn not specified")
+	if breakerFailures == nil || *breakerFailures < 1 {
+		return nil, errors.New("breaker failures must be positive")
+	}
+
+	if breakerCooldown == nil || *breakerCooldown <= 0 {
+		return nil, errors.New("breaker cooldown must be positive")
+	}
+
+	if snapshotSize == nil || *snapshotSize < 0 {
+		return nil, errors.New("snapshot size must not be negative")
+	}
+
+	if err := database.validate(); err != nil {
+		return nil, err
 	}
 
 	if secretKey == nil {
@@ -45,8 +186,26 @@ func NewConfig() (*appConfig, error) {
 	return &appConfig{
 		ServerAddress:   *serverAddress,
 		BaseURL:         *baseURL,
-		FileStoragePath: *fileStoragePath,
-		DatabaseDSN:     *databaseDSN,
+		StorageURL:      storage,
+		MetricsAddress:  *metricsAddress,
+		TraceExporter:   *traceExporter,
+		OTLPEndpoint:    *otlpEndpoint,
+		ShutdownDelay:   *shutdownDelay,
+		MaxBodySize:     *maxBodySize,
+		MaxBodyRatio:    *maxBodyRatio,
+		MaxImportSize:   *maxImportSize,
+		TrackingParams:  splitList(*trackingParams),
+		BlocklistPath:   *blocklistPath,
+		BlocklistReload: *blocklistReload,
+		AdminToken:      *adminToken,
+		DefaultRedirect: *defaultRedirect,
+		WebhookPath:     *webhookPath,
+		WebhookAttempts: *webhookAttempts,
+		WebhookBackoff:  *webhookBackoff,
+		BreakerFailures: *breakerFailures,
+		BreakerCooldown: *breakerCooldown,
+		SnapshotSize:    *snapshotSize,
+		Database:        database.config(),
 		SecretKey:       []byte(*secretKey),
 	}, nil
 }
@@ -69,16 +228,308 @@ func getBaseURL() *string {
 	return flag.String("b", url, "base url")
 }
 
+// getStorageURL returns where links are kept: memory://, file:///path, bolt:///path, sqlite:///path, postgres://... or sharded://...
+// Postgres is set up by the DATABASE_* settings, see getDatabaseConfig, the pool options of pgx in the url win over them,
+// replica=<url-encoded dsn> adds a replica of the database and may repeat.
+// sharded://?shard=...&index=... spreads the links over the url-encoded storages of the shards, the urls
+// are deduplicated through the indexes, both options repeat.
+func getStorageURL() *string {
+	storage := os.Getenv("STORAGE_URL")
+
+	return flag.String("storage", storage, "storage url: memory://, file:///path, bolt:///path, sqlite:///path, postgres://... or sharded://?shard=...&index=...")
+}
+
+// getFileStoragePath is the file storage setting from before STORAGE_URL, kept for existing deployments
 func getFileStoragePath() *string {
 	path := os.Getenv("FILE_STORAGE_PATH")
 
-	return flag.String("f", path, "file storage path")
+	return flag.String("f", path, "file storage path, deprecated: use -storage file:///path")
 }
 
+// getDatabaseDSN is the database setting from before STORAGE_URL, kept for existing deployments
 func getDatabaseDSN() *string {
 	databaseDSN := os.Getenv("DATABASE_DSN")
 
-	return flag.String("d", databaseDSN, "database")
+	return flag.String("d", databaseDSN, "database dsn, deprecated: use -storage postgres://...")
+}
+
+// resolveStorageURL turns the deprecated settings into a storage url. A storage url next to a deprecated
+// setting is an error, with no setting at all the links are kept in memory with a warning.
+func resolveStorageURL(storageURL, filePath, databaseDSN string) (string, error) {
+	switch {
+	case storageURL != "" && (filePath != "" || databaseDSN != ""):
+		return "", fmt.Errorf("%w: STORAGE_URL can't be combined with FILE_STORAGE_PATH or DATABASE_DSN", errs.ErrInvalidStorageURL)
+	case storageURL != "":
+		return storageURL, nil
+	case databaseDSN != "":
+		// the database always won over the file
+		if !strings.Contains(databaseDSN, ":") {
+			return "", fmt.Errorf("%w: DATABASE_DSN must be a url, e.g. postgres://user@host/db", errs.ErrInvalidStorageURL)
+		}
+		return databaseDSN, nil
+	case filePath != "":
+		if filepath.IsAbs(filePath) {
+			return (&url.URL{Scheme: "file", Path: filePath}).String(), nil
+		}
+		return (&url.URL{Scheme: "file", Opaque: filePath}).String(), nil
+	}
+
+	log.Warn("storage not specified, links are kept in memory and lost on restart; set STORAGE_URL, e.g. file:///var/lib/shortener/urls.dat")
+
+	return defaultStorageURL, nil
+}
+
+// getMetricsAddress returns the address of a separate admin listener for /metrics,
+// when empty the metrics are served by the main server
+func getMetricsAddress() *string {
+	address := os.Getenv("METRICS_ADDRESS")
+
+	return flag.String("m", address, "metrics server address")
+}
+
+// getTraceExporter returns where spans go: stdout, otlp or nowhere when empty
+func getTraceExporter() *string {
+	exporter := os.Getenv("TRACE_EXPORTER")
+
+	return flag.String("trace-exporter", exporter, "trace exporter: stdout or otlp")
+}
+
+func getOTLPEndpoint() *string {
+	endpoint := os.Getenv("OTLP_ENDPOINT")
+	if endpoint == "" {
+		endpoint = "http://localhost:4318"
+	}
+
+	return flag.String("otlp-endpoint", endpoint, "otlp http collector endpoint")
+}
+
+// getShutdownDelay returns how long the server keeps serving while reporting not ready,
+// so load balancers stop sending traffic before connections are closed
+func getShutdownDelay() *time.Duration {
+	delay := 5 * time.Second
+	if value, err := time.ParseDuration(os.Getenv("SHUTDOWN_DELAY")); err == nil {
+		delay = value
+	}
+
+	return flag.Duration("shutdown-delay", delay, "drain delay before shutdown")
+}
+
+// getMaxBodySize returns the request body limit in bytes after decompression
+func getMaxBodySize() *int64 {
+	size := int64(10 << 20)
+	if value, err := strconv.ParseInt(os.Getenv("MAX_BODY_SIZE"), 10, 64); err == nil {
+		size = value
+	}
+
+	return flag.Int64("max-body-size", size, "max request body size after decompression, 0 disables the limit")
+}
+
+// getMaxImportSize returns the body limit of link imports in bytes after decompression, they take whole files
+func getMaxImportSize() *int64 {
+	size := int64(256 << 20)
+	if value, err := strconv.ParseInt(os.Getenv("MAX_IMPORT_SIZE"), 10, 64); err == nil {
+		size = value
+	}
+
+	return flag.Int64("max-import-size", size, "max import body size after decompression, 0 disables the limit")
+}
+
+// getMaxBodyRatio returns how many times a compressed request body may expand
+func getMaxBodyRatio() *int64 {
+	ratio := int64(100)
+	if value, err := strconv.ParseInt(os.Getenv("MAX_BODY_RATIO"), 10, 64); err == nil {
+		ratio = value
+	}
+
+	return flag.Int64("max-body-ratio", ratio, "max request body compression ratio, 0 disables the limit")
+}
+
+// getTrackingParams returns comma separated query parameters stripped from urls before deduplication,
+// a trailing * matches a prefix
+func getTrackingParams() *string {
+	params, ok := os.LookupEnv("TRACKING_PARAMS")
+	if !ok {
+		params = strings.Join(normalizer.DefaultTrackingParams, ",")
+	}
+
+	return flag.String("tracking-params", params, "query parameters stripped from urls")
+}
+
+// getBlocklistPath returns the file of blocked domains, url prefixes and regexps, when empty
+// only dangerous schemes and links to the shortener itself are refused
+func getBlocklistPath() *string {
+	path := os.Getenv("BLOCKLIST_PATH")
+
+	return flag.String("blocklist", path, "blocklist file path")
+}
+
+// getBlocklistReload returns how often the blocklist file is checked for changes
+func getBlocklistReload() *time.Duration {
+	interval := 30 * time.Second
+	if value, err := time.ParseDuration(os.Getenv("BLOCKLIST_RELOAD")); err == nil {
+		interval = value
+	}
+
+	return flag.Duration("blocklist-reload", interval, "blocklist reload interval")
+}
+
+// getAdminToken returns the bearer token of the admin endpoints, they are disabled when it is empty
+func getAdminToken() *string {
+	token := os.Getenv("ADMIN_TOKEN")
+
+	return flag.String("admin-token", token, "admin api token")
+}
+
+// getDefaultRedirect returns the redirect code of links created without one
+func getDefaultRedirect() *int {
+	code := http.StatusTemporaryRedirect
+	if value, err := strconv.Atoi(os.Getenv("DEFAULT_REDIRECT")); err == nil {
+		code = value
+	}
+
+	return flag.Int("redirect", code, "default redirect code: 301, 302, 307 or 308")
+}
+
+// getWebhookPath returns the file keeping webhooks and their delivery queue, when not set they live in memory only.
+// A postgres storage, or a sharded one over postgres, keeps them in its (first) database instead.
+func getWebhookPath() *string {
+	return flag.String("webhook-storage", os.Getenv("WEBHOOK_STORAGE_PATH"), "webhook storage file path")
+}
+
+// getWebhookAttempts returns how many times a delivery is tried before it goes to the dead letters
+func getWebhookAttempts() *int {
+	attempts := 8
+	if value, err := strconv.Atoi(os.Getenv("WEBHOOK_MAX_ATTEMPTS")); err == nil {
+		attempts = value
+	}
+
+	return flag.Int("webhook-attempts", attempts, "webhook delivery attempts")
+}
+
+// getWebhookBackoff returns the wait after the first failed delivery, it doubles with every attempt
+func getWebhookBackoff() *time.Duration {
+	backoff := 30 * time.Second
+	if value, err := time.ParseDuration(os.Getenv("WEBHOOK_BACKOFF")); err == nil {
+		backoff = value
+	}
+
+	return flag.Duration("webhook-backoff", backoff, "webhook retry backoff")
+}
+
+// getBreakerFailures returns how many database failures in a row make the server stop trying it for a while
+func getBreakerFailures() *int {
+	failures := 5
+	if value, err := strconv.Atoi(os.Getenv("BREAKER_FAILURES")); err == nil {
+		failures = value
+	}
+
+	return flag.Int("breaker-failures", failures, "database failures in a row opening the circuit")
+}
+
+// getBreakerCooldown returns how long the circuit stays open before the database is probed
+func getBreakerCooldown() *time.Duration {
+	cooldown := 10 * time.Second
+	if value, err := time.ParseDuration(os.Getenv("BREAKER_COOLDOWN")); err == nil {
+		cooldown = value
+	}
+
+	return flag.Duration("breaker-cooldown", cooldown, "wait before probing the database again")
+}
+
+// getSnapshotSize returns how many recently expanded links are kept to redirect while the database is down, 0 keeps none
+func getSnapshotSize() *int {
+	size := 10000
+	if value, err := strconv.Atoi(os.Getenv("SNAPSHOT_SIZE")); err == nil {
+		size = value
+	}
+
+	return flag.Int("snapshot-size", size, "links kept for redirects during database outages")
+}
+
+// databaseFlags are the DATABASE_* settings until the flags are parsed
+type databaseFlags struct {
+	maxConns         *int
+	maxConnLifetime  *time.Duration
+	maxConnIdleTime  *time.Duration
+	queryTimeout     *time.Duration
+	migrationTimeout *time.Duration
+	retryAttempts    *int
+	replicas         *string
+	readYourWrites   *time.Duration
+}
+
+// getDatabaseConfig returns the settings of a postgres storage: the pool size and connection lifetimes,
+// the timeout of a call with its retries, of the schema update at start and the tries per call.
+// Reads go to the comma separated replica dsns and, for the read-your-writes window after a write, to the primary;
+// the database shards of a sharded storage name their replicas in their urls instead.
+func getDatabaseConfig() databaseFlags {
+	return databaseFlags{
+		maxConns:         flag.Int("db-max-conns", envInt("DATABASE_MAX_CONNS", 20), "database pool size"),
+		maxConnLifetime:  flag.Duration("db-max-conn-lifetime", envDuration("DATABASE_MAX_CONN_LIFETIME", 2*time.Minute), "database connection lifetime"),
+		maxConnIdleTime:  flag.Duration("db-max-conn-idle-time", envDuration("DATABASE_MAX_CONN_IDLE_TIME", 30*time.Second), "database connection idle time"),
+		queryTimeout:     flag.Duration("db-query-timeout", envDuration("DATABASE_QUERY_TIMEOUT", 3*time.Second), "database call timeout, retries included"),
+		migrationTimeout: flag.Duration("db-migration-timeout", envDuration("DATABASE_MIGRATION_TIMEOUT", 10*time.Minute), "database schema update timeout"),
+		retryAttempts:    flag.Int("db-retry-attempts", envInt("DATABASE_RETRY_ATTEMPTS", 3), "database tries per call"),
+		replicas:         flag.String("db-replicas", os.Getenv("DATABASE_REPLICAS"), "comma separated database replica dsns"),
+		readYourWrites:   flag.Duration("db-read-your-writes", envDuration("DATABASE_READ_YOUR_WRITES", 0), "reads go to the primary for this long after a write, 0 disables"),
+	}
+}
+
+func (f databaseFlags) config() DatabaseConfig {
+	return DatabaseConfig{
+		MaxConns:         int32(*f.maxConns),
+		MaxConnLifetime:  *f.maxConnLifetime,
+		MaxConnIdleTime:  *f.maxConnIdleTime,
+		QueryTimeout:     *f.queryTimeout,
+		MigrationTimeout: *f.migrationTimeout,
+		RetryAttempts:    *f.retryAttempts,
+		Replicas:         splitList(*f.replicas),
+		ReadYourWrites:   *f.readYourWrites,
+	}
+}
+
+func (f databaseFlags) validate() error {
+	switch {
+	case *f.maxConns < 1:
+		return errors.New("database pool size must be positive")
+	case *f.maxConnLifetime <= 0 || *f.maxConnIdleTime <= 0:
+		return errors.New("database connection lifetimes must be positive")
+	case *f.queryTimeout <= 0 || *f.migrationTimeout <= 0:
+		return errors.New("database timeouts must be positive")
+	case *f.retryAttempts < 1:
+		return errors.New("database retry attempts must be positive")
+	case *f.readYourWrites < 0:
+		return errors.New("database read-your-writes window must not be negative")
+	}
+
+	return nil
+}
+
+func envInt(name string, value int) int {
+	if parsed, err := strconv.Atoi(os.Getenv(name)); err == nil {
+		return parsed
+	}
+
+	return value
+}
+
+func envDuration(name string, value time.Duration) time.Duration {
+	if parsed, err := time.ParseDuration(os.Getenv(name)); err == nil {
+		return parsed
+	}
+
+	return value
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
 }
 
 func getSecretKey() *string {
@@ -0,0 +1,114 @@
+package configs
+
+import (
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func Test_resolveStorageURL(t *testing.T) {
+	tests := []struct {
+		name        string
+		storageURL  string
+		filePath    string
+		databaseDSN string
+		want        string
+		err         error
+	}{
+		{
+			name:       "storage url",
+			storageURL: "bolt:///var/lib/shortener/urls.db",
+			want:       "bolt:///var/lib/shortener/urls.db",
+		},
+		{
+			name:     "absolute file path",
+			filePath: "/var/lib/shortener/urls.dat",
+			want:     "file:///var/lib/shortener/urls.dat",
+		},
+		{
+			name:     "relative file path",
+			filePath: "storage.dat",
+			want:     "file:storage.dat",
+		},
+		{
+			name:        "database wins over the file",
+			filePath:    "storage.dat",
+			databaseDSN: "postgres://user@localhost/db",
+			want:        "postgres://user@localhost/db",
+		},
+		{
+			name:        "key value dsn",
+			databaseDSN: "host=localhost user=user",
+			err:         errs.ErrInvalidStorageURL,
+		},
+		{
+			name:       "storage url with a deprecated setting",
+			storageURL: "memory://",
+			filePath:   "storage.dat",
+			err:        errs.ErrInvalidStorageURL,
+		},
+		{
+			name: "nothing",
+			want: "memory://",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			act, err := resolveStorageURL(tt.storageURL, tt.filePath, tt.databaseDSN)
+
+			assert.ErrorIs(t, err, tt.err)
+			assert.Equal(t, tt.want, act)
+		})
+	}
+}
+
+func Test_databaseFlags_validate(t *testing.T) {
+	valid := func() databaseFlags {
+		maxConns, retryAttempts := 20, 3
+		lifetime, idleTime, queryTimeout, migrationTimeout, readYourWrites := 2*time.Minute, 30*time.Second, 3*time.Second, 10*time.Minute, time.Duration(0)
+		replicas := "postgres://user@replica1/db, postgres://user@replica2/db"
+
+		return databaseFlags{
+			maxConns:         &maxConns,
+			maxConnLifetime:  &lifetime,
+			maxConnIdleTime:  &idleTime,
+			queryTimeout:     &queryTimeout,
+			migrationTimeout: &migrationTimeout,
+			retryAttempts:    &retryAttempts,
+			replicas:         &replicas,
+			readYourWrites:   &readYourWrites,
+		}
+	}
+
+	tests := []struct {
+		name  string
+		flags func(f databaseFlags)
+		ok    bool
+	}{
+		{name: "defaults", flags: func(databaseFlags) {}, ok: true},
+		{name: "no pool", flags: func(f databaseFlags) { *f.maxConns = 0 }},
+		{name: "no query timeout", flags: func(f databaseFlags) { *f.queryTimeout = 0 }},
+		{name: "no retry attempts", flags: func(f databaseFlags) { *f.retryAttempts = 0 }},
+		{name: "negative read your writes", flags: func(f databaseFlags) { *f.readYourWrites = -time.Second }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := valid()
+			tt.flags(f)
+
+			err := f.validate()
+			if tt.ok {
+				assert.NoError(t, err)
+				return
+			}
+			assert.Error(t, err)
+		})
+	}
+
+	config := valid().config()
+	assert.Equal(t, int32(20), config.MaxConns)
+	assert.Equal(t, []string{"postgres://user@replica1/db", "postgres://user@replica2/db"}, config.Replicas)
+}
@@ -0,0 +1,293 @@
+package blocklist
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/idna"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rule kinds of the blocklist file, a line without a kind is a domain
+const (
+	ruleDomain = "domain"
+	rulePrefix = "prefix"
+	ruleRegex  = "regex"
+)
+
+// dangerousSchemes run code or embed content in the browser instead of navigating
+var dangerousSchemes = map[string]struct{}{
+	"javascript": {},
+	"vbscript":   {},
+	"data":       {},
+	"blob":       {},
+	"file":       {},
+}
+
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+type rules struct {
+	domains  []string
+	prefixes []string
+	regexps  []*regexp.Regexp
+}
+
+type blocklist struct {
+	path     string
+	interval time.Duration
+	selfHost string
+
+	mu      sync.RWMutex
+	rules   *rules
+	modTime time.Time
+	size    int64
+}
+
+// NewBlocklist loads the rules file, an empty path leaves only the built-in rules:
+// dangerous schemes and links to baseURL itself
+func NewBlocklist(path string, reloadInterval time.Duration, baseURL string) (*blocklist, error) {
+	b := &blocklist{
+		path:     path,
+		interval: reloadInterval,
+		selfHost: hostOf(baseURL),
+		rules:    &rules{},
+	}
+
+	if path == "" {
+		return b, nil
+	}
+
+	if err := b.Reload(); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// Check refuses a url matching a rule. normalizedURL is empty when the url couldn't be normalized,
+// only its scheme is checked then.
+func (b *blocklist) Check(rawURL, normalizedURL string) error {
+	if scheme := schemeOf(rawURL); scheme != "" {
+		if _, ok := dangerousSchemes[scheme]; ok {
+			return errs.NewBlockedURLErr(rawURL, fmt.Sprintf("scheme %s is not allowed", scheme))
+		}
+	}
+
+	if normalizedURL == "" {
+		return nil
+	}
+
+	if b.selfHost != "" && hostOf(normalizedURL) == b.selfHost {
+		return errs.NewBlockedURLErr(rawURL, "links to the shortener itself are not allowed")
+	}
+
+	b.mu.RLock()
+	rules := b.rules
+	b.mu.RUnlock()
+
+	if reason, ok := rules.match(rawURL, normalizedURL); ok {
+		return errs.NewBlockedURLErr(rawURL, reason)
+	}
+
+	return nil
+}
+
+// Reload reads the rules file again, the current rules stay when it is invalid
+func (b *blocklist) Reload() error {
+	file, err := os.Open(b.path)
+	if err != nil {
+		return fmt.Errorf("open blocklist error: %w", err)
+	}
+
+	defer func(file *os.File) {
+		_ = file.Close()
+	}(file)
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("stat blocklist error: %w", err)
+	}
+
+	parsed, err := parseRules(file)
+	if err != nil {
+		return fmt.Errorf("parse blocklist error: %w", err)
+	}
+
+	b.mu.Lock()
+	b.rules = parsed
+	b.modTime = info.ModTime()
+	b.size = info.Size()
+	b.mu.Unlock()
+
+	log.WithField("path", b.path).
+		WithField("domains", len(parsed.domains)).
+		WithField("prefixes", len(parsed.prefixes)).
+		WithField("regexps", len(parsed.regexps)).
+		Info("blocklist loaded")
+
+	return nil
+}
+
+// Watch polls the rules file and reloads it once it changes, until ctx is done
+func (b *blocklist) Watch(ctx context.Context) {
+	if b.path == "" || b.interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := b.reloadIfChanged(); err != nil {
+				log.WithError(err).WithField("path", b.path).Error("reload blocklist error")
+			}
+		}
+	}
+}
+
+func (b *blocklist) reloadIfChanged() error {
+	info, err := os.Stat(b.path)
+	if err != nil {
+		return fmt.Errorf("stat blocklist error: %w", err)
+	}
+
+	b.mu.RLock()
+	changed := !info.ModTime().Equal(b.modTime) || info.Size() != b.size
+	b.mu.RUnlock()
+
+	if !changed {
+		return nil
+	}
+
+	return b.Reload()
+}
+
+// parseRules reads one rule per line as "kind:value", blank lines and lines starting with # are skipped
+func parseRules(r io.Reader) (*rules, error) {
+	parsed := &rules{}
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		kind, value := ruleDomain, text
+		if idx := strings.Index(text, ":"); idx > 0 {
+			switch prefix := strings.ToLower(text[:idx]); prefix {
+			case ruleDomain, rulePrefix, ruleRegex:
+				kind, value = prefix, strings.TrimSpace(text[idx+1:])
+			}
+		}
+
+		if value == "" {
+			return nil, fmt.Errorf("line %d: empty %s rule", line, kind)
+		}
+
+		switch kind {
+		case ruleDomain:
+			domain, err := idna.Lookup.ToASCII(strings.TrimSuffix(strings.ToLower(value), "."))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid domain %q: %w", line, value, err)
+			}
+			parsed.domains = append(parsed.domains, domain)
+		case rulePrefix:
+			parsed.prefixes = append(parsed.prefixes, value)
+		case ruleRegex:
+			re, err := regexp.Compile(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid regex: %w", line, err)
+			}
+			parsed.regexps = append(parsed.regexps, re)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return parsed, nil
+}
+
+// match checks domains against the host of the canonical url, so subdomains are blocked too,
+// prefixes against the canonical url and regexps against both forms
+func (r *rules) match(rawURL, normalizedURL string) (string, bool) {
+	hostname := hostnameOf(normalizedURL)
+	for _, domain := range r.domains {
+		if hostname == domain || strings.HasSuffix(hostname, "."+domain) {
+			return fmt.Sprintf("domain %s is blocked", domain), true
+		}
+	}
+
+	for _, prefix := range r.prefixes {
+		if strings.HasPrefix(normalizedURL, prefix) {
+			return fmt.Sprintf("url prefix %s is blocked", prefix), true
+		}
+	}
+
+	for _, re := range r.regexps {
+		if re.MatchString(normalizedURL) || re.MatchString(rawURL) {
+			return "url matches a blocked pattern", true
+		}
+	}
+
+	return "", false
+}
+
+// schemeOf returns the lowercase scheme the way browsers read it, ignoring whitespace
+// and control characters that could hide it
+func schemeOf(rawURL string) string {
+	idx := strings.Index(rawURL, ":")
+	if idx < 0 {
+		return ""
+	}
+
+	scheme := strings.Map(func(r rune) rune {
+		if r <= ' ' {
+			return -1
+		}
+		return r
+	}, rawURL[:idx])
+
+	return strings.ToLower(scheme)
+}
+
+// hostOf returns the lowercase host with a non default port
+func hostOf(rawURL string) string {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return ""
+	}
+
+	hostname := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if port := u.Port(); port != "" && defaultPorts[strings.ToLower(u.Scheme)] != port {
+		return hostname + ":" + port
+	}
+
+	return hostname
+}
+
+func hostnameOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+
+	return strings.ToLower(u.Hostname())
+}
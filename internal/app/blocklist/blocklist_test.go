@@ -0,0 +1,116 @@
+package blocklist
+
+import (
+	"errors"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const rulesFile = `# phishing reported on 2022-05-01
+domain:phishing.example
+пример.рф
+prefix:https://docs.example.com/shared/
+regex:(?i)paypal.*login
+`
+
+func TestBlocklist_Check(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	require.NoError(t, os.WriteFile(path, []byte(rulesFile), 0600))
+
+	b, err := NewBlocklist(path, time.Minute, "http://localhost:8080")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name          string
+		rawURL        string
+		normalizedURL string
+		reason        string
+	}{
+		{name: "allowed", rawURL: "https://yandex.ru", normalizedURL: "https://yandex.ru/"},
+		{name: "javascript", rawURL: "javascript:alert(1)", reason: "scheme javascript is not allowed"},
+		{name: "hidden javascript", rawURL: " Java\tScript:alert(1)", reason: "scheme javascript is not allowed"},
+		{name: "data", rawURL: "data:text/html;base64,PHNjcmlwdD4=", reason: "scheme data is not allowed"},
+		{name: "self", rawURL: "http://localhost:8080/abcde", normalizedURL: "http://localhost:8080/abcde", reason: "links to the shortener itself are not allowed"},
+		{name: "self on other port", rawURL: "http://localhost:9090/abcde", normalizedURL: "http://localhost:9090/abcde"},
+		{name: "domain", rawURL: "https://phishing.example", normalizedURL: "https://phishing.example/", reason: "domain phishing.example is blocked"},
+		{name: "subdomain", rawURL: "https://a.b.phishing.example/x", normalizedURL: "https://a.b.phishing.example/x", reason: "domain phishing.example is blocked"},
+		{name: "similar domain", rawURL: "https://notphishing.example/", normalizedURL: "https://notphishing.example/"},
+		{name: "idn domain", rawURL: "https://пример.рф", normalizedURL: "https://xn--e1afmkfd.xn--p1ai/", reason: "domain xn--e1afmkfd.xn--p1ai is blocked"},
+		{name: "prefix", rawURL: "https://docs.example.com/shared/123", normalizedURL: "https://docs.example.com/shared/123", reason: "url prefix https://docs.example.com/shared/ is blocked"},
+		{name: "outside prefix", rawURL: "https://docs.example.com/public", normalizedURL: "https://docs.example.com/public"},
+		{name: "regex on raw url", rawURL: "https://evil.example/PayPal/Login", normalizedURL: "https://evil.example/PayPal/Login", reason: "url matches a blocked pattern"},
+		{name: "not normalized", rawURL: "yandex", normalizedURL: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := b.Check(tt.rawURL, tt.normalizedURL)
+			if tt.reason == "" {
+				assert.NoError(t, err)
+				return
+			}
+
+			var blockedErr *errs.BlockedURLErr
+			require.True(t, errors.As(err, &blockedErr), "expected blocked url error, got %v", err)
+			assert.Equal(t, tt.reason, blockedErr.Reason)
+		})
+	}
+}
+
+func TestBlocklist_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	require.NoError(t, os.WriteFile(path, []byte("domain:phishing.example\n"), 0600))
+
+	b, err := NewBlocklist(path, time.Minute, "")
+	require.NoError(t, err)
+
+	assert.NoError(t, b.Check("https://malware.example", "https://malware.example/"))
+
+	require.NoError(t, os.WriteFile(path, []byte("domain:phishing.example\ndomain:malware.example\n"), 0600))
+	require.NoError(t, b.reloadIfChanged())
+	assert.Error(t, b.Check("https://malware.example", "https://malware.example/"))
+
+	// a broken file keeps the rules loaded before
+	require.NoError(t, os.WriteFile(path, []byte("regex:([\n"), 0600))
+	assert.Error(t, b.reloadIfChanged())
+	assert.Error(t, b.Check("https://malware.example", "https://malware.example/"))
+}
+
+func TestNewBlocklist(t *testing.T) {
+	b, err := NewBlocklist("", time.Minute, "http://localhost:8080")
+	require.NoError(t, err)
+	assert.NoError(t, b.Check("https://phishing.example", "https://phishing.example/"))
+
+	_, err = NewBlocklist(filepath.Join(t.TempDir(), "missing.txt"), time.Minute, "")
+	assert.Error(t, err)
+}
+
+func Test_parseRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		wantErr string
+	}{
+		{name: "valid", file: rulesFile},
+		{name: "empty rule", file: "prefix:\n", wantErr: "line 1: empty prefix rule"},
+		{name: "invalid regex", file: "\nregex:([\n", wantErr: "line 2: invalid regex"},
+		{name: "invalid domain", file: "https://phishing.example\n", wantErr: "line 1: invalid domain"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseRules(strings.NewReader(tt.file))
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,63 @@
+// Package consistency carries the time of the last write of a user from request to request, so every
+// instance serving the user reads from the primary until the replicas have caught up with the write
+package consistency
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type contextKey struct{}
+
+type lastWrite struct {
+	mu      sync.Mutex
+	at      time.Time
+	written bool
+}
+
+// WithLastWrite returns a context carrying the time the user wrote last, zero when unknown.
+// Writes recorded under the context move that time.
+func WithLastWrite(ctx context.Context, at time.Time) context.Context {
+	return context.WithValue(ctx, contextKey{}, &lastWrite{at: at})
+}
+
+// LastWrite returns the time the user of the request wrote last, zero when unknown
+func LastWrite(ctx context.Context) time.Time {
+	lw, ok := ctx.Value(contextKey{}).(*lastWrite)
+	if !ok {
+		return time.Time{}
+	}
+
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	return lw.at
+}
+
+// Wrote records a write made for the user of the request, contexts without WithLastWrite ignore it
+func Wrote(ctx context.Context) {
+	lw, ok := ctx.Value(contextKey{}).(*lastWrite)
+	if !ok {
+		return
+	}
+
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	lw.at = time.Now()
+	lw.written = true
+}
+
+// Written returns the time of the last write made during the request, ok is false when there was none
+func Written(ctx context.Context) (at time.Time, ok bool) {
+	lw, found := ctx.Value(contextKey{}).(*lastWrite)
+	if !found {
+		return time.Time{}, false
+	}
+
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	return lw.at, lw.written
+}
@@ -0,0 +1,56 @@
+package invalidation
+
+import (
+	"context"
+	"sync"
+)
+
+// event types, an instance keeping links in memory drops the link named by the event
+const (
+	EventDelete = "delete"
+	EventUpdate = "update"
+	EventExpire = "expire"
+	// EventReset follows a break in delivery, events may have been missed and everything kept must be dropped
+	EventReset = "reset"
+)
+
+// Event tells the instances a link changed
+type Event struct {
+	Type  string `json:"type"`
+	URLID string `json:"url_id,omitempty"`
+}
+
+// Handler takes the events of a bus, it must not block
+type Handler func(event Event)
+
+// Bus carries link changes to every instance, the publishing one included
+type Bus interface {
+	Publish(ctx context.Context, event Event) error
+	Subscribe(handler Handler)
+	// Run delivers the events to the handlers until ctx is done
+	Run(ctx context.Context)
+	Close() error
+}
+
+// Subscribers keeps the handlers of a bus
+type Subscribers struct {
+	mu       sync.RWMutex
+	handlers []Handler
+}
+
+func (s *Subscribers) Subscribe(handler Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.handlers = append(s.handlers, handler)
+}
+
+// Notify hands the event to every handler in the order they subscribed
+func (s *Subscribers) Notify(event Event) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, handler := range s.handlers {
+		handler(event)
+	}
+}
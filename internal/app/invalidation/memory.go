@@ -0,0 +1,35 @@
+package invalidation
+
+import (
+	"context"
+)
+
+type memoryBus struct {
+	Subscribers
+}
+
+// NewMemoryBus delivers the events to the handlers of this instance only, it stands in
+// for the Postgres bus when a single instance runs
+func NewMemoryBus() *memoryBus {
+	return &memoryBus{}
+}
+
+// Publish calls the handlers before it returns
+func (b *memoryBus) Publish(ctx context.Context, event Event) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	b.Notify(event)
+
+	return nil
+}
+
+// Run has nothing to wait for, events are delivered by Publish
+func (b *memoryBus) Run(ctx context.Context) {
+	<-ctx.Done()
+}
+
+func (b *memoryBus) Close() error {
+	return nil
+}
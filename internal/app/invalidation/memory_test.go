@@ -0,0 +1,33 @@
+package invalidation
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestMemoryBus_Publish(t *testing.T) {
+	ctx := context.Background()
+	bus := NewMemoryBus()
+
+	var first, second []Event
+	bus.Subscribe(func(event Event) {
+		first = append(first, event)
+	})
+	bus.Subscribe(func(event Event) {
+		second = append(second, event)
+	})
+
+	require.NoError(t, bus.Publish(ctx, Event{Type: EventDelete, URLID: "abc"}))
+	require.NoError(t, bus.Publish(ctx, Event{Type: EventUpdate, URLID: "cba"}))
+
+	exp := []Event{{Type: EventDelete, URLID: "abc"}, {Type: EventUpdate, URLID: "cba"}}
+	assert.Equal(t, exp, first)
+	assert.Equal(t, exp, second)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.ErrorIs(t, bus.Publish(cancelled, Event{Type: EventExpire, URLID: "abc"}), context.Canceled)
+	assert.Len(t, first, 2)
+}
@@ -1,12 +1,222 @@
 package models
 
+import (
+	"net/http"
+	"net/url"
+	"time"
+)
+
 type OriginalURL struct {
 	CorrelationID string
 	URL           string
+	RedirectCode  int
+	Passthrough   bool
 }
 
 type UserURL struct {
 	CorrelationID string
 	ShortURL      string
 	OriginalURL   string
+	NormalizedURL string
+	RedirectCode  int
+	Passthrough   bool
+	// Existing url was shortened before, ShortURL is the stored link
+	Existing bool
+}
+
+// BatchResult tells what became of a batch entry: Created when it was stored under its id,
+// otherwise its url was already shortened and ID is the stored link
+type BatchResult struct {
+	ID      string
+	Created bool
+}
+
+// Link is a stored short link together with its owner. OriginalURL is the user's input kept
+// for display and redirects, NormalizedURL is its canonical form links are deduplicated on.
+// A passthrough link appends the path and query of the visit to OriginalURL. Rules send matching
+// clients elsewhere, the others are split across Variants when there are any, OriginalURL is the fallback.
+type Link struct {
+	ID            string
+	OriginalURL   string
+	NormalizedURL string
+	UserID        string
+	RedirectCode  int
+	Passthrough   bool
+	Rules         []TargetRule
+	Variants      []Variant
+	Sticky        bool
+	CreatedAt     time.Time
+	DeletedAt     time.Time
+}
+
+// Canonical returns the form the link is deduplicated on, links stored before
+// normalization existed only have the original url
+func (l Link) Canonical() string {
+	if l.NormalizedURL != "" {
+		return l.NormalizedURL
+	}
+
+	return l.OriginalURL
+}
+
+// Options returns the settings the owner chose for the link
+func (l Link) Options() LinkOptions {
+	return LinkOptions{
+		RedirectCode: l.RedirectCode,
+		Passthrough:  l.Passthrough,
+		Rules:        l.Rules,
+		Variants:     l.Variants,
+		Sticky:       l.Sticky,
+	}
+}
+
+func (l Link) Deleted() bool {
+	return !l.DeletedAt.IsZero()
+}
+
+// Target returns the destination of the first rule the client matches, ok is false when none does
+func (l Link) Target(client Client) (string, bool) {
+	for _, rule := range l.Rules {
+		if rule.Matches(client) {
+			return rule.URL, true
+		}
+	}
+
+	return l.OriginalURL, false
+}
+
+// VariantIndex returns the position of the variant with the name if it still gets traffic
+func (l Link) VariantIndex(name string) (int, bool) {
+	for idx, variant := range l.Variants {
+		if variant.Name == name && variant.Weight > 0 {
+			return idx, true
+		}
+	}
+
+	return 0, false
+}
+
+// operating systems and device classes told by the User-Agent
+const (
+	OSIOS     = "ios"
+	OSAndroid = "android"
+	OSWindows = "windows"
+	OSMacOS   = "macos"
+	OSLinux   = "linux"
+
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceDesktop = "desktop"
+	DeviceBot     = "bot"
+)
+
+// Client is the visitor as its User-Agent describes it, OS is empty when unknown
+type Client struct {
+	OS     string
+	Device string
+}
+
+// TargetRule sends clients matching both conditions to URL, an empty condition matches any client
+type TargetRule struct {
+	OS     string
+	Device string
+	URL    string
+}
+
+// Variant is one destination of a split link, it gets Weight shares of the traffic
+type Variant struct {
+	Name   string
+	URL    string
+	Weight int
+}
+
+func (r TargetRule) Matches(client Client) bool {
+	return (r.OS == "" || r.OS == client.OS) && (r.Device == "" || r.Device == client.Device)
+}
+
+// Valid reports whether the rule has a known condition, a rule matching everyone would hide the fallback
+func (r TargetRule) Valid() bool {
+	if r.OS == "" && r.Device == "" {
+		return false
+	}
+
+	switch r.OS {
+	case "", OSIOS, OSAndroid, OSWindows, OSMacOS, OSLinux:
+	default:
+		return false
+	}
+
+	switch r.Device {
+	case "", DeviceMobile, DeviceTablet, DeviceDesktop, DeviceBot:
+	default:
+		return false
+	}
+
+	return true
+}
+
+// LinkOptions are chosen by the owner when the link is created
+type LinkOptions struct {
+	RedirectCode int
+	Passthrough  bool
+	Rules        []TargetRule
+	Variants     []Variant
+	Sticky       bool
+}
+
+// Same reports whether links with either options redirect alike, no rules or variants is the same however it is kept
+func (o LinkOptions) Same(other LinkOptions) bool {
+	if o.RedirectCode != other.RedirectCode || o.Passthrough != other.Passthrough || o.Sticky != other.Sticky ||
+		len(o.Rules) != len(other.Rules) || len(o.Variants) != len(other.Variants) {
+		return false
+	}
+
+	for idx := range o.Rules {
+		if o.Rules[idx] != other.Rules[idx] {
+			return false
+		}
+	}
+
+	for idx := range o.Variants {
+		if o.Variants[idx] != other.Variants[idx] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Visit is what a request to a short link adds after the id, Variant is the one the visitor got before
+type Visit struct {
+	Path      string
+	Query     url.Values
+	UserAgent string
+	Variant   string
+}
+
+// Redirect is the answer to a visit of a short link, Targeted ones depend on the User-Agent.
+// Variant names the variant of a split link the visitor got and VariantIndex is its position,
+// Sticky ones should be remembered.
+type Redirect struct {
+	URL          string
+	Code         int
+	Targeted     bool
+	Variant      string
+	VariantIndex int
+	Sticky       bool
+}
+
+// ValidRedirectCode reports whether a link may redirect with the code, zero stands for the server default
+func ValidRedirectCode(code int) bool {
+	switch code {
+	case 0, http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+
+	return false
+}
+
+// PermanentRedirect reports whether clients may cache the redirect
+func PermanentRedirect(code int) bool {
+	return code == http.StatusMovedPermanently || code == http.StatusPermanentRedirect
 }
@@ -0,0 +1,74 @@
+package models
+
+import "time"
+
+// events webhooks may subscribe to
+const (
+	EventLinkCreated = "link.created"
+	EventLinkClicked = "link.clicked"
+)
+
+// MaxDeadLetters is how many deliveries given up on are kept per user, the oldest are dropped first
+const MaxDeadLetters = 100
+
+// Webhook receives the events of one link of the user, or of all their links when URLID is empty.
+// No Events means every event.
+type Webhook struct {
+	ID        string
+	UserID    string
+	URLID     string
+	URL       string
+	Secret    string
+	Events    []string
+	CreatedAt time.Time
+}
+
+// Wants reports whether the event goes to the webhook
+func (w Webhook) Wants(event Event) bool {
+	if w.UserID != event.UserID || (w.URLID != "" && w.URLID != event.URLID) {
+		return false
+	}
+
+	if len(w.Events) == 0 {
+		return true
+	}
+
+	for _, name := range w.Events {
+		if name == event.Type {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ValidEvent reports whether webhooks may subscribe to the event
+func ValidEvent(name string) bool {
+	return name == EventLinkCreated || name == EventLinkClicked
+}
+
+// Event is something that happened to a link of UserID. Target, Variant and UserAgent describe clicks.
+type Event struct {
+	Type        string
+	URLID       string
+	UserID      string
+	OriginalURL string
+	ShortURL    string
+	Target      string
+	Variant     string
+	UserAgent   string
+	OccurredAt  time.Time
+}
+
+// Delivery is the payload of an event waiting to be sent to a webhook, or given up on
+type Delivery struct {
+	ID          string
+	WebhookID   string
+	UserID      string
+	Event       string
+	Payload     []byte
+	Attempts    int
+	NextAttempt time.Time
+	LastError   string
+	CreatedAt   time.Time
+}
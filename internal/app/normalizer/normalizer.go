@@ -0,0 +1,139 @@
+package normalizer
+
+import (
+	"fmt"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	"golang.org/x/net/idna"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// DefaultTrackingParams are query parameters that only tell where a click came from,
+// a trailing * matches any parameter with the prefix
+var DefaultTrackingParams = []string{"utm_*", "gclid", "fbclid", "yclid", "_openstat"}
+
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+type normalizer struct {
+	params   map[string]struct{}
+	prefixes []string
+}
+
+// NewNormalizer builds the canonical form of urls, stripping the tracking parameters
+func NewNormalizer(trackingParams []string) *normalizer {
+	n := &normalizer{
+		params: map[string]struct{}{},
+	}
+
+	for _, param := range trackingParams {
+		param = strings.ToLower(strings.TrimSpace(param))
+		switch {
+		case param == "":
+		case strings.HasSuffix(param, "*"):
+			n.prefixes = append(n.prefixes, strings.TrimSuffix(param, "*"))
+		default:
+			n.params[param] = struct{}{}
+		}
+	}
+
+	return n
+}
+
+// Normalize returns the canonical form of an absolute url: lowercase scheme and host, punycode host,
+// no default port, clean path and sorted query without tracking parameters
+func (n *normalizer) Normalize(rawURL string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errs.ErrInvalidURL, err)
+	}
+
+	if u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("%w: absolute url expected", errs.ErrInvalidURL)
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+
+	host, err := normalizeHost(u.Scheme, u.Hostname(), u.Port())
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errs.ErrInvalidURL, err)
+	}
+	u.Host = host
+
+	escapedPath := cleanPath(u.EscapedPath())
+	if u.Path, err = url.PathUnescape(escapedPath); err != nil {
+		return "", fmt.Errorf("%w: %v", errs.ErrInvalidURL, err)
+	}
+	u.RawPath = escapedPath
+
+	u.RawQuery = n.normalizeQuery(u.Query())
+	u.ForceQuery = false
+
+	return u.String(), nil
+}
+
+func normalizeHost(scheme, hostname, port string) (string, error) {
+	hostname = strings.TrimSuffix(hostname, ".")
+
+	if ip := net.ParseIP(hostname); ip == nil {
+		ascii, err := idna.Lookup.ToASCII(hostname)
+		if err != nil {
+			return "", err
+		}
+		hostname = ascii
+	} else if ip.To4() == nil {
+		hostname = "[" + ip.String() + "]"
+	}
+
+	hostname = strings.ToLower(hostname)
+	if port == "" || defaultPorts[scheme] == port {
+		return hostname, nil
+	}
+
+	return hostname + ":" + port, nil
+}
+
+// cleanPath resolves dot segments and duplicate slashes, keeping the trailing slash
+func cleanPath(escapedPath string) string {
+	if escapedPath == "" {
+		return "/"
+	}
+
+	cleaned := path.Clean("/" + escapedPath)
+	if strings.HasSuffix(escapedPath, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+
+	return cleaned
+}
+
+// normalizeQuery drops tracking parameters and sorts the rest by name, values of a repeated
+// parameter keep their order
+func (n *normalizer) normalizeQuery(query url.Values) string {
+	for name := range query {
+		if n.tracking(name) {
+			query.Del(name)
+		}
+	}
+
+	return query.Encode()
+}
+
+func (n *normalizer) tracking(name string) bool {
+	name = strings.ToLower(name)
+	if _, ok := n.params[name]; ok {
+		return true
+	}
+
+	for _, prefix := range n.prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
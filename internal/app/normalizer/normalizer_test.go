@@ -0,0 +1,74 @@
+package normalizer
+
+import (
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestNormalizer_Normalize(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		want string
+		err  error
+	}{
+		{name: "already canonical", url: "https://yandex.ru/", want: "https://yandex.ru/"},
+		{name: "empty path", url: "https://yandex.ru", want: "https://yandex.ru/"},
+		{name: "scheme and host case", url: "HTTPS://Yandex.RU/Path", want: "https://yandex.ru/Path"},
+		{name: "default http port", url: "http://example.com:80/a", want: "http://example.com/a"},
+		{name: "default https port", url: "https://example.com:443/a", want: "https://example.com/a"},
+		{name: "custom port", url: "http://example.com:8080/a", want: "http://example.com:8080/a"},
+		{name: "dot segments", url: "http://Example.com/a/../b", want: "http://example.com/b"},
+		{name: "duplicate slashes", url: "http://example.com//a///b/", want: "http://example.com/a/b/"},
+		{name: "trailing slash kept", url: "http://example.com/a/./", want: "http://example.com/a/"},
+		{name: "escaped path kept", url: "http://example.com/a%2Fb", want: "http://example.com/a%2Fb"},
+		{name: "idn", url: "https://пример.рф/путь", want: "https://xn--e1afmkfd.xn--p1ai/%D0%BF%D1%83%D1%82%D1%8C"},
+		{name: "trailing dot", url: "https://yandex.ru./", want: "https://yandex.ru/"},
+		{name: "ipv6", url: "http://[2001:DB8::1]:80/", want: "http://[2001:db8::1]/"},
+		{name: "sorted query", url: "http://example.com/?b=2&a=1&a=0", want: "http://example.com/?a=1&a=0&b=2"},
+		{name: "tracking params", url: "http://Example.com/a/../b?utm_source=x&UTM_Medium=y&gclid=1&id=5", want: "http://example.com/b?id=5"},
+		{name: "only tracking params", url: "http://example.com/b?utm_source=x", want: "http://example.com/b"},
+		{name: "empty query", url: "http://example.com/b?", want: "http://example.com/b"},
+		{name: "fragment kept", url: "http://example.com/#section", want: "http://example.com/#section"},
+		{name: "surrounding spaces", url: "  https://yandex.ru  ", want: "https://yandex.ru/"},
+		{name: "relative url", url: "yandex.ru", err: errs.ErrInvalidURL},
+		{name: "no host", url: "mailto:user@example.com", err: errs.ErrInvalidURL},
+		{name: "malformed", url: "http://exa mple.com/%zz", err: errs.ErrInvalidURL},
+		{name: "invalid idn", url: "http://xn--a.com/", err: errs.ErrInvalidURL},
+	}
+
+	n := NewNormalizer(DefaultTrackingParams)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := n.Normalize(tt.url)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestNormalizer_SameCanonicalForm(t *testing.T) {
+	n := NewNormalizer(DefaultTrackingParams)
+
+	first, err := n.Normalize("http://Example.com/a/../b?utm_source=x")
+	require.NoError(t, err)
+
+	second, err := n.Normalize("http://example.com/b")
+	require.NoError(t, err)
+
+	assert.Equal(t, first, second)
+}
+
+func TestNormalizer_NoTrackingParams(t *testing.T) {
+	got, err := NewNormalizer(nil).Normalize("http://example.com/?utm_source=x")
+	require.NoError(t, err)
+
+	assert.Equal(t, "http://example.com/?utm_source=x", got)
+}
@@ -0,0 +1,460 @@
+package bolt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ChristinaFomenko/shortener/internal/app/models"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	bbolt "go.etcd.io/bbolt"
+	"time"
+)
+
+const (
+	// openTimeout bounds the wait for the file lock, another process keeping the file open fails the start
+	openTimeout = time.Second
+
+	// iteratePage is how many links Iterate reads per transaction, fn is never called inside one
+	iteratePage = 100
+)
+
+var (
+	// linksBucket maps link ids onto json encoded links
+	linksBucket = []byte("links")
+	// usersBucket keeps a bucket of link ids per user
+	usersBucket = []byte("users")
+	// urlsBucket maps canonical urls onto link ids
+	urlsBucket = []byte("urls")
+
+	// errFound stops a scan once the link is found
+	errFound = errors.New("found")
+)
+
+type boltRepo struct {
+	db *bbolt.DB
+}
+
+// NewRepo opens the bolt file, creating it with its buckets when missing
+func NewRepo(filePath string) (*boltRepo, error) {
+	db, err := bbolt.Open(filePath, 0600, &bbolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("open bolt file error: %w", err)
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{linksBucket, usersBucket, urlsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("create bolt buckets error: %w", err)
+	}
+
+	return &boltRepo{
+		db: db,
+	}, nil
+}
+
+// Add URL, a link whose canonical url is already stored is reported with the stored id
+func (r *boltRepo) Add(_ context.Context, link models.Link) error {
+	if link.CreatedAt.IsZero() {
+		link.CreatedAt = time.Now()
+	}
+
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		if doubleURLID := tx.Bucket(urlsBucket).Get([]byte(link.Canonical())); doubleURLID != nil {
+			return errs.NewNotUniqueURLErr(string(doubleURLID), link.OriginalURL, nil)
+		}
+
+		return put(tx, link)
+	})
+}
+
+// Get returns an active link
+func (r *boltRepo) Get(_ context.Context, urlID string) (models.Link, error) {
+	var link models.Link
+	err := r.db.View(func(tx *bbolt.Tx) (err error) {
+		link, err = get(tx, urlID)
+		return err
+	})
+	if err != nil {
+		return models.Link{}, err
+	}
+
+	if link.Deleted() {
+		return models.Link{}, errs.ErrURLNotFound
+	}
+
+	return link, nil
+}
+
+func (r *boltRepo) FetchURLs(_ context.Context, userID string) ([]models.UserURL, error) {
+	urls := make([]models.UserURL, 0)
+
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		userBucket := tx.Bucket(usersBucket).Bucket([]byte(userID))
+		if userBucket == nil {
+			return nil
+		}
+
+		return userBucket.ForEach(func(urlID, _ []byte) error {
+			link, err := get(tx, string(urlID))
+			if err != nil {
+				return err
+			}
+
+			if !link.Deleted() {
+				urls = append(urls, models.UserURL{
+					ShortURL:    link.ID,
+					OriginalURL: link.OriginalURL,
+				})
+			}
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return urls, nil
+}
+
+// IterateURLs calls fn for every active link of the user, a page of iteratePage links per transaction
+func (r *boltRepo) IterateURLs(ctx context.Context, userID string, fn func(url models.UserURL) error) error {
+	afterID := ""
+	for {
+		page := make([]models.UserURL, 0, iteratePage)
+		scanned := 0
+
+		err := r.db.View(func(tx *bbolt.Tx) error {
+			userBucket := tx.Bucket(usersBucket).Bucket([]byte(userID))
+			if userBucket == nil {
+				return nil
+			}
+
+			cursor := userBucket.Cursor()
+			key, _ := cursor.Seek([]byte(afterID))
+			if key != nil && string(key) == afterID {
+				key, _ = cursor.Next()
+			}
+
+			for ; key != nil && scanned < iteratePage; key, _ = cursor.Next() {
+				scanned++
+				afterID = string(key)
+
+				link, err := get(tx, afterID)
+				if err != nil {
+					return err
+				}
+
+				if !link.Deleted() {
+					page = append(page, models.UserURL{ShortURL: link.ID, OriginalURL: link.OriginalURL})
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for idx := range page {
+			if err = ctx.Err(); err != nil {
+				return err
+			}
+
+			if err = fn(page[idx]); err != nil {
+				return err
+			}
+		}
+
+		if scanned < iteratePage {
+			return nil
+		}
+	}
+}
+
+// AddBatch stores the links whose urls are not shortened yet in one transaction and reports the stored id for the others
+func (r *boltRepo) AddBatch(ctx context.Context, urls []models.UserURL, userID string) ([]models.BatchResult, error) {
+	results := make([]models.BatchResult, len(urls))
+	now := time.Now()
+
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		for idx := range urls {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			link := models.Link{
+				ID:            urls[idx].ShortURL,
+				OriginalURL:   urls[idx].OriginalURL,
+				NormalizedURL: urls[idx].NormalizedURL,
+				UserID:        userID,
+				RedirectCode:  urls[idx].RedirectCode,
+				Passthrough:   urls[idx].Passthrough,
+				CreatedAt:     now,
+			}
+
+			if doubleURLID := tx.Bucket(urlsBucket).Get([]byte(link.Canonical())); doubleURLID != nil {
+				results[idx] = models.BatchResult{ID: string(doubleURLID)}
+				continue
+			}
+
+			// the id is taken by another url, the result stays empty
+			if tx.Bucket(linksBucket).Get([]byte(link.ID)) != nil {
+				continue
+			}
+
+			if err := put(tx, link); err != nil {
+				return err
+			}
+			results[idx] = models.BatchResult{ID: link.ID, Created: true}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// Update replaces the settings the owner may edit: redirect code, passthrough, targeting rules and variants
+func (r *boltRepo) Update(_ context.Context, link models.Link) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		stored, err := get(tx, link.ID)
+		if err != nil {
+			return err
+		}
+
+		stored.RedirectCode = link.RedirectCode
+		stored.Passthrough = link.Passthrough
+		stored.Rules = link.Rules
+		stored.Variants = link.Variants
+		stored.Sticky = link.Sticky
+
+		return put(tx, stored)
+	})
+}
+
+// GetLink returns the link with its owner, deleted links included
+func (r *boltRepo) GetLink(_ context.Context, urlID string) (models.Link, error) {
+	var link models.Link
+	err := r.db.View(func(tx *bbolt.Tx) (err error) {
+		link, err = get(tx, urlID)
+		return err
+	})
+
+	return link, err
+}
+
+// FindByURL returns the link shortening the url given in its canonical or original form, deleted links included.
+// Only canonical urls are indexed, an original url is looked up by a scan.
+func (r *boltRepo) FindByURL(_ context.Context, url string) (models.Link, error) {
+	var link models.Link
+	err := r.db.View(func(tx *bbolt.Tx) (err error) {
+		if urlID := tx.Bucket(urlsBucket).Get([]byte(url)); urlID != nil {
+			link, err = get(tx, string(urlID))
+			return err
+		}
+
+		err = tx.Bucket(linksBucket).ForEach(func(_, value []byte) error {
+			var candidate models.Link
+			if err := json.Unmarshal(value, &candidate); err != nil {
+				return err
+			}
+
+			if candidate.OriginalURL == url {
+				link = candidate
+				return errFound
+			}
+
+			return nil
+		})
+		if errors.Is(err, errFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		return errs.ErrURLNotFound
+	})
+
+	return link, err
+}
+
+func (r *boltRepo) Delete(_ context.Context, urlID string) error {
+	return r.setDeletedAt(urlID, time.Now())
+}
+
+func (r *boltRepo) Restore(_ context.Context, urlID string) error {
+	return r.setDeletedAt(urlID, time.Time{})
+}
+
+// Import stores the link as is, keeping its id, owner and timestamps. Importing the same link again
+// refreshes its canonical url, owner and state.
+func (r *boltRepo) Import(_ context.Context, link models.Link) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		existing, err := get(tx, link.ID)
+		found := err == nil
+		switch {
+		case found && existing.OriginalURL != link.OriginalURL:
+			return errs.ErrURLIDConflict
+		case err != nil && !errors.Is(err, errs.ErrURLNotFound):
+			return err
+		}
+
+		if doubleURLID := tx.Bucket(urlsBucket).Get([]byte(link.Canonical())); doubleURLID != nil && string(doubleURLID) != link.ID {
+			return errs.NewNotUniqueURLErr(string(doubleURLID), link.OriginalURL, nil)
+		}
+
+		if found {
+			if err = remove(tx, existing); err != nil {
+				return err
+			}
+		}
+
+		return put(tx, link)
+	})
+}
+
+// Iterate calls fn for every link with id greater than afterID, deleted ones included, in ascending id order
+func (r *boltRepo) Iterate(ctx context.Context, afterID string, fn func(link models.Link) error) error {
+	for {
+		page := make([]models.Link, 0, iteratePage)
+
+		err := r.db.View(func(tx *bbolt.Tx) error {
+			cursor := tx.Bucket(linksBucket).Cursor()
+
+			key, value := cursor.Seek([]byte(afterID))
+			if key != nil && string(key) == afterID {
+				key, value = cursor.Next()
+			}
+
+			for ; key != nil && len(page) < iteratePage; key, value = cursor.Next() {
+				var link models.Link
+				if err := json.Unmarshal(value, &link); err != nil {
+					return fmt.Errorf("decode link %s error: %w", key, err)
+				}
+				page = append(page, link)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for idx := range page {
+			if err = ctx.Err(); err != nil {
+				return err
+			}
+
+			if err = fn(page[idx]); err != nil {
+				return err
+			}
+		}
+
+		if len(page) < iteratePage {
+			return nil
+		}
+
+		afterID = page[len(page)-1].ID
+	}
+}
+
+// Ping fails once the file is closed
+func (r *boltRepo) Ping(_ context.Context) error {
+	return r.db.View(func(_ *bbolt.Tx) error {
+		return nil
+	})
+}
+
+func (r *boltRepo) Close() error {
+	return r.db.Close()
+}
+
+func (r *boltRepo) setDeletedAt(urlID string, deletedAt time.Time) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		link, err := get(tx, urlID)
+		if err != nil {
+			return err
+		}
+
+		if link.Deleted() && !deletedAt.IsZero() {
+			return nil
+		}
+
+		link.DeletedAt = deletedAt
+
+		return put(tx, link)
+	})
+}
+
+func get(tx *bbolt.Tx, urlID string) (models.Link, error) {
+	value := tx.Bucket(linksBucket).Get([]byte(urlID))
+	if value == nil {
+		return models.Link{}, errs.ErrURLNotFound
+	}
+
+	var link models.Link
+	if err := json.Unmarshal(value, &link); err != nil {
+		return models.Link{}, fmt.Errorf("decode link %s error: %w", urlID, err)
+	}
+
+	return link, nil
+}
+
+// put writes the link together with its user and url index entries
+func put(tx *bbolt.Tx, link models.Link) error {
+	value, err := json.Marshal(link)
+	if err != nil {
+		return fmt.Errorf("encode link %s error: %w", link.ID, err)
+	}
+
+	if err = tx.Bucket(linksBucket).Put([]byte(link.ID), value); err != nil {
+		return err
+	}
+
+	// links imported from storages that didn't keep owners have none to index by
+	if link.UserID != "" {
+		userBucket, err := tx.Bucket(usersBucket).CreateBucketIfNotExists([]byte(link.UserID))
+		if err != nil {
+			return err
+		}
+
+		if err = userBucket.Put([]byte(link.ID), []byte{}); err != nil {
+			return err
+		}
+	}
+
+	return tx.Bucket(urlsBucket).Put([]byte(link.Canonical()), []byte(link.ID))
+}
+
+// remove drops the link and its index entries
+func remove(tx *bbolt.Tx, link models.Link) error {
+	if err := tx.Bucket(linksBucket).Delete([]byte(link.ID)); err != nil {
+		return err
+	}
+
+	if userBucket := tx.Bucket(usersBucket).Bucket([]byte(link.UserID)); userBucket != nil {
+		if err := userBucket.Delete([]byte(link.ID)); err != nil {
+			return err
+		}
+	}
+
+	urls := tx.Bucket(urlsBucket)
+	if string(urls.Get([]byte(link.Canonical()))) == link.ID {
+		return urls.Delete([]byte(link.Canonical()))
+	}
+
+	return nil
+}
@@ -0,0 +1,259 @@
+package bolt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ChristinaFomenko/shortener/internal/app/models"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+const defaultUserID = "user"
+
+func newRepo(t *testing.T) (*boltRepo, string) {
+	filePath := filepath.Join(t.TempDir(), "storage.db")
+
+	repo, err := NewRepo(filePath)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_ = repo.Close()
+	})
+
+	return repo, filePath
+}
+
+func TestBoltRepo_AddGet(t *testing.T) {
+	ctx := context.Background()
+	repo, filePath := newRepo(t)
+
+	err := repo.Add(ctx, models.Link{ID: "abc", OriginalURL: "https://Yandex.ru/", NormalizedURL: "https://yandex.ru", UserID: defaultUserID, RedirectCode: http.StatusMovedPermanently})
+	require.NoError(t, err)
+
+	err = repo.Add(ctx, models.Link{ID: "cba", OriginalURL: "https://yandex.ru", NormalizedURL: "https://yandex.ru", UserID: "other"})
+	var uniqueErr *errs.NotUniqueURLErr
+	require.True(t, errors.As(err, &uniqueErr))
+	assert.Equal(t, "abc", uniqueErr.URLID)
+
+	require.NoError(t, repo.Close())
+	repo, err = NewRepo(filePath)
+	require.NoError(t, err)
+	defer func() {
+		_ = repo.Close()
+	}()
+
+	act, err := repo.Get(ctx, "abc")
+	require.NoError(t, err)
+	assert.Equal(t, "https://Yandex.ru/", act.OriginalURL)
+	assert.Equal(t, http.StatusMovedPermanently, act.RedirectCode)
+	assert.False(t, act.CreatedAt.IsZero())
+
+	_, err = repo.Get(ctx, "cba")
+	assert.ErrorIs(t, err, errs.ErrURLNotFound)
+}
+
+func TestBoltRepo_FetchURLs(t *testing.T) {
+	ctx := context.Background()
+	repo, _ := newRepo(t)
+
+	require.NoError(t, repo.Add(ctx, models.Link{ID: "qwerty", OriginalURL: "yandex.ru", UserID: defaultUserID}))
+	require.NoError(t, repo.Add(ctx, models.Link{ID: "ytrewq", OriginalURL: "avito.ru", UserID: defaultUserID}))
+	require.NoError(t, repo.Add(ctx, models.Link{ID: "asdfgh", OriginalURL: "ozon.ru", UserID: "other"}))
+	require.NoError(t, repo.Delete(ctx, "ytrewq"))
+
+	act, err := repo.FetchURLs(ctx, defaultUserID)
+	require.NoError(t, err)
+	assert.Equal(t, []models.UserURL{{ShortURL: "qwerty", OriginalURL: "yandex.ru"}}, act)
+
+	act, err = repo.FetchURLs(ctx, "fake")
+	require.NoError(t, err)
+	assert.Len(t, act, 0)
+}
+
+func TestBoltRepo_IterateURLs(t *testing.T) {
+	ctx := context.Background()
+	repo, _ := newRepo(t)
+
+	for i := 0; i < iteratePage+5; i++ {
+		require.NoError(t, repo.Add(ctx, models.Link{ID: fmt.Sprintf("link%03d", i), OriginalURL: fmt.Sprintf("https://yandex.ru/%d", i), UserID: defaultUserID}))
+	}
+	require.NoError(t, repo.Add(ctx, models.Link{ID: "other", OriginalURL: "https://ozon.ru", UserID: "other"}))
+	require.NoError(t, repo.Delete(ctx, "link000"))
+
+	var act []models.UserURL
+	err := repo.IterateURLs(ctx, defaultUserID, func(url models.UserURL) error {
+		act = append(act, url)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, act, iteratePage+4)
+	assert.Equal(t, models.UserURL{ShortURL: "link001", OriginalURL: "https://yandex.ru/1"}, act[0])
+	assert.Equal(t, "link104", act[len(act)-1].ShortURL)
+
+	errStop := errors.New("stop")
+	calls := 0
+	err = repo.IterateURLs(ctx, defaultUserID, func(_ models.UserURL) error {
+		calls++
+		return errStop
+	})
+	assert.ErrorIs(t, err, errStop)
+	assert.Equal(t, 1, calls)
+}
+
+func TestBoltRepo_AddBatch(t *testing.T) {
+	ctx := context.Background()
+	repo, _ := newRepo(t)
+
+	require.NoError(t, repo.Add(ctx, models.Link{ID: "taken", OriginalURL: "ozon.ru", UserID: defaultUserID}))
+
+	act, err := repo.AddBatch(ctx, []models.UserURL{
+		{ShortURL: "first", OriginalURL: "yandex.ru", RedirectCode: http.StatusFound},
+		{ShortURL: "second", OriginalURL: "ozon.ru"},
+		{ShortURL: "third", OriginalURL: "yandex.ru"},
+		{ShortURL: "fourth", OriginalURL: "avito.ru", Passthrough: true},
+		{ShortURL: "taken", OriginalURL: "wildberries.ru"},
+	}, defaultUserID)
+	require.NoError(t, err)
+	assert.Equal(t, []models.BatchResult{
+		{ID: "first", Created: true},
+		{ID: "taken"},
+		{ID: "first"},
+		{ID: "fourth", Created: true},
+		{},
+	}, act)
+
+	link, err := repo.Get(ctx, "first")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusFound, link.RedirectCode)
+
+	_, err = repo.GetLink(ctx, "second")
+	assert.ErrorIs(t, err, errs.ErrURLNotFound)
+
+	link, err = repo.Get(ctx, "fourth")
+	require.NoError(t, err)
+	assert.True(t, link.Passthrough)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = repo.AddBatch(cancelled, []models.UserURL{{ShortURL: "fifth", OriginalURL: "mail.ru"}}, defaultUserID)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestBoltRepo_FindByURL(t *testing.T) {
+	ctx := context.Background()
+	repo, _ := newRepo(t)
+
+	require.NoError(t, repo.Add(ctx, models.Link{ID: "abc", OriginalURL: "https://Yandex.ru/?utm_source=x", NormalizedURL: "https://yandex.ru", UserID: defaultUserID}))
+
+	for _, url := range []string{"https://yandex.ru", "https://Yandex.ru/?utm_source=x"} {
+		act, err := repo.FindByURL(ctx, url)
+		require.NoError(t, err)
+		assert.Equal(t, "abc", act.ID)
+	}
+
+	_, err := repo.FindByURL(ctx, "https://avito.ru")
+	assert.ErrorIs(t, err, errs.ErrURLNotFound)
+}
+
+func TestBoltRepo_DeleteRestore(t *testing.T) {
+	ctx := context.Background()
+	repo, _ := newRepo(t)
+
+	require.NoError(t, repo.Add(ctx, models.Link{ID: "qwerty", OriginalURL: "yandex.ru", UserID: defaultUserID}))
+	require.NoError(t, repo.Delete(ctx, "qwerty"))
+
+	_, err := repo.Get(ctx, "qwerty")
+	assert.ErrorIs(t, err, errs.ErrURLNotFound)
+
+	link, err := repo.GetLink(ctx, "qwerty")
+	require.NoError(t, err)
+	assert.True(t, link.Deleted())
+
+	require.NoError(t, repo.Restore(ctx, "qwerty"))
+	_, err = repo.Get(ctx, "qwerty")
+	assert.NoError(t, err)
+
+	assert.ErrorIs(t, repo.Delete(ctx, "missing"), errs.ErrURLNotFound)
+}
+
+func TestBoltRepo_Import(t *testing.T) {
+	ctx := context.Background()
+	repo, _ := newRepo(t)
+
+	link := models.Link{ID: "abc", OriginalURL: "yandex.ru", UserID: defaultUserID}
+	require.NoError(t, repo.Import(ctx, link))
+
+	// a second import moves the link to its new owner
+	link.UserID = "other"
+	require.NoError(t, repo.Import(ctx, link))
+
+	urls, err := repo.FetchURLs(ctx, defaultUserID)
+	require.NoError(t, err)
+	assert.Empty(t, urls)
+
+	urls, err = repo.FetchURLs(ctx, "other")
+	require.NoError(t, err)
+	assert.Len(t, urls, 1)
+
+	assert.ErrorIs(t, repo.Import(ctx, models.Link{ID: "abc", OriginalURL: "avito.ru"}), errs.ErrURLIDConflict)
+
+	var uniqueErr *errs.NotUniqueURLErr
+	assert.True(t, errors.As(repo.Import(ctx, models.Link{ID: "cba", OriginalURL: "yandex.ru"}), &uniqueErr))
+
+	// importing again moves the link to its new canonical url unless another link holds it
+	require.NoError(t, repo.Import(ctx, models.Link{ID: "cba", OriginalURL: "https://avito.ru", UserID: "other"}))
+	assert.True(t, errors.As(repo.Import(ctx, models.Link{ID: "abc", OriginalURL: "yandex.ru", NormalizedURL: "https://avito.ru", UserID: "other"}), &uniqueErr))
+	assert.Equal(t, "cba", uniqueErr.URLID)
+
+	link.NormalizedURL = "https://yandex.ru"
+	require.NoError(t, repo.Import(ctx, link))
+	found, err := repo.FindByURL(ctx, "https://yandex.ru")
+	require.NoError(t, err)
+	assert.Equal(t, "abc", found.ID)
+	_, err = repo.FindByURL(ctx, "yandex.ru")
+	require.NoError(t, err)
+}
+
+func TestBoltRepo_Iterate(t *testing.T) {
+	ctx := context.Background()
+	repo, _ := newRepo(t)
+
+	ids := make([]string, 0, 2*iteratePage+1)
+	for i := 0; i < 2*iteratePage+1; i++ {
+		id := string(rune('a'+i/26/26%26)) + string(rune('a'+i/26%26)) + string(rune('a'+i%26))
+		ids = append(ids, id)
+		require.NoError(t, repo.Add(ctx, models.Link{ID: id, OriginalURL: "https://example.com/" + id, UserID: defaultUserID}))
+	}
+	require.NoError(t, repo.Delete(ctx, ids[0]))
+
+	var act []string
+	err := repo.Iterate(ctx, "", func(link models.Link) error {
+		act = append(act, link.ID)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, ids, act)
+
+	act = nil
+	err = repo.Iterate(ctx, ids[150], func(link models.Link) error {
+		act = append(act, link.ID)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, ids[151:], act)
+}
+
+func TestBoltRepo_Ping(t *testing.T) {
+	ctx := context.Background()
+	repo, _ := newRepo(t)
+
+	assert.NoError(t, repo.Ping(ctx))
+
+	require.NoError(t, repo.Close())
+	assert.Error(t, repo.Ping(ctx))
+}
@@ -0,0 +1,169 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"github.com/ChristinaFomenko/shortener/internal/app/invalidation"
+	"github.com/ChristinaFomenko/shortener/internal/app/models"
+	repositoryURL "github.com/ChristinaFomenko/shortener/internal/app/repository/urls"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	"time"
+)
+
+type repository struct {
+	repositoryURL.Repo
+	circuit  *circuit
+	snapshot *snapshot
+}
+
+// NewRepo decorates the repository with a circuit breaker: after threshold storage failures in a row
+// calls fail with errs.UnavailableErr without waiting on the storage until a probe after cooldown succeeds.
+// Meanwhile Get answers from a snapshot of the snapshotSize links expanded last.
+// Ping, Iterate and Close always reach the storage.
+func NewRepo(repo repositoryURL.Repo, threshold int, cooldown time.Duration, snapshotSize int) *repository {
+	return &repository{
+		Repo:     repo,
+		circuit:  newCircuit(threshold, cooldown),
+		snapshot: newSnapshot(snapshotSize),
+	}
+}
+
+// Invalidate drops changed links from the snapshot, subscribe it to the invalidation bus
+func (r *repository) Invalidate(event invalidation.Event) {
+	if event.Type == invalidation.EventReset {
+		r.snapshot.clear()
+		return
+	}
+
+	r.snapshot.remove(event.URLID)
+}
+
+// Open reports whether calls are turned away, a probe on its way included
+func (r *repository) Open() bool {
+	return r.circuit.open()
+}
+
+// Get falls back on the snapshot while the storage fails
+func (r *repository) Get(ctx context.Context, urlID string) (models.Link, error) {
+	ok, retryAfter := r.circuit.allow()
+	if !ok {
+		if link, found := r.snapshot.get(urlID); found {
+			return link, nil
+		}
+
+		return models.Link{}, errs.NewUnavailableErr(retryAfter)
+	}
+
+	link, err := r.Repo.Get(ctx, urlID)
+	r.circuit.done(err)
+
+	switch {
+	case err == nil:
+		r.snapshot.put(link)
+	case errors.Is(err, errs.ErrURLNotFound):
+		r.snapshot.remove(urlID)
+	case failure(err):
+		if link, found := r.snapshot.get(urlID); found {
+			return link, nil
+		}
+	}
+
+	return link, err
+}
+
+func (r *repository) Add(ctx context.Context, link models.Link) error {
+	return r.call(func() error {
+		return r.Repo.Add(ctx, link)
+	})
+}
+
+func (r *repository) FetchURLs(ctx context.Context, userID string) (urls []models.UserURL, err error) {
+	err = r.call(func() error {
+		urls, err = r.Repo.FetchURLs(ctx, userID)
+		return err
+	})
+
+	return urls, err
+}
+
+// IterateURLs counts the failures of the storage only, an error of fn tells nothing about it
+func (r *repository) IterateURLs(ctx context.Context, userID string, fn func(url models.UserURL) error) error {
+	if ok, retryAfter := r.circuit.allow(); !ok {
+		return errs.NewUnavailableErr(retryAfter)
+	}
+
+	var fnErr error
+	err := r.Repo.IterateURLs(ctx, userID, func(url models.UserURL) error {
+		fnErr = fn(url)
+		return fnErr
+	})
+	if fnErr != nil {
+		r.circuit.done(nil)
+		return err
+	}
+	r.circuit.done(err)
+
+	return err
+}
+
+func (r *repository) AddBatch(ctx context.Context, urls []models.UserURL, userID string) (results []models.BatchResult, err error) {
+	err = r.call(func() error {
+		results, err = r.Repo.AddBatch(ctx, urls, userID)
+		return err
+	})
+
+	return results, err
+}
+
+func (r *repository) Update(ctx context.Context, link models.Link) error {
+	return r.call(func() error {
+		return r.Repo.Update(ctx, link)
+	})
+}
+
+func (r *repository) GetLink(ctx context.Context, urlID string) (link models.Link, err error) {
+	err = r.call(func() error {
+		link, err = r.Repo.GetLink(ctx, urlID)
+		return err
+	})
+
+	return link, err
+}
+
+func (r *repository) FindByURL(ctx context.Context, url string) (link models.Link, err error) {
+	err = r.call(func() error {
+		link, err = r.Repo.FindByURL(ctx, url)
+		return err
+	})
+
+	return link, err
+}
+
+func (r *repository) Delete(ctx context.Context, urlID string) error {
+	return r.call(func() error {
+		return r.Repo.Delete(ctx, urlID)
+	})
+}
+
+func (r *repository) Restore(ctx context.Context, urlID string) error {
+	return r.call(func() error {
+		return r.Repo.Restore(ctx, urlID)
+	})
+}
+
+func (r *repository) Import(ctx context.Context, link models.Link) error {
+	return r.call(func() error {
+		return r.Repo.Import(ctx, link)
+	})
+}
+
+func (r *repository) call(fn func() error) error {
+	if ok, retryAfter := r.circuit.allow(); !ok {
+		return errs.NewUnavailableErr(retryAfter)
+	}
+
+	err := fn()
+	r.circuit.done(err)
+
+	return err
+}
@@ -0,0 +1,163 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"github.com/ChristinaFomenko/shortener/internal/app/invalidation"
+	"github.com/ChristinaFomenko/shortener/internal/app/models"
+	repositoryURL "github.com/ChristinaFomenko/shortener/internal/app/repository/urls"
+	"github.com/ChristinaFomenko/shortener/internal/app/repository/urls/memory"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+var errDown = errors.New("connection refused")
+
+// flakyRepo fails every call reaching it while down
+type flakyRepo struct {
+	repositoryURL.Repo
+	down  bool
+	calls int
+}
+
+func (r *flakyRepo) Get(ctx context.Context, urlID string) (models.Link, error) {
+	r.calls++
+	if r.down {
+		return models.Link{}, errDown
+	}
+
+	return r.Repo.Get(ctx, urlID)
+}
+
+func (r *flakyRepo) Add(ctx context.Context, link models.Link) error {
+	r.calls++
+	if r.down {
+		return errDown
+	}
+
+	return r.Repo.Add(ctx, link)
+}
+
+func TestRepository_Breaker(t *testing.T) {
+	ctx := context.Background()
+
+	storage := &flakyRepo{Repo: memory.NewRepo()}
+	repo := NewRepo(storage, 3, 10*time.Second, 10)
+
+	now := time.Now()
+	repo.circuit.now = func() time.Time {
+		return now
+	}
+
+	require.NoError(t, repo.Add(ctx, models.Link{ID: "warm", OriginalURL: "https://yandex.ru", UserID: "user"}))
+	require.NoError(t, repo.Add(ctx, models.Link{ID: "cold", OriginalURL: "https://ozon.ru", UserID: "user"}))
+	_, err := repo.Get(ctx, "warm")
+	require.NoError(t, err)
+
+	// answers about the data are no failures
+	for i := 0; i < 5; i++ {
+		_, err = repo.Get(ctx, "missing")
+		assert.ErrorIs(t, err, errs.ErrURLNotFound)
+	}
+
+	storage.down = true
+
+	// failing calls still get the snapshot
+	link, err := repo.Get(ctx, "warm")
+	require.NoError(t, err)
+	assert.Equal(t, "https://yandex.ru", link.OriginalURL)
+
+	_, err = repo.Get(ctx, "cold")
+	assert.ErrorIs(t, err, errDown)
+	assert.ErrorIs(t, repo.Add(ctx, models.Link{ID: "new", OriginalURL: "https://avito.ru"}), errDown)
+
+	// the circuit is open, nothing reaches the storage
+	assert.True(t, repo.Open())
+	calls := storage.calls
+
+	link, err = repo.Get(ctx, "warm")
+	require.NoError(t, err)
+	assert.Equal(t, "https://yandex.ru", link.OriginalURL)
+
+	_, err = repo.Get(ctx, "cold")
+	var unavailableErr *errs.UnavailableErr
+	require.True(t, errors.As(err, &unavailableErr))
+	assert.Equal(t, 10*time.Second, unavailableErr.RetryAfter)
+
+	assert.ErrorIs(t, repo.Add(ctx, models.Link{ID: "new", OriginalURL: "https://avito.ru"}), errs.ErrStorageUnavailable)
+	assert.Equal(t, calls, storage.calls)
+
+	// a failed probe opens the circuit for another cooldown
+	now = now.Add(10 * time.Second)
+	_, err = repo.Get(ctx, "cold")
+	assert.ErrorIs(t, err, errDown)
+	assert.Equal(t, calls+1, storage.calls)
+
+	_, err = repo.Get(ctx, "cold")
+	assert.ErrorIs(t, err, errs.ErrStorageUnavailable)
+
+	// a successful probe closes it
+	storage.down = false
+	now = now.Add(10 * time.Second)
+
+	link, err = repo.Get(ctx, "cold")
+	require.NoError(t, err)
+	assert.Equal(t, "https://ozon.ru", link.OriginalURL)
+	assert.NoError(t, repo.Add(ctx, models.Link{ID: "new", OriginalURL: "https://avito.ru"}))
+	assert.False(t, repo.Open())
+}
+
+func TestRepository_Invalidate(t *testing.T) {
+	ctx := context.Background()
+
+	storage := &flakyRepo{Repo: memory.NewRepo()}
+	repo := NewRepo(storage, 1, time.Minute, 10)
+
+	for _, link := range []models.Link{
+		{ID: "first", OriginalURL: "https://yandex.ru", UserID: "user"},
+		{ID: "second", OriginalURL: "https://ozon.ru", UserID: "user"},
+	} {
+		require.NoError(t, repo.Add(ctx, link))
+		_, err := repo.Get(ctx, link.ID)
+		require.NoError(t, err)
+	}
+
+	storage.down = true
+
+	repo.Invalidate(invalidation.Event{Type: invalidation.EventDelete, URLID: "first"})
+
+	_, err := repo.Get(ctx, "first")
+	assert.Error(t, err)
+
+	_, err = repo.Get(ctx, "second")
+	assert.NoError(t, err)
+
+	repo.Invalidate(invalidation.Event{Type: invalidation.EventReset})
+
+	_, err = repo.Get(ctx, "second")
+	assert.ErrorIs(t, err, errs.ErrStorageUnavailable)
+}
+
+func TestSnapshot_Evicts(t *testing.T) {
+	s := newSnapshot(2)
+
+	s.put(models.Link{ID: "first"})
+	s.put(models.Link{ID: "second"})
+
+	// a read makes first the most recent
+	_, ok := s.get("first")
+	require.True(t, ok)
+
+	s.put(models.Link{ID: "third"})
+
+	_, ok = s.get("second")
+	assert.False(t, ok)
+
+	for _, urlID := range []string{"first", "third"} {
+		_, ok = s.get(urlID)
+		assert.True(t, ok)
+	}
+}
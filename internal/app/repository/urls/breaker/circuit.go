@@ -0,0 +1,115 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// probeWait is the retry hint for the calls turned away while a probe is on its way
+const probeWait = time.Second
+
+const (
+	stateClosed = iota
+	stateOpen
+	stateHalfOpen
+)
+
+// circuit opens after threshold storage failures in a row and turns calls away for cooldown,
+// then lets a single call through as a probe: its success closes the circuit, its failure opens it again
+type circuit struct {
+	mu        sync.Mutex
+	state     int
+	failures  int
+	openedAt  time.Time
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+}
+
+func newCircuit(threshold int, cooldown time.Duration) *circuit {
+	return &circuit{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+	}
+}
+
+// allow reports whether the call may go to the storage, otherwise how long until the next probe
+func (c *circuit) allow() (bool, time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch c.state {
+	case stateOpen:
+		wait := c.openedAt.Add(c.cooldown).Sub(c.now())
+		if wait > 0 {
+			return false, wait
+		}
+
+		c.state = stateHalfOpen
+		return true, 0
+	case stateHalfOpen:
+		return false, probeWait
+	}
+
+	return true, 0
+}
+
+func (c *circuit) open() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.state != stateClosed
+}
+
+// done records the outcome of a call allowed through
+func (c *circuit) done(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		// the caller is gone, the call tells nothing about the storage; a probe is sent again
+		if c.state == stateHalfOpen {
+			c.state = stateOpen
+		}
+	case failure(err):
+		c.failures++
+		if c.state == stateHalfOpen || (c.state == stateClosed && c.failures >= c.threshold) {
+			if c.state == stateClosed {
+				log.WithError(err).WithField("failures", c.failures).Warn("storage circuit opened")
+			}
+			c.state = stateOpen
+			c.openedAt = c.now()
+		}
+	default:
+		// calls let through before the circuit opened don't close it, only the probe does
+		if c.state == stateOpen {
+			return
+		}
+		if c.state == stateHalfOpen {
+			log.Info("storage circuit closed")
+		}
+		c.state = stateClosed
+		c.failures = 0
+	}
+}
+
+// failure tells storage errors from answers about the data
+func failure(err error) bool {
+	var notUniqueErr *errs.NotUniqueURLErr
+	switch {
+	case err == nil,
+		errors.Is(err, errs.ErrURLNotFound),
+		errors.Is(err, errs.ErrURLIDConflict),
+		errors.As(err, &notUniqueErr),
+		errors.Is(err, context.Canceled):
+		return false
+	}
+
+	return true
+}
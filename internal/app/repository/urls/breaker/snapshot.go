@@ -0,0 +1,78 @@
+package breaker
+
+import (
+	"container/list"
+	"github.com/ChristinaFomenko/shortener/internal/app/models"
+	"sync"
+)
+
+// snapshot keeps the links most recently expanded, the least recent one goes first when it is full
+type snapshot struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List
+	links map[string]*list.Element
+}
+
+func newSnapshot(size int) *snapshot {
+	return &snapshot{
+		size:  size,
+		order: list.New(),
+		links: make(map[string]*list.Element, size),
+	}
+}
+
+func (s *snapshot) get(urlID string) (models.Link, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	element, ok := s.links[urlID]
+	if !ok {
+		return models.Link{}, false
+	}
+
+	s.order.MoveToFront(element)
+
+	return element.Value.(models.Link), true
+}
+
+func (s *snapshot) put(link models.Link) {
+	if s.size <= 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if element, ok := s.links[link.ID]; ok {
+		element.Value = link
+		s.order.MoveToFront(element)
+		return
+	}
+
+	s.links[link.ID] = s.order.PushFront(link)
+
+	if s.order.Len() > s.size {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.links, oldest.Value.(models.Link).ID)
+	}
+}
+
+func (s *snapshot) remove(urlID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if element, ok := s.links[urlID]; ok {
+		s.order.Remove(element)
+		delete(s.links, urlID)
+	}
+}
+
+func (s *snapshot) clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.order.Init()
+	s.links = make(map[string]*list.Element, s.size)
+}
@@ -0,0 +1,127 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/ChristinaFomenko/shortener/internal/app/invalidation"
+	"github.com/jackc/pgx/v4/pgxpool"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+const (
+	// channel carries the invalidation events between the instances sharing the database
+	channel = "shortener_links"
+
+	// busConns is one connection listening and one notifying
+	busConns = 2
+
+	reconnectDelay = time.Second
+)
+
+type pgBus struct {
+	invalidation.Subscribers
+	pool    *pgxpool.Pool
+	timeout time.Duration
+}
+
+// NewBus sends the events with NOTIFY and receives them with LISTEN on a pool of its own,
+// dsn is the storage url and the query timeout of the options bounds publishing
+func NewBus(dsn string, opts Options) (*pgBus, error) {
+	config, opts, err := parseConfig(dsn, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// lifetimes only apply to idle connections, the listening one is kept for as long as it works
+	config.MaxConns = busConns
+	config.MinConns = 0
+	config.LazyConnect = true
+
+	pool, err := pgxpool.ConnectConfig(context.Background(), config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pgBus{
+		pool:    pool,
+		timeout: opts.QueryTimeout,
+	}, nil
+}
+
+// Publish notifies every instance listening, this one included, once the call returns
+func (b *pgBus) Publish(ctx context.Context, event invalidation.Event) error {
+	ctx, cancel := context.WithTimeout(ctx, b.timeout)
+	defer cancel()
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = b.pool.Exec(ctx, `select pg_notify($1, $2)`, channel, string(payload))
+
+	return err
+}
+
+// Run listens until ctx is done. A lost connection is opened again and followed by a reset event,
+// whatever was sent in between is missed.
+func (b *pgBus) Run(ctx context.Context) {
+	reconnect := false
+	for {
+		err := b.listen(ctx, reconnect)
+		if ctx.Err() != nil {
+			return
+		}
+
+		log.WithError(err).Warn("invalidation listener stopped, reconnecting")
+		reconnect = true
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(reconnectDelay):
+		}
+	}
+}
+
+func (b *pgBus) Close() error {
+	b.pool.Close()
+	return nil
+}
+
+func (b *pgBus) listen(ctx context.Context, reconnect bool) error {
+	conn, err := b.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	// a listening connection must not go back to the pool, closed ones are dropped on release
+	defer func(conn *pgxpool.Conn) {
+		_ = conn.Conn().Close(context.Background())
+		conn.Release()
+	}(conn)
+
+	if _, err = conn.Exec(ctx, `listen `+channel); err != nil {
+		return err
+	}
+
+	if reconnect {
+		b.Notify(invalidation.Event{Type: invalidation.EventReset})
+	}
+
+	for {
+		notification, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		var event invalidation.Event
+		if err = json.Unmarshal([]byte(notification.Payload), &event); err != nil {
+			log.WithError(err).WithField("payload", notification.Payload).Error("decode invalidation event error")
+			continue
+		}
+
+		b.Notify(event)
+	}
+}
@@ -0,0 +1,52 @@
+package database
+
+import (
+	"context"
+	"github.com/ChristinaFomenko/shortener/internal/app/invalidation"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPgBus_PublishSubscribe(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// two instances, each hears its own events and the other's
+	received := make([]chan invalidation.Event, 2)
+	buses := make([]*pgBus, 2)
+	for idx := range buses {
+		bus, err := NewBus(dsn, DefaultOptions())
+		require.NoError(t, err)
+		defer func() {
+			_ = bus.Close()
+		}()
+
+		events := make(chan invalidation.Event, 10)
+		bus.Subscribe(func(event invalidation.Event) {
+			events <- event
+		})
+		go bus.Run(ctx)
+
+		received[idx] = events
+		buses[idx] = bus
+	}
+
+	// LISTEN is issued by Run, publish until both listen
+	exp := invalidation.Event{Type: invalidation.EventDelete, URLID: "abc"}
+	require.Eventually(t, func() bool {
+		require.NoError(t, buses[0].Publish(ctx, exp))
+		return len(received[0]) > 0 && len(received[1]) > 0
+	}, 5*time.Second, 100*time.Millisecond)
+
+	for _, events := range received {
+		assert.Equal(t, exp, <-events)
+	}
+}
@@ -2,103 +2,428 @@ package database
 
 import (
 	"context"
-	"database/sql"
+	"encoding/json"
 	"errors"
+	"github.com/ChristinaFomenko/shortener/internal/app/consistency"
 	"github.com/ChristinaFomenko/shortener/internal/app/models"
 	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	"github.com/jackc/pgconn"
 	"github.com/jackc/pgerrcode"
-	"github.com/lib/pq"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"math/rand"
 	"time"
 )
 
-const timeout = time.Second * 3
-
-type database interface {
-	PingContext(ctx context.Context) error
-	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
-	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
-	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
-	Close() error
-	Begin() (*sql.Tx, error)
-}
+// urlsPage is how many links of a user IterateURLs reads per query
+const urlsPage = 1000
 
 type pgRepo struct {
-	db database
+	pool     *pgxpool.Pool
+	timeout  time.Duration
+	attempts int
+	jitter   func(n int64) int64
+	replicas []*replica
+	next     uint32
+	written  *recentWrites
+	done     chan struct{}
 }
 
-func NewRepo(dsn string) (*pgRepo, error) {
-	db, err := sql.Open("postgres", dsn)
+// NewRepo connects a pool sized by the options, see parseConfig. Get and FetchURLs read from
+// the replicas when there are any, everything else goes to the primary.
+func NewRepo(dsn string, opts Options) (*pgRepo, error) {
+	config, opts, err := parseConfig(dsn, opts)
 	if err != nil {
 		return nil, err
 	}
 
-	db.SetMaxOpenConns(20)
-	db.SetMaxIdleConns(20)
-	db.SetConnMaxIdleTime(time.Second * 30)
-	db.SetConnMaxLifetime(time.Minute * 2)
+	ctx, cancel := context.WithTimeout(context.Background(), opts.QueryTimeout)
+	defer cancel()
 
-	_, err = db.Exec(query)
+	pool, err := pgxpool.ConnectConfig(ctx, config)
 	if err != nil {
 		return nil, err
 	}
 
-	return &pgRepo{
-		db: db,
-	}, nil
+	// bringing a large table up to date takes far longer than a query
+	migrateCtx, cancelMigrate := context.WithTimeout(context.Background(), opts.MigrationTimeout)
+	defer cancelMigrate()
+
+	if _, err = pool.Exec(migrateCtx, query); err != nil {
+		pool.Close()
+		return nil, err
+	}
+
+	replicas, err := connectReplicas(opts.Replicas, opts)
+	if err != nil {
+		pool.Close()
+		return nil, err
+	}
+
+	r := &pgRepo{
+		pool:     pool,
+		timeout:  opts.QueryTimeout,
+		attempts: opts.RetryAttempts,
+		jitter:   rand.Int63n,
+		replicas: replicas,
+		written:  newRecentWrites(opts.ReadYourWrites),
+		done:     make(chan struct{}),
+	}
+
+	if len(replicas) > 0 {
+		go r.watchReplicas()
+	}
+
+	return r, nil
 }
 
-func (r *pgRepo) Add(ctx context.Context, urlID, url, userID string) error {
-	ctx, cancel := context.WithTimeout(ctx, timeout)
+// Add URL, a link whose canonical url is already stored is reported with the stored id
+func (r *pgRepo) Add(ctx context.Context, link models.Link) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
 	defer cancel()
 
-	_, err := r.db.ExecContext(ctx, `insert into urls(id,url,user_id) values ($1,$2,$3)`,
-		urlID,
-		url,
-		&userID)
+	rules, variants, err := encodeTargets(link)
 	if err != nil {
-		var pqErr *pq.Error
-		if errors.As(err, &pqErr) && pqErr.Code == pgerrcode.UniqueViolation {
-			err = r.db.QueryRowContext(ctx, "select id from urls where url=$1", url).Scan(&urlID)
+		return err
+	}
+
+	err = r.retry(ctx, func() error {
+		_, err := r.pool.Exec(ctx, `insert into urls(id,url,normalized_url,user_id,redirect_code,passthrough,rules,variants,sticky)
+			values ($1,$2,$3,$4,$5,$6,$7,$8,$9)`,
+			link.ID,
+			link.OriginalURL,
+			link.Canonical(),
+			link.UserID,
+			link.RedirectCode,
+			link.Passthrough,
+			rules,
+			variants,
+			link.Sticky)
+		constraint, ok := uniqueViolation(err)
+		if !ok {
+			return err
+		}
+
+		if constraint == idIndex {
+			stored, err := scanLink(r.pool.QueryRow(ctx, `select `+linkColumns+` from urls where id=$1`, link.ID))
 			if err != nil {
 				return err
 			}
-			err = errs.NewNotUniqueURLErr(urlID, url, err)
+
+			// an attempt whose answer was lost has stored the link already
+			if stored.Canonical() == link.Canonical() {
+				return nil
+			}
+
+			return errs.ErrURLIDConflict
+		}
+
+		var urlID string
+		if err = r.pool.QueryRow(ctx, "select id from urls where normalized_url=$1", link.Canonical()).Scan(&urlID); err != nil {
 			return err
 		}
 
+		// an attempt whose answer was lost has stored the link already
+		if urlID == link.ID {
+			return nil
+		}
+
+		return errs.NewNotUniqueURLErr(urlID, link.OriginalURL, nil)
+	})
+
+	return r.wrote(ctx, err, linkKey(link.ID), userKey(link.UserID))
+}
+
+// Get returns an active link
+func (r *pgRepo) Get(ctx context.Context, urlID string) (link models.Link, err error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	err = r.retry(ctx, func() error {
+		rep := r.reader(ctx, linkKey(urlID))
+		link, err = scanLink(r.readPool(rep).QueryRow(ctx, `select `+linkColumns+` from urls where id=$1 and deleted_at is null`, urlID))
+		r.readDone(rep, err)
+		return err
+	})
+
+	return link, err
+}
+
+func (r *pgRepo) FetchURLs(ctx context.Context, userID string) (urls []models.UserURL, err error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	err = r.retry(ctx, func() error {
+		rep := r.reader(ctx, userKey(userID))
+		urls, err = r.fetchURLs(ctx, r.readPool(rep), userID)
+		r.readDone(rep, err)
 		return err
+	})
+
+	return urls, err
+}
+
+// IterateURLs calls fn for every active link of the user, a page of urlsPage links per query,
+// so neither the links nor a connection are held while fn runs
+func (r *pgRepo) IterateURLs(ctx context.Context, userID string, fn func(url models.UserURL) error) error {
+	afterID := ""
+	for {
+		page, err := r.urlsPage(ctx, userID, afterID)
+		if err != nil {
+			return err
+		}
+
+		for idx := range page {
+			if err = fn(page[idx]); err != nil {
+				return err
+			}
+		}
+
+		if len(page) < urlsPage {
+			return nil
+		}
+
+		afterID = page[len(page)-1].ShortURL
 	}
+}
+
+func (r *pgRepo) urlsPage(ctx context.Context, userID, afterID string) (page []models.UserURL, err error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	err = r.retry(ctx, func() error {
+		rep := r.reader(ctx, userKey(userID))
+		page, err = collectURLs(r.readPool(rep).Query(ctx, `select id, url from urls
+			where user_id=$1 and deleted_at is null and id > $2 collate "C" order by id collate "C" limit $3`, userID, afterID, urlsPage))
+		r.readDone(rep, err)
+		return err
+	})
 
+	return page, err
+}
+
+// Ping is never retried, it reports the state of the database as it is
+func (r *pgRepo) Ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	return r.pool.Ping(ctx)
+}
+
+func (r *pgRepo) Close() error {
+	close(r.done)
+	closeReplicas(r.replicas)
+	r.pool.Close()
 	return nil
+}
+
+// AddBatch copies the links into a staging table and moves the ones whose urls are not shortened yet
+// into urls with a single statement, the others are reported with the stored id. The batch is retried whole,
+// links stored by an attempt whose answer was lost are reported as created.
+func (r *pgRepo) AddBatch(ctx context.Context, urls []models.UserURL, userID string) (results []models.BatchResult, err error) {
+	err = r.retry(ctx, func() error {
+		results, err = r.addBatch(ctx, urls, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
 
+	keys := []string{userKey(userID)}
+	for idx := range results {
+		if results[idx].Created {
+			keys = append(keys, linkKey(results[idx].ID))
+		}
+	}
+	r.written.mark(keys...)
+	consistency.Wrote(ctx)
+
+	return results, nil
 }
 
-func (r *pgRepo) Get(ctx context.Context, urlID string) (string, error) {
-	ctx, cancel := context.WithTimeout(ctx, timeout)
+// Update replaces the settings the owner may edit: redirect code, passthrough, targeting rules and variants
+func (r *pgRepo) Update(ctx context.Context, link models.Link) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
 	defer cancel()
 
-	var url sql.NullString
-	_ = r.db.QueryRowContext(ctx, `select url from urls where id=$1 and deleted_at is null`, urlID).Scan(&url)
-	if url.Valid {
-		return url.String, nil
+	rules, variants, err := encodeTargets(link)
+	if err != nil {
+		return err
 	}
 
-	return "", errs.ErrURLNotFound
+	err = r.retry(ctx, func() error {
+		res, err := r.pool.Exec(ctx, `update urls set redirect_code=$2, passthrough=$3, rules=$4, variants=$5, sticky=$6 where id=$1`,
+			link.ID,
+			link.RedirectCode,
+			link.Passthrough,
+			rules,
+			variants,
+			link.Sticky)
+		if err != nil {
+			return err
+		}
+
+		return checkAffected(res)
+	})
+
+	return r.wrote(ctx, err, linkKey(link.ID))
 }
 
-func (r *pgRepo) FetchURLs(ctx context.Context, userID string) ([]models.UserURL, error) {
-	ctx, cancel := context.WithTimeout(ctx, timeout)
+// GetLink returns the link with its owner, deleted links included
+func (r *pgRepo) GetLink(ctx context.Context, urlID string) (link models.Link, err error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
 	defer cancel()
 
-	res := make([]models.UserURL, 0)
-	rows, err := r.db.QueryContext(ctx, `select id, url from urls where user_id=$1 and deleted_at is null;`, userID)
+	err = r.retry(ctx, func() error {
+		link, err = scanLink(r.pool.QueryRow(ctx, `select `+linkColumns+` from urls where id=$1`, urlID))
+		return err
+	})
+
+	return link, err
+}
+
+// FindByURL returns the link shortening the url given in its canonical or original form, deleted links included
+func (r *pgRepo) FindByURL(ctx context.Context, url string) (link models.Link, err error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	err = r.retry(ctx, func() error {
+		link, err = scanLink(r.pool.QueryRow(ctx, `select `+linkColumns+` from urls where normalized_url=$1 or url=$1 order by normalized_url=$1 desc limit 1`, url))
+		return err
+	})
+
+	return link, err
+}
+
+// Delete keeps the time of the first deletion, deleting again changes nothing
+func (r *pgRepo) Delete(ctx context.Context, urlID string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	var userID string
+	err := r.retry(ctx, func() error {
+		err := r.pool.QueryRow(ctx, `update urls set deleted_at=coalesce(deleted_at, now()) where id=$1 returning user_id`, urlID).Scan(&userID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return errs.ErrURLNotFound
+		}
+		return err
+	})
+
+	return r.wrote(ctx, err, linkKey(urlID), userKey(userID))
+}
+
+func (r *pgRepo) Restore(ctx context.Context, urlID string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	var userID string
+	err := r.retry(ctx, func() error {
+		err := r.pool.QueryRow(ctx, `update urls set deleted_at=null where id=$1 returning user_id`, urlID).Scan(&userID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return errs.ErrURLNotFound
+		}
+		return err
+	})
+
+	return r.wrote(ctx, err, linkKey(urlID), userKey(userID))
+}
+
+// Import stores the link as is, keeping its id, owner and timestamps. Importing the same link again
+// refreshes its canonical url, owner and state.
+func (r *pgRepo) Import(ctx context.Context, link models.Link) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	rules, variants, err := encodeTargets(link)
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	err = r.retry(ctx, func() error {
+		res, err := r.pool.Exec(ctx, `insert into urls(id,url,normalized_url,user_id,redirect_code,passthrough,rules,variants,sticky,created_at,deleted_at)
+			values ($1,$2,$3,$4,$5,$6,$7,$8,$9,coalesce($10,now()),$11)
+			on conflict (id) do update
+			set normalized_url=excluded.normalized_url, user_id=excluded.user_id, redirect_code=excluded.redirect_code, passthrough=excluded.passthrough, rules=excluded.rules, variants=excluded.variants, sticky=excluded.sticky, created_at=excluded.created_at, deleted_at=excluded.deleted_at
+			where urls.url=excluded.url`,
+			link.ID,
+			link.OriginalURL,
+			link.Canonical(),
+			link.UserID,
+			link.RedirectCode,
+			link.Passthrough,
+			rules,
+			variants,
+			link.Sticky,
+			nullTime(link.CreatedAt),
+			nullTime(link.DeletedAt))
+		if err != nil {
+			if _, ok := uniqueViolation(err); ok {
+				var urlID string
+				err = r.pool.QueryRow(ctx, "select id from urls where normalized_url=$1", link.Canonical()).Scan(&urlID)
+				if err != nil {
+					return err
+				}
+				return errs.NewNotUniqueURLErr(urlID, link.OriginalURL, nil)
+			}
+
+			return err
+		}
+
+		if res.RowsAffected() == 0 {
+			return errs.ErrURLIDConflict
+		}
+
+		return nil
+	})
+
+	return r.wrote(ctx, err, linkKey(link.ID), userKey(link.UserID))
+}
+
+// Iterate calls fn for every link with id greater than afterID, deleted ones included, in ascending byte order
+// of the ids whatever the collation of the database, like the other storages. It is not retried, fn may have seen part of the links already.
+func (r *pgRepo) Iterate(ctx context.Context, afterID string, fn func(link models.Link) error) error {
+	rows, err := r.pool.Query(ctx, `select `+linkColumns+` from urls where id > $1 collate "C" order by id collate "C"`, afterID)
+	if err != nil {
+		return err
 	}
-	err = rows.Err()
+
+	defer rows.Close()
+
+	for rows.Next() {
+		link, err := scanLink(rows)
+		if err != nil {
+			return err
+		}
+
+		if err = fn(link); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// wrote remembers the keys of a successful write for read-your-writes, err is returned as is
+func (r *pgRepo) wrote(ctx context.Context, err error, keys ...string) error {
+	if err == nil {
+		r.written.mark(keys...)
+		consistency.Wrote(ctx)
+	}
+
+	return err
+}
+
+func (r *pgRepo) fetchURLs(ctx context.Context, pool *pgxpool.Pool, userID string) ([]models.UserURL, error) {
+	return collectURLs(pool.Query(ctx, `select id, url from urls where user_id=$1 and deleted_at is null;`, userID))
+}
+
+func collectURLs(rows pgx.Rows, err error) ([]models.UserURL, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
+
+	res := make([]models.UserURL, 0)
 
 	for rows.Next() {
 		var url models.UserURL
@@ -110,44 +435,180 @@ func (r *pgRepo) FetchURLs(ctx context.Context, userID string) ([]models.UserURL
 		res = append(res, url)
 	}
 
-	return res, nil
+	return res, rows.Err()
 }
 
-func (r *pgRepo) Ping(ctx context.Context) error {
-	ctx, cancel := context.WithTimeout(ctx, timeout)
-	defer cancel()
+func (r *pgRepo) addBatch(ctx context.Context, urls []models.UserURL, userID string) ([]models.BatchResult, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func(tx pgx.Tx) {
+		_ = tx.Rollback(ctx)
+	}(tx)
+
+	_, err = tx.Exec(ctx, `create temporary table urls_staging
+		(position integer not null, id varchar(10) not null, url text not null, normalized_url text not null, redirect_code smallint not null, passthrough boolean not null)
+		on commit drop`)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([][]interface{}, len(urls))
+	for idx := range urls {
+		normalizedURL := urls[idx].NormalizedURL
+		if normalizedURL == "" {
+			normalizedURL = urls[idx].OriginalURL
+		}
+
+		rows[idx] = []interface{}{idx, urls[idx].ShortURL, urls[idx].OriginalURL, normalizedURL, urls[idx].RedirectCode, urls[idx].Passthrough}
+	}
+
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"urls_staging"}, stagingColumns, pgx.CopyFromRows(rows))
+	if err != nil {
+		return nil, err
+	}
+
+	// the first of the links sharing a url wins, the rest of them and the ones shortened before are left out,
+	// so are the ones whose id is taken by another url, their results stay empty
+	_, err = tx.Exec(ctx, `insert into urls(id,url,normalized_url,user_id,redirect_code,passthrough)
+		select distinct on (normalized_url) id, url, normalized_url, $1, redirect_code, passthrough
+		from urls_staging order by normalized_url, position
+		on conflict do nothing`, userID)
+	if err != nil {
+		return nil, err
+	}
 
-	return r.db.PingContext(ctx)
+	stored, err := tx.Query(ctx, `select s.position, s.id, u.id from urls_staging s join urls u on u.normalized_url=s.normalized_url`)
+	if err != nil {
+		return nil, err
+	}
+	defer stored.Close()
+
+	results := make([]models.BatchResult, len(urls))
+	for stored.Next() {
+		var (
+			position       int
+			urlID, savedID string
+		)
+		if err = stored.Scan(&position, &urlID, &savedID); err != nil {
+			return nil, err
+		}
+
+		results[position] = models.BatchResult{ID: savedID, Created: urlID == savedID}
+	}
+	if err = stored.Err(); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	return results, nil
 }
 
-func (r *pgRepo) Close() error {
-	return r.db.Close()
+var stagingColumns = []string{"position", "id", "url", "normalized_url", "redirect_code", "passthrough"}
+
+const linkColumns = `id, url, normalized_url, user_id, redirect_code, passthrough, rules, variants, sticky, created_at, deleted_at`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
 }
 
-func (r *pgRepo) AddBatch(ctx context.Context, urls []models.UserURL, userID string) error {
-	tx, err := r.db.Begin()
+func scanLink(row scanner) (models.Link, error) {
+	var (
+		link      models.Link
+		rules     []byte
+		variants  []byte
+		deletedAt *time.Time
+	)
+
+	err := row.Scan(&link.ID, &link.OriginalURL, &link.NormalizedURL, &link.UserID, &link.RedirectCode, &link.Passthrough,
+		&rules, &variants, &link.Sticky, &link.CreatedAt, &deletedAt)
 	if err != nil {
-		return err
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.Link{}, errs.ErrURLNotFound
+		}
+		return models.Link{}, err
 	}
 
-	defer func(tx *sql.Tx) {
-		_ = tx.Rollback()
-	}(tx)
+	if deletedAt != nil {
+		link.DeletedAt = *deletedAt
+	}
+
+	if err = json.Unmarshal(rules, &link.Rules); err != nil {
+		return models.Link{}, err
+	}
+
+	if err = json.Unmarshal(variants, &link.Variants); err != nil {
+		return models.Link{}, err
+	}
+
+	if len(link.Rules) == 0 {
+		link.Rules = nil
+	}
 
-	stmt, err := tx.PrepareContext(ctx, `insert into urls(id,url,user_id) values ($1,$2,$3);`)
+	if len(link.Variants) == 0 {
+		link.Variants = nil
+	}
+
+	return link, nil
+}
+
+// encodeTargets returns the json stored in the rules and variants columns
+func encodeTargets(link models.Link) (string, string, error) {
+	rules, err := encodeList(link.Rules)
 	if err != nil {
-		return err
+		return "", "", err
+	}
+
+	variants, err := encodeList(link.Variants)
+	if err != nil {
+		return "", "", err
 	}
 
-	defer func(stmt *sql.Stmt) {
-		_ = stmt.Close()
-	}(stmt)
+	return rules, variants, nil
+}
 
-	for idx := range urls {
-		if _, err = stmt.ExecContext(ctx, urls[idx].ShortURL, urls[idx].OriginalURL, userID); err != nil {
-			return err
-		}
+// encodeList stores nil slices as empty json arrays
+func encodeList(list interface{}) (string, error) {
+	encoded, err := json.Marshal(list)
+	if err != nil {
+		return "", err
+	}
+
+	if string(encoded) == "null" {
+		return "[]", nil
+	}
+
+	return string(encoded), nil
+}
+
+// nullTime stores zero times as null
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+
+	return &t
+}
+
+func checkAffected(tag pgconn.CommandTag) error {
+	if tag.RowsAffected() == 0 {
+		return errs.ErrURLNotFound
+	}
+
+	return nil
+}
+
+// uniqueViolation reports whether err is a unique violation and the name of the index violated
+func uniqueViolation(err error) (string, bool) {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != pgerrcode.UniqueViolation {
+		return "", false
 	}
 
-	return tx.Commit()
+	return pgErr.ConstraintName, true
 }
@@ -0,0 +1,201 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ChristinaFomenko/shortener/internal/app/models"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tests and benchmarks run against the database in TEST_DATABASE_URL, e.g.
+// TEST_DATABASE_URL=postgres://user@localhost/db?sslmode=disable go test -bench . ./internal/app/repository/urls/database/
+func newTestRepo(tb testing.TB, options string) *pgRepo {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		tb.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	separator := "?"
+	if strings.Contains(dsn, "?") {
+		separator = "&"
+	}
+
+	repo, err := NewRepo(dsn+separator+options, DefaultOptions())
+	require.NoError(tb, err)
+
+	tb.Cleanup(func() {
+		_ = repo.Close()
+	})
+
+	return repo
+}
+
+// testPrefix keeps the ids of a run apart from the ones already stored, they are removed afterwards
+func testPrefix(tb testing.TB, repo *pgRepo) string {
+	prefix := fmt.Sprintf("t%04d", time.Now().UnixNano()%10000)
+
+	tb.Cleanup(func() {
+		_, _ = repo.pool.Exec(context.Background(), `delete from urls where id like $1`, prefix+"%")
+	})
+
+	return prefix
+}
+
+func TestPgRepo_AddBatch(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepo(t, "")
+	prefix := testPrefix(t, repo)
+
+	require.NoError(t, repo.Add(ctx, models.Link{ID: prefix + "t", OriginalURL: "https://ozon.ru/" + prefix, UserID: "test"}))
+
+	act, err := repo.AddBatch(ctx, []models.UserURL{
+		{ShortURL: prefix + "a", OriginalURL: "https://yandex.ru/" + prefix},
+		{ShortURL: prefix + "b", OriginalURL: "https://ozon.ru/" + prefix},
+		{ShortURL: prefix + "c", OriginalURL: "https://yandex.ru/" + prefix},
+		{ShortURL: prefix + "d", OriginalURL: "https://avito.ru/" + prefix, Passthrough: true},
+		{ShortURL: prefix + "t", OriginalURL: "https://wildberries.ru/" + prefix},
+	}, "test")
+	require.NoError(t, err)
+	assert.Equal(t, []models.BatchResult{
+		{ID: prefix + "a", Created: true},
+		{ID: prefix + "t"},
+		{ID: prefix + "a"},
+		{ID: prefix + "d", Created: true},
+		{},
+	}, act)
+
+	link, err := repo.Get(ctx, prefix+"d")
+	require.NoError(t, err)
+	assert.True(t, link.Passthrough)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = repo.AddBatch(cancelled, []models.UserURL{{ShortURL: prefix + "e", OriginalURL: "https://mail.ru/" + prefix}}, "test")
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestPgRepo_Add(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepo(t, "")
+	prefix := testPrefix(t, repo)
+
+	link := models.Link{ID: prefix + "a", OriginalURL: "https://yandex.ru/" + prefix, UserID: "test"}
+	require.NoError(t, repo.Add(ctx, link))
+
+	// a retried add finds its own link
+	require.NoError(t, repo.Add(ctx, link))
+
+	var uniqueErr *errs.NotUniqueURLErr
+	require.True(t, errors.As(repo.Add(ctx, models.Link{ID: prefix + "b", OriginalURL: link.OriginalURL, UserID: "test"}), &uniqueErr))
+	assert.Equal(t, link.ID, uniqueErr.URLID)
+
+	err := repo.Add(ctx, models.Link{ID: link.ID, OriginalURL: "https://ozon.ru/" + prefix, UserID: "test"})
+	assert.ErrorIs(t, err, errs.ErrURLIDConflict)
+}
+
+func TestPgRepo_Iterate(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepo(t, "")
+	prefix := testPrefix(t, repo)
+
+	// the order must not depend on the collation of the database, upper case goes first
+	exp := []string{prefix + "B", prefix + "Z", prefix + "a", prefix + "b"}
+	for _, urlID := range []string{prefix + "b", prefix + "Z", prefix + "a", prefix + "B"} {
+		require.NoError(t, repo.Add(ctx, models.Link{ID: urlID, OriginalURL: "https://yandex.ru/" + urlID, UserID: prefix}))
+	}
+
+	stop := errors.New("stop")
+	var act []string
+	err := repo.Iterate(ctx, prefix, func(link models.Link) error {
+		if !strings.HasPrefix(link.ID, prefix) {
+			return stop
+		}
+		act = append(act, link.ID)
+		return nil
+	})
+	if !errors.Is(err, stop) {
+		require.NoError(t, err)
+	}
+	assert.Equal(t, exp, act)
+
+	act = nil
+	require.NoError(t, repo.IterateURLs(ctx, prefix, func(url models.UserURL) error {
+		act = append(act, url.ShortURL)
+		return nil
+	}))
+	assert.Equal(t, exp, act)
+}
+
+// BenchmarkPgRepo_AddBatch stores batches of the size of a typical import
+func BenchmarkPgRepo_AddBatch(b *testing.B) {
+	const size = 10000
+
+	ctx := context.Background()
+	repo := newTestRepo(b, "")
+	prefix := testPrefix(b, repo)
+
+	for i := 0; i < b.N; i++ {
+		urls := make([]models.UserURL, size)
+		for idx := range urls {
+			urlID := fmt.Sprintf("%s%x", prefix, i*size+idx)
+			urls[idx] = models.UserURL{ShortURL: urlID, OriginalURL: "https://bench.example/" + urlID}
+		}
+
+		if _, err := repo.AddBatch(ctx, urls, "bench"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkPgRepo_Get compares the prepared statement cache with the simple protocol
+// that parses and plans the query on every call
+func BenchmarkPgRepo_Get(b *testing.B) {
+	modes := []struct {
+		name    string
+		options string
+	}{
+		{name: "prepared", options: "statement_cache_mode=prepare"},
+		{name: "simple protocol", options: "prefer_simple_protocol=true"},
+	}
+
+	urlID := fmt.Sprintf("b%d", time.Now().UnixNano()%1e9)
+	ctx := context.Background()
+
+	seed := newTestRepo(b, "")
+	err := seed.Add(ctx, models.Link{ID: urlID, OriginalURL: "https://bench.example/" + urlID, UserID: "bench"})
+	require.NoError(b, err)
+
+	b.Cleanup(func() {
+		_, _ = seed.pool.Exec(ctx, `delete from urls where id=$1`, urlID)
+	})
+
+	for _, mode := range modes {
+		repo := newTestRepo(b, mode.options)
+
+		b.Run(mode.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := repo.Get(ctx, urlID); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		b.Run(mode.name+" parallel", func(b *testing.B) {
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := repo.Get(ctx, urlID); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package database
+
+import (
+	"fmt"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	"github.com/jackc/pgx/v4/pgxpool"
+	log "github.com/sirupsen/logrus"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pool options of the storage url, pgxpool reads them itself, Options apply when one is missing
+const (
+	optionMaxConns        = "pool_max_conns"
+	optionMaxConnLifetime = "pool_max_conn_lifetime"
+	optionMaxConnIdleTime = "pool_max_conn_idle_time"
+	// options of ours, they never reach the server. optionReplica names a replica dsn of this very database
+	// and may repeat, the others are deprecated, Options set them
+	optionQueryTimeout     = "query_timeout"
+	optionMigrationTimeout = "migration_timeout"
+	optionRetryAttempts    = "retry_attempts"
+	optionReplica          = "replica"
+	optionReadYourWrites   = "read_your_writes"
+
+	defaultMaxConns         = 20
+	defaultMaxConnLifetime  = 2 * time.Minute
+	defaultMaxConnIdleTime  = 30 * time.Second
+	defaultQueryTimeout     = 3 * time.Second
+	defaultMigrationTimeout = 10 * time.Minute
+	defaultRetryAttempts    = 3
+)
+
+// Options are the pool settings and ours: QueryTimeout bounds every call with its retries, MigrationTimeout
+// bounds the schema update at start, RetryAttempts is the tries per call, reads go to the Replicas and,
+// for ReadYourWrites after a write, to the primary. The server takes them from its config.
+type Options struct {
+	MaxConns         int32
+	MaxConnLifetime  time.Duration
+	MaxConnIdleTime  time.Duration
+	QueryTimeout     time.Duration
+	MigrationTimeout time.Duration
+	RetryAttempts    int
+	Replicas         []string
+	ReadYourWrites   time.Duration
+}
+
+// DefaultOptions apply to a storage opened by its url alone
+func DefaultOptions() Options {
+	return Options{
+		MaxConns:         defaultMaxConns,
+		MaxConnLifetime:  defaultMaxConnLifetime,
+		MaxConnIdleTime:  defaultMaxConnIdleTime,
+		QueryTimeout:     defaultQueryTimeout,
+		MigrationTimeout: defaultMigrationTimeout,
+		RetryAttempts:    defaultRetryAttempts,
+	}
+}
+
+// parseConfig applies the options to the pool of the url. The pool options and replicas of the url win, so do
+// our deprecated options for the deployments setting them there before Options, e.g.
+// postgres://user@host/db?pool_max_conns=50&replica=postgres%3A%2F%2Fuser%40replica%2Fdb&query_timeout=1s.
+// Statements are prepared and cached per connection unless statement_cache_mode or prefer_simple_protocol say otherwise.
+func parseConfig(dsn string, opts Options) (*pgxpool.Config, Options, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, opts, fmt.Errorf("%w: %v", errs.ErrInvalidStorageURL, err)
+	}
+
+	query := u.Query()
+
+	deprecated := make([]string, 0)
+	for _, option := range []string{optionQueryTimeout, optionMigrationTimeout, optionRetryAttempts, optionReadYourWrites} {
+		if _, ok := query[option]; ok {
+			deprecated = append(deprecated, option)
+		}
+	}
+	if len(deprecated) > 0 {
+		log.WithField("options", strings.Join(deprecated, ",")).
+			Warn("database options of the storage url are deprecated, use the DATABASE_* settings")
+	}
+
+	if value := query.Get(optionQueryTimeout); value != "" {
+		opts.QueryTimeout, err = time.ParseDuration(value)
+		if err != nil || opts.QueryTimeout <= 0 {
+			return nil, opts, fmt.Errorf("%w: %s must be a positive duration", errs.ErrInvalidStorageURL, optionQueryTimeout)
+		}
+	}
+
+	if value := query.Get(optionMigrationTimeout); value != "" {
+		opts.MigrationTimeout, err = time.ParseDuration(value)
+		if err != nil || opts.MigrationTimeout <= 0 {
+			return nil, opts, fmt.Errorf("%w: %s must be a positive duration", errs.ErrInvalidStorageURL, optionMigrationTimeout)
+		}
+	}
+
+	if value := query.Get(optionRetryAttempts); value != "" {
+		opts.RetryAttempts, err = strconv.Atoi(value)
+		if err != nil || opts.RetryAttempts < 1 {
+			return nil, opts, fmt.Errorf("%w: %s must be a positive number", errs.ErrInvalidStorageURL, optionRetryAttempts)
+		}
+	}
+
+	if value := query.Get(optionReadYourWrites); value != "" {
+		opts.ReadYourWrites, err = time.ParseDuration(value)
+		if err != nil || opts.ReadYourWrites < 0 {
+			return nil, opts, fmt.Errorf("%w: %s must be a duration", errs.ErrInvalidStorageURL, optionReadYourWrites)
+		}
+	}
+
+	if replicas, ok := query[optionReplica]; ok {
+		opts.Replicas = replicas
+	}
+	for _, replica := range opts.Replicas {
+		if !strings.HasPrefix(replica, "postgres://") && !strings.HasPrefix(replica, "postgresql://") {
+			return nil, opts, fmt.Errorf("%w: %s must be a postgres url", errs.ErrInvalidStorageURL, optionReplica)
+		}
+	}
+
+	for _, option := range []string{optionQueryTimeout, optionMigrationTimeout, optionRetryAttempts, optionReplica, optionReadYourWrites} {
+		query.Del(option)
+	}
+	u.RawQuery = query.Encode()
+
+	config, err := pgxpool.ParseConfig(u.String())
+	if err != nil {
+		return nil, opts, fmt.Errorf("%w: %v", errs.ErrInvalidStorageURL, err)
+	}
+
+	if query.Get(optionMaxConns) == "" {
+		config.MaxConns = opts.MaxConns
+	}
+
+	if query.Get(optionMaxConnLifetime) == "" {
+		config.MaxConnLifetime = opts.MaxConnLifetime
+	}
+
+	if query.Get(optionMaxConnIdleTime) == "" {
+		config.MaxConnIdleTime = opts.MaxConnIdleTime
+	}
+
+	return config, opts, nil
+}
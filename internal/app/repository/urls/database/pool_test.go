@@ -0,0 +1,162 @@
+package database
+
+import (
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		name             string
+		dsn              string
+		opts             *Options
+		maxConns         int32
+		maxConnLifetime  time.Duration
+		maxConnIdleTime  time.Duration
+		queryTimeout     time.Duration
+		migrationTimeout time.Duration
+		retryAttempts    int
+		replicas         []string
+		readYourWrites   time.Duration
+		cacheMode        bool
+		err              error
+	}{
+		{
+			name:             "defaults",
+			dsn:              "postgres://user@localhost/db?sslmode=disable",
+			maxConns:         defaultMaxConns,
+			maxConnLifetime:  defaultMaxConnLifetime,
+			maxConnIdleTime:  defaultMaxConnIdleTime,
+			queryTimeout:     defaultQueryTimeout,
+			migrationTimeout: defaultMigrationTimeout,
+			retryAttempts:    defaultRetryAttempts,
+			cacheMode:        true,
+		},
+		{
+			name: "options",
+			dsn:  "postgres://user@localhost/db",
+			opts: &Options{
+				MaxConns:         10,
+				MaxConnLifetime:  time.Minute,
+				MaxConnIdleTime:  10 * time.Second,
+				QueryTimeout:     time.Second,
+				MigrationTimeout: time.Hour,
+				RetryAttempts:    2,
+				Replicas:         []string{"postgres://user@replica1/db"},
+				ReadYourWrites:   time.Second,
+			},
+			maxConns:         10,
+			maxConnLifetime:  time.Minute,
+			maxConnIdleTime:  10 * time.Second,
+			queryTimeout:     time.Second,
+			migrationTimeout: time.Hour,
+			retryAttempts:    2,
+			replicas:         []string{"postgres://user@replica1/db"},
+			readYourWrites:   time.Second,
+			cacheMode:        true,
+		},
+		{
+			name:             "url options win",
+			dsn:              "postgres://user@localhost/db?pool_max_conns=50&pool_max_conn_lifetime=5m&pool_max_conn_idle_time=1m&query_timeout=500ms&migration_timeout=1h&retry_attempts=5",
+			maxConns:         50,
+			maxConnLifetime:  5 * time.Minute,
+			maxConnIdleTime:  time.Minute,
+			queryTimeout:     500 * time.Millisecond,
+			migrationTimeout: time.Hour,
+			retryAttempts:    5,
+			cacheMode:        true,
+		},
+		{
+			name:             "simple protocol",
+			dsn:              "postgres://user@localhost/db?prefer_simple_protocol=true",
+			maxConns:         defaultMaxConns,
+			maxConnLifetime:  defaultMaxConnLifetime,
+			maxConnIdleTime:  defaultMaxConnIdleTime,
+			queryTimeout:     defaultQueryTimeout,
+			migrationTimeout: defaultMigrationTimeout,
+			retryAttempts:    defaultRetryAttempts,
+		},
+		{
+			name:             "replicas",
+			dsn:              "postgres://user@primary/db?replica=postgres%3A%2F%2Fuser%40replica1%2Fdb&replica=postgresql%3A%2F%2Fuser%40replica2%2Fdb&read_your_writes=2s",
+			maxConns:         defaultMaxConns,
+			maxConnLifetime:  defaultMaxConnLifetime,
+			maxConnIdleTime:  defaultMaxConnIdleTime,
+			queryTimeout:     defaultQueryTimeout,
+			migrationTimeout: defaultMigrationTimeout,
+			retryAttempts:    defaultRetryAttempts,
+			replicas:         []string{"postgres://user@replica1/db", "postgresql://user@replica2/db"},
+			readYourWrites:   2 * time.Second,
+			cacheMode:        true,
+		},
+		{
+			name: "bad query timeout",
+			dsn:  "postgres://user@localhost/db?query_timeout=soon",
+			err:  errs.ErrInvalidStorageURL,
+		},
+		{
+			name: "bad migration timeout",
+			dsn:  "postgres://user@localhost/db?migration_timeout=0s",
+			err:  errs.ErrInvalidStorageURL,
+		},
+		{
+			name: "bad retry attempts",
+			dsn:  "postgres://user@localhost/db?retry_attempts=0",
+			err:  errs.ErrInvalidStorageURL,
+		},
+		{
+			name: "bad replica",
+			dsn:  "postgres://user@localhost/db?replica=replica1",
+			err:  errs.ErrInvalidStorageURL,
+		},
+		{
+			name: "bad read your writes",
+			dsn:  "postgres://user@localhost/db?read_your_writes=-1s",
+			err:  errs.ErrInvalidStorageURL,
+		},
+		{
+			name: "bad replica option",
+			dsn:  "postgres://user@localhost/db",
+			opts: &Options{Replicas: []string{"replica1"}},
+			err:  errs.ErrInvalidStorageURL,
+		},
+		{
+			name: "bad pool size",
+			dsn:  "postgres://user@localhost/db?pool_max_conns=many",
+			err:  errs.ErrInvalidStorageURL,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := DefaultOptions()
+			if tt.opts != nil {
+				options = *tt.opts
+			}
+
+			config, opts, err := parseConfig(tt.dsn, options)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.maxConns, config.MaxConns)
+			assert.Equal(t, tt.maxConnLifetime, config.MaxConnLifetime)
+			assert.Equal(t, tt.maxConnIdleTime, config.MaxConnIdleTime)
+			assert.Equal(t, tt.queryTimeout, opts.QueryTimeout)
+			assert.Equal(t, tt.migrationTimeout, opts.MigrationTimeout)
+			assert.Equal(t, tt.retryAttempts, opts.RetryAttempts)
+			assert.Equal(t, tt.replicas, opts.Replicas)
+			assert.Equal(t, tt.readYourWrites, opts.ReadYourWrites)
+			assert.Equal(t, tt.cacheMode, !config.ConnConfig.PreferSimpleProtocol && config.ConnConfig.BuildStatementCache != nil)
+			for _, option := range []string{optionQueryTimeout, optionMigrationTimeout, optionRetryAttempts, optionReplica, optionReadYourWrites} {
+				_, ok := config.ConnConfig.RuntimeParams[option]
+				assert.False(t, ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,21 @@
+package database
+
+import (
+	repositoryURL "github.com/ChristinaFomenko/shortener/internal/app/repository/urls"
+	"net/url"
+)
+
+func init() {
+	repositoryURL.Register(repositoryURL.BackendDatabase, open, "postgres", "postgresql")
+}
+
+// open hands the url to the pool with the default options, the server opens its databases with
+// the options of its config instead
+func open(u *url.URL) (repositoryURL.Repo, error) {
+	r, err := NewRepo(u.String(), DefaultOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
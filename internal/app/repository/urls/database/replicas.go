@@ -0,0 +1,232 @@
+package database
+
+import (
+	"context"
+	"github.com/ChristinaFomenko/shortener/internal/app/consistency"
+	"github.com/jackc/pgx/v4/pgxpool"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// replicaCheckInterval is how often replicas are pinged, a replica failing a read is left out until it answers again
+const replicaCheckInterval = 5 * time.Second
+
+type replica struct {
+	pool    *pgxpool.Pool
+	host    string
+	healthy int32
+}
+
+func (r *replica) isHealthy() bool {
+	return atomic.LoadInt32(&r.healthy) == 1
+}
+
+// setHealthy reports whether the state changed
+func (r *replica) setHealthy(healthy bool) bool {
+	value := int32(0)
+	if healthy {
+		value = 1
+	}
+
+	return atomic.SwapInt32(&r.healthy, value) != value
+}
+
+// connectReplicas opens the pools lazily, a replica down at start only keeps reads on the primary.
+// Replicas count as unhealthy until their first check, their pools are sized like the primary's.
+func connectReplicas(dsns []string, opts Options) ([]*replica, error) {
+	opts.Replicas = nil
+
+	replicas := make([]*replica, 0, len(dsns))
+	for _, dsn := range dsns {
+		config, _, err := parseConfig(dsn, opts)
+		if err != nil {
+			closeReplicas(replicas)
+			return nil, err
+		}
+		config.LazyConnect = true
+
+		pool, err := pgxpool.ConnectConfig(context.Background(), config)
+		if err != nil {
+			closeReplicas(replicas)
+			return nil, err
+		}
+
+		replicas = append(replicas, &replica{pool: pool, host: config.ConnConfig.Host})
+	}
+
+	return replicas, nil
+}
+
+func closeReplicas(replicas []*replica) {
+	for _, replica := range replicas {
+		replica.pool.Close()
+	}
+}
+
+// reader picks the replica for a read, round robin over the healthy ones. None is returned when there
+// is no healthy replica, the user of the request wrote within the read-your-writes window on any instance
+// or one of the keys was written within it on this one, the read goes to the primary then.
+func (r *pgRepo) reader(ctx context.Context, keys ...string) *replica {
+	if len(r.replicas) == 0 {
+		return nil
+	}
+
+	if r.written.since(consistency.LastWrite(ctx)) {
+		return nil
+	}
+
+	for _, key := range keys {
+		if r.written.recent(key) {
+			return nil
+		}
+	}
+
+	start := atomic.AddUint32(&r.next, 1)
+	for idx := range r.replicas {
+		replica := r.replicas[(int(start)+idx)%len(r.replicas)]
+		if replica.isHealthy() {
+			return replica
+		}
+	}
+
+	return nil
+}
+
+// readPool returns the pool of the replica, the primary's when there is none
+func (r *pgRepo) readPool(replica *replica) *pgxpool.Pool {
+	if replica == nil {
+		return r.pool
+	}
+
+	return replica.pool
+}
+
+// readDone leaves a replica out after a connection failure, the retry goes elsewhere
+func (r *pgRepo) readDone(replica *replica, err error) {
+	if replica == nil || !retryable(err) {
+		return
+	}
+
+	if replica.setHealthy(false) {
+		log.WithError(err).WithField("host", replica.host).Warn("replica left out of reads")
+	}
+}
+
+// watchReplicas pings the replicas and forgets old writes until done is closed
+func (r *pgRepo) watchReplicas() {
+	ticker := time.NewTicker(replicaCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		r.checkReplicas()
+		r.written.prune()
+
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *pgRepo) checkReplicas() {
+	var wg sync.WaitGroup
+	for _, rep := range r.replicas {
+		wg.Add(1)
+		go func(rep *replica) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+			defer cancel()
+
+			err := rep.pool.Ping(ctx)
+			if !rep.setHealthy(err == nil) {
+				return
+			}
+
+			if err != nil {
+				log.WithError(err).WithField("host", rep.host).Warn("replica left out of reads")
+				return
+			}
+			log.WithField("host", rep.host).Info("replica serves reads")
+		}(rep)
+	}
+
+	wg.Wait()
+}
+
+// recentWrites remembers what was written within the window, its reads go to the primary that has it for sure.
+// It covers the reads served by this instance, the ones of the writer served elsewhere are covered by the time
+// of the last write the writer carries along, see consistency.
+type recentWrites struct {
+	mu      sync.Mutex
+	window  time.Duration
+	written map[string]time.Time
+	now     func() time.Time
+}
+
+func newRecentWrites(window time.Duration) *recentWrites {
+	return &recentWrites{
+		window:  window,
+		written: map[string]time.Time{},
+		now:     time.Now,
+	}
+}
+
+func (w *recentWrites) mark(keys ...string) {
+	if w.window <= 0 {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := w.now()
+	for _, key := range keys {
+		w.written[key] = now
+	}
+}
+
+func (w *recentWrites) recent(key string) bool {
+	if w.window <= 0 {
+		return false
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	at, ok := w.written[key]
+
+	return ok && w.now().Sub(at) < w.window
+}
+
+// since reports whether a write made at the time is within the window, zero time is no write
+func (w *recentWrites) since(at time.Time) bool {
+	if w.window <= 0 || at.IsZero() {
+		return false
+	}
+
+	return w.now().Sub(at) < w.window
+}
+
+func (w *recentWrites) prune() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := w.now()
+	for key, at := range w.written {
+		if now.Sub(at) >= w.window {
+			delete(w.written, key)
+		}
+	}
+}
+
+func userKey(userID string) string {
+	return "user:" + userID
+}
+
+func linkKey(urlID string) string {
+	return "url:" + urlID
+}
@@ -0,0 +1,147 @@
+package database
+
+import (
+	"context"
+	"github.com/ChristinaFomenko/shortener/internal/app/consistency"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestPgRepo_reader(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		healthy []bool
+		written map[string]time.Time
+		// lastWrite is the time of the last write of the user made on any instance
+		lastWrite time.Time
+		keys      []string
+		exp       []int
+	}{
+		{
+			name: "no replicas",
+			keys: []string{linkKey("abc")},
+			exp:  []int{-1, -1},
+		},
+		{
+			name:    "round robin",
+			healthy: []bool{true, true},
+			keys:    []string{linkKey("abc")},
+			exp:     []int{1, 0, 1},
+		},
+		{
+			name:    "unhealthy replica skipped",
+			healthy: []bool{false, true},
+			keys:    []string{linkKey("abc")},
+			exp:     []int{1, 1},
+		},
+		{
+			name:    "no healthy replica",
+			healthy: []bool{false, false},
+			keys:    []string{linkKey("abc")},
+			exp:     []int{-1, -1},
+		},
+		{
+			name:    "recently written",
+			healthy: []bool{true},
+			written: map[string]time.Time{userKey("user1"): now.Add(-time.Second)},
+			keys:    []string{userKey("user1")},
+			exp:     []int{-1},
+		},
+		{
+			name:    "written before the window",
+			healthy: []bool{true},
+			written: map[string]time.Time{userKey("user1"): now.Add(-time.Minute)},
+			keys:    []string{userKey("user1")},
+			exp:     []int{0},
+		},
+		{
+			name:    "written by another user",
+			healthy: []bool{true},
+			written: map[string]time.Time{userKey("user2"): now},
+			keys:    []string{userKey("user1")},
+			exp:     []int{0},
+		},
+		{
+			name:      "recently written on another instance",
+			healthy:   []bool{true},
+			lastWrite: now.Add(-time.Second),
+			keys:      []string{userKey("user1")},
+			exp:       []int{-1},
+		},
+		{
+			name:      "written on another instance before the window",
+			healthy:   []bool{true},
+			lastWrite: now.Add(-time.Minute),
+			keys:      []string{linkKey("abc")},
+			exp:       []int{0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &pgRepo{written: newRecentWrites(5 * time.Second)}
+			r.written.now = func() time.Time { return now }
+			for key, at := range tt.written {
+				r.written.written[key] = at
+			}
+			for _, healthy := range tt.healthy {
+				rep := &replica{}
+				rep.setHealthy(healthy)
+				r.replicas = append(r.replicas, rep)
+			}
+
+			ctx := consistency.WithLastWrite(context.Background(), tt.lastWrite)
+			for _, exp := range tt.exp {
+				rep := r.reader(ctx, tt.keys...)
+				if exp < 0 {
+					assert.Nil(t, rep)
+					continue
+				}
+				assert.Same(t, r.replicas[exp], rep)
+			}
+		})
+	}
+}
+
+func TestPgRepo_readDone(t *testing.T) {
+	rep := &replica{}
+	rep.setHealthy(true)
+	r := &pgRepo{replicas: []*replica{rep}, written: newRecentWrites(0)}
+
+	r.readDone(rep, nil)
+	assert.True(t, rep.isHealthy())
+
+	r.readDone(rep, errs.ErrURLNotFound)
+	assert.True(t, rep.isHealthy())
+
+	r.readDone(rep, io.ErrUnexpectedEOF)
+	assert.False(t, rep.isHealthy())
+	assert.Nil(t, r.reader(context.Background(), linkKey("abc")))
+}
+
+func TestRecentWrites(t *testing.T) {
+	now := time.Now()
+	w := newRecentWrites(2 * time.Second)
+	w.now = func() time.Time { return now }
+
+	w.mark(userKey("user1"), linkKey("abc"))
+	assert.True(t, w.recent(userKey("user1")))
+	assert.True(t, w.recent(linkKey("abc")))
+	assert.False(t, w.recent(userKey("user2")))
+
+	now = now.Add(2 * time.Second)
+	assert.False(t, w.recent(userKey("user1")))
+
+	w.prune()
+	assert.Empty(t, w.written)
+
+	off := newRecentWrites(0)
+	off.mark(userKey("user1"))
+	assert.False(t, off.recent(userKey("user1")))
+	assert.Empty(t, off.written)
+}
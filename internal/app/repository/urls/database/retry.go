@@ -0,0 +1,73 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+	"io"
+	"net"
+	"time"
+)
+
+const (
+	retryBaseDelay = 50 * time.Millisecond
+	retryMaxDelay  = time.Second
+)
+
+// retry runs op until it succeeds, fails for good or runs out of attempts. The waits between attempts
+// double from retryBaseDelay and are jittered so that instances don't retry in step; a wait the deadline
+// of ctx doesn't leave room for ends the retries. op must be safe to run again after a failure at any point.
+func (r *pgRepo) retry(ctx context.Context, op func() error) error {
+	delay := retryBaseDelay
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if attempt >= r.attempts || !retryable(err) {
+			return err
+		}
+
+		wait := delay/2 + time.Duration(r.jitter(int64(delay/2)+1))
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+
+		if delay *= 2; delay > retryMaxDelay {
+			delay = retryMaxDelay
+		}
+	}
+}
+
+// retryable tells transient errors: the server asking to try again or going away and connections lost or refused
+func retryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case pgerrcode.SerializationFailure,
+			pgerrcode.DeadlockDetected,
+			pgerrcode.AdminShutdown,
+			pgerrcode.CrashShutdown,
+			pgerrcode.CannotConnectNow,
+			pgerrcode.TooManyConnections:
+			return true
+		}
+
+		return pgerrcode.IsConnectionException(pgErr.Code)
+	}
+
+	if pgconn.SafeToRetry(err) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,106 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		exp  bool
+	}{
+		{name: "serialization failure", err: &pgconn.PgError{Code: pgerrcode.SerializationFailure}, exp: true},
+		{name: "deadlock", err: &pgconn.PgError{Code: pgerrcode.DeadlockDetected}, exp: true},
+		{name: "admin shutdown", err: fmt.Errorf("query: %w", &pgconn.PgError{Code: pgerrcode.AdminShutdown}), exp: true},
+		{name: "connection failure", err: &pgconn.PgError{Code: pgerrcode.ConnectionFailure}, exp: true},
+		{name: "connection reset", err: &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}, exp: true},
+		{name: "connection closed", err: io.ErrUnexpectedEOF, exp: true},
+		{name: "unique violation", err: &pgconn.PgError{Code: pgerrcode.UniqueViolation}},
+		{name: "syntax error", err: &pgconn.PgError{Code: pgerrcode.SyntaxError}},
+		{name: "not found", err: errs.ErrURLNotFound},
+		{name: "cancelled", err: context.Canceled},
+		{name: "timeout", err: fmt.Errorf("timeout: %w", context.DeadlineExceeded)},
+		{name: "no error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.exp, retryable(tt.err))
+		})
+	}
+}
+
+func TestPgRepo_retry(t *testing.T) {
+	transient := &pgconn.PgError{Code: pgerrcode.SerializationFailure}
+
+	tests := []struct {
+		name     string
+		errs     []error
+		timeout  time.Duration
+		calls    int
+		expError error
+	}{
+		{
+			name:  "succeeds after transient errors",
+			errs:  []error{transient, transient, nil},
+			calls: 3,
+		},
+		{
+			name:     "runs out of attempts",
+			errs:     []error{transient, transient, transient, nil},
+			calls:    3,
+			expError: transient,
+		},
+		{
+			name:     "permanent error",
+			errs:     []error{errs.ErrURLNotFound, nil},
+			calls:    1,
+			expError: errs.ErrURLNotFound,
+		},
+		{
+			name:     "no time left to wait",
+			errs:     []error{transient, nil},
+			timeout:  time.Millisecond,
+			calls:    1,
+			expError: transient,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &pgRepo{
+				attempts: 3,
+				jitter: func(n int64) int64 {
+					return 0
+				},
+			}
+
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+
+			calls := 0
+			err := r.retry(ctx, func() error {
+				calls++
+				return tt.errs[calls-1]
+			})
+
+			assert.Equal(t, tt.expError, err)
+			assert.Equal(t, tt.calls, calls)
+		})
+	}
+}
@@ -1,10 +1,32 @@
 package database
 
+// idIndex is the unique index on the short ids
+const idIndex = "urls_id_uindex"
+
+// query creates the schema and brings tables created by older versions up to date. Links stored before
+// urls were normalized get their original url as the canonical one, the maintenance backfill normalizes them.
 const query = `create table if not exists urls 
 (
     id varchar(10) not null ,
-    url varchar(500) not null unique,
+    url varchar(500) not null,
+    normalized_url varchar(500) not null,
     user_id varchar(10) not null,
+    redirect_code smallint default 0 not null,
+    passthrough boolean default false not null,
+    rules jsonb default '[]' not null,
+    variants jsonb default '[]' not null,
+    sticky boolean default false not null,
     created_at timestamp with time zone default now() not null,
     deleted_at  timestamp with time zone default null
-);`
+);
+create unique index if not exists urls_id_uindex on urls (id);
+alter table urls add column if not exists normalized_url varchar(500);
+update urls set normalized_url=url where normalized_url is null;
+alter table urls alter column normalized_url set not null;
+alter table urls drop constraint if exists urls_url_key;
+create unique index if not exists urls_normalized_url_uindex on urls (normalized_url);
+alter table urls add column if not exists redirect_code smallint default 0 not null;
+alter table urls add column if not exists passthrough boolean default false not null;
+alter table urls add column if not exists rules jsonb default '[]' not null;
+alter table urls add column if not exists variants jsonb default '[]' not null;
+alter table urls add column if not exists sticky boolean default false not null;`
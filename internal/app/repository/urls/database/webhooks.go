@@ -0,0 +1,366 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/ChristinaFomenko/shortener/internal/app/models"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"sort"
+	"time"
+)
+
+// deliveryLease is how long a delivery handed out by Due is hidden from the dispatchers of other instances,
+// a delivery whose instance stopped before finishing it is sent again afterwards
+const deliveryLease = 10 * time.Minute
+
+const webhooksQuery = `create table if not exists webhooks
+(
+    id varchar(20) primary key,
+    user_id varchar(10) not null,
+    url_id varchar(10) default '' not null,
+    url varchar(2000) not null,
+    secret varchar(100) not null,
+    events jsonb default '[]' not null,
+    created_at timestamp with time zone default now() not null
+);
+create index if not exists webhooks_user_id_index on webhooks (user_id);
+create table if not exists webhook_deliveries
+(
+    id varchar(20) primary key,
+    webhook_id varchar(20) not null,
+    user_id varchar(10) not null,
+    event varchar(50) not null,
+    payload bytea not null,
+    attempts integer default 0 not null,
+    next_attempt timestamp with time zone not null,
+    last_error text default '' not null,
+    created_at timestamp with time zone default now() not null,
+    dead boolean default false not null
+);
+create index if not exists webhook_deliveries_due_index on webhook_deliveries (next_attempt) where not dead;
+create index if not exists webhook_deliveries_dead_index on webhook_deliveries (user_id, next_attempt) where dead;`
+
+const deliveryColumns = `id, webhook_id, user_id, event, payload, attempts, next_attempt, last_error, created_at`
+
+type pgWebhookRepo struct {
+	pool    *pgxpool.Pool
+	timeout time.Duration
+}
+
+// NewWebhookRepo keeps webhooks and their delivery queue in the database of the storage url, so every
+// instance sharing it queues the deliveries of its events there and sends whatever is due
+func NewWebhookRepo(dsn string, opts Options) (*pgWebhookRepo, error) {
+	config, opts, err := parseConfig(dsn, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.MigrationTimeout)
+	defer cancel()
+
+	pool, err := pgxpool.ConnectConfig(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = pool.Exec(ctx, webhooksQuery); err != nil {
+		pool.Close()
+		return nil, err
+	}
+
+	return &pgWebhookRepo{
+		pool:    pool,
+		timeout: opts.QueryTimeout,
+	}, nil
+}
+
+func (r *pgWebhookRepo) AddWebhook(ctx context.Context, hook models.Webhook) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	events, err := json.Marshal(hook.Events)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.pool.Exec(ctx, `insert into webhooks(id,user_id,url_id,url,secret,events,created_at) values ($1,$2,$3,$4,$5,$6,$7)`,
+		hook.ID, hook.UserID, hook.URLID, hook.URL, hook.Secret, events, hook.CreatedAt)
+
+	return err
+}
+
+// DeleteWebhook removes the webhook of the user together with its queued deliveries
+func (r *pgWebhookRepo) DeleteWebhook(ctx context.Context, userID, hookID string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer func(tx pgx.Tx) {
+		_ = tx.Rollback(ctx)
+	}(tx)
+
+	tag, err := tx.Exec(ctx, `delete from webhooks where id=$1 and user_id=$2`, hookID, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return errs.ErrWebhookNotFound
+	}
+
+	if _, err = tx.Exec(ctx, `delete from webhook_deliveries where webhook_id=$1 and not dead`, hookID); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (r *pgWebhookRepo) Webhook(ctx context.Context, hookID string) (models.Webhook, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	hooks, err := r.webhooks(ctx, `where id=$1`, hookID)
+	if err != nil {
+		return models.Webhook{}, err
+	}
+	if len(hooks) == 0 {
+		return models.Webhook{}, errs.ErrWebhookNotFound
+	}
+
+	return hooks[0], nil
+}
+
+// Webhooks returns the webhooks of the user, oldest first
+func (r *pgWebhookRepo) Webhooks(ctx context.Context, userID string) ([]models.Webhook, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	return r.webhooks(ctx, `where user_id=$1 order by created_at, id`, userID)
+}
+
+// Subscribers returns the webhooks the event goes to
+func (r *pgWebhookRepo) Subscribers(ctx context.Context, event models.Event) ([]models.Webhook, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	hooks, err := r.webhooks(ctx, `where user_id=$1 and (url_id='' or url_id=$2)`, event.UserID, event.URLID)
+	if err != nil {
+		return nil, err
+	}
+
+	var subscribers []models.Webhook
+	for _, hook := range hooks {
+		if hook.Wants(event) {
+			subscribers = append(subscribers, hook)
+		}
+	}
+
+	return subscribers, nil
+}
+
+func (r *pgWebhookRepo) Enqueue(ctx context.Context, deliveries []models.Delivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	batch := &pgx.Batch{}
+	for _, delivery := range deliveries {
+		batch.Queue(`insert into webhook_deliveries(`+deliveryColumns+`) values ($1,$2,$3,$4,$5,$6,$7,$8,$9)`,
+			delivery.ID, delivery.WebhookID, delivery.UserID, delivery.Event, nullBytes(delivery.Payload),
+			delivery.Attempts, delivery.NextAttempt, delivery.LastError, delivery.CreatedAt)
+	}
+
+	return r.pool.SendBatch(ctx, batch).Close()
+}
+
+// Due returns up to limit queued deliveries whose next attempt is not after now, earliest first.
+// They are leased for deliveryLease, the dispatchers of other instances skip them meanwhile.
+func (r *pgWebhookRepo) Due(ctx context.Context, now time.Time, limit int) ([]models.Delivery, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	rows, err := r.pool.Query(ctx, `with due as (
+			select id, next_attempt from webhook_deliveries where not dead and next_attempt <= $1
+			order by next_attempt limit $2 for update skip locked
+		)
+		update webhook_deliveries d set next_attempt=$3 from due where d.id=due.id
+		returning d.id, d.webhook_id, d.user_id, d.event, d.payload, d.attempts, due.next_attempt, d.last_error, d.created_at`,
+		now, limit, now.Add(deliveryLease))
+	if err != nil {
+		return nil, err
+	}
+
+	due, err := scanDeliveries(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].NextAttempt.Before(due[j].NextAttempt)
+	})
+
+	return due, nil
+}
+
+// Reschedule stores the attempts of a queued delivery
+func (r *pgWebhookRepo) Reschedule(ctx context.Context, delivery models.Delivery) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	tag, err := r.pool.Exec(ctx, `update webhook_deliveries set attempts=$2, next_attempt=$3, last_error=$4 where id=$1 and not dead`,
+		delivery.ID, delivery.Attempts, delivery.NextAttempt, delivery.LastError)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return errs.ErrDeliveryNotFound
+	}
+
+	return nil
+}
+
+// Complete removes a delivered delivery from the queue
+func (r *pgWebhookRepo) Complete(ctx context.Context, deliveryID string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	_, err := r.pool.Exec(ctx, `delete from webhook_deliveries where id=$1 and not dead`, deliveryID)
+
+	return err
+}
+
+// Bury moves a delivery that ran out of attempts to the dead letters, keeping models.MaxDeadLetters of the user
+func (r *pgWebhookRepo) Bury(ctx context.Context, delivery models.Delivery) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer func(tx pgx.Tx) {
+		_ = tx.Rollback(ctx)
+	}(tx)
+
+	_, err = tx.Exec(ctx, `insert into webhook_deliveries(`+deliveryColumns+`, dead) values ($1,$2,$3,$4,$5,$6,$7,$8,$9,true)
+		on conflict (id) do update set attempts=excluded.attempts, next_attempt=excluded.next_attempt, last_error=excluded.last_error, dead=true`,
+		delivery.ID, delivery.WebhookID, delivery.UserID, delivery.Event, nullBytes(delivery.Payload),
+		delivery.Attempts, delivery.NextAttempt, delivery.LastError, delivery.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `delete from webhook_deliveries where id in (
+			select id from webhook_deliveries where user_id=$1 and dead order by next_attempt desc offset $2
+		)`, delivery.UserID, models.MaxDeadLetters)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// DeadLetters returns the deliveries given up on for the user, latest first
+func (r *pgWebhookRepo) DeadLetters(ctx context.Context, userID string) ([]models.Delivery, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	rows, err := r.pool.Query(ctx, `select `+deliveryColumns+` from webhook_deliveries where user_id=$1 and dead order by next_attempt desc`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanDeliveries(rows)
+}
+
+// Requeue moves a dead letter of the user back to the queue with fresh attempts
+func (r *pgWebhookRepo) Requeue(ctx context.Context, userID, deliveryID string, now time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	var hookID string
+	err := r.pool.QueryRow(ctx, `select webhook_id from webhook_deliveries where id=$1 and user_id=$2 and dead`, deliveryID, userID).Scan(&hookID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return errs.ErrDeliveryNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	tag, err := r.pool.Exec(ctx, `update webhook_deliveries set dead=false, attempts=0, next_attempt=$2
+		where id=$1 and dead and exists (select 1 from webhooks where webhooks.id=webhook_deliveries.webhook_id)`, deliveryID, now)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return errs.ErrWebhookNotFound
+	}
+
+	return nil
+}
+
+func (r *pgWebhookRepo) Close() error {
+	r.pool.Close()
+	return nil
+}
+
+func (r *pgWebhookRepo) webhooks(ctx context.Context, where string, args ...interface{}) ([]models.Webhook, error) {
+	rows, err := r.pool.Query(ctx, `select id, user_id, url_id, url, secret, events, created_at from webhooks `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hooks := make([]models.Webhook, 0)
+	for rows.Next() {
+		var (
+			hook   models.Webhook
+			events []byte
+		)
+		if err = rows.Scan(&hook.ID, &hook.UserID, &hook.URLID, &hook.URL, &hook.Secret, &events, &hook.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(events, &hook.Events); err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, hook)
+	}
+
+	return hooks, rows.Err()
+}
+
+func scanDeliveries(rows pgx.Rows) ([]models.Delivery, error) {
+	defer rows.Close()
+
+	deliveries := make([]models.Delivery, 0)
+	for rows.Next() {
+		var delivery models.Delivery
+		err := rows.Scan(&delivery.ID, &delivery.WebhookID, &delivery.UserID, &delivery.Event, &delivery.Payload,
+			&delivery.Attempts, &delivery.NextAttempt, &delivery.LastError, &delivery.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// nullBytes stores a missing payload as an empty one, the column takes no nulls
+func nullBytes(b []byte) []byte {
+	if b == nil {
+		return []byte{}
+	}
+
+	return b
+}
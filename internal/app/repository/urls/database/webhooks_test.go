@@ -0,0 +1,98 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"github.com/ChristinaFomenko/shortener/internal/app/models"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+	"time"
+)
+
+func newTestWebhookRepo(t *testing.T) (*pgWebhookRepo, string) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	repo, err := NewWebhookRepo(dsn, DefaultOptions())
+	require.NoError(t, err)
+
+	prefix := fmt.Sprintf("t%04d", time.Now().UnixNano()%10000)
+	t.Cleanup(func() {
+		ctx := context.Background()
+		_, _ = repo.pool.Exec(ctx, `delete from webhook_deliveries where user_id like $1`, prefix+"%")
+		_, _ = repo.pool.Exec(ctx, `delete from webhooks where user_id like $1`, prefix+"%")
+		_ = repo.Close()
+	})
+
+	return repo, prefix
+}
+
+func TestPgWebhookRepo_Queue(t *testing.T) {
+	ctx := context.Background()
+	repo, prefix := newTestWebhookRepo(t)
+	now := time.Now().Truncate(time.Millisecond)
+	userID := prefix + "u"
+
+	hook := models.Webhook{ID: prefix + "hook", UserID: userID, URL: "https://example.com/hook", Secret: "secret", Events: []string{models.EventLinkClicked}, CreatedAt: now}
+	require.NoError(t, repo.AddWebhook(ctx, hook))
+
+	subscribers, err := repo.Subscribers(ctx, models.Event{Type: models.EventLinkClicked, UserID: userID, URLID: "abc"})
+	require.NoError(t, err)
+	require.Len(t, subscribers, 1)
+	assert.Equal(t, hook.Events, subscribers[0].Events)
+
+	require.NoError(t, repo.Enqueue(ctx, []models.Delivery{
+		{ID: prefix + "a", WebhookID: hook.ID, UserID: userID, Event: models.EventLinkClicked, Payload: []byte(`{}`), NextAttempt: now.Add(-time.Second), CreatedAt: now},
+		{ID: prefix + "b", WebhookID: hook.ID, UserID: userID, Event: models.EventLinkClicked, Payload: []byte(`{}`), NextAttempt: now.Add(time.Minute), CreatedAt: now},
+	}))
+
+	due, err := repo.Due(ctx, now, 10)
+	require.NoError(t, err)
+	require.Len(t, due, 1)
+	assert.Equal(t, prefix+"a", due[0].ID)
+	assert.True(t, due[0].NextAttempt.Equal(now.Add(-time.Second)))
+
+	// leased to this dispatcher, the others skip it
+	due, err = repo.Due(ctx, now, 10)
+	require.NoError(t, err)
+	assert.Empty(t, due)
+
+	require.NoError(t, repo.Bury(ctx, models.Delivery{ID: prefix + "a", WebhookID: hook.ID, UserID: userID, Attempts: 8, NextAttempt: now}))
+	assert.ErrorIs(t, repo.Reschedule(ctx, models.Delivery{ID: prefix + "a"}), errs.ErrDeliveryNotFound)
+
+	dead, err := repo.DeadLetters(ctx, userID)
+	require.NoError(t, err)
+	require.Len(t, dead, 1)
+	assert.Equal(t, 8, dead[0].Attempts)
+
+	assert.ErrorIs(t, repo.Requeue(ctx, "other", prefix+"a", now), errs.ErrDeliveryNotFound)
+	require.NoError(t, repo.Requeue(ctx, userID, prefix+"a", now))
+
+	require.NoError(t, repo.DeleteWebhook(ctx, userID, hook.ID))
+	due, err = repo.Due(ctx, now.Add(time.Hour), 10)
+	require.NoError(t, err)
+	for _, delivery := range due {
+		assert.NotEqual(t, hook.ID, delivery.WebhookID)
+	}
+}
+
+func TestPgWebhookRepo_BuryKeepsLatest(t *testing.T) {
+	ctx := context.Background()
+	repo, prefix := newTestWebhookRepo(t)
+	now := time.Now()
+	userID := prefix + "u"
+
+	for idx := 0; idx <= models.MaxDeadLetters; idx++ {
+		require.NoError(t, repo.Bury(ctx, models.Delivery{ID: fmt.Sprintf("%sd%d", prefix, idx), WebhookID: "hook", UserID: userID, Event: models.EventLinkClicked, NextAttempt: now.Add(time.Duration(idx) * time.Second)}))
+	}
+
+	dead, err := repo.DeadLetters(ctx, userID)
+	require.NoError(t, err)
+	require.Len(t, dead, models.MaxDeadLetters)
+	assert.Equal(t, fmt.Sprintf("%sd%d", prefix, models.MaxDeadLetters), dead[0].ID)
+}
@@ -1,21 +1,25 @@
 package file
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"encoding/gob"
 	"fmt"
 	"github.com/ChristinaFomenko/shortener/internal/app/models"
 	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	"io"
 	"os"
+	"sort"
 	"sync"
+	"time"
 )
 
 type fileRepository struct {
-	store    map[string]map[string]string
+	store    map[string]map[string]models.Link
 	ma       sync.RWMutex
 	filePath string
+	// imported links not written to the file yet
+	dirty bool
 }
 
 func NewRepo(filePath string) (*fileRepository, error) {
@@ -30,7 +34,7 @@ func NewRepo(filePath string) (*fileRepository, error) {
 	}, nil
 }
 
-func readLines(filePath string) (map[string]map[string]string, error) {
+func readLines(filePath string) (map[string]map[string]models.Link, error) {
 	file, err := os.OpenFile(filePath, os.O_CREATE, 0600)
 	if err != nil {
 		return nil, err
@@ -40,14 +44,18 @@ func readLines(filePath string) (map[string]map[string]string, error) {
 		_ = file.Close()
 	}(file)
 
-	scanner := bufio.NewScanner(file)
-	res := make(map[string]map[string]string)
+	// the gob stream is binary and may contain line breaks, so the whole file is read
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
 
-	if ok := scanner.Scan(); !ok {
+	res := make(map[string]map[string]models.Link)
+	if len(data) == 0 {
 		return res, nil
 	}
 
-	res, err = unmarshal(scanner.Bytes())
+	res, err = unmarshal(data)
 	if err != nil {
 		return nil, err
 	}
@@ -55,38 +63,40 @@ func readLines(filePath string) (map[string]map[string]string, error) {
 	return res, nil
 }
 
-// Add URL
-func (r *fileRepository) Add(_ context.Context, urlID, url, userID string) error {
+// Add URL, a link whose canonical url is already stored is reported with the stored id
+func (r *fileRepository) Add(_ context.Context, link models.Link) error {
 	r.ma.Lock()
 	defer r.ma.Unlock()
 
-	if doubleURLID, exists := r.urlExist(url); exists {
-		return errs.NewNotUniqueURLErr(doubleURLID, url, nil)
+	if doubleURLID, exists := r.urlExist(link.Canonical()); exists {
+		return errs.NewNotUniqueURLErr(doubleURLID, link.OriginalURL, nil)
 	}
 
-	userStore, ok := r.store[userID]
+	userStore, ok := r.store[link.UserID]
 	if !ok {
-		userStore = map[string]string{}
+		userStore = map[string]models.Link{}
 	}
 
-	userStore[urlID] = url
-	r.store[userID] = userStore
+	if link.CreatedAt.IsZero() {
+		link.CreatedAt = time.Now()
+	}
+	userStore[link.ID] = link
+	r.store[link.UserID] = userStore
 
 	return r.save()
 }
 
-// Get URL
-func (r *fileRepository) Get(_ context.Context, urlID string) (string, error) {
+// Get returns an active link
+func (r *fileRepository) Get(_ context.Context, urlID string) (models.Link, error) {
 	r.ma.RLock()
 	defer r.ma.RUnlock()
 
-	for _, userStore := range r.store {
-		if url, ok := userStore[urlID]; ok {
-			return url, nil
-		}
+	link, ok := r.find(urlID)
+	if !ok || link.Deleted() {
+		return models.Link{}, errs.ErrURLNotFound
 	}
 
-	return "", errs.ErrURLNotFound
+	return link, nil
 }
 
 func (r *fileRepository) FetchURLs(_ context.Context, userID string) ([]models.UserURL, error) {
@@ -100,40 +110,224 @@ func (r *fileRepository) FetchURLs(_ context.Context, userID string) ([]models.U
 		return urls, nil
 	}
 
-	for shortURL, originalURL := range userStore {
+	for shortURL, link := range userStore {
+		if link.Deleted() {
+			continue
+		}
+
 		urls = append(urls, models.UserURL{
 			ShortURL:    shortURL,
-			OriginalURL: originalURL,
+			OriginalURL: link.OriginalURL,
 		})
 	}
 
 	return urls, nil
 }
 
-func (r *fileRepository) AddBatch(_ context.Context, urls []models.UserURL, userID string) error {
+// IterateURLs calls fn for every active link of the user, the links are held in memory anyway
+func (r *fileRepository) IterateURLs(ctx context.Context, userID string, fn func(url models.UserURL) error) error {
+	urls, err := r.FetchURLs(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	for idx := range urls {
+		if err = fn(urls[idx]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// AddBatch stores the links whose urls are not shortened yet and reports the stored id for the others
+func (r *fileRepository) AddBatch(ctx context.Context, urls []models.UserURL, userID string) ([]models.BatchResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	r.ma.Lock()
 	defer r.ma.Unlock()
 
 	userStore, ok := r.store[userID]
 	if !ok {
-		userStore = map[string]string{}
+		userStore = map[string]models.Link{}
 	}
 
+	results := make([]models.BatchResult, len(urls))
+	now := time.Now()
 	for idx := range urls {
-		userStore[urls[idx].ShortURL] = urls[idx].OriginalURL
+		link := models.Link{
+			ID:            urls[idx].ShortURL,
+			OriginalURL:   urls[idx].OriginalURL,
+			NormalizedURL: urls[idx].NormalizedURL,
+			UserID:        userID,
+			RedirectCode:  urls[idx].RedirectCode,
+			Passthrough:   urls[idx].Passthrough,
+			CreatedAt:     now,
+		}
+
+		if doubleURLID, exists := r.urlExist(link.Canonical()); exists {
+			results[idx] = models.BatchResult{ID: doubleURLID}
+			continue
+		}
+
+		// the id is taken by another url, the result stays empty
+		if _, taken := r.find(link.ID); taken {
+			continue
+		}
+
+		userStore[link.ID] = link
+		r.store[userID] = userStore
+		results[idx] = models.BatchResult{ID: link.ID, Created: true}
+	}
+
+	return results, r.save()
+}
+
+// Update replaces the settings the owner may edit: redirect code, passthrough, targeting rules and variants
+func (r *fileRepository) Update(_ context.Context, link models.Link) error {
+	r.ma.Lock()
+	defer r.ma.Unlock()
+
+	stored, ok := r.find(link.ID)
+	if !ok {
+		return errs.ErrURLNotFound
+	}
+
+	stored.RedirectCode = link.RedirectCode
+	stored.Passthrough = link.Passthrough
+	stored.Rules = link.Rules
+	stored.Variants = link.Variants
+	stored.Sticky = link.Sticky
+	r.store[stored.UserID][stored.ID] = stored
+
+	return r.save()
+}
+
+// GetLink returns the link with its owner, deleted links included
+func (r *fileRepository) GetLink(_ context.Context, urlID string) (models.Link, error) {
+	r.ma.RLock()
+	defer r.ma.RUnlock()
+
+	link, ok := r.find(urlID)
+	if !ok {
+		return models.Link{}, errs.ErrURLNotFound
+	}
+
+	return link, nil
+}
+
+// FindByURL returns the link shortening the url given in its canonical or original form, deleted links included
+func (r *fileRepository) FindByURL(_ context.Context, url string) (models.Link, error) {
+	r.ma.RLock()
+	defer r.ma.RUnlock()
+
+	for _, userStore := range r.store {
+		for _, link := range userStore {
+			if link.Canonical() == url || link.OriginalURL == url {
+				return link, nil
+			}
+		}
+	}
+
+	return models.Link{}, errs.ErrURLNotFound
+}
+
+func (r *fileRepository) Delete(_ context.Context, urlID string) error {
+	r.ma.Lock()
+	defer r.ma.Unlock()
+
+	return r.setDeletedAt(urlID, time.Now())
+}
+
+func (r *fileRepository) Restore(_ context.Context, urlID string) error {
+	r.ma.Lock()
+	defer r.ma.Unlock()
+
+	return r.setDeletedAt(urlID, time.Time{})
+}
+
+// Import stores the link as is, keeping its id, owner and timestamps. Importing the same link again
+// refreshes its canonical url, owner and state. Imported links are written to the file by Flush or Close,
+// so a bulk import doesn't rewrite the whole file per link.
+func (r *fileRepository) Import(_ context.Context, link models.Link) error {
+	r.ma.Lock()
+	defer r.ma.Unlock()
+
+	existing, found := r.find(link.ID)
+	if found && existing.OriginalURL != link.OriginalURL {
+		return errs.ErrURLIDConflict
+	}
+
+	if doubleURLID, exists := r.urlExist(link.Canonical()); exists && doubleURLID != link.ID {
+		return errs.NewNotUniqueURLErr(doubleURLID, link.OriginalURL, nil)
 	}
 
-	r.store[userID] = userStore
+	if found {
+		delete(r.store[existing.UserID], existing.ID)
+	}
+
+	userStore, ok := r.store[link.UserID]
+	if !ok {
+		userStore = map[string]models.Link{}
+	}
+
+	userStore[link.ID] = link
+	r.store[link.UserID] = userStore
+	r.dirty = true
+
+	return nil
+}
+
+// Flush writes the imported links to the file
+func (r *fileRepository) Flush() error {
+	r.ma.Lock()
+	defer r.ma.Unlock()
+
+	if !r.dirty {
+		return nil
+	}
 
 	return r.save()
 }
 
+// Iterate calls fn for every link with id greater than afterID, deleted ones included, in ascending id order
+func (r *fileRepository) Iterate(ctx context.Context, afterID string, fn func(link models.Link) error) error {
+	r.ma.RLock()
+	links := make([]models.Link, 0)
+	for _, userStore := range r.store {
+		for _, link := range userStore {
+			if link.ID > afterID {
+				links = append(links, link)
+			}
+		}
+	}
+	r.ma.RUnlock()
+
+	sort.Slice(links, func(i, j int) bool {
+		return links[i].ID < links[j].ID
+	})
+
+	for idx := range links {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := fn(links[idx]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (r *fileRepository) Ping(_ context.Context) error {
 	return nil
 }
 
 func (r *fileRepository) Close() error {
-	return nil
+	return r.Flush()
 }
 
 func (r *fileRepository) save() error {
@@ -156,10 +350,12 @@ func (r *fileRepository) save() error {
 		return fmt.Errorf("write url to file error: %w", err)
 	}
 
+	r.dirty = false
+
 	return nil
 }
 
-func marshal(store map[string]map[string]string) ([]byte, error) {
+func marshal(store map[string]map[string]models.Link) ([]byte, error) {
 	var buff bytes.Buffer
 	encoder := gob.NewEncoder(&buff)
 
@@ -171,24 +367,75 @@ func marshal(store map[string]map[string]string) ([]byte, error) {
 	return buff.Bytes(), nil
 }
 
-func unmarshal(data []byte) (map[string]map[string]string, error) {
-	store := map[string]map[string]string{}
+func unmarshal(data []byte) (map[string]map[string]models.Link, error) {
+	store := map[string]map[string]models.Link{}
 
-	buff := bytes.NewBuffer(data)
-	decoder := gob.NewDecoder(buff)
+	decoder := gob.NewDecoder(bytes.NewBuffer(data))
 
 	err := decoder.Decode(&store)
+	if err != nil {
+		return unmarshalLegacy(data)
+	}
+
+	return store, nil
+}
+
+// unmarshalLegacy reads the storage format written before links kept their owner and state
+func unmarshalLegacy(data []byte) (map[string]map[string]models.Link, error) {
+	legacy := map[string]map[string]string{}
+
+	decoder := gob.NewDecoder(bytes.NewBuffer(data))
+
+	err := decoder.Decode(&legacy)
 	if err != nil {
 		return nil, err
 	}
 
+	store := make(map[string]map[string]models.Link, len(legacy))
+	for userID, userStore := range legacy {
+		store[userID] = make(map[string]models.Link, len(userStore))
+		for urlID, url := range userStore {
+			store[userID][urlID] = models.Link{
+				ID:          urlID,
+				OriginalURL: url,
+				UserID:      userID,
+			}
+		}
+	}
+
 	return store, nil
 }
 
+func (r *fileRepository) find(urlID string) (models.Link, bool) {
+	for _, userStore := range r.store {
+		if link, ok := userStore[urlID]; ok {
+			return link, true
+		}
+	}
+
+	return models.Link{}, false
+}
+
+func (r *fileRepository) setDeletedAt(urlID string, deletedAt time.Time) error {
+	link, ok := r.find(urlID)
+	if !ok {
+		return errs.ErrURLNotFound
+	}
+
+	if link.Deleted() && !deletedAt.IsZero() {
+		return nil
+	}
+
+	link.DeletedAt = deletedAt
+	r.store[link.UserID][urlID] = link
+
+	return r.save()
+}
+
 func (r *fileRepository) urlExist(url string) (string, bool) {
 	for _, userStore := range r.store {
-		for urlID, originalURL := range userStore {
-			if url == originalURL {
+		for urlID, link := range userStore {
+			if url == link.Canonical() {
 				return urlID, true
 			}
 		}
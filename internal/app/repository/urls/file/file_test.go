@@ -1,9 +1,14 @@
 package file
 
 import (
+	"bytes"
 	"context"
+	"encoding/gob"
+	"github.com/ChristinaFomenko/shortener/internal/app/models"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"net/http"
 	"os"
 	"testing"
 )
@@ -23,7 +28,7 @@ func TestFileRepo_Add(t *testing.T) {
 		_ = os.Remove(filePath)
 	}()
 
-	err = repo.Add(ctx, "qwe", "yandex.ru", defaultUserID)
+	err = repo.Add(ctx, models.Link{ID: "qwe", OriginalURL: "yandex.ru", UserID: defaultUserID})
 	require.NoError(t, err)
 }
 
@@ -37,13 +42,14 @@ func TestFileRepo_Get(t *testing.T) {
 		_ = os.Remove(filePath)
 	}()
 
-	err = repo.Add(ctx, "abc", "yandex.ru", defaultUserID)
+	err = repo.Add(ctx, models.Link{ID: "abc", OriginalURL: "yandex.ru", UserID: defaultUserID, RedirectCode: http.StatusMovedPermanently})
 	require.NoError(t, err)
 
 	act, err := repo.Get(ctx, "abc")
 
 	assert.NoError(t, err)
-	assert.Equal(t, "yandex.ru", act)
+	assert.Equal(t, "yandex.ru", act.OriginalURL)
+	assert.Equal(t, http.StatusMovedPermanently, act.RedirectCode)
 }
 
 func TestFileRepo_FetchURls_Success(t *testing.T) {
@@ -56,10 +62,10 @@ func TestFileRepo_FetchURls_Success(t *testing.T) {
 		_ = os.Remove(filePath)
 	}()
 
-	err = repo.Add(ctx, "qwerty", "yandex.ru", defaultUserID)
+	err = repo.Add(ctx, models.Link{ID: "qwerty", OriginalURL: "yandex.ru", UserID: defaultUserID})
 	require.NoError(t, err)
 
-	err = repo.Add(ctx, "ytrewq", "avito.ru", defaultUserID)
+	err = repo.Add(ctx, models.Link{ID: "ytrewq", OriginalURL: "avito.ru", UserID: defaultUserID})
 	require.NoError(t, err)
 
 	repo, err = NewRepo(filePath)
@@ -81,10 +87,10 @@ func TestFileRepo_FetchURls_NotFound(t *testing.T) {
 		_ = os.Remove(filePath)
 	}()
 
-	err = repo.Add(ctx, "qwerty", "avito.ru", defaultUserID)
+	err = repo.Add(ctx, models.Link{ID: "qwerty", OriginalURL: "avito.ru", UserID: defaultUserID})
 	require.NoError(t, err)
 
-	err = repo.Add(ctx, "ytrewq", "yandex.ru", defaultUserID)
+	err = repo.Add(ctx, models.Link{ID: "ytrewq", OriginalURL: "yandex.ru", UserID: defaultUserID})
 	require.NoError(t, err)
 
 	repo, err = NewRepo(filePath)
@@ -109,3 +115,163 @@ func TestFileRepo_Ping(t *testing.T) {
 	err = repo.Ping(ctx)
 	assert.NoError(t, err)
 }
+
+func TestFileRepo_DeleteRestore(t *testing.T) {
+	ctx := context.Background()
+
+	repo, err := NewRepo(filePath)
+	require.NoError(t, err)
+
+	defer func() {
+		_ = os.Remove(filePath)
+	}()
+
+	err = repo.Add(ctx, models.Link{ID: "qwerty", OriginalURL: "yandex.ru", UserID: defaultUserID})
+	require.NoError(t, err)
+
+	err = repo.Delete(ctx, "qwerty")
+	require.NoError(t, err)
+
+	repo, err = NewRepo(filePath)
+	require.NoError(t, err)
+
+	_, err = repo.Get(ctx, "qwerty")
+	assert.ErrorIs(t, err, errs.ErrURLNotFound)
+
+	link, err := repo.GetLink(ctx, "qwerty")
+	require.NoError(t, err)
+	assert.True(t, link.Deleted())
+	assert.Equal(t, defaultUserID, link.UserID)
+
+	err = repo.Restore(ctx, "qwerty")
+	require.NoError(t, err)
+
+	act, err := repo.Get(ctx, "qwerty")
+	require.NoError(t, err)
+	assert.Equal(t, "yandex.ru", act.OriginalURL)
+}
+
+func TestFileRepo_LegacyFormat(t *testing.T) {
+	ctx := context.Background()
+
+	var buff bytes.Buffer
+	err := gob.NewEncoder(&buff).Encode(map[string]map[string]string{
+		defaultUserID: {"qwerty": "yandex.ru"},
+	})
+	require.NoError(t, err)
+
+	err = os.WriteFile(filePath, buff.Bytes(), 0600)
+	require.NoError(t, err)
+
+	defer func() {
+		_ = os.Remove(filePath)
+	}()
+
+	repo, err := NewRepo(filePath)
+	require.NoError(t, err)
+
+	link, err := repo.GetLink(ctx, "qwerty")
+	require.NoError(t, err)
+	assert.Equal(t, models.Link{ID: "qwerty", OriginalURL: "yandex.ru", UserID: defaultUserID}, link)
+}
+
+func TestFileRepo_AddDeduplicatesCanonicalURL(t *testing.T) {
+	ctx := context.Background()
+
+	repo, err := NewRepo(filePath)
+	require.NoError(t, err)
+
+	defer func() {
+		_ = os.Remove(filePath)
+	}()
+
+	err = repo.Add(ctx, models.Link{
+		ID:            "qwerty",
+		OriginalURL:   "http://Example.com/a/../b?utm_source=x",
+		NormalizedURL: "http://example.com/b",
+		UserID:        defaultUserID,
+	})
+	require.NoError(t, err)
+
+	err = repo.Add(ctx, models.Link{
+		ID:            "ytrewq",
+		OriginalURL:   "http://example.com/b",
+		NormalizedURL: "http://example.com/b",
+		UserID:        "other",
+	})
+	var uniqueErr *errs.NotUniqueURLErr
+	require.ErrorAs(t, err, &uniqueErr)
+	assert.Equal(t, "qwerty", uniqueErr.URLID)
+
+	act, err := repo.Get(ctx, "qwerty")
+	require.NoError(t, err)
+	assert.Equal(t, "http://Example.com/a/../b?utm_source=x", act.OriginalURL)
+
+	link, err := repo.FindByURL(ctx, "http://example.com/b")
+	require.NoError(t, err)
+	assert.Equal(t, "qwerty", link.ID)
+}
+
+func TestFileRepo_Update(t *testing.T) {
+	ctx := context.Background()
+
+	repo, err := NewRepo(filePath)
+	require.NoError(t, err)
+
+	defer func() {
+		_ = os.Remove(filePath)
+	}()
+
+	require.NoError(t, repo.Add(ctx, models.Link{ID: "qwerty", OriginalURL: "https://app.example", UserID: defaultUserID}))
+
+	rules := []models.TargetRule{{OS: models.OSIOS, URL: "https://apps.apple.com/app/id1"}}
+	variants := []models.Variant{{Name: "a", URL: "https://app.example/a", Weight: 1}}
+	require.NoError(t, repo.Update(ctx, models.Link{
+		ID:          "qwerty",
+		OriginalURL: "ignored",
+		UserID:      "ignored",
+		Rules:       rules,
+		Variants:    variants,
+		Sticky:      true,
+	}))
+
+	reopened, err := NewRepo(filePath)
+	require.NoError(t, err)
+
+	link, err := reopened.Get(ctx, "qwerty")
+	require.NoError(t, err)
+	assert.Equal(t, "https://app.example", link.OriginalURL)
+	assert.Equal(t, defaultUserID, link.UserID)
+	assert.Equal(t, rules, link.Rules)
+	assert.Equal(t, variants, link.Variants)
+	assert.True(t, link.Sticky)
+
+	assert.ErrorIs(t, repo.Update(ctx, models.Link{ID: "missing"}), errs.ErrURLNotFound)
+}
+
+func TestFileRepo_ImportWrittenOnFlush(t *testing.T) {
+	ctx := context.Background()
+
+	repo, err := NewRepo(filePath)
+	require.NoError(t, err)
+
+	defer func() {
+		_ = os.Remove(filePath)
+	}()
+
+	link := models.Link{ID: "imported", OriginalURL: "yandex.ru", UserID: defaultUserID}
+	require.NoError(t, repo.Import(ctx, link))
+
+	reopened, err := NewRepo(filePath)
+	require.NoError(t, err)
+	_, err = reopened.GetLink(ctx, link.ID)
+	assert.ErrorIs(t, err, errs.ErrURLNotFound)
+
+	require.NoError(t, repo.Flush())
+
+	reopened, err = NewRepo(filePath)
+	require.NoError(t, err)
+	act, err := reopened.GetLink(ctx, link.ID)
+	require.NoError(t, err)
+	assert.Equal(t, link, act)
+}
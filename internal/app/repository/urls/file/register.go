@@ -0,0 +1,29 @@
+package file
+
+import (
+	repositoryURL "github.com/ChristinaFomenko/shortener/internal/app/repository/urls"
+	"net/url"
+)
+
+func init() {
+	repositoryURL.Register(repositoryURL.BackendFile, open, "file")
+}
+
+// open takes the file from a file:///absolute/path or file:relative/path url
+func open(u *url.URL) (repositoryURL.Repo, error) {
+	filePath, err := repositoryURL.FilePath(u)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = repositoryURL.CheckOptions(u); err != nil {
+		return nil, err
+	}
+
+	r, err := NewRepo(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
@@ -0,0 +1,81 @@
+package invalidating
+
+import (
+	"context"
+	"github.com/ChristinaFomenko/shortener/internal/app/invalidation"
+	"github.com/ChristinaFomenko/shortener/internal/app/models"
+	repositoryURL "github.com/ChristinaFomenko/shortener/internal/app/repository/urls"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// publishTimeout bounds publishing after a change, the change is kept even when the request is gone by then
+const publishTimeout = 3 * time.Second
+
+type publisher interface {
+	Publish(ctx context.Context, event invalidation.Event) error
+}
+
+type repository struct {
+	repositoryURL.Repo
+	bus publisher
+}
+
+// NewRepo decorates the repository with an event on the bus per changed link. A failed publish
+// is logged, the change itself has succeeded.
+func NewRepo(repo repositoryURL.Repo, bus publisher) *repository {
+	return &repository{
+		Repo: repo,
+		bus:  bus,
+	}
+}
+
+func (r *repository) Update(ctx context.Context, link models.Link) error {
+	if err := r.Repo.Update(ctx, link); err != nil {
+		return err
+	}
+
+	r.publish(invalidation.EventUpdate, link.ID)
+
+	return nil
+}
+
+func (r *repository) Delete(ctx context.Context, urlID string) error {
+	if err := r.Repo.Delete(ctx, urlID); err != nil {
+		return err
+	}
+
+	r.publish(invalidation.EventDelete, urlID)
+
+	return nil
+}
+
+func (r *repository) Restore(ctx context.Context, urlID string) error {
+	if err := r.Repo.Restore(ctx, urlID); err != nil {
+		return err
+	}
+
+	r.publish(invalidation.EventUpdate, urlID)
+
+	return nil
+}
+
+// Import may replace the owner and state of a stored link
+func (r *repository) Import(ctx context.Context, link models.Link) error {
+	if err := r.Repo.Import(ctx, link); err != nil {
+		return err
+	}
+
+	r.publish(invalidation.EventUpdate, link.ID)
+
+	return nil
+}
+
+func (r *repository) publish(eventType, urlID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+
+	if err := r.bus.Publish(ctx, invalidation.Event{Type: eventType, URLID: urlID}); err != nil {
+		log.WithError(err).WithField("urlID", urlID).WithField("type", eventType).Error("publish invalidation event error")
+	}
+}
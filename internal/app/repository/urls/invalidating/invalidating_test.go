@@ -0,0 +1,39 @@
+package invalidating
+
+import (
+	"context"
+	"github.com/ChristinaFomenko/shortener/internal/app/invalidation"
+	"github.com/ChristinaFomenko/shortener/internal/app/models"
+	"github.com/ChristinaFomenko/shortener/internal/app/repository/urls/memory"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+)
+
+func TestRepository_Publishes(t *testing.T) {
+	ctx := context.Background()
+
+	bus := invalidation.NewMemoryBus()
+	var act []invalidation.Event
+	bus.Subscribe(func(event invalidation.Event) {
+		act = append(act, event)
+	})
+
+	repo := NewRepo(memory.NewRepo(), bus)
+
+	require.NoError(t, repo.Add(ctx, models.Link{ID: "abc", OriginalURL: "https://yandex.ru", UserID: "user"}))
+	require.NoError(t, repo.Update(ctx, models.Link{ID: "abc", RedirectCode: http.StatusFound}))
+	require.NoError(t, repo.Delete(ctx, "abc"))
+	require.NoError(t, repo.Restore(ctx, "abc"))
+
+	// failed changes are not published
+	assert.ErrorIs(t, repo.Delete(ctx, "missing"), errs.ErrURLNotFound)
+
+	assert.Equal(t, []invalidation.Event{
+		{Type: invalidation.EventUpdate, URLID: "abc"},
+		{Type: invalidation.EventDelete, URLID: "abc"},
+		{Type: invalidation.EventUpdate, URLID: "abc"},
+	}, act)
+}
@@ -0,0 +1,21 @@
+package memory
+
+import (
+	"fmt"
+	repositoryURL "github.com/ChristinaFomenko/shortener/internal/app/repository/urls"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	"net/url"
+)
+
+func init() {
+	repositoryURL.Register(repositoryURL.BackendMemory, open, "memory")
+}
+
+// open accepts memory:// only, the links are gone on restart
+func open(u *url.URL) (repositoryURL.Repo, error) {
+	if u.Host != "" || u.Path != "" || u.Opaque != "" || u.RawQuery != "" {
+		return nil, fmt.Errorf("%w: memory storage takes no path or options, use memory://", errs.ErrInvalidStorageURL)
+	}
+
+	return NewRepo(), nil
+}
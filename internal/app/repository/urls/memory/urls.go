@@ -4,52 +4,56 @@ import (
 	"context"
 	"github.com/ChristinaFomenko/shortener/internal/app/models"
 	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	"sort"
 	"sync"
+	"time"
 )
 
 type repository struct {
-	store map[string]map[string]string
+	store map[string]map[string]models.Link
 	ma    sync.RWMutex
 }
 
 func NewRepo() *repository {
 	return &repository{
-		store: map[string]map[string]string{},
+		store: map[string]map[string]models.Link{},
 	}
 }
 
-// Add URL
-func (r *repository) Add(_ context.Context, urlID, url, userID string) error {
+// Add URL, a link whose canonical url is already stored is reported with the stored id
+func (r *repository) Add(_ context.Context, link models.Link) error {
 	r.ma.Lock()
 	defer r.ma.Unlock()
 
-	if doubleURLID, exists := r.urlExist(url); exists {
-		return errs.NewNotUniqueURLErr(doubleURLID, url, nil)
+	if doubleURLID, exists := r.urlExist(link.Canonical()); exists {
+		return errs.NewNotUniqueURLErr(doubleURLID, link.OriginalURL, nil)
 	}
 
-	userStore, ok := r.store[userID]
+	userStore, ok := r.store[link.UserID]
 	if !ok {
-		userStore = map[string]string{}
+		userStore = map[string]models.Link{}
 	}
 
-	userStore[urlID] = url
-	r.store[userID] = userStore
+	if link.CreatedAt.IsZero() {
+		link.CreatedAt = time.Now()
+	}
+	userStore[link.ID] = link
+	r.store[link.UserID] = userStore
 
 	return nil
 }
 
-// Get URL
-func (r *repository) Get(_ context.Context, urlID string) (string, error) {
+// Get returns an active link
+func (r *repository) Get(_ context.Context, urlID string) (models.Link, error) {
 	r.ma.RLock()
 	defer r.ma.RUnlock()
 
-	for _, userStore := range r.store {
-		if url, ok := userStore[urlID]; ok {
-			return url, nil
-		}
+	link, ok := r.find(urlID)
+	if !ok || link.Deleted() {
+		return models.Link{}, errs.ErrURLNotFound
 	}
 
-	return "", errs.ErrURLNotFound
+	return link, nil
 }
 
 func (r *repository) FetchURLs(_ context.Context, userID string) ([]models.UserURL, error) {
@@ -63,16 +67,36 @@ func (r *repository) FetchURLs(_ context.Context, userID string) ([]models.UserU
 		return urls, nil
 	}
 
-	for shortURL, originalURL := range userStore {
+	for shortURL, link := range userStore {
+		if link.Deleted() {
+			continue
+		}
+
 		urls = append(urls, models.UserURL{
 			ShortURL:    shortURL,
-			OriginalURL: originalURL,
+			OriginalURL: link.OriginalURL,
 		})
 	}
 
 	return urls, nil
 }
 
+// IterateURLs calls fn for every active link of the user, the links are held in memory anyway
+func (r *repository) IterateURLs(ctx context.Context, userID string, fn func(url models.UserURL) error) error {
+	urls, err := r.FetchURLs(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	for idx := range urls {
+		if err = fn(urls[idx]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (r *repository) Ping(_ context.Context) error {
 	return nil
 }
@@ -81,28 +105,204 @@ func (r *repository) Close() error {
 	return nil
 }
 
-func (r *repository) AddBatch(_ context.Context, urls []models.UserURL, userID string) error {
+// AddBatch stores the links whose urls are not shortened yet and reports the stored id for the others
+func (r *repository) AddBatch(ctx context.Context, urls []models.UserURL, userID string) ([]models.BatchResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	r.ma.Lock()
 	defer r.ma.Unlock()
 
 	userStore, ok := r.store[userID]
 	if !ok {
-		userStore = map[string]string{}
+		userStore = map[string]models.Link{}
 	}
 
+	results := make([]models.BatchResult, len(urls))
+	now := time.Now()
 	for idx := range urls {
-		userStore[urls[idx].ShortURL] = urls[idx].OriginalURL
+		link := models.Link{
+			ID:            urls[idx].ShortURL,
+			OriginalURL:   urls[idx].OriginalURL,
+			NormalizedURL: urls[idx].NormalizedURL,
+			UserID:        userID,
+			RedirectCode:  urls[idx].RedirectCode,
+			Passthrough:   urls[idx].Passthrough,
+			CreatedAt:     now,
+		}
+
+		if doubleURLID, exists := r.urlExist(link.Canonical()); exists {
+			results[idx] = models.BatchResult{ID: doubleURLID}
+			continue
+		}
+
+		// the id is taken by another url, the result stays empty
+		if _, taken := r.find(link.ID); taken {
+			continue
+		}
+
+		userStore[link.ID] = link
+		r.store[userID] = userStore
+		results[idx] = models.BatchResult{ID: link.ID, Created: true}
+	}
+
+	return results, nil
+}
+
+// Update replaces the settings the owner may edit: redirect code, passthrough, targeting rules and variants
+func (r *repository) Update(_ context.Context, link models.Link) error {
+	r.ma.Lock()
+	defer r.ma.Unlock()
+
+	stored, ok := r.find(link.ID)
+	if !ok {
+		return errs.ErrURLNotFound
+	}
+
+	stored.RedirectCode = link.RedirectCode
+	stored.Passthrough = link.Passthrough
+	stored.Rules = link.Rules
+	stored.Variants = link.Variants
+	stored.Sticky = link.Sticky
+	r.store[stored.UserID][stored.ID] = stored
+
+	return nil
+}
+
+// GetLink returns the link with its owner, deleted links included
+func (r *repository) GetLink(_ context.Context, urlID string) (models.Link, error) {
+	r.ma.RLock()
+	defer r.ma.RUnlock()
+
+	link, ok := r.find(urlID)
+	if !ok {
+		return models.Link{}, errs.ErrURLNotFound
+	}
+
+	return link, nil
+}
+
+// FindByURL returns the link shortening the url given in its canonical or original form, deleted links included
+func (r *repository) FindByURL(_ context.Context, url string) (models.Link, error) {
+	r.ma.RLock()
+	defer r.ma.RUnlock()
+
+	for _, userStore := range r.store {
+		for _, link := range userStore {
+			if link.Canonical() == url || link.OriginalURL == url {
+				return link, nil
+			}
+		}
+	}
+
+	return models.Link{}, errs.ErrURLNotFound
+}
+
+func (r *repository) Delete(_ context.Context, urlID string) error {
+	r.ma.Lock()
+	defer r.ma.Unlock()
+
+	return r.setDeletedAt(urlID, time.Now())
+}
+
+func (r *repository) Restore(_ context.Context, urlID string) error {
+	r.ma.Lock()
+	defer r.ma.Unlock()
+
+	return r.setDeletedAt(urlID, time.Time{})
+}
+
+// Import stores the link as is, keeping its id, owner and timestamps. Importing the same link again
+// refreshes its canonical url, owner and state.
+func (r *repository) Import(_ context.Context, link models.Link) error {
+	r.ma.Lock()
+	defer r.ma.Unlock()
+
+	existing, found := r.find(link.ID)
+	if found && existing.OriginalURL != link.OriginalURL {
+		return errs.ErrURLIDConflict
+	}
+
+	if doubleURLID, exists := r.urlExist(link.Canonical()); exists && doubleURLID != link.ID {
+		return errs.NewNotUniqueURLErr(doubleURLID, link.OriginalURL, nil)
+	}
+
+	if found {
+		delete(r.store[existing.UserID], existing.ID)
+	}
+
+	userStore, ok := r.store[link.UserID]
+	if !ok {
+		userStore = map[string]models.Link{}
+	}
+
+	userStore[link.ID] = link
+	r.store[link.UserID] = userStore
+
+	return nil
+}
+
+// Iterate calls fn for every link with id greater than afterID, deleted ones included, in ascending id order
+func (r *repository) Iterate(ctx context.Context, afterID string, fn func(link models.Link) error) error {
+	r.ma.RLock()
+	links := make([]models.Link, 0)
+	for _, userStore := range r.store {
+		for _, link := range userStore {
+			if link.ID > afterID {
+				links = append(links, link)
+			}
+		}
+	}
+	r.ma.RUnlock()
+
+	sort.Slice(links, func(i, j int) bool {
+		return links[i].ID < links[j].ID
+	})
+
+	for idx := range links {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := fn(links[idx]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *repository) find(urlID string) (models.Link, bool) {
+	for _, userStore := range r.store {
+		if link, ok := userStore[urlID]; ok {
+			return link, true
+		}
+	}
+
+	return models.Link{}, false
+}
+
+func (r *repository) setDeletedAt(urlID string, deletedAt time.Time) error {
+	link, ok := r.find(urlID)
+	if !ok {
+		return errs.ErrURLNotFound
+	}
+
+	if link.Deleted() && !deletedAt.IsZero() {
+		return nil
 	}
 
-	r.store[userID] = userStore
+	link.DeletedAt = deletedAt
+	r.store[link.UserID][urlID] = link
 
 	return nil
 }
 
 func (r *repository) urlExist(url string) (string, bool) {
 	for _, userStore := range r.store {
-		for urlID, originalURL := range userStore {
-			if url == originalURL {
+		for urlID, link := range userStore {
+			if url == link.Canonical() {
 				return urlID, true
 			}
 		}
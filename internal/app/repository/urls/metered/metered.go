@@ -0,0 +1,152 @@
+package metered
+
+import (
+	"context"
+	"errors"
+	"github.com/ChristinaFomenko/shortener/internal/app/models"
+	repositoryURL "github.com/ChristinaFomenko/shortener/internal/app/repository/urls"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	"github.com/ChristinaFomenko/shortener/pkg/metrics"
+	"time"
+)
+
+type repository struct {
+	repositoryURL.Repo
+	backend      string
+	latency      *metrics.HistogramVec
+	errors       *metrics.CounterVec
+	linksCreated *metrics.CounterVec
+}
+
+// NewRepo decorates the repository with per operation latency and error metrics of the backend
+func NewRepo(repo repositoryURL.Repo, backend string, registry *metrics.Registry) *repository {
+	return &repository{
+		Repo:    repo,
+		backend: backend,
+		latency: registry.NewHistogramVec("shortener_repository_operation_duration_seconds",
+			"Storage operation latency, by backend and operation.", metrics.DefBuckets, "backend", "operation"),
+		errors: registry.NewCounterVec("shortener_repository_errors_total",
+			"Storage operation failures, by backend and operation.", "backend", "operation"),
+		linksCreated: registry.NewCounterVec("shortener_links_created_total",
+			"Short links created."),
+	}
+}
+
+func (r *repository) Add(ctx context.Context, link models.Link) error {
+	err := r.observe("add", time.Now(), func() error {
+		return r.Repo.Add(ctx, link)
+	})
+	if err == nil {
+		r.linksCreated.Inc()
+	}
+
+	return err
+}
+
+func (r *repository) Get(ctx context.Context, urlID string) (link models.Link, err error) {
+	err = r.observe("get", time.Now(), func() error {
+		link, err = r.Repo.Get(ctx, urlID)
+		return err
+	})
+
+	return link, err
+}
+
+func (r *repository) FetchURLs(ctx context.Context, userID string) (urls []models.UserURL, err error) {
+	err = r.observe("fetch_urls", time.Now(), func() error {
+		urls, err = r.Repo.FetchURLs(ctx, userID)
+		return err
+	})
+
+	return urls, err
+}
+
+func (r *repository) Ping(ctx context.Context) error {
+	return r.observe("ping", time.Now(), func() error {
+		return r.Repo.Ping(ctx)
+	})
+}
+
+func (r *repository) AddBatch(ctx context.Context, urls []models.UserURL, userID string) ([]models.BatchResult, error) {
+	var results []models.BatchResult
+	err := r.observe("add_batch", time.Now(), func() (err error) {
+		results, err = r.Repo.AddBatch(ctx, urls, userID)
+		return err
+	})
+
+	created := 0
+	for idx := range results {
+		if results[idx].Created {
+			created++
+		}
+	}
+	r.linksCreated.Add(float64(created))
+
+	return results, err
+}
+
+func (r *repository) GetLink(ctx context.Context, urlID string) (link models.Link, err error) {
+	err = r.observe("get_link", time.Now(), func() error {
+		link, err = r.Repo.GetLink(ctx, urlID)
+		return err
+	})
+
+	return link, err
+}
+
+func (r *repository) FindByURL(ctx context.Context, url string) (link models.Link, err error) {
+	err = r.observe("find_by_url", time.Now(), func() error {
+		link, err = r.Repo.FindByURL(ctx, url)
+		return err
+	})
+
+	return link, err
+}
+
+func (r *repository) Delete(ctx context.Context, urlID string) error {
+	return r.observe("delete", time.Now(), func() error {
+		return r.Repo.Delete(ctx, urlID)
+	})
+}
+
+func (r *repository) Update(ctx context.Context, link models.Link) error {
+	return r.observe("update", time.Now(), func() error {
+		return r.Repo.Update(ctx, link)
+	})
+}
+
+func (r *repository) Restore(ctx context.Context, urlID string) error {
+	return r.observe("restore", time.Now(), func() error {
+		return r.Repo.Restore(ctx, urlID)
+	})
+}
+
+func (r *repository) Import(ctx context.Context, link models.Link) error {
+	return r.observe("import", time.Now(), func() error {
+		return r.Repo.Import(ctx, link)
+	})
+}
+
+func (r *repository) Iterate(ctx context.Context, afterID string, fn func(link models.Link) error) error {
+	return r.observe("iterate", time.Now(), func() error {
+		return r.Repo.Iterate(ctx, afterID, fn)
+	})
+}
+
+func (r *repository) observe(operation string, start time.Time, fn func() error) error {
+	err := fn()
+
+	r.latency.Observe(time.Since(start).Seconds(), r.backend, operation)
+	if err != nil && !isExpected(err) {
+		r.errors.Inc(r.backend, operation)
+	}
+
+	return err
+}
+
+// isExpected tells apart business outcomes from storage failures
+func isExpected(err error) bool {
+	var uniqueErr *errs.NotUniqueURLErr
+
+	return errors.Is(err, errs.ErrURLNotFound) || errors.As(err, &uniqueErr)
+}
@@ -0,0 +1,89 @@
+package sharded
+
+import (
+	"context"
+	"github.com/ChristinaFomenko/shortener/internal/app/models"
+	repositoryURL "github.com/ChristinaFomenko/shortener/internal/app/repository/urls"
+	"sync"
+)
+
+// Iterate calls fn for every link with id greater than afterID, deleted ones included, in ascending id order.
+// The shards are iterated at once and merged.
+func (r *repository) Iterate(ctx context.Context, afterID string, fn func(link models.Link) error) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	streams := make([]*stream, len(r.shards))
+	for idx, shard := range r.shards {
+		streams[idx] = iterate(ctx, &wg, shard, afterID)
+	}
+
+	for _, s := range streams {
+		if err := s.advance(); err != nil {
+			return err
+		}
+	}
+
+	for {
+		var next *stream
+		for _, s := range streams {
+			if s.ok && (next == nil || s.head.ID < next.head.ID) {
+				next = s
+			}
+		}
+
+		if next == nil {
+			return nil
+		}
+
+		if err := fn(next.head); err != nil {
+			return err
+		}
+
+		if err := next.advance(); err != nil {
+			return err
+		}
+	}
+}
+
+// stream hands over the links of a shard one at a time, head is the current one while ok
+type stream struct {
+	links chan models.Link
+	err   error
+	head  models.Link
+	ok    bool
+}
+
+func iterate(ctx context.Context, wg *sync.WaitGroup, shard repositoryURL.Repo, afterID string) *stream {
+	s := &stream{links: make(chan models.Link)}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(s.links)
+
+		s.err = shard.Iterate(ctx, afterID, func(link models.Link) error {
+			select {
+			case s.links <- link:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+
+	return s
+}
+
+// advance moves to the next link, the error of the shard is returned once it has no more
+func (s *stream) advance() error {
+	s.head, s.ok = <-s.links
+	if s.ok {
+		return nil
+	}
+
+	return s.err
+}
@@ -0,0 +1,110 @@
+package sharded
+
+import (
+	"fmt"
+	repositoryURL "github.com/ChristinaFomenko/shortener/internal/app/repository/urls"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	"net/url"
+)
+
+const (
+	optionShard = "shard"
+	optionIndex = "index"
+)
+
+// PartOpener opens a shard or an index from its storage url
+type PartOpener func(storageURL string) (repositoryURL.Repo, error)
+
+func init() {
+	repositoryURL.Register(repositoryURL.BackendSharded, func(u *url.URL) (repositoryURL.Repo, error) {
+		return open(u, repositoryURL.NewStorage)
+	}, "sharded")
+}
+
+// NewStorage opens a sharded storage like repositoryURL.NewStorage with every shard and index opened by openPart,
+// e.g. to open the databases with the options of the server and give each its own circuit breaker
+// so one failing storage doesn't turn the others away
+func NewStorage(storageURL string, openPart PartOpener) (repositoryURL.Repo, error) {
+	u, err := url.Parse(storageURL)
+	if err != nil || u.Scheme != "sharded" {
+		return nil, fmt.Errorf("%w: %s is not a sharded storage", errs.ErrInvalidStorageURL, storageURL)
+	}
+
+	return open(u, openPart)
+}
+
+// open takes the url-encoded storage urls of the shards and of the indexes as repeated options,
+// e.g. sharded://?shard=postgres%3A%2F%2Fhost1%2Fdb&shard=postgres%3A%2F%2Fhost2%2Fdb&index=postgres%3A%2F%2Fhost3%2Fdb.
+// The order of the urls decides where the links go, it must not change.
+func open(u *url.URL, openPart PartOpener) (repositoryURL.Repo, error) {
+	if u.Host != "" || u.Path != "" || u.Opaque != "" {
+		return nil, fmt.Errorf("%w: sharded storage takes options only, use sharded://?shard=...&index=...", errs.ErrInvalidStorageURL)
+	}
+
+	if err := repositoryURL.CheckOptions(u, optionShard, optionIndex); err != nil {
+		return nil, err
+	}
+
+	query := u.Query()
+	if len(query[optionShard]) == 0 || len(query[optionIndex]) == 0 {
+		return nil, fmt.Errorf("%w: sharded storage needs a %s and an %s at least", errs.ErrInvalidStorageURL, optionShard, optionIndex)
+	}
+
+	shardURLs := query[optionShard]
+	storageURLs := append(shardURLs[:len(shardURLs):len(shardURLs)], query[optionIndex]...)
+
+	// an index sharing a storage with a shard would take its links for claims, memory:// is a new one each time
+	seen := map[string]bool{}
+	for _, storageURL := range storageURLs {
+		backend, _ := repositoryURL.Backend(storageURL)
+		switch {
+		case backend == repositoryURL.BackendSharded:
+			return nil, fmt.Errorf("%w: a shard can't be sharded", errs.ErrInvalidStorageURL)
+		case backend != repositoryURL.BackendMemory && seen[storageURL]:
+			return nil, fmt.Errorf("%w: %s is used twice", errs.ErrInvalidStorageURL, storageURL)
+		}
+		seen[storageURL] = true
+	}
+
+	repos := make([]repositoryURL.Repo, 0, len(storageURLs))
+	for _, storageURL := range storageURLs {
+		repo, err := openPart(storageURL)
+		if err != nil {
+			for _, opened := range repos {
+				_ = opened.Close()
+			}
+			return nil, err
+		}
+		repos = append(repos, repo)
+	}
+
+	return NewRepo(repos[:len(shardURLs)], repos[len(shardURLs):]), nil
+}
+
+// Database returns the url of a postgres database every instance opening the storage url shares: the storage
+// itself when it is one, otherwise the first shard or index that is one. Ok is false when there is none.
+func Database(storageURL string) (string, bool) {
+	backend, err := repositoryURL.Backend(storageURL)
+	switch {
+	case err != nil:
+		return "", false
+	case backend == repositoryURL.BackendDatabase:
+		return storageURL, true
+	case backend != repositoryURL.BackendSharded:
+		return "", false
+	}
+
+	u, err := url.Parse(storageURL)
+	if err != nil {
+		return "", false
+	}
+
+	query := u.Query()
+	for _, partURL := range append(query[optionShard], query[optionIndex]...) {
+		if backend, _ := repositoryURL.Backend(partURL); backend == repositoryURL.BackendDatabase {
+			return partURL, true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,456 @@
+package sharded
+
+import (
+	"context"
+	"errors"
+	"github.com/ChristinaFomenko/shortener/internal/app/models"
+	repositoryURL "github.com/ChristinaFomenko/shortener/internal/app/repository/urls"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// claimGrace is how long an add has to store the link it claimed the url for, well over the time
+// a storage takes to answer or fail, the clocks of the instances and storages may differ a bit too
+const claimGrace = time.Minute
+
+type repository struct {
+	shards  []repositoryURL.Repo
+	indexes []repositoryURL.Repo
+}
+
+// NewRepo spreads the links over the shards by a hash of their id. The urls are deduplicated through
+// the indexes, spread by a hash of the canonical url, an index entry is a link with the url and the id
+// of the link shortening it. Changing the number of shards or indexes moves the links, export and
+// import them to reshard.
+func NewRepo(shards, indexes []repositoryURL.Repo) *repository {
+	return &repository{
+		shards:  shards,
+		indexes: indexes,
+	}
+}
+
+// Add URL, a link whose canonical url is already stored is reported with the stored id.
+// The url is claimed in the index first, a claim whose link was not stored within claimGrace is taken over.
+func (r *repository) Add(ctx context.Context, link models.Link) error {
+	urlID, err := r.claim(ctx, link)
+	if err != nil {
+		return err
+	}
+
+	takeOver := urlID != link.ID
+	if takeOver {
+		lost, err := r.lost(ctx, urlID, link.Canonical())
+		if err != nil {
+			return err
+		}
+		// the link claiming the url is stored or about to be
+		if !lost {
+			return errs.NewNotUniqueURLErr(urlID, link.OriginalURL, nil)
+		}
+		link.ID = urlID
+	}
+
+	err = r.shard(link.ID).Add(ctx, link)
+
+	var notUniqueErr *errs.NotUniqueURLErr
+	switch {
+	case errors.As(err, &notUniqueErr):
+		if notUniqueErr.URLID == link.ID {
+			err = r.own(ctx, link)
+		}
+	case err != nil && !takeOver:
+		r.release(ctx, link.ID, link.Canonical())
+	}
+
+	if err == nil && takeOver {
+		return errs.NewNotUniqueURLErr(urlID, link.OriginalURL, nil)
+	}
+
+	return err
+}
+
+// Get returns an active link
+func (r *repository) Get(ctx context.Context, urlID string) (models.Link, error) {
+	return r.shard(urlID).Get(ctx, urlID)
+}
+
+// FetchURLs gathers the links of the user from every shard
+func (r *repository) FetchURLs(ctx context.Context, userID string) ([]models.UserURL, error) {
+	found := make([][]models.UserURL, len(r.shards))
+	err := scatter(ctx, r.shards, func(ctx context.Context, idx int, shard repositoryURL.Repo) (err error) {
+		found[idx], err = shard.FetchURLs(ctx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	urls := make([]models.UserURL, 0)
+	for idx := range found {
+		urls = append(urls, found[idx]...)
+	}
+
+	return urls, nil
+}
+
+// IterateURLs walks the links of the user shard after shard
+func (r *repository) IterateURLs(ctx context.Context, userID string, fn func(url models.UserURL) error) error {
+	for _, shard := range r.shards {
+		if err := shard.IterateURLs(ctx, userID, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Ping checks every shard and index
+func (r *repository) Ping(ctx context.Context) error {
+	return scatter(ctx, r.all(), func(ctx context.Context, _ int, repo repositoryURL.Repo) error {
+		return repo.Ping(ctx)
+	})
+}
+
+// Flush writes the links imported into shards buffering them
+func (r *repository) Flush() error {
+	for _, repo := range r.all() {
+		if flusher, ok := repo.(interface{ Flush() error }); ok {
+			if err := flusher.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (r *repository) Close() error {
+	var err error
+	for _, repo := range r.all() {
+		if closeErr := repo.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
+
+// AddBatch claims the urls index by index, then stores the links claimed shard by shard
+func (r *repository) AddBatch(ctx context.Context, urls []models.UserURL, userID string) ([]models.BatchResult, error) {
+	claims, err := r.claimBatch(ctx, urls, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// urls repeated in the batch are claimed by their first entry
+	claimed := map[string]bool{}
+	for idx := range claims {
+		if claims[idx].Created {
+			claimed[claims[idx].ID] = true
+		}
+	}
+
+	results := make([]models.BatchResult, len(urls))
+	batches := make([][]models.UserURL, len(r.shards))
+	positions := make([][]int, len(r.shards))
+	for idx := range urls {
+		entry := urls[idx]
+		if claims[idx].ID != entry.ShortURL {
+			results[idx] = claims[idx]
+			// an empty claim is an id taken in the index, the result stays empty
+			if claims[idx].ID == "" || claimed[claims[idx].ID] {
+				continue
+			}
+
+			lost, err := r.lost(ctx, claims[idx].ID, canonical(entry))
+			if errors.Is(err, errs.ErrURLIDConflict) {
+				results[idx] = models.BatchResult{}
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if !lost {
+				continue
+			}
+			entry.ShortURL = claims[idx].ID
+		}
+
+		shard := r.shardIndex(entry.ShortURL)
+		batches[shard] = append(batches[shard], entry)
+		positions[shard] = append(positions[shard], idx)
+	}
+
+	failed := make([]bool, len(r.shards))
+	err = scatter(ctx, r.shards, func(ctx context.Context, shard int, repo repositoryURL.Repo) error {
+		if len(batches[shard]) == 0 {
+			return nil
+		}
+
+		stored, err := repo.AddBatch(ctx, batches[shard], userID)
+		if err != nil {
+			failed[shard] = true
+			return err
+		}
+
+		for pos, idx := range positions[shard] {
+			// taken over claims keep the result of their claim
+			if urls[idx].ShortURL == batches[shard][pos].ShortURL {
+				results[idx] = models.BatchResult{ID: stored[pos].ID, Created: stored[pos].ID == urls[idx].ShortURL}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		for shard := range failed {
+			if !failed[shard] {
+				continue
+			}
+			for _, idx := range positions[shard] {
+				if claims[idx].Created {
+					r.release(ctx, urls[idx].ShortURL, canonical(urls[idx]))
+				}
+			}
+		}
+
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// Update replaces the settings the owner may edit: redirect code, passthrough, targeting rules and variants
+func (r *repository) Update(ctx context.Context, link models.Link) error {
+	return r.shard(link.ID).Update(ctx, link)
+}
+
+// GetLink returns the link with its owner, deleted links included
+func (r *repository) GetLink(ctx context.Context, urlID string) (models.Link, error) {
+	return r.shard(urlID).GetLink(ctx, urlID)
+}
+
+// FindByURL returns the link shortening the url given in its canonical or original form, deleted links included.
+// A canonical url is looked up in its index, an original one is searched for on every shard.
+func (r *repository) FindByURL(ctx context.Context, url string) (models.Link, error) {
+	entry, err := r.index(url).FindByURL(ctx, url)
+	if err == nil && entry.Canonical() == url {
+		return r.GetLink(ctx, entry.ID)
+	}
+	if err != nil && !errors.Is(err, errs.ErrURLNotFound) {
+		return models.Link{}, err
+	}
+
+	for _, shard := range r.shards {
+		link, err := shard.FindByURL(ctx, url)
+		if errors.Is(err, errs.ErrURLNotFound) {
+			continue
+		}
+
+		return link, err
+	}
+
+	return models.Link{}, errs.ErrURLNotFound
+}
+
+// Delete keeps the url claimed, like a deleted link does on a single storage
+func (r *repository) Delete(ctx context.Context, urlID string) error {
+	return r.shard(urlID).Delete(ctx, urlID)
+}
+
+func (r *repository) Restore(ctx context.Context, urlID string) error {
+	return r.shard(urlID).Restore(ctx, urlID)
+}
+
+// Import stores the link as is, keeping its id, owner and timestamps. Importing the same link again
+// refreshes its canonical url, owner and state.
+func (r *repository) Import(ctx context.Context, link models.Link) error {
+	// the index of the url can't tell that the id is taken by another url
+	stored, err := r.shard(link.ID).GetLink(ctx, link.ID)
+	switch {
+	case err == nil && stored.OriginalURL != link.OriginalURL:
+		return errs.ErrURLIDConflict
+	case err != nil && !errors.Is(err, errs.ErrURLNotFound):
+		return err
+	}
+
+	// an entry left behind by a changed canonical url holds a form no url is normalized to
+	if err = r.index(link.Canonical()).Import(ctx, indexEntry(link)); err != nil {
+		return err
+	}
+
+	return r.shard(link.ID).Import(ctx, link)
+}
+
+// claim stores the index entry of the link, it returns the id of the link shortening the url
+func (r *repository) claim(ctx context.Context, link models.Link) (string, error) {
+	err := r.index(link.Canonical()).Add(ctx, indexEntry(link))
+
+	var notUniqueErr *errs.NotUniqueURLErr
+	if errors.As(err, &notUniqueErr) {
+		return notUniqueErr.URLID, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return link.ID, nil
+}
+
+func (r *repository) claimBatch(ctx context.Context, urls []models.UserURL, userID string) ([]models.BatchResult, error) {
+	claims := make([]models.BatchResult, len(urls))
+	batches := make([][]models.UserURL, len(r.indexes))
+	positions := make([][]int, len(r.indexes))
+	for idx := range urls {
+		index := r.indexIndex(canonical(urls[idx]))
+		batches[index] = append(batches[index], models.UserURL{
+			ShortURL:      urls[idx].ShortURL,
+			OriginalURL:   urls[idx].OriginalURL,
+			NormalizedURL: urls[idx].NormalizedURL,
+		})
+		positions[index] = append(positions[index], idx)
+	}
+
+	err := scatter(ctx, r.indexes, func(ctx context.Context, index int, repo repositoryURL.Repo) error {
+		if len(batches[index]) == 0 {
+			return nil
+		}
+
+		claimed, err := repo.AddBatch(ctx, batches[index], userID)
+		if err != nil {
+			return err
+		}
+
+		for pos, idx := range positions[index] {
+			claims[idx] = claimed[pos]
+		}
+
+		return nil
+	})
+
+	return claims, err
+}
+
+// release gives up the claim of a link that failed to be stored, the next add of the url takes it over
+// at once instead of waiting out claimGrace. A claim that can't be released expires.
+func (r *repository) release(ctx context.Context, urlID, url string) {
+	if err := r.index(url).Delete(ctx, urlID); err != nil {
+		log.WithError(err).WithField("urlID", urlID).Warn("release claim error")
+	}
+}
+
+// lost reports whether the claim of the url was left by an add that failed to store its link. A claim is
+// given claimGrace to be stored unless released, taking over one being stored would hand the link of
+// one user to another. A claim whose id another url took on the shard is never stored, it is reported
+// as errs.ErrURLIDConflict.
+func (r *repository) lost(ctx context.Context, urlID, url string) (bool, error) {
+	stored, err := r.shard(urlID).GetLink(ctx, urlID)
+	if err == nil && stored.Canonical() != url {
+		return false, errs.ErrURLIDConflict
+	}
+	if err == nil || !errors.Is(err, errs.ErrURLNotFound) {
+		return false, err
+	}
+
+	entry, err := r.index(url).FindByURL(ctx, url)
+	if err != nil {
+		return false, err
+	}
+
+	return entry.ID == urlID && (entry.Deleted() || time.Since(entry.CreatedAt) > claimGrace), nil
+}
+
+// own tells a retried add that finds its own link from one whose claim was taken over meanwhile
+func (r *repository) own(ctx context.Context, link models.Link) error {
+	stored, err := r.shard(link.ID).GetLink(ctx, link.ID)
+	if err != nil {
+		return err
+	}
+
+	if stored.UserID != link.UserID || stored.OriginalURL != link.OriginalURL {
+		return errs.NewNotUniqueURLErr(link.ID, link.OriginalURL, nil)
+	}
+
+	return nil
+}
+
+func (r *repository) shard(urlID string) repositoryURL.Repo {
+	return r.shards[r.shardIndex(urlID)]
+}
+
+func (r *repository) shardIndex(urlID string) int {
+	return bucket(urlID, len(r.shards))
+}
+
+func (r *repository) index(url string) repositoryURL.Repo {
+	return r.indexes[r.indexIndex(url)]
+}
+
+func (r *repository) indexIndex(url string) int {
+	return bucket(url, len(r.indexes))
+}
+
+func (r *repository) all() []repositoryURL.Repo {
+	all := make([]repositoryURL.Repo, 0, len(r.shards)+len(r.indexes))
+	all = append(all, r.shards...)
+
+	return append(all, r.indexes...)
+}
+
+// indexEntry is what the index keeps of a link, the urls and the id
+func indexEntry(link models.Link) models.Link {
+	return models.Link{
+		ID:            link.ID,
+		OriginalURL:   link.OriginalURL,
+		NormalizedURL: link.NormalizedURL,
+		UserID:        link.UserID,
+		CreatedAt:     link.CreatedAt,
+	}
+}
+
+func canonical(url models.UserURL) string {
+	if url.NormalizedURL != "" {
+		return url.NormalizedURL
+	}
+
+	return url.OriginalURL
+}
+
+// bucket hashes the key with 32-bit FNV-1a, the buckets stay put across restarts and instances
+func bucket(key string, n int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+
+	return int(h.Sum32() % uint32(n))
+}
+
+// scatter calls fn for every repository at once, the first error cancels the others
+func scatter(ctx context.Context, repos []repositoryURL.Repo, fn func(ctx context.Context, idx int, repo repositoryURL.Repo) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg    sync.WaitGroup
+		once  sync.Once
+		first error
+	)
+	for idx, repo := range repos {
+		wg.Add(1)
+		go func(idx int, repo repositoryURL.Repo) {
+			defer wg.Done()
+
+			if err := fn(ctx, idx, repo); err != nil {
+				once.Do(func() {
+					first = err
+					cancel()
+				})
+			}
+		}(idx, repo)
+	}
+	wg.Wait()
+
+	return first
+}
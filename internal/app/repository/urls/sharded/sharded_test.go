@@ -0,0 +1,552 @@
+package sharded
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ChristinaFomenko/shortener/internal/app/models"
+	repositoryURL "github.com/ChristinaFomenko/shortener/internal/app/repository/urls"
+	_ "github.com/ChristinaFomenko/shortener/internal/app/repository/urls/database"
+	_ "github.com/ChristinaFomenko/shortener/internal/app/repository/urls/file"
+	"github.com/ChristinaFomenko/shortener/internal/app/repository/urls/memory"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestRepo(shards, indexes int) *repository {
+	newRepos := func(n int) []repositoryURL.Repo {
+		repos := make([]repositoryURL.Repo, n)
+		for idx := range repos {
+			repos[idx] = memory.NewRepo()
+		}
+		return repos
+	}
+
+	return NewRepo(newRepos(shards), newRepos(indexes))
+}
+
+func TestRepository_AddGet(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepo(3, 2)
+
+	used := map[int]bool{}
+	for idx := 0; idx < 30; idx++ {
+		link := models.Link{ID: fmt.Sprintf("id%d", idx), OriginalURL: fmt.Sprintf("https://example.com/%d", idx), UserID: "user"}
+		require.NoError(t, repo.Add(ctx, link))
+
+		stored, err := repo.Get(ctx, link.ID)
+		require.NoError(t, err)
+		assert.Equal(t, link.OriginalURL, stored.OriginalURL)
+
+		_, err = repo.shards[repo.shardIndex(link.ID)].Get(ctx, link.ID)
+		require.NoError(t, err)
+		used[repo.shardIndex(link.ID)] = true
+	}
+	assert.Len(t, used, 3)
+
+	// the url is shortened already, whatever shard the new id falls on
+	for idx := 0; idx < 10; idx++ {
+		err := repo.Add(ctx, models.Link{ID: fmt.Sprintf("other%d", idx), OriginalURL: "https://example.com/1", UserID: "user2"})
+		var notUniqueErr *errs.NotUniqueURLErr
+		require.True(t, errors.As(err, &notUniqueErr))
+		assert.Equal(t, "id1", notUniqueErr.URLID)
+	}
+
+	// a retried add finds its own link
+	assert.NoError(t, repo.Add(ctx, models.Link{ID: "id2", OriginalURL: "https://example.com/2", UserID: "user"}))
+
+	_, err := repo.Get(ctx, "missing")
+	assert.ErrorIs(t, err, errs.ErrURLNotFound)
+}
+
+func TestRepository_TakesOverLostClaim(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepo(2, 1)
+
+	// the link was claimed but storing it failed
+	claimedAt := time.Now().Add(-2 * claimGrace)
+	require.NoError(t, repo.indexes[0].Add(ctx, models.Link{ID: "lost", OriginalURL: "https://yandex.ru", UserID: "user", CreatedAt: claimedAt}))
+
+	err := repo.Add(ctx, models.Link{ID: "abc", OriginalURL: "https://yandex.ru", UserID: "user2"})
+	var notUniqueErr *errs.NotUniqueURLErr
+	require.True(t, errors.As(err, &notUniqueErr))
+	assert.Equal(t, "lost", notUniqueErr.URLID)
+
+	link, err := repo.Get(ctx, "lost")
+	require.NoError(t, err)
+	assert.Equal(t, "user2", link.UserID)
+
+	_, err = repo.Get(ctx, "abc")
+	assert.ErrorIs(t, err, errs.ErrURLNotFound)
+
+	// the add that claimed the url finishes late, the link is not its own
+	err = repo.Add(ctx, models.Link{ID: "lost", OriginalURL: "https://yandex.ru", UserID: "user"})
+	require.True(t, errors.As(err, &notUniqueErr))
+	assert.Equal(t, "lost", notUniqueErr.URLID)
+}
+
+// failingRepo fails every write while down
+type failingRepo struct {
+	repositoryURL.Repo
+	down bool
+}
+
+var errDown = errors.New("connection refused")
+
+func (r *failingRepo) Add(ctx context.Context, link models.Link) error {
+	if r.down {
+		return errDown
+	}
+
+	return r.Repo.Add(ctx, link)
+}
+
+func (r *failingRepo) AddBatch(ctx context.Context, urls []models.UserURL, userID string) ([]models.BatchResult, error) {
+	if r.down {
+		return nil, errDown
+	}
+
+	return r.Repo.AddBatch(ctx, urls, userID)
+}
+
+func TestRepository_ReleasesFailedClaim(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepo(1, 1)
+	shard := &failingRepo{Repo: repo.shards[0], down: true}
+	repo.shards[0] = shard
+
+	assert.ErrorIs(t, repo.Add(ctx, models.Link{ID: "abc", OriginalURL: "https://yandex.ru", UserID: "user"}), errDown)
+	_, err := repo.AddBatch(ctx, []models.UserURL{{ShortURL: "qwe", OriginalURL: "https://ozon.ru"}}, "user")
+	assert.ErrorIs(t, err, errDown)
+
+	// the next add takes the claims over without waiting out claimGrace
+	shard.down = false
+
+	err = repo.Add(ctx, models.Link{ID: "def", OriginalURL: "https://yandex.ru", UserID: "user2"})
+	var notUniqueErr *errs.NotUniqueURLErr
+	require.True(t, errors.As(err, &notUniqueErr))
+	assert.Equal(t, "abc", notUniqueErr.URLID)
+
+	link, err := repo.Get(ctx, "abc")
+	require.NoError(t, err)
+	assert.Equal(t, "user2", link.UserID)
+
+	results, err := repo.AddBatch(ctx, []models.UserURL{{ShortURL: "rty", OriginalURL: "https://ozon.ru"}}, "user2")
+	require.NoError(t, err)
+	assert.Equal(t, []models.BatchResult{{ID: "qwe"}}, results)
+
+	link, err = repo.Get(ctx, "qwe")
+	require.NoError(t, err)
+	assert.Equal(t, "user2", link.UserID)
+}
+
+func TestRepository_IDTakenOnShard(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepo(1, 1)
+
+	// another index claimed the id for its own url
+	require.NoError(t, repo.shards[0].Add(ctx, models.Link{ID: "abc", OriginalURL: "https://ozon.ru", UserID: "user"}))
+
+	results, err := repo.AddBatch(ctx, []models.UserURL{{ShortURL: "abc", OriginalURL: "https://yandex.ru"}}, "user2")
+	require.NoError(t, err)
+	assert.Equal(t, []models.BatchResult{{}}, results)
+
+	// the claim is never stored, it isn't reported as the link of the url
+	results, err = repo.AddBatch(ctx, []models.UserURL{{ShortURL: "def", OriginalURL: "https://yandex.ru"}}, "user2")
+	require.NoError(t, err)
+	assert.Equal(t, []models.BatchResult{{}}, results)
+
+	assert.ErrorIs(t, repo.Add(ctx, models.Link{ID: "def", OriginalURL: "https://yandex.ru", UserID: "user2"}), errs.ErrURLIDConflict)
+
+	link, err := repo.Get(ctx, "abc")
+	require.NoError(t, err)
+	assert.Equal(t, "https://ozon.ru", link.OriginalURL)
+}
+
+func TestRepository_KeepsClaimBeingStored(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepo(2, 1)
+
+	// another add has just claimed the url and is storing the link
+	require.NoError(t, repo.indexes[0].Add(ctx, models.Link{ID: "claimed", OriginalURL: "https://yandex.ru", UserID: "user"}))
+
+	err := repo.Add(ctx, models.Link{ID: "abc", OriginalURL: "https://yandex.ru", UserID: "user2"})
+	var notUniqueErr *errs.NotUniqueURLErr
+	require.True(t, errors.As(err, &notUniqueErr))
+	assert.Equal(t, "claimed", notUniqueErr.URLID)
+
+	results, err := repo.AddBatch(ctx, []models.UserURL{{ShortURL: "def", OriginalURL: "https://yandex.ru"}}, "user2")
+	require.NoError(t, err)
+	assert.Equal(t, []models.BatchResult{{ID: "claimed"}}, results)
+
+	// the claiming add stores its own link
+	require.NoError(t, repo.Add(ctx, models.Link{ID: "claimed", OriginalURL: "https://yandex.ru", UserID: "user"}))
+
+	link, err := repo.Get(ctx, "claimed")
+	require.NoError(t, err)
+	assert.Equal(t, "user", link.UserID)
+}
+
+func TestRepository_ConcurrentAdd(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name  string
+		claim *models.Link
+	}{
+		{
+			name: "new url",
+		},
+		{
+			name:  "lost claim",
+			claim: &models.Link{ID: "lost", OriginalURL: "https://yandex.ru", UserID: "user", CreatedAt: time.Now().Add(-2 * claimGrace)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newTestRepo(4, 2)
+			if tt.claim != nil {
+				require.NoError(t, repo.index(tt.claim.OriginalURL).Add(ctx, *tt.claim))
+			}
+
+			const adds = 20
+			results := make([]error, adds)
+			var wg sync.WaitGroup
+			for idx := 0; idx < adds; idx++ {
+				wg.Add(1)
+				go func(idx int) {
+					defer wg.Done()
+					results[idx] = repo.Add(ctx, models.Link{ID: fmt.Sprintf("id%d", idx), OriginalURL: "https://yandex.ru", UserID: fmt.Sprintf("user%d", idx)})
+				}(idx)
+			}
+			wg.Wait()
+
+			link, err := repo.FindByURL(ctx, "https://yandex.ru")
+			require.NoError(t, err)
+
+			// whoever is told the link was created owns it, the others are told its id
+			created := 0
+			for idx, err := range results {
+				if err == nil {
+					created++
+					assert.Equal(t, fmt.Sprintf("id%d", idx), link.ID)
+					assert.Equal(t, fmt.Sprintf("user%d", idx), link.UserID)
+					continue
+				}
+
+				var notUniqueErr *errs.NotUniqueURLErr
+				require.ErrorAs(t, err, &notUniqueErr)
+				assert.Equal(t, link.ID, notUniqueErr.URLID)
+			}
+
+			if tt.claim != nil {
+				assert.Zero(t, created)
+				assert.Equal(t, tt.claim.ID, link.ID)
+			} else {
+				assert.Equal(t, 1, created)
+			}
+
+			stored := 0
+			require.NoError(t, repo.Iterate(ctx, "", func(models.Link) error {
+				stored++
+				return nil
+			}))
+			assert.Equal(t, 1, stored)
+		})
+	}
+}
+
+func TestRepository_FetchURLs(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepo(3, 1)
+
+	var exp []string
+	for idx := 0; idx < 10; idx++ {
+		link := models.Link{ID: fmt.Sprintf("id%d", idx), OriginalURL: fmt.Sprintf("https://example.com/%d", idx), UserID: "user"}
+		require.NoError(t, repo.Add(ctx, link))
+		exp = append(exp, link.ID)
+	}
+	require.NoError(t, repo.Add(ctx, models.Link{ID: "other", OriginalURL: "https://yandex.ru", UserID: "user2"}))
+	require.NoError(t, repo.Delete(ctx, "id3"))
+	exp = append(exp[:3], exp[4:]...)
+
+	urls, err := repo.FetchURLs(ctx, "user")
+	require.NoError(t, err)
+
+	act := make([]string, 0, len(urls))
+	for _, u := range urls {
+		act = append(act, u.ShortURL)
+	}
+	sort.Strings(act)
+	assert.Equal(t, exp, act)
+
+	urls, err = repo.FetchURLs(ctx, "nobody")
+	require.NoError(t, err)
+	assert.Empty(t, urls)
+}
+
+func TestRepository_AddBatch(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepo(3, 2)
+
+	require.NoError(t, repo.Add(ctx, models.Link{ID: "old", OriginalURL: "https://yandex.ru", UserID: "user"}))
+
+	results, err := repo.AddBatch(ctx, []models.UserURL{
+		{ShortURL: "a", OriginalURL: "https://example.com/a"},
+		{ShortURL: "b", OriginalURL: "https://YANDEX.ru", NormalizedURL: "https://yandex.ru"},
+		{ShortURL: "c", OriginalURL: "https://example.com/c"},
+		{ShortURL: "d", OriginalURL: "https://example.com/a"},
+	}, "user2")
+	require.NoError(t, err)
+
+	assert.Equal(t, []models.BatchResult{
+		{ID: "a", Created: true},
+		{ID: "old"},
+		{ID: "c", Created: true},
+		{ID: "a"},
+	}, results)
+
+	for _, urlID := range []string{"a", "c"} {
+		link, err := repo.Get(ctx, urlID)
+		require.NoError(t, err)
+		assert.Equal(t, "user2", link.UserID)
+	}
+	for _, urlID := range []string{"b", "d"} {
+		_, err = repo.Get(ctx, urlID)
+		assert.ErrorIs(t, err, errs.ErrURLNotFound)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = repo.AddBatch(cancelled, []models.UserURL{{ShortURL: "e", OriginalURL: "https://example.com/e"}}, "user2")
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestRepository_FindByURL(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepo(3, 2)
+
+	require.NoError(t, repo.Add(ctx, models.Link{ID: "abc", OriginalURL: "https://YANDEX.ru", NormalizedURL: "https://yandex.ru", UserID: "user"}))
+
+	for _, u := range []string{"https://yandex.ru", "https://YANDEX.ru"} {
+		link, err := repo.FindByURL(ctx, u)
+		require.NoError(t, err)
+		assert.Equal(t, "abc", link.ID)
+	}
+
+	_, err := repo.FindByURL(ctx, "https://example.com")
+	assert.ErrorIs(t, err, errs.ErrURLNotFound)
+}
+
+func TestRepository_Import(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepo(3, 2)
+
+	link := models.Link{ID: "abc", OriginalURL: "https://yandex.ru", UserID: "user"}
+	require.NoError(t, repo.Import(ctx, link))
+
+	link.UserID = "user2"
+	require.NoError(t, repo.Import(ctx, link))
+	stored, err := repo.GetLink(ctx, "abc")
+	require.NoError(t, err)
+	assert.Equal(t, "user2", stored.UserID)
+
+	// the url of another id is indexed elsewhere, the shard of the id tells
+	err = repo.Import(ctx, models.Link{ID: "abc", OriginalURL: "https://example.com", UserID: "user"})
+	assert.ErrorIs(t, err, errs.ErrURLIDConflict)
+
+	err = repo.Import(ctx, models.Link{ID: "xyz", OriginalURL: "https://yandex.ru", UserID: "user"})
+	var notUniqueErr *errs.NotUniqueURLErr
+	require.True(t, errors.As(err, &notUniqueErr))
+	assert.Equal(t, "abc", notUniqueErr.URLID)
+}
+
+func TestRepository_Iterate(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepo(3, 1)
+
+	var exp []string
+	for idx := 0; idx < 20; idx++ {
+		link := models.Link{ID: fmt.Sprintf("id%02d", idx), OriginalURL: fmt.Sprintf("https://example.com/%d", idx), UserID: "user"}
+		require.NoError(t, repo.Add(ctx, link))
+		exp = append(exp, link.ID)
+	}
+	require.NoError(t, repo.Delete(ctx, "id05"))
+
+	var act []string
+	require.NoError(t, repo.Iterate(ctx, "id09", func(link models.Link) error {
+		act = append(act, link.ID)
+		return nil
+	}))
+	assert.Equal(t, exp[10:], act)
+
+	stop := errors.New("stop")
+	act = nil
+	err := repo.Iterate(ctx, "", func(link models.Link) error {
+		act = append(act, link.ID)
+		if len(act) == 3 {
+			return stop
+		}
+		return nil
+	})
+	assert.ErrorIs(t, err, stop)
+	assert.Equal(t, exp[:3], act)
+
+	// the shards are merged in byte order of the ids, upper case first
+	repo = newTestRepo(3, 1)
+	exp = []string{"B", "Ab", "a0", "ab", "b", "Z", "z"}
+	for idx, urlID := range exp {
+		require.NoError(t, repo.Add(ctx, models.Link{ID: urlID, OriginalURL: fmt.Sprintf("https://example.com/%d", idx), UserID: "user"}))
+	}
+	sort.Strings(exp)
+
+	act = nil
+	require.NoError(t, repo.Iterate(ctx, "", func(link models.Link) error {
+		act = append(act, link.ID)
+		return nil
+	}))
+	assert.Equal(t, exp, act)
+}
+
+func TestOpen(t *testing.T) {
+	dir := t.TempDir()
+	file := "file://" + filepath.Join(dir, "urls.dat")
+
+	tests := []struct {
+		name       string
+		storageURL string
+		err        error
+	}{
+		{
+			name:       "memory shards",
+			storageURL: "sharded://?shard=memory://&shard=memory://&index=memory://",
+		},
+		{
+			name:       "file shards",
+			storageURL: "sharded://?shard=" + url.QueryEscape(file) + "&index=" + url.QueryEscape("file://"+filepath.Join(dir, "index.dat")),
+		},
+		{
+			name:       "no index",
+			storageURL: "sharded://?shard=memory://",
+			err:        errs.ErrInvalidStorageURL,
+		},
+		{
+			name:       "storage used twice",
+			storageURL: "sharded://?shard=" + url.QueryEscape(file) + "&index=" + url.QueryEscape(file),
+			err:        errs.ErrInvalidStorageURL,
+		},
+		{
+			name:       "sharded shard",
+			storageURL: "sharded://?shard=" + url.QueryEscape("sharded://?shard=memory://&index=memory://") + "&index=memory://",
+			err:        errs.ErrInvalidStorageURL,
+		},
+		{
+			name:       "bad shard",
+			storageURL: "sharded://?shard=redis://localhost&index=memory://",
+			err:        errs.ErrInvalidStorageURL,
+		},
+		{
+			name:       "unknown option",
+			storageURL: "sharded://?shard=memory://&index=memory://&replicas=2",
+			err:        errs.ErrInvalidStorageURL,
+		},
+		{
+			name:       "path",
+			storageURL: "sharded:///var/lib/shortener",
+			err:        errs.ErrInvalidStorageURL,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, err := repositoryURL.NewStorage(tt.storageURL)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+
+			assert.NoError(t, repo.Ping(context.Background()))
+			assert.NoError(t, repo.Close())
+		})
+	}
+}
+
+func TestNewStorage(t *testing.T) {
+	opened := make([]string, 0)
+	repo, err := NewStorage("sharded://?shard=memory://&shard=memory://&index=memory://",
+		func(storageURL string) (repositoryURL.Repo, error) {
+			opened = append(opened, storageURL)
+			return memory.NewRepo(), nil
+		})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"memory://", "memory://", "memory://"}, opened)
+	assert.NoError(t, repo.Close())
+
+	_, err = NewStorage("sharded://?shard=memory://&index=memory://", func(string) (repositoryURL.Repo, error) {
+		return nil, errs.ErrStorageUnavailable
+	})
+	assert.ErrorIs(t, err, errs.ErrStorageUnavailable)
+
+	_, err = NewStorage("memory://", repositoryURL.NewStorage)
+	assert.ErrorIs(t, err, errs.ErrInvalidStorageURL)
+}
+
+func TestDatabase(t *testing.T) {
+	shard := "postgres://user@host1/db"
+	index := "postgres://user@host2/db"
+
+	tests := []struct {
+		name       string
+		storageURL string
+		want       string
+		ok         bool
+	}{
+		{
+			name:       "database",
+			storageURL: shard,
+			want:       shard,
+			ok:         true,
+		},
+		{
+			name:       "memory",
+			storageURL: "memory://",
+		},
+		{
+			name:       "database shards",
+			storageURL: "sharded://?shard=" + url.QueryEscape(shard) + "&index=" + url.QueryEscape(index),
+			want:       shard,
+			ok:         true,
+		},
+		{
+			name:       "database index",
+			storageURL: "sharded://?shard=memory://&index=" + url.QueryEscape(index),
+			want:       index,
+			ok:         true,
+		},
+		{
+			name:       "memory shards",
+			storageURL: "sharded://?shard=memory://&index=memory://",
+		},
+		{
+			name:       "unknown storage",
+			storageURL: "redis://localhost",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := Database(tt.storageURL)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package sqlite
+
+import (
+	repositoryURL "github.com/ChristinaFomenko/shortener/internal/app/repository/urls"
+	"net/url"
+)
+
+func init() {
+	repositoryURL.Register(repositoryURL.BackendSQLite, open, "sqlite")
+}
+
+// open takes the file from a sqlite:///absolute/path or sqlite:relative/path url
+func open(u *url.URL) (repositoryURL.Repo, error) {
+	filePath, err := repositoryURL.FilePath(u)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = repositoryURL.CheckOptions(u); err != nil {
+		return nil, err
+	}
+
+	r, err := NewRepo(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
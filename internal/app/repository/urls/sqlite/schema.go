@@ -0,0 +1,22 @@
+package sqlite
+
+// migrations bring the schema up to date one step at a time, the number of applied steps is kept in user_version.
+// Steps are only ever appended.
+var migrations = []string{
+	`create table urls
+(
+    id varchar(10) not null primary key,
+    url text not null,
+    normalized_url text not null,
+    user_id varchar(10) not null,
+    redirect_code integer default 0 not null,
+    passthrough boolean default false not null,
+    rules text default '[]' not null,
+    variants text default '[]' not null,
+    sticky boolean default false not null,
+    created_at timestamp not null,
+    deleted_at timestamp default null
+);
+create unique index urls_normalized_url_uindex on urls (normalized_url);
+create index urls_user_id_index on urls (user_id);`,
+}
@@ -0,0 +1,533 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ChristinaFomenko/shortener/internal/app/models"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	"github.com/mattn/go-sqlite3"
+	"time"
+)
+
+const (
+	timeout = time.Second * 3
+
+	// busyTimeout is how long a writer waits for another one before failing with SQLITE_BUSY
+	busyTimeout = 5000
+
+	// urlsPage is how many links of a user IterateURLs reads per query
+	urlsPage = 1000
+)
+
+type sqliteRepo struct {
+	db *sql.DB
+}
+
+// NewRepo opens the database file in WAL mode, so readers don't wait for the writer, and migrates its schema
+func NewRepo(filePath string) (*sqliteRepo, error) {
+	dsn := fmt.Sprintf("file:%s?_journal_mode=WAL&_busy_timeout=%d&_txlock=immediate&_synchronous=NORMAL", filePath, busyTimeout)
+
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	db.SetMaxOpenConns(8)
+	db.SetMaxIdleConns(8)
+	db.SetConnMaxIdleTime(time.Second * 30)
+
+	if err = migrate(db); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("migrate sqlite schema error: %w", err)
+	}
+
+	return &sqliteRepo{
+		db: db,
+	}, nil
+}
+
+// migrate applies the migrations not applied yet, each in its own transaction
+func migrate(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow(`pragma user_version`).Scan(&version); err != nil {
+		return err
+	}
+
+	if version > len(migrations) {
+		return fmt.Errorf("schema version %d is newer than this build knows (%d)", version, len(migrations))
+	}
+
+	for ; version < len(migrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+
+		if _, err = tx.Exec(migrations[version]); err == nil {
+			// pragmas don't take parameters
+			_, err = tx.Exec(fmt.Sprintf(`pragma user_version = %d`, version+1))
+		}
+		if err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("migration %d: %w", version+1, err)
+		}
+
+		if err = tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *sqliteRepo) Add(ctx context.Context, link models.Link) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	rules, variants, err := encodeTargets(link)
+	if err != nil {
+		return err
+	}
+
+	if link.CreatedAt.IsZero() {
+		link.CreatedAt = time.Now()
+	}
+
+	_, err = r.db.ExecContext(ctx, `insert into urls(id,url,normalized_url,user_id,redirect_code,passthrough,rules,variants,sticky,created_at)
+		values (?,?,?,?,?,?,?,?,?,?)`,
+		link.ID,
+		link.OriginalURL,
+		link.Canonical(),
+		link.UserID,
+		link.RedirectCode,
+		link.Passthrough,
+		rules,
+		variants,
+		link.Sticky,
+		link.CreatedAt)
+	if err != nil {
+		return notUniqueErr(ctx, r.db, err, link)
+	}
+
+	return nil
+}
+
+// Get returns an active link
+func (r *sqliteRepo) Get(ctx context.Context, urlID string) (models.Link, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	row := r.db.QueryRowContext(ctx, `select `+linkColumns+` from urls where id=? and deleted_at is null`, urlID)
+
+	return scanLink(row)
+}
+
+func (r *sqliteRepo) FetchURLs(ctx context.Context, userID string) ([]models.UserURL, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx, `select id, url from urls where user_id=? and deleted_at is null order by created_at, id`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	res := make([]models.UserURL, 0)
+	for rows.Next() {
+		var url models.UserURL
+		if err = rows.Scan(&url.ShortURL, &url.OriginalURL); err != nil {
+			return nil, err
+		}
+
+		res = append(res, url)
+	}
+
+	return res, rows.Err()
+}
+
+// IterateURLs calls fn for every active link of the user, a page of urlsPage links per query,
+// so the database is not kept busy while fn runs
+func (r *sqliteRepo) IterateURLs(ctx context.Context, userID string, fn func(url models.UserURL) error) error {
+	afterID := ""
+	for {
+		page, err := r.urlsPage(ctx, userID, afterID)
+		if err != nil {
+			return err
+		}
+
+		for idx := range page {
+			if err = fn(page[idx]); err != nil {
+				return err
+			}
+		}
+
+		if len(page) < urlsPage {
+			return nil
+		}
+
+		afterID = page[len(page)-1].ShortURL
+	}
+}
+
+func (r *sqliteRepo) urlsPage(ctx context.Context, userID, afterID string) ([]models.UserURL, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx, `select id, url from urls where user_id=? and deleted_at is null and id > ? order by id limit ?`,
+		userID, afterID, urlsPage)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	page := make([]models.UserURL, 0, urlsPage)
+	for rows.Next() {
+		var url models.UserURL
+		if err = rows.Scan(&url.ShortURL, &url.OriginalURL); err != nil {
+			return nil, err
+		}
+
+		page = append(page, url)
+	}
+
+	return page, rows.Err()
+}
+
+func (r *sqliteRepo) Ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	return r.db.PingContext(ctx)
+}
+
+func (r *sqliteRepo) Close() error {
+	return r.db.Close()
+}
+
+// AddBatch stores the links whose urls are not shortened yet in one transaction and reports the stored id for the others
+func (r *sqliteRepo) AddBatch(ctx context.Context, urls []models.UserURL, userID string) ([]models.BatchResult, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	insert, err := tx.PrepareContext(ctx, `insert into urls(id,url,normalized_url,user_id,redirect_code,passthrough,created_at) values (?,?,?,?,?,?,?)
+		on conflict do nothing`)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func(stmt *sql.Stmt) {
+		_ = stmt.Close()
+	}(insert)
+
+	find, err := tx.PrepareContext(ctx, `select id from urls where normalized_url=?`)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func(stmt *sql.Stmt) {
+		_ = stmt.Close()
+	}(find)
+
+	results := make([]models.BatchResult, len(urls))
+	now := time.Now()
+	for idx := range urls {
+		link := models.Link{
+			ID:            urls[idx].ShortURL,
+			OriginalURL:   urls[idx].OriginalURL,
+			NormalizedURL: urls[idx].NormalizedURL,
+		}
+
+		res, err := insert.ExecContext(ctx, link.ID, link.OriginalURL, link.Canonical(), userID, urls[idx].RedirectCode, urls[idx].Passthrough, now)
+		if err != nil {
+			return nil, err
+		}
+
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+
+		if affected > 0 {
+			results[idx] = models.BatchResult{ID: link.ID, Created: true}
+			continue
+		}
+
+		// the id is taken by another url when the url is not stored, the result stays empty
+		err = find.QueryRowContext(ctx, link.Canonical()).Scan(&results[idx].ID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// Update replaces the settings the owner may edit: redirect code, passthrough, targeting rules and variants
+func (r *sqliteRepo) Update(ctx context.Context, link models.Link) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	rules, variants, err := encodeTargets(link)
+	if err != nil {
+		return err
+	}
+
+	res, err := r.db.ExecContext(ctx, `update urls set redirect_code=?, passthrough=?, rules=?, variants=?, sticky=? where id=?`,
+		link.RedirectCode,
+		link.Passthrough,
+		rules,
+		variants,
+		link.Sticky,
+		link.ID)
+	if err != nil {
+		return err
+	}
+
+	return checkAffected(res)
+}
+
+// GetLink returns the link with its owner, deleted links included
+func (r *sqliteRepo) GetLink(ctx context.Context, urlID string) (models.Link, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	row := r.db.QueryRowContext(ctx, `select `+linkColumns+` from urls where id=?`, urlID)
+
+	return scanLink(row)
+}
+
+// FindByURL returns the link shortening the url given in its canonical or original form, deleted links included
+func (r *sqliteRepo) FindByURL(ctx context.Context, url string) (models.Link, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	row := r.db.QueryRowContext(ctx, `select `+linkColumns+` from urls where normalized_url=?1 or url=?1 order by normalized_url=?1 desc limit 1`, url)
+
+	return scanLink(row)
+}
+
+func (r *sqliteRepo) Delete(ctx context.Context, urlID string) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	res, err := r.db.ExecContext(ctx, `update urls set deleted_at=coalesce(deleted_at, ?) where id=?`, time.Now(), urlID)
+	if err != nil {
+		return err
+	}
+
+	return checkAffected(res)
+}
+
+func (r *sqliteRepo) Restore(ctx context.Context, urlID string) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	res, err := r.db.ExecContext(ctx, `update urls set deleted_at=null where id=?`, urlID)
+	if err != nil {
+		return err
+	}
+
+	return checkAffected(res)
+}
+
+// Import stores the link as is, keeping its id, owner and timestamps. Importing the same link again
+// refreshes its canonical url, owner and state.
+func (r *sqliteRepo) Import(ctx context.Context, link models.Link) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	rules, variants, err := encodeTargets(link)
+	if err != nil {
+		return err
+	}
+
+	if link.CreatedAt.IsZero() {
+		link.CreatedAt = time.Now()
+	}
+
+	res, err := r.db.ExecContext(ctx, `insert into urls(id,url,normalized_url,user_id,redirect_code,passthrough,rules,variants,sticky,created_at,deleted_at)
+		values (?,?,?,?,?,?,?,?,?,?,?)
+		on conflict (id) do update
+		set normalized_url=excluded.normalized_url, user_id=excluded.user_id, redirect_code=excluded.redirect_code, passthrough=excluded.passthrough, rules=excluded.rules, variants=excluded.variants, sticky=excluded.sticky, created_at=excluded.created_at, deleted_at=excluded.deleted_at
+		where urls.url=excluded.url`,
+		link.ID,
+		link.OriginalURL,
+		link.Canonical(),
+		link.UserID,
+		link.RedirectCode,
+		link.Passthrough,
+		rules,
+		variants,
+		link.Sticky,
+		link.CreatedAt,
+		nullTime(link.DeletedAt))
+	if err != nil {
+		return notUniqueErr(ctx, r.db, err, link)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return errs.ErrURLIDConflict
+	}
+
+	return nil
+}
+
+// Iterate calls fn for every link with id greater than afterID, deleted ones included, in ascending id order
+func (r *sqliteRepo) Iterate(ctx context.Context, afterID string, fn func(link models.Link) error) error {
+	rows, err := r.db.QueryContext(ctx, `select `+linkColumns+` from urls where id > ? order by id`, afterID)
+	if err != nil {
+		return err
+	}
+
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	for rows.Next() {
+		link, err := scanLink(rows)
+		if err != nil {
+			return err
+		}
+
+		if err = fn(link); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+type querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// notUniqueErr reports a link whose canonical url is already stored with the stored id, other errors are returned as is
+func notUniqueErr(ctx context.Context, q querier, err error, link models.Link) error {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) || sqliteErr.ExtendedCode != sqlite3.ErrConstraintUnique {
+		return err
+	}
+
+	var urlID string
+	if err = q.QueryRowContext(ctx, "select id from urls where normalized_url=?", link.Canonical()).Scan(&urlID); err != nil {
+		return err
+	}
+
+	return errs.NewNotUniqueURLErr(urlID, link.OriginalURL, nil)
+}
+
+const linkColumns = `id, url, normalized_url, user_id, redirect_code, passthrough, rules, variants, sticky, created_at, deleted_at`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanLink(row scanner) (models.Link, error) {
+	var (
+		link      models.Link
+		rules     []byte
+		variants  []byte
+		deletedAt sql.NullTime
+	)
+
+	err := row.Scan(&link.ID, &link.OriginalURL, &link.NormalizedURL, &link.UserID, &link.RedirectCode, &link.Passthrough,
+		&rules, &variants, &link.Sticky, &link.CreatedAt, &deletedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Link{}, errs.ErrURLNotFound
+		}
+		return models.Link{}, err
+	}
+
+	if deletedAt.Valid {
+		link.DeletedAt = deletedAt.Time
+	}
+
+	if err = json.Unmarshal(rules, &link.Rules); err != nil {
+		return models.Link{}, err
+	}
+
+	if err = json.Unmarshal(variants, &link.Variants); err != nil {
+		return models.Link{}, err
+	}
+
+	if len(link.Rules) == 0 {
+		link.Rules = nil
+	}
+
+	if len(link.Variants) == 0 {
+		link.Variants = nil
+	}
+
+	return link, nil
+}
+
+// encodeTargets returns the json stored in the rules and variants columns
+func encodeTargets(link models.Link) (string, string, error) {
+	rules, err := encodeList(link.Rules)
+	if err != nil {
+		return "", "", err
+	}
+
+	variants, err := encodeList(link.Variants)
+	if err != nil {
+		return "", "", err
+	}
+
+	return rules, variants, nil
+}
+
+// encodeList stores nil slices as empty json arrays
+func encodeList(list interface{}) (string, error) {
+	encoded, err := json.Marshal(list)
+	if err != nil {
+		return "", err
+	}
+
+	if string(encoded) == "null" {
+		return "[]", nil
+	}
+
+	return string(encoded), nil
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+func checkAffected(res sql.Result) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return errs.ErrURLNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,228 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ChristinaFomenko/shortener/internal/app/models"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+const defaultUserID = "user"
+
+func newRepo(t *testing.T) (*sqliteRepo, string) {
+	filePath := filepath.Join(t.TempDir(), "storage.db")
+
+	repo, err := NewRepo(filePath)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_ = repo.Close()
+	})
+
+	return repo, filePath
+}
+
+func TestSQLiteRepo_Migrate(t *testing.T) {
+	repo, filePath := newRepo(t)
+
+	var version int
+	require.NoError(t, repo.db.QueryRow(`pragma user_version`).Scan(&version))
+	assert.Equal(t, len(migrations), version)
+
+	var mode string
+	require.NoError(t, repo.db.QueryRow(`pragma journal_mode`).Scan(&mode))
+	assert.Equal(t, "wal", mode)
+
+	// opening a migrated file applies nothing again
+	require.NoError(t, repo.Close())
+	repo, err := NewRepo(filePath)
+	require.NoError(t, err)
+	require.NoError(t, repo.Close())
+}
+
+func TestSQLiteRepo_AddGet(t *testing.T) {
+	ctx := context.Background()
+	repo, _ := newRepo(t)
+
+	link := models.Link{
+		ID:            "abc",
+		OriginalURL:   "https://Yandex.ru/",
+		NormalizedURL: "https://yandex.ru",
+		UserID:        defaultUserID,
+		RedirectCode:  http.StatusMovedPermanently,
+		Rules:         []models.TargetRule{{OS: models.OSIOS, URL: "https://apps.apple.com/app/id1"}},
+		Variants:      []models.Variant{{Name: "a", URL: "https://yandex.ru/a", Weight: 1}},
+		Sticky:        true,
+	}
+	require.NoError(t, repo.Add(ctx, link))
+
+	err := repo.Add(ctx, models.Link{ID: "cba", OriginalURL: "https://yandex.ru", NormalizedURL: "https://yandex.ru", UserID: "other"})
+	var uniqueErr *errs.NotUniqueURLErr
+	require.True(t, errors.As(err, &uniqueErr))
+	assert.Equal(t, "abc", uniqueErr.URLID)
+
+	act, err := repo.Get(ctx, "abc")
+	require.NoError(t, err)
+	assert.Equal(t, link.OriginalURL, act.OriginalURL)
+	assert.Equal(t, link.RedirectCode, act.RedirectCode)
+	assert.Equal(t, link.Rules, act.Rules)
+	assert.Equal(t, link.Variants, act.Variants)
+	assert.True(t, act.Sticky)
+	assert.False(t, act.CreatedAt.IsZero())
+	assert.False(t, act.Deleted())
+
+	_, err = repo.Get(ctx, "cba")
+	assert.ErrorIs(t, err, errs.ErrURLNotFound)
+}
+
+func TestSQLiteRepo_AddBatch(t *testing.T) {
+	ctx := context.Background()
+	repo, _ := newRepo(t)
+
+	require.NoError(t, repo.Add(ctx, models.Link{ID: "taken", OriginalURL: "ozon.ru", UserID: defaultUserID}))
+
+	act, err := repo.AddBatch(ctx, []models.UserURL{
+		{ShortURL: "first", OriginalURL: "yandex.ru", RedirectCode: http.StatusFound},
+		{ShortURL: "second", OriginalURL: "ozon.ru"},
+		{ShortURL: "third", OriginalURL: "yandex.ru"},
+		{ShortURL: "fourth", OriginalURL: "avito.ru", Passthrough: true},
+		{ShortURL: "taken", OriginalURL: "wildberries.ru"},
+	}, defaultUserID)
+	require.NoError(t, err)
+	assert.Equal(t, []models.BatchResult{
+		{ID: "first", Created: true},
+		{ID: "taken"},
+		{ID: "first"},
+		{ID: "fourth", Created: true},
+		{},
+	}, act)
+
+	urls, err := repo.FetchURLs(ctx, defaultUserID)
+	require.NoError(t, err)
+	assert.Len(t, urls, 3)
+
+	link, err := repo.Get(ctx, "fourth")
+	require.NoError(t, err)
+	assert.True(t, link.Passthrough)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = repo.AddBatch(cancelled, []models.UserURL{{ShortURL: "fifth", OriginalURL: "mail.ru"}}, defaultUserID)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestSQLiteRepo_UpdateDeleteRestore(t *testing.T) {
+	ctx := context.Background()
+	repo, _ := newRepo(t)
+
+	require.NoError(t, repo.Add(ctx, models.Link{ID: "qwerty", OriginalURL: "yandex.ru", UserID: defaultUserID}))
+
+	require.NoError(t, repo.Update(ctx, models.Link{ID: "qwerty", RedirectCode: http.StatusPermanentRedirect}))
+	assert.ErrorIs(t, repo.Update(ctx, models.Link{ID: "missing"}), errs.ErrURLNotFound)
+
+	require.NoError(t, repo.Delete(ctx, "qwerty"))
+	_, err := repo.Get(ctx, "qwerty")
+	assert.ErrorIs(t, err, errs.ErrURLNotFound)
+
+	link, err := repo.GetLink(ctx, "qwerty")
+	require.NoError(t, err)
+	assert.True(t, link.Deleted())
+	assert.Equal(t, http.StatusPermanentRedirect, link.RedirectCode)
+
+	require.NoError(t, repo.Restore(ctx, "qwerty"))
+	_, err = repo.Get(ctx, "qwerty")
+	assert.NoError(t, err)
+}
+
+func TestSQLiteRepo_FindByURL(t *testing.T) {
+	ctx := context.Background()
+	repo, _ := newRepo(t)
+
+	require.NoError(t, repo.Add(ctx, models.Link{ID: "abc", OriginalURL: "https://Yandex.ru/?utm_source=x", NormalizedURL: "https://yandex.ru", UserID: defaultUserID}))
+
+	for _, url := range []string{"https://yandex.ru", "https://Yandex.ru/?utm_source=x"} {
+		act, err := repo.FindByURL(ctx, url)
+		require.NoError(t, err)
+		assert.Equal(t, "abc", act.ID)
+	}
+
+	_, err := repo.FindByURL(ctx, "https://avito.ru")
+	assert.ErrorIs(t, err, errs.ErrURLNotFound)
+}
+
+func TestSQLiteRepo_ImportIterate(t *testing.T) {
+	ctx := context.Background()
+	repo, _ := newRepo(t)
+
+	require.NoError(t, repo.Import(ctx, models.Link{ID: "b", OriginalURL: "yandex.ru", UserID: defaultUserID}))
+	require.NoError(t, repo.Import(ctx, models.Link{ID: "a", OriginalURL: "avito.ru", UserID: defaultUserID}))
+	require.NoError(t, repo.Import(ctx, models.Link{ID: "b", OriginalURL: "yandex.ru", UserID: "other"}))
+
+	assert.ErrorIs(t, repo.Import(ctx, models.Link{ID: "b", OriginalURL: "ozon.ru"}), errs.ErrURLIDConflict)
+
+	var uniqueErr *errs.NotUniqueURLErr
+	assert.True(t, errors.As(repo.Import(ctx, models.Link{ID: "c", OriginalURL: "yandex.ru"}), &uniqueErr))
+
+	// importing again refreshes the canonical url unless another link holds it
+	assert.True(t, errors.As(repo.Import(ctx, models.Link{ID: "a", OriginalURL: "avito.ru", NormalizedURL: "yandex.ru", UserID: defaultUserID}), &uniqueErr))
+	assert.Equal(t, "b", uniqueErr.URLID)
+	require.NoError(t, repo.Import(ctx, models.Link{ID: "a", OriginalURL: "avito.ru", NormalizedURL: "https://avito.ru", UserID: defaultUserID}))
+
+	var act []models.Link
+	err := repo.Iterate(ctx, "", func(link models.Link) error {
+		act = append(act, link)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, act, 2)
+	assert.Equal(t, "a", act[0].ID)
+	assert.Equal(t, "other", act[1].UserID)
+}
+
+func TestSQLiteRepo_IterateURLs(t *testing.T) {
+	ctx := context.Background()
+	repo, _ := newRepo(t)
+
+	require.NoError(t, repo.Add(ctx, models.Link{ID: "b", OriginalURL: "yandex.ru", UserID: defaultUserID}))
+	require.NoError(t, repo.Add(ctx, models.Link{ID: "a", OriginalURL: "avito.ru", UserID: defaultUserID}))
+	require.NoError(t, repo.Add(ctx, models.Link{ID: "c", OriginalURL: "ozon.ru", UserID: defaultUserID}))
+	require.NoError(t, repo.Add(ctx, models.Link{ID: "d", OriginalURL: "github.com", UserID: "other"}))
+	require.NoError(t, repo.Delete(ctx, "c"))
+
+	var act []models.UserURL
+	err := repo.IterateURLs(ctx, defaultUserID, func(url models.UserURL) error {
+		act = append(act, url)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []models.UserURL{{ShortURL: "a", OriginalURL: "avito.ru"}, {ShortURL: "b", OriginalURL: "yandex.ru"}}, act)
+}
+
+func TestSQLiteRepo_ConcurrentWrites(t *testing.T) {
+	ctx := context.Background()
+	repo, _ := newRepo(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := fmt.Sprintf("id%02d", i)
+			assert.NoError(t, repo.Add(ctx, models.Link{ID: id, OriginalURL: "https://example.com/" + id, UserID: defaultUserID}))
+			_, err := repo.FetchURLs(ctx, defaultUserID)
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	urls, err := repo.FetchURLs(ctx, defaultUserID)
+	require.NoError(t, err)
+	assert.Len(t, urls, 20)
+}
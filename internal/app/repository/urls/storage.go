@@ -4,36 +4,164 @@ import (
 	"context"
 	"fmt"
 	"github.com/ChristinaFomenko/shortener/internal/app/models"
-	"github.com/ChristinaFomenko/shortener/internal/app/repository/urls/database"
-	"github.com/ChristinaFomenko/shortener/internal/app/repository/urls/file"
-	"github.com/ChristinaFomenko/shortener/internal/app/repository/urls/memory"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
 )
 
 type Repo interface {
-	Add(ctx context.Context, urlID, url, userID string) error
-	Get(ctx context.Context, urlID string) (string, error)
+	Add(ctx context.Context, link models.Link) error
+	Get(ctx context.Context, urlID string) (models.Link, error)
 	FetchURLs(ctx context.Context, userID string) ([]models.UserURL, error)
+	// IterateURLs calls fn for every active link of the user without holding all of them at once
+	IterateURLs(ctx context.Context, userID string, fn func(url models.UserURL) error) error
 	Ping(ctx context.Context) error
-	AddBatch(ctx context.Context, urls []models.UserURL, userID string) error
+	// AddBatch leaves the result of a url whose id is taken by another url empty, the caller retries it under another id
+	AddBatch(ctx context.Context, urls []models.UserURL, userID string) ([]models.BatchResult, error)
+	Update(ctx context.Context, link models.Link) error
 	Close() error
+
+	// maintenance operations, they see deleted links too
+	GetLink(ctx context.Context, urlID string) (models.Link, error)
+	FindByURL(ctx context.Context, url string) (models.Link, error)
+	Delete(ctx context.Context, urlID string) error
+	Restore(ctx context.Context, urlID string) error
+	Import(ctx context.Context, link models.Link) error
+	Iterate(ctx context.Context, afterID string, fn func(link models.Link) error) error
+}
+
+const (
+	BackendMemory   = "memory"
+	BackendFile     = "file"
+	BackendBolt     = "bolt"
+	BackendDatabase = "database"
+	BackendSQLite   = "sqlite"
+	BackendSharded  = "sharded"
+)
+
+// Opener opens a backend from its storage url, it must reject options it doesn't know
+type Opener func(u *url.URL) (Repo, error)
+
+type backend struct {
+	name string
+	open Opener
 }
 
-func NewStorage(filePath string, databaseDSN string) (Repo, error) {
-	switch {
-	case databaseDSN != "":
-		r, err := database.NewRepo(databaseDSN)
-		if err != nil {
-			return nil, fmt.Errorf("initialize database repo error: %w", err)
+var (
+	backendsMu sync.RWMutex
+	backends   = map[string]backend{}
+)
+
+// Register makes the backend available under the url schemes, backend packages call it from init.
+// Registering a scheme twice panics.
+func Register(name string, open Opener, schemes ...string) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+
+	for _, scheme := range schemes {
+		if _, ok := backends[scheme]; ok {
+			panic(fmt.Sprintf("storage scheme %q registered twice", scheme))
 		}
-		return r, nil
+		backends[scheme] = backend{name: name, open: open}
+	}
+}
 
-	case filePath != "":
+// Backend names the backend serving the storage url
+func Backend(storageURL string) (string, error) {
+	_, b, err := lookup(storageURL)
+	if err != nil {
+		return "", err
+	}
 
-		r, err := file.NewRepo(filePath)
-		if err != nil {
-			return nil, fmt.Errorf("initialize file repo error: %w", err)
+	return b.name, nil
+}
+
+// NewStorage opens the storage the url points at, e.g. memory://, file:///var/lib/shortener/urls.dat
+// or postgres://user@host/db
+func NewStorage(storageURL string) (Repo, error) {
+	u, b, err := lookup(storageURL)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := b.open(u)
+	if err != nil {
+		return nil, fmt.Errorf("initialize %s repo error: %w", b.name, err)
+	}
+
+	return r, nil
+}
+
+func lookup(storageURL string) (*url.URL, backend, error) {
+	if storageURL == "" {
+		return nil, backend{}, fmt.Errorf("%w: not specified", errs.ErrInvalidStorageURL)
+	}
+
+	u, err := url.Parse(storageURL)
+	if err != nil {
+		return nil, backend{}, fmt.Errorf("%w: %v", errs.ErrInvalidStorageURL, err)
+	}
+
+	backendsMu.RLock()
+	b, ok := backends[u.Scheme]
+	backendsMu.RUnlock()
+
+	if !ok {
+		return nil, backend{}, fmt.Errorf("%w: unknown scheme %q, known: %s", errs.ErrInvalidStorageURL, u.Scheme, strings.Join(schemes(), ", "))
+	}
+
+	return u, b, nil
+}
+
+func schemes() []string {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+
+	names := make([]string, 0, len(backends))
+	for scheme := range backends {
+		names = append(names, scheme)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// FilePath returns the file of a file:///abs/path or file:relative/path like url
+func FilePath(u *url.URL) (string, error) {
+	if u.Host != "" {
+		return "", fmt.Errorf("%w: %s url can't have a host, use %s:///absolute/path or %s:relative/path",
+			errs.ErrInvalidStorageURL, u.Scheme, u.Scheme, u.Scheme)
+	}
+
+	path := u.Path
+	if u.Opaque != "" {
+		path = u.Opaque
+	}
+
+	if path == "" {
+		return "", fmt.Errorf("%w: %s url needs a file path", errs.ErrInvalidStorageURL, u.Scheme)
+	}
+
+	return path, nil
+}
+
+// CheckOptions rejects query parameters of the url other than the known ones
+func CheckOptions(u *url.URL, known ...string) error {
+	for name := range u.Query() {
+		found := false
+		for _, option := range known {
+			if name == option {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return fmt.Errorf("%w: unknown %s option %q", errs.ErrInvalidStorageURL, u.Scheme, name)
 		}
-		return r, nil
 	}
-	return memory.NewRepo(), nil
+
+	return nil
 }
@@ -0,0 +1,126 @@
+package urls_test
+
+import (
+	"context"
+	repositoryURL "github.com/ChristinaFomenko/shortener/internal/app/repository/urls"
+	_ "github.com/ChristinaFomenko/shortener/internal/app/repository/urls/bolt"
+	_ "github.com/ChristinaFomenko/shortener/internal/app/repository/urls/file"
+	_ "github.com/ChristinaFomenko/shortener/internal/app/repository/urls/memory"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewStorage(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name       string
+		storageURL string
+		backend    string
+		err        error
+	}{
+		{
+			name:       "memory",
+			storageURL: "memory://",
+			backend:    repositoryURL.BackendMemory,
+		},
+		{
+			name:       "absolute file",
+			storageURL: "file://" + filepath.Join(dir, "urls.dat"),
+			backend:    repositoryURL.BackendFile,
+		},
+		{
+			name:       "bolt",
+			storageURL: "bolt://" + filepath.Join(dir, "urls.db"),
+			backend:    repositoryURL.BackendBolt,
+		},
+		{
+			name:       "not specified",
+			storageURL: "",
+			err:        errs.ErrInvalidStorageURL,
+		},
+		{
+			name:       "unknown scheme",
+			storageURL: "redis://localhost:6379",
+			err:        errs.ErrInvalidStorageURL,
+		},
+		{
+			name:       "plain path",
+			storageURL: "storage.dat",
+			err:        errs.ErrInvalidStorageURL,
+		},
+		{
+			name:       "memory with a path",
+			storageURL: "memory:///tmp/urls.dat",
+			backend:    repositoryURL.BackendMemory,
+			err:        errs.ErrInvalidStorageURL,
+		},
+		{
+			name:       "file with a host",
+			storageURL: "file://urls.dat",
+			backend:    repositoryURL.BackendFile,
+			err:        errs.ErrInvalidStorageURL,
+		},
+		{
+			name:       "file without a path",
+			storageURL: "file://",
+			backend:    repositoryURL.BackendFile,
+			err:        errs.ErrInvalidStorageURL,
+		},
+		{
+			name:       "unknown option",
+			storageURL: "file://" + filepath.Join(dir, "other.dat") + "?sync=always",
+			backend:    repositoryURL.BackendFile,
+			err:        errs.ErrInvalidStorageURL,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend, _ := repositoryURL.Backend(tt.storageURL)
+			assert.Equal(t, tt.backend, backend)
+
+			repo, err := repositoryURL.NewStorage(tt.storageURL)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				assert.Nil(t, repo)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.NoError(t, repo.Ping(context.Background()))
+			assert.NoError(t, repo.Close())
+		})
+	}
+}
+
+func TestNewStorage_RelativeFile(t *testing.T) {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	defer func() {
+		_ = os.Chdir(wd)
+	}()
+
+	repo, err := repositoryURL.NewStorage("file:urls.dat")
+	require.NoError(t, err)
+	require.NoError(t, repo.Close())
+
+	assert.FileExists(t, filepath.Join(dir, "urls.dat"))
+}
+
+func TestRegister_Twice(t *testing.T) {
+	open := func(u *url.URL) (repositoryURL.Repo, error) {
+		return nil, nil
+	}
+
+	assert.Panics(t, func() {
+		repositoryURL.Register("other", open, "memory")
+	})
+}
@@ -0,0 +1,158 @@
+package traced
+
+import (
+	"context"
+	"errors"
+	"github.com/ChristinaFomenko/shortener/internal/app/models"
+	repositoryURL "github.com/ChristinaFomenko/shortener/internal/app/repository/urls"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	"github.com/ChristinaFomenko/shortener/pkg/tracing"
+	"strconv"
+)
+
+type repository struct {
+	repositoryURL.Repo
+	backend string
+}
+
+// NewRepo decorates the repository with a span per call
+func NewRepo(repo repositoryURL.Repo, backend string) *repository {
+	return &repository{
+		Repo:    repo,
+		backend: backend,
+	}
+}
+
+func (r *repository) Add(ctx context.Context, link models.Link) error {
+	ctx, span := r.start(ctx, "Add")
+	span.SetAttribute("url.id", link.ID)
+
+	err := r.Repo.Add(ctx, link)
+	end(span, err)
+
+	return err
+}
+
+func (r *repository) Get(ctx context.Context, urlID string) (models.Link, error) {
+	ctx, span := r.start(ctx, "Get")
+	span.SetAttribute("url.id", urlID)
+
+	link, err := r.Repo.Get(ctx, urlID)
+	end(span, err)
+
+	return link, err
+}
+
+func (r *repository) FetchURLs(ctx context.Context, userID string) ([]models.UserURL, error) {
+	ctx, span := r.start(ctx, "FetchURLs")
+
+	urls, err := r.Repo.FetchURLs(ctx, userID)
+	span.SetAttribute("urls.count", strconv.Itoa(len(urls)))
+	end(span, err)
+
+	return urls, err
+}
+
+func (r *repository) Ping(ctx context.Context) error {
+	ctx, span := r.start(ctx, "Ping")
+
+	err := r.Repo.Ping(ctx)
+	end(span, err)
+
+	return err
+}
+
+func (r *repository) AddBatch(ctx context.Context, urls []models.UserURL, userID string) ([]models.BatchResult, error) {
+	ctx, span := r.start(ctx, "AddBatch")
+	span.SetAttribute("urls.count", strconv.Itoa(len(urls)))
+
+	results, err := r.Repo.AddBatch(ctx, urls, userID)
+	end(span, err)
+
+	return results, err
+}
+
+func (r *repository) GetLink(ctx context.Context, urlID string) (models.Link, error) {
+	ctx, span := r.start(ctx, "GetLink")
+	span.SetAttribute("url.id", urlID)
+
+	link, err := r.Repo.GetLink(ctx, urlID)
+	end(span, err)
+
+	return link, err
+}
+
+func (r *repository) FindByURL(ctx context.Context, url string) (models.Link, error) {
+	ctx, span := r.start(ctx, "FindByURL")
+
+	link, err := r.Repo.FindByURL(ctx, url)
+	end(span, err)
+
+	return link, err
+}
+
+func (r *repository) Delete(ctx context.Context, urlID string) error {
+	ctx, span := r.start(ctx, "Delete")
+	span.SetAttribute("url.id", urlID)
+
+	err := r.Repo.Delete(ctx, urlID)
+	end(span, err)
+
+	return err
+}
+
+func (r *repository) Update(ctx context.Context, link models.Link) error {
+	ctx, span := r.start(ctx, "Update")
+	span.SetAttribute("url.id", link.ID)
+
+	err := r.Repo.Update(ctx, link)
+	end(span, err)
+
+	return err
+}
+
+func (r *repository) Restore(ctx context.Context, urlID string) error {
+	ctx, span := r.start(ctx, "Restore")
+	span.SetAttribute("url.id", urlID)
+
+	err := r.Repo.Restore(ctx, urlID)
+	end(span, err)
+
+	return err
+}
+
+func (r *repository) Import(ctx context.Context, link models.Link) error {
+	ctx, span := r.start(ctx, "Import")
+	span.SetAttribute("url.id", link.ID)
+
+	err := r.Repo.Import(ctx, link)
+	end(span, err)
+
+	return err
+}
+
+func (r *repository) Iterate(ctx context.Context, afterID string, fn func(link models.Link) error) error {
+	ctx, span := r.start(ctx, "Iterate")
+
+	err := r.Repo.Iterate(ctx, afterID, fn)
+	end(span, err)
+
+	return err
+}
+
+func (r *repository) start(ctx context.Context, operation string) (context.Context, *tracing.Span) {
+	ctx, span := tracing.Start(ctx, "repository."+operation, tracing.WithKind(tracing.KindClient))
+	span.SetAttribute("db.backend", r.backend)
+
+	return ctx, span
+}
+
+// end finishes the span, lookups of missing or duplicated urls are not failures
+func end(span *tracing.Span, err error) {
+	var uniqueErr *errs.NotUniqueURLErr
+	if err != nil && !errors.Is(err, errs.ErrURLNotFound) && !errors.As(err, &uniqueErr) {
+		span.RecordError(err)
+	}
+
+	span.End()
+}
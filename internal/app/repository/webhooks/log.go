@@ -0,0 +1,182 @@
+package webhooks
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"github.com/ChristinaFomenko/shortener/internal/app/models"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// logMagic starts a log file, a file without it holds a whole state written by older versions
+const logMagic = "webhooks log v1\n"
+
+// compactMin is how many changes the log takes before it is compacted, it is compacted once it
+// holds more changes than the state has entries
+const compactMin = 1000
+
+// change is a record of the log, the first record of a file holds the whole state
+type change struct {
+	State      *state
+	Hooks      []models.Webhook
+	DeleteHook string
+	Queue      []models.Delivery
+	Unqueue    []string
+	Dead       []models.Delivery
+	Undead     []string
+}
+
+// apply replays the change on the state
+func (s *state) apply(c change) {
+	if c.State != nil {
+		*s = *c.State
+		s.init()
+	}
+
+	for _, hook := range c.Hooks {
+		s.Hooks[hook.ID] = hook
+	}
+
+	if c.DeleteHook != "" {
+		delete(s.Hooks, c.DeleteHook)
+		for id, delivery := range s.Queue {
+			if delivery.WebhookID == c.DeleteHook {
+				delete(s.Queue, id)
+			}
+		}
+	}
+
+	for _, id := range c.Unqueue {
+		delete(s.Queue, id)
+	}
+	for _, id := range c.Undead {
+		delete(s.Dead, id)
+	}
+	for _, delivery := range c.Queue {
+		s.Queue[delivery.ID] = delivery
+	}
+	for _, delivery := range c.Dead {
+		s.Dead[delivery.ID] = delivery
+	}
+}
+
+// init makes the maps gob leaves out when empty
+func (s *state) init() {
+	if s.Hooks == nil {
+		s.Hooks = map[string]models.Webhook{}
+	}
+	if s.Queue == nil {
+		s.Queue = map[string]models.Delivery{}
+	}
+	if s.Dead == nil {
+		s.Dead = map[string]models.Delivery{}
+	}
+}
+
+func (s *state) size() int {
+	return len(s.Hooks) + len(s.Queue) + len(s.Dead)
+}
+
+// readLog replays the file on the state. A record cut short by a crash ends the log, the change
+// it held was never acknowledged.
+func readLog(filePath string, s *state) error {
+	data, err := os.ReadFile(filePath)
+	switch {
+	case os.IsNotExist(err):
+		return nil
+	case err != nil:
+		return fmt.Errorf("read webhooks file error: %w", err)
+	case len(data) == 0:
+		return nil
+	}
+
+	if !bytes.HasPrefix(data, []byte(logMagic)) {
+		if err = gob.NewDecoder(bytes.NewReader(data)).Decode(s); err != nil {
+			return fmt.Errorf("decode webhooks file error: %w", err)
+		}
+		return nil
+	}
+
+	reader := bufio.NewReader(bytes.NewReader(data[len(logMagic):]))
+	for {
+		var size uint32
+		if err = binary.Read(reader, binary.BigEndian, &size); err != nil {
+			break
+		}
+
+		record := make([]byte, size)
+		if _, err = io.ReadFull(reader, record); err != nil {
+			break
+		}
+
+		var c change
+		if err = gob.NewDecoder(bytes.NewReader(record)).Decode(&c); err != nil {
+			return fmt.Errorf("decode webhooks file error: %w", err)
+		}
+		s.apply(c)
+	}
+
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil
+	}
+
+	return fmt.Errorf("read webhooks file error: %w", err)
+}
+
+// encode frames the change for the log, every record carries its own gob types so it can be read alone
+func encode(c change) ([]byte, error) {
+	var record bytes.Buffer
+	if err := gob.NewEncoder(&record).Encode(c); err != nil {
+		return nil, fmt.Errorf("encode webhooks error: %w", err)
+	}
+
+	framed := make([]byte, 4, 4+record.Len())
+	binary.BigEndian.PutUint32(framed, uint32(record.Len()))
+
+	return append(framed, record.Bytes()...), nil
+}
+
+// writeLog replaces the file with a log holding the state alone through a temporary one, so a crash never
+// leaves half a state behind. It returns the file opened for appending.
+func writeLog(filePath string, s state) (*os.File, error) {
+	record, err := encode(change{State: &s})
+	if err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), filepath.Base(filePath)+".*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("create webhooks file error: %w", err)
+	}
+
+	if _, err = tmp.WriteString(logMagic); err == nil {
+		_, err = tmp.Write(record)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return nil, fmt.Errorf("write webhooks file error: %w", err)
+	}
+
+	if err = os.Rename(tmp.Name(), filePath); err != nil {
+		_ = os.Remove(tmp.Name())
+		return nil, fmt.Errorf("replace webhooks file error: %w", err)
+	}
+
+	file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("open webhooks file error: %w", err)
+	}
+
+	return file, nil
+}
@@ -0,0 +1,319 @@
+package webhooks
+
+import (
+	"context"
+	"fmt"
+	"github.com/ChristinaFomenko/shortener/internal/app/models"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Repo keeps webhooks, the queue of their deliveries and the deliveries given up on
+type Repo interface {
+	AddWebhook(ctx context.Context, hook models.Webhook) error
+	DeleteWebhook(ctx context.Context, userID, hookID string) error
+	Webhook(ctx context.Context, hookID string) (models.Webhook, error)
+	Webhooks(ctx context.Context, userID string) ([]models.Webhook, error)
+	Subscribers(ctx context.Context, event models.Event) ([]models.Webhook, error)
+	Enqueue(ctx context.Context, deliveries []models.Delivery) error
+	Due(ctx context.Context, now time.Time, limit int) ([]models.Delivery, error)
+	Reschedule(ctx context.Context, delivery models.Delivery) error
+	Complete(ctx context.Context, deliveryID string) error
+	Bury(ctx context.Context, delivery models.Delivery) error
+	DeadLetters(ctx context.Context, userID string) ([]models.Delivery, error)
+	Requeue(ctx context.Context, userID, deliveryID string, now time.Time) error
+	Close() error
+}
+
+// state is everything the repository keeps
+type state struct {
+	Hooks map[string]models.Webhook
+	Queue map[string]models.Delivery
+	Dead  map[string]models.Delivery
+}
+
+type repository struct {
+	filePath string
+
+	mu    sync.Mutex
+	state state
+	// file is the log the changes are appended to, records is how many it holds since it was compacted
+	file    *os.File
+	records int
+}
+
+// NewRepo keeps webhooks and their delivery queue in memory and, unless filePath is empty,
+// in the file so queued deliveries survive restarts. The file is a log every change is appended to,
+// it is compacted on start and once it grows well over the state. It belongs to one instance,
+// servers sharing a database keep webhooks there, see database.NewWebhookRepo.
+func NewRepo(filePath string) (*repository, error) {
+	r := &repository{filePath: filePath}
+	r.state.init()
+
+	if filePath == "" {
+		return r, nil
+	}
+
+	if err := readLog(filePath, &r.state); err != nil {
+		return nil, err
+	}
+	r.state.init()
+
+	if err := r.compact(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *repository) AddWebhook(_ context.Context, hook models.Webhook) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.commit(change{Hooks: []models.Webhook{hook}})
+}
+
+// DeleteWebhook removes the webhook of the user together with its queued deliveries
+func (r *repository) DeleteWebhook(_ context.Context, userID, hookID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	hook, ok := r.state.Hooks[hookID]
+	if !ok || hook.UserID != userID {
+		return errs.ErrWebhookNotFound
+	}
+
+	return r.commit(change{DeleteHook: hookID})
+}
+
+func (r *repository) Webhook(_ context.Context, hookID string) (models.Webhook, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	hook, ok := r.state.Hooks[hookID]
+	if !ok {
+		return models.Webhook{}, errs.ErrWebhookNotFound
+	}
+
+	return hook, nil
+}
+
+// Webhooks returns the webhooks of the user, oldest first
+func (r *repository) Webhooks(_ context.Context, userID string) ([]models.Webhook, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	hooks := make([]models.Webhook, 0)
+	for _, hook := range r.state.Hooks {
+		if hook.UserID == userID {
+			hooks = append(hooks, hook)
+		}
+	}
+
+	sort.Slice(hooks, func(i, j int) bool {
+		return hooks[i].CreatedAt.Before(hooks[j].CreatedAt)
+	})
+
+	return hooks, nil
+}
+
+// Subscribers returns the webhooks the event goes to
+func (r *repository) Subscribers(_ context.Context, event models.Event) ([]models.Webhook, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var hooks []models.Webhook
+	for _, hook := range r.state.Hooks {
+		if hook.Wants(event) {
+			hooks = append(hooks, hook)
+		}
+	}
+
+	return hooks, nil
+}
+
+func (r *repository) Enqueue(_ context.Context, deliveries []models.Delivery) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.commit(change{Queue: deliveries})
+}
+
+// Due returns up to limit queued deliveries whose next attempt is not after now, earliest first
+func (r *repository) Due(_ context.Context, now time.Time, limit int) ([]models.Delivery, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var due []models.Delivery
+	for _, delivery := range r.state.Queue {
+		if !delivery.NextAttempt.After(now) {
+			due = append(due, delivery)
+		}
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].NextAttempt.Before(due[j].NextAttempt)
+	})
+
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	return due, nil
+}
+
+// Reschedule stores the attempts of a queued delivery
+func (r *repository) Reschedule(_ context.Context, delivery models.Delivery) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.state.Queue[delivery.ID]; !ok {
+		return errs.ErrDeliveryNotFound
+	}
+
+	return r.commit(change{Queue: []models.Delivery{delivery}})
+}
+
+// Complete removes a delivered delivery from the queue
+func (r *repository) Complete(_ context.Context, deliveryID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.commit(change{Unqueue: []string{deliveryID}})
+}
+
+// Bury moves a delivery that ran out of attempts to the dead letters, keeping models.MaxDeadLetters of the user
+func (r *repository) Bury(_ context.Context, delivery models.Delivery) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	dead := []models.Delivery{delivery}
+	for _, buried := range r.state.Dead {
+		if buried.UserID == delivery.UserID && buried.ID != delivery.ID {
+			dead = append(dead, buried)
+		}
+	}
+
+	var dropped []string
+	if len(dead) > models.MaxDeadLetters {
+		sort.Slice(dead, func(i, j int) bool {
+			return dead[i].NextAttempt.After(dead[j].NextAttempt)
+		})
+		for _, buried := range dead[models.MaxDeadLetters:] {
+			dropped = append(dropped, buried.ID)
+		}
+	}
+
+	return r.commit(change{Unqueue: []string{delivery.ID}, Dead: []models.Delivery{delivery}, Undead: dropped})
+}
+
+// DeadLetters returns the deliveries given up on for the user, latest first
+func (r *repository) DeadLetters(_ context.Context, userID string) ([]models.Delivery, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	dead := make([]models.Delivery, 0)
+	for _, delivery := range r.state.Dead {
+		if delivery.UserID == userID {
+			dead = append(dead, delivery)
+		}
+	}
+
+	sort.Slice(dead, func(i, j int) bool {
+		return dead[i].NextAttempt.After(dead[j].NextAttempt)
+	})
+
+	return dead, nil
+}
+
+// Requeue moves a dead letter of the user back to the queue with fresh attempts
+func (r *repository) Requeue(_ context.Context, userID, deliveryID string, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delivery, ok := r.state.Dead[deliveryID]
+	if !ok || delivery.UserID != userID {
+		return errs.ErrDeliveryNotFound
+	}
+
+	if _, ok = r.state.Hooks[delivery.WebhookID]; !ok {
+		return errs.ErrWebhookNotFound
+	}
+
+	delivery.Attempts = 0
+	delivery.NextAttempt = now
+
+	return r.commit(change{Undead: []string{deliveryID}, Queue: []models.Delivery{delivery}})
+}
+
+// QueueLen returns the number of deliveries waiting to be sent
+func (r *repository) QueueLen() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.state.Queue)
+}
+
+func (r *repository) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+
+	err := r.file.Close()
+	r.file = nil
+
+	return err
+}
+
+// commit appends the change to the log before applying it, a change that can't be written is not applied
+func (r *repository) commit(c change) error {
+	if r.file != nil {
+		record, err := encode(c)
+		if err != nil {
+			return err
+		}
+
+		if _, err = r.file.Write(record); err == nil {
+			err = r.file.Sync()
+		}
+		if err != nil {
+			// a record written in part would hide the ones appended after it
+			if compactErr := r.compact(); compactErr != nil {
+				log.WithError(compactErr).Error("compact webhooks file error")
+			}
+			return fmt.Errorf("write webhooks file error: %w", err)
+		}
+		r.records++
+	}
+
+	r.state.apply(c)
+
+	if r.file != nil && r.records > compactMin && r.records > r.state.size() {
+		return r.compact()
+	}
+
+	return nil
+}
+
+// compact replaces the log with the state alone
+func (r *repository) compact() error {
+	file, err := writeLog(r.filePath, r.state)
+	if err != nil {
+		return err
+	}
+
+	if r.file != nil {
+		_ = r.file.Close()
+	}
+	r.file = file
+	r.records = 0
+
+	return nil
+}
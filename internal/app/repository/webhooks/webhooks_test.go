@@ -0,0 +1,203 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"github.com/ChristinaFomenko/shortener/internal/app/models"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRepository_Persistence(t *testing.T) {
+	ctx := context.Background()
+	filePath := filepath.Join(t.TempDir(), "webhooks.dat")
+	now := time.Now().Truncate(time.Second)
+
+	repo, err := NewRepo(filePath)
+	require.NoError(t, err)
+
+	hook := models.Webhook{ID: "hook", UserID: "user", URL: "http://localhost/hook", Secret: "secret", CreatedAt: now}
+	require.NoError(t, repo.AddWebhook(ctx, hook))
+	require.NoError(t, repo.Enqueue(ctx, []models.Delivery{
+		{ID: "first", WebhookID: "hook", UserID: "user", Payload: []byte(`{}`), NextAttempt: now},
+		{ID: "dead", WebhookID: "hook", UserID: "user", Payload: []byte(`{}`), NextAttempt: now},
+	}))
+	require.NoError(t, repo.Bury(ctx, models.Delivery{ID: "dead", WebhookID: "hook", UserID: "user", Attempts: 8}))
+
+	reopened, err := NewRepo(filePath)
+	require.NoError(t, err)
+
+	act, err := reopened.Webhook(ctx, "hook")
+	require.NoError(t, err)
+	assert.Equal(t, "secret", act.Secret)
+
+	due, err := reopened.Due(ctx, now, 10)
+	require.NoError(t, err)
+	require.Len(t, due, 1)
+	assert.Equal(t, "first", due[0].ID)
+
+	dead, err := reopened.DeadLetters(ctx, "user")
+	require.NoError(t, err)
+	require.Len(t, dead, 1)
+	assert.Equal(t, 8, dead[0].Attempts)
+}
+
+func TestRepository_Log(t *testing.T) {
+	ctx := context.Background()
+	filePath := filepath.Join(t.TempDir(), "webhooks.dat")
+
+	repo, err := NewRepo(filePath)
+	require.NoError(t, err)
+	require.NoError(t, repo.AddWebhook(ctx, models.Webhook{ID: "hook", UserID: "user"}))
+
+	// changes are appended, the file is not rewritten
+	info, err := os.Stat(filePath)
+	require.NoError(t, err)
+	size := info.Size()
+
+	require.NoError(t, repo.Enqueue(ctx, []models.Delivery{{ID: "queued", WebhookID: "hook", UserID: "user"}}))
+	require.NoError(t, repo.Complete(ctx, "queued"))
+
+	info, err = os.Stat(filePath)
+	require.NoError(t, err)
+	assert.Greater(t, info.Size(), size)
+
+	// the log is compacted once it outgrows the state
+	for idx := 0; idx <= compactMin; idx++ {
+		require.NoError(t, repo.Enqueue(ctx, []models.Delivery{{ID: fmt.Sprintf("queued%d", idx), WebhookID: "hook", UserID: "user"}}))
+		require.NoError(t, repo.Complete(ctx, fmt.Sprintf("queued%d", idx)))
+	}
+	assert.Less(t, repo.records, compactMin)
+
+	require.NoError(t, repo.Enqueue(ctx, []models.Delivery{{ID: "last", WebhookID: "hook", UserID: "user"}}))
+	require.NoError(t, repo.Close())
+
+	// a record cut short by a crash is dropped
+	file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_APPEND, 0600)
+	require.NoError(t, err)
+	_, err = file.Write([]byte{0, 0, 1})
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	reopened, err := NewRepo(filePath)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	assert.Equal(t, 1, reopened.QueueLen())
+	_, err = reopened.Webhook(ctx, "hook")
+	assert.NoError(t, err)
+}
+
+func TestRepository_LegacyFile(t *testing.T) {
+	ctx := context.Background()
+	filePath := filepath.Join(t.TempDir(), "webhooks.dat")
+
+	var buff bytes.Buffer
+	require.NoError(t, gob.NewEncoder(&buff).Encode(state{
+		Hooks: map[string]models.Webhook{"hook": {ID: "hook", UserID: "user"}},
+		Queue: map[string]models.Delivery{"queued": {ID: "queued", WebhookID: "hook", UserID: "user"}},
+	}))
+	require.NoError(t, os.WriteFile(filePath, buff.Bytes(), 0600))
+
+	repo, err := NewRepo(filePath)
+	require.NoError(t, err)
+	defer repo.Close()
+
+	assert.Equal(t, 1, repo.QueueLen())
+	require.NoError(t, repo.Bury(ctx, models.Delivery{ID: "queued", WebhookID: "hook", UserID: "user"}))
+
+	dead, err := repo.DeadLetters(ctx, "user")
+	require.NoError(t, err)
+	assert.Len(t, dead, 1)
+}
+
+func TestRepository_Due(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	repo, err := NewRepo("")
+	require.NoError(t, err)
+
+	require.NoError(t, repo.Enqueue(ctx, []models.Delivery{
+		{ID: "later", NextAttempt: now.Add(time.Minute)},
+		{ID: "second", NextAttempt: now.Add(-time.Second)},
+		{ID: "first", NextAttempt: now.Add(-time.Minute)},
+		{ID: "third", NextAttempt: now},
+	}))
+
+	due, err := repo.Due(ctx, now, 2)
+	require.NoError(t, err)
+	require.Len(t, due, 2)
+	assert.Equal(t, "first", due[0].ID)
+	assert.Equal(t, "second", due[1].ID)
+}
+
+func TestRepository_DeleteWebhook(t *testing.T) {
+	ctx := context.Background()
+
+	repo, err := NewRepo("")
+	require.NoError(t, err)
+
+	require.NoError(t, repo.AddWebhook(ctx, models.Webhook{ID: "hook", UserID: "user"}))
+	require.NoError(t, repo.Enqueue(ctx, []models.Delivery{{ID: "queued", WebhookID: "hook", UserID: "user"}}))
+
+	assert.ErrorIs(t, repo.DeleteWebhook(ctx, "other", "hook"), errs.ErrWebhookNotFound)
+	require.NoError(t, repo.DeleteWebhook(ctx, "user", "hook"))
+
+	assert.Equal(t, 0, repo.QueueLen())
+	_, err = repo.Webhook(ctx, "hook")
+	assert.ErrorIs(t, err, errs.ErrWebhookNotFound)
+}
+
+func TestRepository_Requeue(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	repo, err := NewRepo("")
+	require.NoError(t, err)
+
+	require.NoError(t, repo.AddWebhook(ctx, models.Webhook{ID: "hook", UserID: "user"}))
+	require.NoError(t, repo.Bury(ctx, models.Delivery{ID: "dead", WebhookID: "hook", UserID: "user", Attempts: 8}))
+
+	assert.ErrorIs(t, repo.Requeue(ctx, "other", "dead", now), errs.ErrDeliveryNotFound)
+	require.NoError(t, repo.Requeue(ctx, "user", "dead", now))
+
+	due, err := repo.Due(ctx, now, 10)
+	require.NoError(t, err)
+	require.Len(t, due, 1)
+	assert.Equal(t, 0, due[0].Attempts)
+
+	dead, err := repo.DeadLetters(ctx, "user")
+	require.NoError(t, err)
+	assert.Empty(t, dead)
+}
+
+func TestRepository_BuryKeepsLatest(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	repo, err := NewRepo("")
+	require.NoError(t, err)
+
+	for idx := 0; idx <= models.MaxDeadLetters; idx++ {
+		require.NoError(t, repo.Bury(ctx, models.Delivery{ID: fmt.Sprintf("dead%d", idx), UserID: "user", NextAttempt: now.Add(time.Duration(idx) * time.Second)}))
+	}
+	require.NoError(t, repo.Bury(ctx, models.Delivery{ID: "other", UserID: "other", NextAttempt: now.Add(-time.Hour)}))
+
+	dead, err := repo.DeadLetters(ctx, "user")
+	require.NoError(t, err)
+	require.Len(t, dead, models.MaxDeadLetters)
+	assert.Equal(t, fmt.Sprintf("dead%d", models.MaxDeadLetters), dead[0].ID)
+	assert.Equal(t, "dead1", dead[len(dead)-1].ID)
+
+	dead, err = repo.DeadLetters(ctx, "other")
+	require.NoError(t, err)
+	assert.Len(t, dead, 1)
+}
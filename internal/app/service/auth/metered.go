@@ -0,0 +1,49 @@
+package auth
+
+import "github.com/ChristinaFomenko/shortener/pkg/metrics"
+
+const (
+	resultSuccess = "success"
+	resultFailure = "failure"
+)
+
+type authService interface {
+	SignUp() (string, string, error)
+	SignIn(token string) (string, error)
+}
+
+type meteredService struct {
+	authService
+	attempts *metrics.CounterVec
+}
+
+// NewMeteredService counts sign ups and sign ins of the wrapped service by result
+func NewMeteredService(service authService, registry *metrics.Registry) *meteredService {
+	return &meteredService{
+		authService: service,
+		attempts: registry.NewCounterVec("shortener_auth_attempts_total",
+			"User sign ups and sign ins, by operation and result.", "operation", "result"),
+	}
+}
+
+func (s *meteredService) SignUp() (string, string, error) {
+	userID, token, err := s.authService.SignUp()
+	s.attempts.Inc("signup", result(err))
+
+	return userID, token, err
+}
+
+func (s *meteredService) SignIn(token string) (string, error) {
+	userID, err := s.authService.SignIn(token)
+	s.attempts.Inc("signin", result(err))
+
+	return userID, err
+}
+
+func result(err error) string {
+	if err != nil {
+		return resultFailure
+	}
+
+	return resultSuccess
+}
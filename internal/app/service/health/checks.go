@@ -0,0 +1,99 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// queueFullRatio is the fill level from which a worker queue is considered stuck
+	queueFullRatio = 0.9
+
+	errUnavailable = "unavailable"
+)
+
+type pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// DatabaseCheck fails when the database can't be reached. The report is public, the error naming hosts
+// and driver details is only logged. A database guarded by circuit breakers, each telling whether it is open,
+// is reported degraded instead: redirects are served from the snapshots meanwhile, so the instance stays ready.
+func DatabaseCheck(db pinger, circuits ...func() bool) Check {
+	return func(ctx context.Context) CheckResult {
+		err := db.Ping(ctx)
+		if err == nil {
+			return CheckResult{Status: StatusOK}
+		}
+
+		log.WithError(err).Warn("database check failed")
+		if len(circuits) == 0 {
+			return CheckResult{Status: StatusFail, Error: errUnavailable}
+		}
+
+		open := 0
+		for _, isOpen := range circuits {
+			if isOpen() {
+				open++
+			}
+		}
+
+		return CheckResult{
+			Status:  StatusDegraded,
+			Error:   errUnavailable,
+			Details: map[string]interface{}{"open_circuits": open, "circuits": len(circuits)},
+		}
+	}
+}
+
+// FileStorageCheck fails when the storage file or its directory is not writable,
+// or when less than minFreeBytes are left on the disk
+func FileStorageCheck(path string, minFreeBytes uint64) Check {
+	return func(_ context.Context) CheckResult {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return CheckResult{Status: StatusFail, Error: fmt.Sprintf("storage file is not writable: %v", err)}
+		}
+		_ = file.Close()
+
+		// the storage is rewritten through the directory, so it has to accept new files too
+		dir := filepath.Dir(path)
+		tmp, err := os.CreateTemp(dir, ".readyz-*")
+		if err != nil {
+			return CheckResult{Status: StatusFail, Error: fmt.Sprintf("storage directory is not writable: %v", err)}
+		}
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+
+		free, err := freeDiskSpace(dir)
+		if err != nil {
+			return CheckResult{Status: StatusOK, Details: map[string]interface{}{"free_bytes": "unknown"}}
+		}
+
+		result := CheckResult{Status: StatusOK, Details: map[string]interface{}{"free_bytes": free}}
+		if free < minFreeBytes {
+			result.Status = StatusFail
+			result.Error = fmt.Sprintf("%d bytes free, %d required", free, minFreeBytes)
+		}
+
+		return result
+	}
+}
+
+// QueueCheck fails when a background worker queue is nearly full, meaning the worker can't keep up
+func QueueCheck(length, capacity func() int) Check {
+	return func(_ context.Context) CheckResult {
+		l, c := length(), capacity()
+
+		result := CheckResult{Status: StatusOK, Details: map[string]interface{}{"length": l, "capacity": c}}
+		if c > 0 && float64(l) >= float64(c)*queueFullRatio {
+			result.Status = StatusFail
+			result.Error = "queue is almost full"
+		}
+
+		return result
+	}
+}
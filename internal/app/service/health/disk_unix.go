@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package health
+
+import "syscall"
+
+func freeDiskSpace(dir string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
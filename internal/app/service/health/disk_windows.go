@@ -0,0 +1,10 @@
+//go:build windows
+// +build windows
+
+package health
+
+import "errors"
+
+func freeDiskSpace(_ string) (uint64, error) {
+	return 0, errors.New("free disk space is not supported on windows")
+}
@@ -0,0 +1,147 @@
+package health
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+	// StatusDegraded is a failing dependency the instance works around, it stays ready
+	StatusDegraded = "degraded"
+
+	StatusReady         = "ready"
+	StatusReadyDegraded = "degraded"
+	StatusNotReady      = "not_ready"
+	StatusShuttingDown  = "shutting_down"
+)
+
+// Check inspects one dependency, it must respect the context deadline
+type Check func(ctx context.Context) CheckResult
+
+type CheckResult struct {
+	Status    string                 `json:"status"`
+	LatencyMS float64                `json:"latency_ms"`
+	Error     string                 `json:"error,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+}
+
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+func (r Report) Ready() bool {
+	return r.Status == StatusReady || r.Status == StatusReadyDegraded
+}
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+type service struct {
+	mu           sync.RWMutex
+	checks       []namedCheck
+	timeout      time.Duration
+	shuttingDown int32
+}
+
+// NewService runs the registered readiness checks, each one limited by the timeout
+func NewService(timeout time.Duration) *service {
+	return &service{
+		timeout: timeout,
+	}
+}
+
+func (s *service) Register(name string, check Check) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.checks = append(s.checks, namedCheck{name: name, check: check})
+	sort.Slice(s.checks, func(i, j int) bool {
+		return s.checks[i].name < s.checks[j].name
+	})
+}
+
+// SetShuttingDown makes the service report not ready, so load balancers drain the instance
+func (s *service) SetShuttingDown() {
+	atomic.StoreInt32(&s.shuttingDown, 1)
+}
+
+func (s *service) ShuttingDown() bool {
+	return atomic.LoadInt32(&s.shuttingDown) == 1
+}
+
+// Ready runs all checks concurrently
+func (s *service) Ready(ctx context.Context) Report {
+	s.mu.RLock()
+	checks := make([]namedCheck, len(s.checks))
+	copy(checks, s.checks)
+	s.mu.RUnlock()
+
+	report := Report{
+		Status: StatusReady,
+		Checks: make(map[string]CheckResult, len(checks)),
+	}
+
+	if s.ShuttingDown() {
+		report.Status = StatusShuttingDown
+		return report
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	results := make([]CheckResult, len(checks))
+
+	var wg sync.WaitGroup
+	for idx := range checks {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			results[idx] = run(ctx, checks[idx].check)
+		}(idx)
+	}
+	wg.Wait()
+
+	for idx := range checks {
+		report.Checks[checks[idx].name] = results[idx]
+		switch {
+		case results[idx].Status == StatusDegraded && report.Status == StatusReady:
+			report.Status = StatusReadyDegraded
+		case results[idx].Status != StatusOK && results[idx].Status != StatusDegraded:
+			report.Status = StatusNotReady
+		}
+	}
+
+	return report
+}
+
+// run measures the check and fails it once the deadline passes even if the check hangs
+func run(ctx context.Context, check Check) CheckResult {
+	start := time.Now()
+	done := make(chan CheckResult, 1)
+
+	go func() {
+		done <- check(ctx)
+	}()
+
+	var result CheckResult
+	select {
+	case result = <-done:
+	case <-ctx.Done():
+		result = CheckResult{Status: StatusFail, Error: ctx.Err().Error()}
+	}
+
+	if result.Status == "" {
+		result.Status = StatusOK
+	}
+	result.LatencyMS = float64(time.Since(start).Microseconds()) / 1000
+
+	return result
+}
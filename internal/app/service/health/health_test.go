@@ -0,0 +1,158 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type pingerFunc func(ctx context.Context) error
+
+func (f pingerFunc) Ping(ctx context.Context) error {
+	return f(ctx)
+}
+
+func TestService_Ready(t *testing.T) {
+	ok := func(_ context.Context) CheckResult { return CheckResult{} }
+	failing := DatabaseCheck(pingerFunc(func(_ context.Context) error { return errors.New("connection refused") }))
+	degraded := DatabaseCheck(pingerFunc(func(_ context.Context) error { return errors.New("connection refused") }),
+		func() bool { return true })
+	hanging := func(_ context.Context) CheckResult {
+		time.Sleep(time.Second)
+		return CheckResult{Status: StatusOK}
+	}
+
+	tests := []struct {
+		name     string
+		checks   map[string]Check
+		status   string
+		statuses map[string]string
+	}{
+		{
+			name:   "no checks",
+			checks: map[string]Check{},
+			status: StatusReady,
+		},
+		{
+			name:     "all pass",
+			checks:   map[string]Check{"database": ok, "storage": ok},
+			status:   StatusReady,
+			statuses: map[string]string{"database": StatusOK, "storage": StatusOK},
+		},
+		{
+			name:     "one fails",
+			checks:   map[string]Check{"database": failing, "storage": ok},
+			status:   StatusNotReady,
+			statuses: map[string]string{"database": StatusFail, "storage": StatusOK},
+		},
+		{
+			name:     "one degraded",
+			checks:   map[string]Check{"database": degraded, "storage": ok},
+			status:   StatusReadyDegraded,
+			statuses: map[string]string{"database": StatusDegraded, "storage": StatusOK},
+		},
+		{
+			name:     "degraded and failing",
+			checks:   map[string]Check{"database": degraded, "storage": failing},
+			status:   StatusNotReady,
+			statuses: map[string]string{"database": StatusDegraded, "storage": StatusFail},
+		},
+		{
+			name:     "check exceeds timeout",
+			checks:   map[string]Check{"queue": hanging},
+			status:   StatusNotReady,
+			statuses: map[string]string{"queue": StatusFail},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewService(50 * time.Millisecond)
+			for name, check := range tt.checks {
+				s.Register(name, check)
+			}
+
+			report := s.Ready(context.Background())
+			assert.Equal(t, tt.status, report.Status)
+			assert.Len(t, report.Checks, len(tt.statuses))
+			for name, status := range tt.statuses {
+				assert.Equal(t, status, report.Checks[name].Status, name)
+			}
+		})
+	}
+}
+
+func TestService_ShuttingDown(t *testing.T) {
+	s := NewService(time.Second)
+	s.Register("database", func(_ context.Context) CheckResult { return CheckResult{Status: StatusOK} })
+	require.True(t, s.Ready(context.Background()).Ready())
+
+	s.SetShuttingDown()
+
+	report := s.Ready(context.Background())
+	assert.False(t, report.Ready())
+	assert.Equal(t, StatusShuttingDown, report.Status)
+	assert.Empty(t, report.Checks)
+}
+
+func TestDatabaseCheck(t *testing.T) {
+	result := DatabaseCheck(pingerFunc(func(_ context.Context) error { return nil }))(context.Background())
+	assert.Equal(t, StatusOK, result.Status)
+
+	result = DatabaseCheck(pingerFunc(func(_ context.Context) error {
+		return errors.New("dial tcp db.internal:5432: connection refused")
+	}))(context.Background())
+	assert.Equal(t, StatusFail, result.Status)
+	assert.Equal(t, "unavailable", result.Error)
+
+	closed, open := func() bool { return false }, func() bool { return true }
+	result = DatabaseCheck(pingerFunc(func(_ context.Context) error {
+		return errors.New("dial tcp db.internal:5432: connection refused")
+	}), closed, open)(context.Background())
+	assert.Equal(t, StatusDegraded, result.Status)
+	assert.Equal(t, 1, result.Details["open_circuits"])
+}
+
+func TestFileStorageCheck(t *testing.T) {
+	dir := t.TempDir()
+
+	result := FileStorageCheck(filepath.Join(dir, "storage.dat"), 0)(context.Background())
+	assert.Equal(t, StatusOK, result.Status)
+	assert.Contains(t, result.Details, "free_bytes")
+
+	result = FileStorageCheck(filepath.Join(dir, "storage.dat"), ^uint64(0))(context.Background())
+	assert.Equal(t, StatusFail, result.Status)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "temporary probe file must be removed")
+
+	result = FileStorageCheck(filepath.Join(dir, "missing", "storage.dat"), 0)(context.Background())
+	assert.Equal(t, StatusFail, result.Status)
+}
+
+func TestQueueCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		length   int
+		capacity int
+		status   string
+	}{
+		{name: "empty", length: 0, capacity: 100, status: StatusOK},
+		{name: "busy", length: 50, capacity: 100, status: StatusOK},
+		{name: "almost full", length: 95, capacity: 100, status: StatusFail},
+		{name: "no capacity", length: 0, capacity: 0, status: StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := QueueCheck(func() int { return tt.length }, func() int { return tt.capacity })(context.Background())
+			assert.Equal(t, tt.status, result.Status)
+			assert.Equal(t, tt.length, result.Details["length"])
+		})
+	}
+}
@@ -0,0 +1,265 @@
+package maintenance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ChristinaFomenko/shortener/internal/app/models"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	"github.com/asaskevich/govalidator"
+	log "github.com/sirupsen/logrus"
+)
+
+type urlRepository interface {
+	Get(ctx context.Context, urlID string) (models.Link, error)
+	GetLink(ctx context.Context, urlID string) (models.Link, error)
+	FindByURL(ctx context.Context, url string) (models.Link, error)
+	Delete(ctx context.Context, urlID string) error
+	Restore(ctx context.Context, urlID string) error
+	Iterate(ctx context.Context, afterID string, fn func(link models.Link) error) error
+	Import(ctx context.Context, link models.Link) error
+}
+
+// flusher is a repository buffering imported links
+type flusher interface {
+	Flush() error
+}
+
+// Stats holds link counters of a storage
+type Stats struct {
+	Links   int
+	Active  int
+	Deleted int
+	Users   int
+}
+
+// Problem is an integrity violation found by Verify
+type Problem struct {
+	URLID       string
+	Description string
+}
+
+// Normalization is the outcome of Normalize, links left as they were are listed as problems
+type Normalization struct {
+	Normalized int
+	Problems   []Problem
+}
+
+type service struct {
+	repository urlRepository
+}
+
+func NewService(repository urlRepository) *service {
+	return &service{
+		repository: repository,
+	}
+}
+
+func (s *service) Lookup(ctx context.Context, urlID string) (models.Link, error) {
+	link, err := s.repository.GetLink(ctx, urlID)
+	if err != nil && !errors.Is(err, errs.ErrURLNotFound) {
+		log.WithError(err).WithField("urlID", urlID).Error("get link error")
+	}
+
+	return link, err
+}
+
+func (s *service) LookupByURL(ctx context.Context, url string) (models.Link, error) {
+	link, err := s.repository.FindByURL(ctx, url)
+	if err != nil && !errors.Is(err, errs.ErrURLNotFound) {
+		log.WithError(err).WithField("url", url).Error("find link error")
+	}
+
+	return link, err
+}
+
+// UserLinks returns all links of the user, deleted ones included
+func (s *service) UserLinks(ctx context.Context, userID string) ([]models.Link, error) {
+	links := make([]models.Link, 0)
+
+	err := s.repository.Iterate(ctx, "", func(link models.Link) error {
+		if link.UserID == userID {
+			links = append(links, link)
+		}
+		return nil
+	})
+	if err != nil {
+		log.WithError(err).WithField("userID", userID).Error("list user links error")
+		return nil, err
+	}
+
+	return links, nil
+}
+
+func (s *service) Delete(ctx context.Context, urlID string) error {
+	if err := s.repository.Delete(ctx, urlID); err != nil {
+		log.WithError(err).WithField("urlID", urlID).Error("delete link error")
+		return err
+	}
+
+	return nil
+}
+
+func (s *service) Restore(ctx context.Context, urlID string) error {
+	if err := s.repository.Restore(ctx, urlID); err != nil {
+		log.WithError(err).WithField("urlID", urlID).Error("restore link error")
+		return err
+	}
+
+	return nil
+}
+
+func (s *service) Stats(ctx context.Context) (Stats, error) {
+	var stats Stats
+	users := map[string]struct{}{}
+
+	err := s.repository.Iterate(ctx, "", func(link models.Link) error {
+		stats.Links++
+		if link.Deleted() {
+			stats.Deleted++
+		} else {
+			stats.Active++
+		}
+		users[link.UserID] = struct{}{}
+		return nil
+	})
+	if err != nil {
+		log.WithError(err).Error("count links error")
+		return Stats{}, err
+	}
+
+	stats.Users = len(users)
+
+	return stats, nil
+}
+
+// Verify walks the whole storage and reports links that break its invariants
+func (s *service) Verify(ctx context.Context) ([]Problem, error) {
+	problems := make([]Problem, 0)
+	report := func(urlID, format string, args ...interface{}) {
+		problems = append(problems, Problem{URLID: urlID, Description: fmt.Sprintf(format, args...)})
+	}
+
+	ids := map[string]struct{}{}
+	urls := map[string]string{}
+
+	err := s.repository.Iterate(ctx, "", func(link models.Link) error {
+		switch {
+		case link.ID == "":
+			report(link.ID, "empty id for url %q", link.OriginalURL)
+		case link.UserID == "":
+			report(link.ID, "empty owner")
+		}
+
+		if _, ok := ids[link.ID]; ok {
+			report(link.ID, "duplicate id")
+		}
+		ids[link.ID] = struct{}{}
+
+		if !govalidator.IsURL(link.OriginalURL) {
+			report(link.ID, "original url %q is not valid", link.OriginalURL)
+		}
+
+		if !models.ValidRedirectCode(link.RedirectCode) {
+			report(link.ID, "redirect code %d is not supported", link.RedirectCode)
+		}
+
+		if urlID, ok := urls[link.OriginalURL]; ok {
+			report(link.ID, "original url %q is already shortened as %s", link.OriginalURL, urlID)
+		}
+		urls[link.OriginalURL] = link.ID
+
+		return s.verifyLookup(ctx, link, report)
+	})
+	if err != nil {
+		log.WithError(err).Error("verify storage error")
+		return nil, err
+	}
+
+	return problems, nil
+}
+
+// verifyLookup checks that the link is reachable the way the server reads it
+func (s *service) verifyLookup(ctx context.Context, link models.Link, report func(urlID, format string, args ...interface{})) error {
+	if link.ID == "" {
+		return nil
+	}
+
+	stored, err := s.repository.GetLink(ctx, link.ID)
+	switch {
+	case errors.Is(err, errs.ErrURLNotFound):
+		report(link.ID, "link is listed but not found by id")
+		return nil
+	case err != nil:
+		return err
+	case stored.OriginalURL != link.OriginalURL:
+		report(link.ID, "lookup by id returns %q instead of %q", stored.OriginalURL, link.OriginalURL)
+	}
+
+	active, err := s.repository.Get(ctx, link.ID)
+	switch {
+	case errors.Is(err, errs.ErrURLNotFound):
+		if !link.Deleted() {
+			report(link.ID, "active link does not redirect")
+		}
+	case err != nil:
+		return err
+	case link.Deleted():
+		report(link.ID, "deleted link still redirects to %q", active.OriginalURL)
+	}
+
+	return nil
+}
+
+// Normalize stores the canonical url of every link whose canonical url differs from the normalized original
+// one, links stored before urls were normalized have the original url as their canonical one.
+// When the canonical url is taken by another link, the link holding it keeps it and later shortenings of
+// the url get it. The link normalized too late keeps redirecting and is reported.
+func (s *service) Normalize(ctx context.Context, normalize func(url string) (string, error)) (Normalization, error) {
+	result := Normalization{Problems: make([]Problem, 0)}
+	report := func(urlID, format string, args ...interface{}) {
+		result.Problems = append(result.Problems, Problem{URLID: urlID, Description: fmt.Sprintf(format, args...)})
+	}
+
+	// the links are changed once the walk is over, some storages can't write while reading
+	changed := make([]models.Link, 0)
+	err := s.repository.Iterate(ctx, "", func(link models.Link) error {
+		normalizedURL, err := normalize(link.OriginalURL)
+		switch {
+		case err != nil:
+			report(link.ID, "original url %q can't be normalized: %v", link.OriginalURL, err)
+		case normalizedURL != link.Canonical():
+			link.NormalizedURL = normalizedURL
+			changed = append(changed, link)
+		}
+		return nil
+	})
+	if err != nil {
+		log.WithError(err).Error("normalize links error")
+		return result, err
+	}
+
+	for _, link := range changed {
+		err = s.repository.Import(ctx, link)
+
+		var notUniqueErr *errs.NotUniqueURLErr
+		switch {
+		case errors.As(err, &notUniqueErr):
+			report(link.ID, "canonical url %q is already shortened as %s", link.NormalizedURL, notUniqueErr.URLID)
+		case err != nil:
+			log.WithError(err).WithField("urlID", link.ID).Error("normalize link error")
+			return result, err
+		default:
+			result.Normalized++
+		}
+	}
+
+	if flusher, ok := s.repository.(flusher); ok {
+		if err = flusher.Flush(); err != nil {
+			log.WithError(err).Error("flush normalized links error")
+			return result, err
+		}
+	}
+
+	return result, nil
+}
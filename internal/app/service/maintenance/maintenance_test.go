@@ -0,0 +1,107 @@
+package maintenance
+
+import (
+	"context"
+	"github.com/ChristinaFomenko/shortener/internal/app/models"
+	"github.com/ChristinaFomenko/shortener/internal/app/normalizer"
+	"github.com/ChristinaFomenko/shortener/internal/app/repository/urls/memory"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestService_DeleteRestore(t *testing.T) {
+	ctx := context.Background()
+
+	repo := memory.NewRepo()
+	require.NoError(t, repo.Add(ctx, models.Link{ID: "abcde", OriginalURL: "https://yandex.ru", UserID: "user"}))
+
+	s := NewService(repo)
+
+	require.NoError(t, s.Delete(ctx, "abcde"))
+
+	_, err := repo.Get(ctx, "abcde")
+	assert.ErrorIs(t, err, errs.ErrURLNotFound)
+
+	link, err := s.Lookup(ctx, "abcde")
+	require.NoError(t, err)
+	assert.True(t, link.Deleted())
+	assert.Equal(t, "user", link.UserID)
+
+	require.NoError(t, s.Restore(ctx, "abcde"))
+
+	active, err := repo.Get(ctx, "abcde")
+	require.NoError(t, err)
+	assert.Equal(t, "https://yandex.ru", active.OriginalURL)
+
+	assert.ErrorIs(t, s.Delete(ctx, "qwerty"), errs.ErrURLNotFound)
+}
+
+func TestService_Stats(t *testing.T) {
+	ctx := context.Background()
+
+	repo := memory.NewRepo()
+	require.NoError(t, repo.Add(ctx, models.Link{ID: "abcde", OriginalURL: "https://yandex.ru", UserID: "user"}))
+	require.NoError(t, repo.Add(ctx, models.Link{ID: "qwert", OriginalURL: "https://github.com", UserID: "user"}))
+	require.NoError(t, repo.Add(ctx, models.Link{ID: "zxcvb", OriginalURL: "https://google.com", UserID: "other"}))
+	require.NoError(t, repo.Delete(ctx, "qwert"))
+
+	s := NewService(repo)
+
+	stats, err := s.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, Stats{Links: 3, Active: 2, Deleted: 1, Users: 2}, stats)
+
+	links, err := s.UserLinks(ctx, "user")
+	require.NoError(t, err)
+	assert.Len(t, links, 2)
+}
+
+func TestService_Verify(t *testing.T) {
+	ctx := context.Background()
+
+	repo := memory.NewRepo()
+	require.NoError(t, repo.Add(ctx, models.Link{ID: "abcde", OriginalURL: "https://yandex.ru", UserID: "user"}))
+	require.NoError(t, repo.Add(ctx, models.Link{ID: "qwert", OriginalURL: "not a url", UserID: "user"}))
+
+	s := NewService(repo)
+
+	problems, err := s.Verify(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []Problem{{URLID: "qwert", Description: "original url \"not a url\" is not valid"}}, problems)
+}
+
+func TestService_Normalize(t *testing.T) {
+	ctx := context.Background()
+
+	// links stored before urls were normalized
+	repo := memory.NewRepo()
+	require.NoError(t, repo.Add(ctx, models.Link{ID: "abcde", OriginalURL: "https://YANDEX.ru/?utm_source=mail", UserID: "user"}))
+	require.NoError(t, repo.Add(ctx, models.Link{ID: "qwert", OriginalURL: "https://yandex.ru/?utm_source=news", UserID: "other"}))
+	require.NoError(t, repo.Add(ctx, models.Link{ID: "zxcvb", OriginalURL: "https://google.com", UserID: "user"}))
+
+	s := NewService(repo)
+	n := normalizer.NewNormalizer(normalizer.DefaultTrackingParams)
+
+	result, err := s.Normalize(ctx, n.Normalize)
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.Normalized)
+	assert.Equal(t, []Problem{{URLID: "qwert", Description: "canonical url \"https://yandex.ru/\" is already shortened as abcde"}}, result.Problems)
+
+	normalizedURL, err := n.Normalize("https://yandex.ru")
+	require.NoError(t, err)
+	link, err := repo.FindByURL(ctx, normalizedURL)
+	require.NoError(t, err)
+	assert.Equal(t, "abcde", link.ID)
+
+	// the duplicate keeps redirecting
+	link, err = repo.Get(ctx, "qwert")
+	require.NoError(t, err)
+	assert.Equal(t, "https://yandex.ru/?utm_source=news", link.OriginalURL)
+
+	result, err = s.Normalize(ctx, n.Normalize)
+	require.NoError(t, err)
+	assert.Zero(t, result.Normalized)
+	assert.Len(t, result.Problems, 1)
+}
@@ -0,0 +1,63 @@
+package migration
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type fileCheckpoint struct {
+	path string
+}
+
+// NewFileCheckpoint keeps the id of the last migrated link in a file, an empty path disables it
+func NewFileCheckpoint(path string) *fileCheckpoint {
+	return &fileCheckpoint{
+		path: path,
+	}
+}
+
+func (c *fileCheckpoint) Load() (string, error) {
+	if c.path == "" {
+		return "", nil
+	}
+
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Save replaces the checkpoint atomically so an interruption never leaves it half written
+func (c *fileCheckpoint) Save(urlID string) error {
+	if c.path == "" {
+		return nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*")
+	if err != nil {
+		return fmt.Errorf("create checkpoint error: %w", err)
+	}
+
+	defer func(name string) {
+		_ = os.Remove(name)
+	}(tmp.Name())
+
+	if _, err = tmp.WriteString(urlID + "\n"); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("write checkpoint error: %w", err)
+	}
+
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("write checkpoint error: %w", err)
+	}
+
+	return os.Rename(tmp.Name(), c.path)
+}
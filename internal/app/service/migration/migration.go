@@ -0,0 +1,203 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ChristinaFomenko/shortener/internal/app/models"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"strings"
+)
+
+const (
+	defaultCheckpointEvery = 100
+	// mismatched ids named in the verify error
+	maxListedMismatches = 10
+)
+
+var (
+	ErrCountMismatch = errors.New("link counts of source and destination differ")
+	ErrLinkMismatch  = errors.New("links of source and destination differ")
+)
+
+type source interface {
+	Iterate(ctx context.Context, afterID string, fn func(link models.Link) error) error
+}
+
+type destination interface {
+	Import(ctx context.Context, link models.Link) error
+	Iterate(ctx context.Context, afterID string, fn func(link models.Link) error) error
+	GetLink(ctx context.Context, urlID string) (models.Link, error)
+}
+
+// flusher is a destination buffering imported links
+type flusher interface {
+	Flush() error
+}
+
+type checkpoint interface {
+	Load() (string, error)
+	Save(urlID string) error
+}
+
+// Counts holds link counters of one side of a migration
+type Counts struct {
+	Links   int
+	Deleted int
+}
+
+// Report describes a finished migration
+type Report struct {
+	ResumedAfter string
+	Migrated     int
+	Source       Counts
+	Destination  Counts
+}
+
+type service struct {
+	source          source
+	destination     destination
+	checkpoint      checkpoint
+	checkpointEvery int
+}
+
+func NewService(source source, destination destination, checkpoint checkpoint, checkpointEvery int) *service {
+	if checkpointEvery <= 0 {
+		checkpointEvery = defaultCheckpointEvery
+	}
+
+	return &service{
+		source:          source,
+		destination:     destination,
+		checkpoint:      checkpoint,
+		checkpointEvery: checkpointEvery,
+	}
+}
+
+// Migrate copies every link with its owner, ids and state from the source into the destination.
+// Links come in ascending id order, so after an interruption the migration resumes after the last
+// saved checkpoint; links copied after it are imported again, which is a no-op.
+func (s *service) Migrate(ctx context.Context) (Report, error) {
+	resumeAfter, err := s.checkpoint.Load()
+	if err != nil {
+		return Report{}, fmt.Errorf("load checkpoint error: %w", err)
+	}
+
+	report := Report{ResumedAfter: resumeAfter}
+	lastID := resumeAfter
+
+	err = s.source.Iterate(ctx, resumeAfter, func(link models.Link) error {
+		if err := s.destination.Import(ctx, link); err != nil {
+			return fmt.Errorf("import link %s error: %w", link.ID, err)
+		}
+
+		report.Migrated++
+		lastID = link.ID
+
+		if report.Migrated%s.checkpointEvery == 0 {
+			log.WithField("migrated", report.Migrated).WithField("lastID", lastID).Info("migration checkpoint")
+			return s.save(lastID)
+		}
+
+		return nil
+	})
+	if err != nil {
+		if saveErr := s.save(lastID); saveErr != nil {
+			log.WithError(saveErr).WithField("lastID", lastID).Error("save checkpoint error")
+		}
+		return report, err
+	}
+
+	if err = s.save(lastID); err != nil {
+		return report, fmt.Errorf("save checkpoint error: %w", err)
+	}
+
+	report.Source, report.Destination, err = s.Verify(ctx)
+
+	return report, err
+}
+
+// save makes the links imported so far durable, then moves the checkpoint past them
+func (s *service) save(lastID string) error {
+	if flusher, ok := s.destination.(flusher); ok {
+		if err := flusher.Flush(); err != nil {
+			return fmt.Errorf("flush destination error: %w", err)
+		}
+	}
+
+	return s.checkpoint.Save(lastID)
+}
+
+// Verify looks up every source link in the destination by id and compares what was migrated,
+// then compares link counters of both sides
+func (s *service) Verify(ctx context.Context) (Counts, Counts, error) {
+	var (
+		src        Counts
+		mismatched []string
+	)
+
+	err := s.source.Iterate(ctx, "", func(link models.Link) error {
+		src.Links++
+		if link.Deleted() {
+			src.Deleted++
+		}
+
+		migrated, err := s.destination.GetLink(ctx, link.ID)
+		switch {
+		case errors.Is(err, errs.ErrURLNotFound):
+			mismatched = append(mismatched, link.ID)
+		case err != nil:
+			return fmt.Errorf("get destination link %s error: %w", link.ID, err)
+		case !same(link, migrated):
+			mismatched = append(mismatched, link.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return Counts{}, Counts{}, fmt.Errorf("verify source links error: %w", err)
+	}
+
+	dst, err := count(ctx, s.destination)
+	if err != nil {
+		return src, Counts{}, fmt.Errorf("count destination links error: %w", err)
+	}
+
+	if len(mismatched) > 0 {
+		listed := mismatched
+		if len(listed) > maxListedMismatches {
+			listed = listed[:maxListedMismatches]
+		}
+		return src, dst, fmt.Errorf("%w: %d links missing or changed, e.g. %s", ErrLinkMismatch, len(mismatched), strings.Join(listed, ", "))
+	}
+
+	if src != dst {
+		return src, dst, fmt.Errorf("%w: source %+v, destination %+v", ErrCountMismatch, src, dst)
+	}
+
+	return src, dst, nil
+}
+
+// same tells whether the migrated link kept the urls, owner, state and settings of the source one,
+// timestamps are compared by state only as backends store them with different precision
+func same(link, migrated models.Link) bool {
+	return link.OriginalURL == migrated.OriginalURL &&
+		link.Canonical() == migrated.Canonical() &&
+		link.UserID == migrated.UserID &&
+		link.Deleted() == migrated.Deleted() &&
+		link.Options().Same(migrated.Options())
+}
+
+func count(ctx context.Context, repo source) (Counts, error) {
+	var counts Counts
+
+	err := repo.Iterate(ctx, "", func(link models.Link) error {
+		counts.Links++
+		if link.Deleted() {
+			counts.Deleted++
+		}
+		return nil
+	})
+
+	return counts, err
+}
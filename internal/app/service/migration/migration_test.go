@@ -0,0 +1,206 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ChristinaFomenko/shortener/internal/app/models"
+	"github.com/ChristinaFomenko/shortener/internal/app/repository/urls/file"
+	"github.com/ChristinaFomenko/shortener/internal/app/repository/urls/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"testing"
+)
+
+var errInterrupted = errors.New("interrupted")
+
+// flakyDestination fails once after the given number of imports
+type flakyDestination struct {
+	destination
+	failAfter int
+	imported  int
+}
+
+func (d *flakyDestination) Import(ctx context.Context, link models.Link) error {
+	if d.imported == d.failAfter {
+		d.failAfter = -1
+		return errInterrupted
+	}
+	d.imported++
+
+	return d.destination.Import(ctx, link)
+}
+
+func (d *flakyDestination) Flush() error {
+	if flusher, ok := d.destination.(flusher); ok {
+		return flusher.Flush()
+	}
+
+	return nil
+}
+
+func TestService_Migrate(t *testing.T) {
+	ctx := context.Background()
+
+	src := memory.NewRepo()
+	for i := 0; i < 10; i++ {
+		require.NoError(t, src.Add(ctx, models.Link{ID: fmt.Sprintf("id%02d", i), OriginalURL: fmt.Sprintf("https://example.com/%d", i), UserID: "user"}))
+	}
+	require.NoError(t, src.Delete(ctx, "id03"))
+
+	dst := memory.NewRepo()
+	s := NewService(src, dst, NewFileCheckpoint(""), 0)
+
+	report, err := s.Migrate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 10, report.Migrated)
+	assert.Equal(t, Counts{Links: 10, Deleted: 1}, report.Destination)
+
+	link, err := dst.GetLink(ctx, "id03")
+	require.NoError(t, err)
+	assert.True(t, link.Deleted())
+	assert.Equal(t, "user", link.UserID)
+	assert.Equal(t, "https://example.com/3", link.OriginalURL)
+}
+
+func TestService_Migrate_Resume(t *testing.T) {
+	ctx := context.Background()
+
+	src := memory.NewRepo()
+	for i := 0; i < 10; i++ {
+		require.NoError(t, src.Add(ctx, models.Link{ID: fmt.Sprintf("id%02d", i), OriginalURL: fmt.Sprintf("https://example.com/%d", i), UserID: "user"}))
+	}
+
+	dst := &flakyDestination{destination: memory.NewRepo(), failAfter: 7}
+	checkpoint := NewFileCheckpoint(filepath.Join(t.TempDir(), "checkpoint"))
+
+	_, err := NewService(src, dst, checkpoint, 5).Migrate(ctx)
+	require.ErrorIs(t, err, errInterrupted)
+
+	lastID, err := checkpoint.Load()
+	require.NoError(t, err)
+	assert.Equal(t, "id06", lastID)
+
+	report, err := NewService(src, dst, checkpoint, 5).Migrate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "id06", report.ResumedAfter)
+	assert.Equal(t, 3, report.Migrated)
+	assert.Equal(t, Counts{Links: 10}, report.Destination)
+}
+
+func TestService_Migrate_Conflict(t *testing.T) {
+	ctx := context.Background()
+
+	src := memory.NewRepo()
+	require.NoError(t, src.Add(ctx, models.Link{ID: "abcde", OriginalURL: "https://yandex.ru", UserID: "user"}))
+
+	dst := memory.NewRepo()
+	require.NoError(t, dst.Add(ctx, models.Link{ID: "qwert", OriginalURL: "https://yandex.ru", UserID: "user"}))
+
+	_, err := NewService(src, dst, NewFileCheckpoint(""), 0).Migrate(ctx)
+	assert.Error(t, err)
+}
+
+func TestService_Migrate_FlushesBeforeCheckpoint(t *testing.T) {
+	ctx := context.Background()
+
+	src := memory.NewRepo()
+	for i := 0; i < 10; i++ {
+		require.NoError(t, src.Add(ctx, models.Link{ID: fmt.Sprintf("id%02d", i), OriginalURL: fmt.Sprintf("https://example.com/%d", i), UserID: "user"}))
+	}
+
+	dir := t.TempDir()
+	dstPath := filepath.Join(dir, "urls.dat")
+	dst, err := file.NewRepo(dstPath)
+	require.NoError(t, err)
+
+	checkpoint := NewFileCheckpoint(filepath.Join(dir, "checkpoint"))
+	_, err = NewService(src, &flakyDestination{destination: dst, failAfter: 7}, checkpoint, 5).Migrate(ctx)
+	require.ErrorIs(t, err, errInterrupted)
+
+	// the process dies without closing the destination
+	reopened, err := file.NewRepo(dstPath)
+	require.NoError(t, err)
+
+	lastID, err := checkpoint.Load()
+	require.NoError(t, err)
+
+	err = src.Iterate(ctx, "", func(link models.Link) error {
+		if link.ID <= lastID {
+			_, err := reopened.GetLink(ctx, link.ID)
+			assert.NoError(t, err, link.ID)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestService_Verify(t *testing.T) {
+	ctx := context.Background()
+
+	links := []models.Link{
+		{ID: "abcde", OriginalURL: "https://yandex.ru", UserID: "user"},
+		{ID: "fghij", OriginalURL: "https://google.com", UserID: "user", RedirectCode: 301},
+	}
+
+	tests := []struct {
+		name        string
+		destination []models.Link
+		err         error
+	}{
+		{
+			name:        "same links",
+			destination: links,
+		},
+		{
+			name:        "missing link",
+			destination: links[:1],
+			err:         ErrLinkMismatch,
+		},
+		{
+			name: "changed settings",
+			destination: []models.Link{
+				links[0],
+				{ID: "fghij", OriginalURL: "https://google.com", UserID: "user", RedirectCode: 302},
+			},
+			err: ErrLinkMismatch,
+		},
+		{
+			name: "changed owner",
+			destination: []models.Link{
+				links[0],
+				{ID: "fghij", OriginalURL: "https://google.com", UserID: "other", RedirectCode: 301},
+			},
+			err: ErrLinkMismatch,
+		},
+		{
+			name: "extra link",
+			destination: append([]models.Link{
+				{ID: "klmno", OriginalURL: "https://ya.ru", UserID: "user"},
+			}, links...),
+			err: ErrCountMismatch,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := memory.NewRepo()
+			for _, link := range links {
+				require.NoError(t, src.Import(ctx, link))
+			}
+
+			dst := memory.NewRepo()
+			for _, link := range tt.destination {
+				require.NoError(t, dst.Import(ctx, link))
+			}
+
+			srcCounts, _, err := NewService(src, dst, NewFileCheckpoint(""), 0).Verify(ctx)
+			assert.ErrorIs(t, err, tt.err)
+			if tt.err == nil {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, Counts{Links: 2}, srcCounts)
+		})
+	}
+}
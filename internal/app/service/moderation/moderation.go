@@ -0,0 +1,82 @@
+package moderation
+
+import (
+	"context"
+	"errors"
+	"github.com/ChristinaFomenko/shortener/internal/app/models"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+type urlRepository interface {
+	Delete(ctx context.Context, urlID string) error
+	Iterate(ctx context.Context, afterID string, fn func(link models.Link) error) error
+}
+
+type blocklist interface {
+	Check(rawURL, normalizedURL string) error
+}
+
+// DisabledLink is an existing link matching a blocklist rule
+type DisabledLink struct {
+	URLID       string
+	OriginalURL string
+	Reason      string
+}
+
+type Report struct {
+	Checked  int
+	Disabled []DisabledLink
+}
+
+type service struct {
+	repository urlRepository
+	blocklist  blocklist
+}
+
+func NewService(repository urlRepository, blocklist blocklist) *service {
+	return &service{
+		repository: repository,
+		blocklist:  blocklist,
+	}
+}
+
+// DisableBlocked deletes active links matching the current blocklist, so rules added later
+// apply to links shortened before them
+func (s *service) DisableBlocked(ctx context.Context) (Report, error) {
+	report := Report{Disabled: make([]DisabledLink, 0)}
+
+	err := s.repository.Iterate(ctx, "", func(link models.Link) error {
+		if link.Deleted() {
+			return nil
+		}
+		report.Checked++
+
+		var blockedErr *errs.BlockedURLErr
+		if err := s.blocklist.Check(link.OriginalURL, link.Canonical()); errors.As(err, &blockedErr) {
+			report.Disabled = append(report.Disabled, DisabledLink{
+				URLID:       link.ID,
+				OriginalURL: link.OriginalURL,
+				Reason:      blockedErr.Reason,
+			})
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.WithError(err).Error("iterate links error")
+		return report, err
+	}
+
+	// links are deleted once the iteration is over, so no backend has to write while reading
+	for _, link := range report.Disabled {
+		if err = s.repository.Delete(ctx, link.URLID); err != nil && !errors.Is(err, errs.ErrURLNotFound) {
+			log.WithError(err).WithField("urlID", link.URLID).Error("disable blocked link error")
+			return report, err
+		}
+
+		log.WithField("urlID", link.URLID).WithField("reason", link.Reason).Info("blocked link disabled")
+	}
+
+	return report, nil
+}
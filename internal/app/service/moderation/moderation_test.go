@@ -0,0 +1,48 @@
+package moderation
+
+import (
+	"context"
+	blocklistRules "github.com/ChristinaFomenko/shortener/internal/app/blocklist"
+	"github.com/ChristinaFomenko/shortener/internal/app/models"
+	"github.com/ChristinaFomenko/shortener/internal/app/repository/urls/memory"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestService_DisableBlocked(t *testing.T) {
+	ctx := context.Background()
+
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	require.NoError(t, os.WriteFile(path, []byte("domain:phishing.example\n"), 0600))
+
+	rules, err := blocklistRules.NewBlocklist(path, time.Minute, "http://localhost:8080")
+	require.NoError(t, err)
+
+	repo := memory.NewRepo()
+	require.NoError(t, repo.Add(ctx, models.Link{ID: "abcde", OriginalURL: "https://yandex.ru", NormalizedURL: "https://yandex.ru/", UserID: "user"}))
+	require.NoError(t, repo.Add(ctx, models.Link{ID: "qwert", OriginalURL: "https://login.phishing.example/bank", NormalizedURL: "https://login.phishing.example/bank", UserID: "user"}))
+	require.NoError(t, repo.Add(ctx, models.Link{ID: "zxcvb", OriginalURL: "https://phishing.example", UserID: "other"}))
+	require.NoError(t, repo.Add(ctx, models.Link{ID: "poiuy", OriginalURL: "https://phishing.example/old", UserID: "other"}))
+	require.NoError(t, repo.Delete(ctx, "poiuy"))
+
+	report, err := NewService(repo, rules).DisableBlocked(ctx)
+	require.NoError(t, err)
+
+	assert.Equal(t, 3, report.Checked)
+	assert.Equal(t, []DisabledLink{
+		{URLID: "qwert", OriginalURL: "https://login.phishing.example/bank", Reason: "domain phishing.example is blocked"},
+		{URLID: "zxcvb", OriginalURL: "https://phishing.example", Reason: "domain phishing.example is blocked"},
+	}, report.Disabled)
+
+	_, err = repo.Get(ctx, "qwert")
+	assert.ErrorIs(t, err, errs.ErrURLNotFound)
+
+	link, err := repo.Get(ctx, "abcde")
+	require.NoError(t, err)
+	assert.Equal(t, "https://yandex.ru", link.OriginalURL)
+}
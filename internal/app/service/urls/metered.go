@@ -0,0 +1,79 @@
+package urls
+
+import (
+	"context"
+	"github.com/ChristinaFomenko/shortener/internal/app/models"
+	"github.com/ChristinaFomenko/shortener/pkg/metrics"
+	"strconv"
+	"sync"
+)
+
+const (
+	visitorNew       = "new"
+	visitorReturning = "returning"
+
+	// maxVariantLinks bounds the split links labelled by id, the visits of later ones are counted under otherLink
+	maxVariantLinks = 100
+	otherLink       = "other"
+)
+
+type urlService interface {
+	Shorten(ctx context.Context, url string, userID string, options models.LinkOptions) (string, error)
+	Expand(ctx context.Context, id string, visit models.Visit) (models.Redirect, error)
+	FetchURLs(ctx context.Context, userID string) ([]models.UserURL, error)
+	ExportURLs(ctx context.Context, userID string, fn func(url models.UserURL) error) error
+	ShortenBatch(ctx context.Context, originalURLs []models.OriginalURL, userID string) ([]models.UserURL, error)
+	Rules(ctx context.Context, urlID, userID string) ([]models.TargetRule, error)
+	SetRules(ctx context.Context, urlID, userID string, rules []models.TargetRule) error
+}
+
+type meteredService struct {
+	urlService
+	variants *metrics.CounterVec
+
+	mu    sync.Mutex
+	links map[string]struct{}
+}
+
+// NewMeteredService counts the visits of split links by link, position of the variant each visitor got
+// and whether a sticky visitor returned. The first maxVariantLinks split links visited get a series of their own,
+// the rest share otherLink; variant names are left out of the labels as there is no end to them.
+func NewMeteredService(service urlService, registry *metrics.Registry) *meteredService {
+	return &meteredService{
+		urlService: service,
+		variants: registry.NewCounterVec("shortener_variant_visits_total",
+			"Visits of split links, by link, position of the variant and whether a sticky visitor returned to it.",
+			"link", "variant", "visitor"),
+		links: map[string]struct{}{},
+	}
+}
+
+func (s *meteredService) Expand(ctx context.Context, id string, visit models.Visit) (models.Redirect, error) {
+	redirect, err := s.urlService.Expand(ctx, id, visit)
+	if err == nil && redirect.Variant != "" {
+		visitor := visitorNew
+		if redirect.Sticky && visit.Variant == redirect.Variant {
+			visitor = visitorReturning
+		}
+		s.variants.Inc(s.linkLabel(id), strconv.Itoa(redirect.VariantIndex), visitor)
+	}
+
+	return redirect, err
+}
+
+// linkLabel returns the id of a link labelled already or while there is room for it, otherLink otherwise
+func (s *meteredService) linkLabel(id string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.links[id]; ok {
+		return id
+	}
+
+	if len(s.links) >= maxVariantLinks {
+		return otherLink
+	}
+	s.links[id] = struct{}{}
+
+	return id
+}
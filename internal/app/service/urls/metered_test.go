@@ -0,0 +1,39 @@
+package urls
+
+import (
+	"context"
+	"fmt"
+	"github.com/ChristinaFomenko/shortener/internal/app/models"
+	"github.com/ChristinaFomenko/shortener/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// splitService sends every visit to the second variant
+type splitService struct {
+	urlService
+}
+
+func (splitService) Expand(_ context.Context, _ string, _ models.Visit) (models.Redirect, error) {
+	return models.Redirect{URL: "https://example.com/b", Variant: "b", VariantIndex: 1, Sticky: true}, nil
+}
+
+func TestMeteredService_Expand(t *testing.T) {
+	ctx := context.Background()
+	s := NewMeteredService(splitService{}, metrics.NewRegistry())
+
+	for i := 0; i < maxVariantLinks; i++ {
+		_, err := s.Expand(ctx, fmt.Sprintf("link%d", i), models.Visit{})
+		assert.NoError(t, err)
+	}
+	_, err := s.Expand(ctx, "link0", models.Visit{Variant: "b"})
+	assert.NoError(t, err)
+	_, err = s.Expand(ctx, "late", models.Visit{})
+	assert.NoError(t, err)
+
+	assert.Equal(t, float64(1), s.variants.Value("link0", "1", visitorNew))
+	assert.Equal(t, float64(1), s.variants.Value("link0", "1", visitorReturning))
+	assert.Equal(t, float64(1), s.variants.Value(fmt.Sprintf("link%d", maxVariantLinks-1), "1", visitorNew))
+	assert.Equal(t, float64(0), s.variants.Value("late", "1", visitorNew))
+	assert.Equal(t, float64(1), s.variants.Value(otherLink, "1", visitorNew))
+}
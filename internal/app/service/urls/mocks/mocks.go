@@ -36,25 +36,26 @@ func (m *MockurlRepository) EXPECT() *MockurlRepositoryMockRecorder {
 }
 
 // Add mocks base method.
-func (m *MockurlRepository) Add(ctx context.Context, urlID, url, userID string) error {
+func (m *MockurlRepository) Add(ctx context.Context, link models.Link) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Add", ctx, urlID, url, userID)
+	ret := m.ctrl.Call(m, "Add", ctx, link)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // Add indicates an expected call of Add.
-func (mr *MockurlRepositoryMockRecorder) Add(ctx, urlID, url, userID interface{}) *gomock.Call {
+func (mr *MockurlRepositoryMockRecorder) Add(ctx, link interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Add", reflect.TypeOf((*MockurlRepository)(nil).Add), ctx, urlID, url, userID)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Add", reflect.TypeOf((*MockurlRepository)(nil).Add), ctx, link)
 }
 
 // AddBatch mocks base method.
-func (m *MockurlRepository) AddBatch(ctx context.Context, urls []models.UserURL, userID string) error {
+func (m *MockurlRepository) AddBatch(ctx context.Context, urls []models.UserURL, userID string) ([]models.BatchResult, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "AddBatch", ctx, urls, userID)
-	ret0, _ := ret[0].(error)
-	return ret0
+	ret0, _ := ret[0].([]models.BatchResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
 // AddBatch indicates an expected call of AddBatch.
@@ -79,10 +80,10 @@ func (mr *MockurlRepositoryMockRecorder) FetchURLs(ctx, userID interface{}) *gom
 }
 
 // Get mocks base method.
-func (m *MockurlRepository) Get(ctx context.Context, urlID string) (string, error) {
+func (m *MockurlRepository) Get(ctx context.Context, urlID string) (models.Link, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "Get", ctx, urlID)
-	ret0, _ := ret[0].(string)
+	ret0, _ := ret[0].(models.Link)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
@@ -93,6 +94,49 @@ func (mr *MockurlRepositoryMockRecorder) Get(ctx, urlID interface{}) *gomock.Cal
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockurlRepository)(nil).Get), ctx, urlID)
 }
 
+// GetLink mocks base method.
+func (m *MockurlRepository) GetLink(ctx context.Context, urlID string) (models.Link, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetLink", ctx, urlID)
+	ret0, _ := ret[0].(models.Link)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetLink indicates an expected call of GetLink.
+func (mr *MockurlRepositoryMockRecorder) GetLink(ctx, urlID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetLink", reflect.TypeOf((*MockurlRepository)(nil).GetLink), ctx, urlID)
+}
+
+// IterateURLs mocks base method.
+func (m *MockurlRepository) IterateURLs(ctx context.Context, userID string, fn func(models.UserURL) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IterateURLs", ctx, userID, fn)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// IterateURLs indicates an expected call of IterateURLs.
+func (mr *MockurlRepositoryMockRecorder) IterateURLs(ctx, userID, fn interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IterateURLs", reflect.TypeOf((*MockurlRepository)(nil).IterateURLs), ctx, userID, fn)
+}
+
+// Update mocks base method.
+func (m *MockurlRepository) Update(ctx context.Context, link models.Link) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Update", ctx, link)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Update indicates an expected call of Update.
+func (mr *MockurlRepositoryMockRecorder) Update(ctx, link interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockurlRepository)(nil).Update), ctx, link)
+}
+
 // Mockgenerator is a mock of generator interface.
 type Mockgenerator struct {
 	ctrl     *gomock.Controller
@@ -130,3 +174,113 @@ func (mr *MockgeneratorMockRecorder) Letters(n interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Letters", reflect.TypeOf((*Mockgenerator)(nil).Letters), n)
 }
+
+// Mocknormalizer is a mock of normalizer interface.
+type Mocknormalizer struct {
+	ctrl     *gomock.Controller
+	recorder *MocknormalizerMockRecorder
+}
+
+// MocknormalizerMockRecorder is the mock recorder for Mocknormalizer.
+type MocknormalizerMockRecorder struct {
+	mock *Mocknormalizer
+}
+
+// NewMocknormalizer creates a new mock instance.
+func NewMocknormalizer(ctrl *gomock.Controller) *Mocknormalizer {
+	mock := &Mocknormalizer{ctrl: ctrl}
+	mock.recorder = &MocknormalizerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *Mocknormalizer) EXPECT() *MocknormalizerMockRecorder {
+	return m.recorder
+}
+
+// Normalize mocks base method.
+func (m *Mocknormalizer) Normalize(url string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Normalize", url)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Normalize indicates an expected call of Normalize.
+func (mr *MocknormalizerMockRecorder) Normalize(url interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Normalize", reflect.TypeOf((*Mocknormalizer)(nil).Normalize), url)
+}
+
+// Mockblocklist is a mock of blocklist interface.
+type Mockblocklist struct {
+	ctrl     *gomock.Controller
+	recorder *MockblocklistMockRecorder
+}
+
+// MockblocklistMockRecorder is the mock recorder for Mockblocklist.
+type MockblocklistMockRecorder struct {
+	mock *Mockblocklist
+}
+
+// NewMockblocklist creates a new mock instance.
+func NewMockblocklist(ctrl *gomock.Controller) *Mockblocklist {
+	mock := &Mockblocklist{ctrl: ctrl}
+	mock.recorder = &MockblocklistMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *Mockblocklist) EXPECT() *MockblocklistMockRecorder {
+	return m.recorder
+}
+
+// Check mocks base method.
+func (m *Mockblocklist) Check(rawURL, normalizedURL string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Check", rawURL, normalizedURL)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Check indicates an expected call of Check.
+func (mr *MockblocklistMockRecorder) Check(rawURL, normalizedURL interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Check", reflect.TypeOf((*Mockblocklist)(nil).Check), rawURL, normalizedURL)
+}
+
+// Mockpublisher is a mock of publisher interface.
+type Mockpublisher struct {
+	ctrl     *gomock.Controller
+	recorder *MockpublisherMockRecorder
+}
+
+// MockpublisherMockRecorder is the mock recorder for Mockpublisher.
+type MockpublisherMockRecorder struct {
+	mock *Mockpublisher
+}
+
+// NewMockpublisher creates a new mock instance.
+func NewMockpublisher(ctrl *gomock.Controller) *Mockpublisher {
+	mock := &Mockpublisher{ctrl: ctrl}
+	mock.recorder = &MockpublisherMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *Mockpublisher) EXPECT() *MockpublisherMockRecorder {
+	return m.recorder
+}
+
+// Publish mocks base method.
+func (m *Mockpublisher) Publish(event models.Event) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Publish", event)
+}
+
+// Publish indicates an expected call of Publish.
+func (mr *MockpublisherMockRecorder) Publish(event interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Publish", reflect.TypeOf((*Mockpublisher)(nil).Publish), event)
+}
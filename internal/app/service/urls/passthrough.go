@@ -0,0 +1,58 @@
+package urls
+
+import (
+	"fmt"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// passthrough appends the visit path to the target path and merges the queries. Dot segments of
+// the visit path are resolved on their own, so it can't climb above the target path. The target
+// keeps its query values when keys clash, visitors can't override parameters set by the owner.
+func passthrough(target string, visitPath string, visitQuery url.Values) (string, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errs.ErrInvalidURL, err)
+	}
+
+	if visitPath != "" {
+		escapedPath := strings.TrimSuffix(u.EscapedPath(), "/") + escapeSegments(cleanVisitPath(visitPath))
+		if u.Path, err = url.PathUnescape(escapedPath); err != nil {
+			return "", fmt.Errorf("%w: %v", errs.ErrInvalidURL, err)
+		}
+		u.RawPath = escapedPath
+	}
+
+	if len(visitQuery) > 0 {
+		query := u.Query()
+		for key, values := range visitQuery {
+			if _, ok := query[key]; !ok {
+				query[key] = values
+			}
+		}
+		u.RawQuery = query.Encode()
+	}
+
+	return u.String(), nil
+}
+
+// cleanVisitPath resolves dot segments and duplicate slashes, keeping the trailing slash
+func cleanVisitPath(visitPath string) string {
+	cleaned := path.Clean("/" + visitPath)
+	if strings.HasSuffix(visitPath, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+
+	return cleaned
+}
+
+func escapeSegments(cleanPath string) string {
+	segments := strings.Split(cleanPath, "/")
+	for idx := range segments {
+		segments[idx] = url.PathEscape(segments[idx])
+	}
+
+	return strings.Join(segments, "/")
+}
@@ -5,55 +5,132 @@ import (
 	"errors"
 	"fmt"
 	"github.com/ChristinaFomenko/shortener/internal/app/models"
+	"github.com/ChristinaFomenko/shortener/internal/app/useragent"
 	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
-	_ "github.com/jackc/pgx/v4"
+	"github.com/ChristinaFomenko/shortener/pkg/tracing"
 	log "github.com/sirupsen/logrus"
+	"math/rand"
+	"regexp"
+	"time"
 )
 
 //go:generate mockgen -source=urls.go -destination=mocks/mocks.go
 
-const idLength int64 = 5
+const (
+	idLength         int64 = 5
+	maxTargetRules         = 20
+	maxVariants            = 10
+	maxVariantWeight       = 1000
+	// batchIDAttempts is how many times a batch url is stored before its generated ids being taken is an error
+	batchIDAttempts = 3
+)
+
+// variantName keeps variant names safe to put into cookies and metric labels
+var variantName = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,32}$`)
 
 type urlRepository interface {
-	Add(ctx context.Context, urlID, url, userID string) error
-	Get(ctx context.Context, urlID string) (string, error)
+	Add(ctx context.Context, link models.Link) error
+	Get(ctx context.Context, urlID string) (models.Link, error)
 	FetchURLs(ctx context.Context, userID string) ([]models.UserURL, error)
-	AddBatch(ctx context.Context, urls []models.UserURL, userID string) error
+	IterateURLs(ctx context.Context, userID string, fn func(url models.UserURL) error) error
+	AddBatch(ctx context.Context, urls []models.UserURL, userID string) ([]models.BatchResult, error)
+	GetLink(ctx context.Context, urlID string) (models.Link, error)
+	Update(ctx context.Context, link models.Link) error
 }
 
 type generator interface {
 	Letters(n int64) (string, error)
 }
 
+type normalizer interface {
+	Normalize(url string) (string, error)
+}
+
+type blocklist interface {
+	Check(rawURL, normalizedURL string) error
+}
+
+type publisher interface {
+	Publish(event models.Event)
+}
+
 type service struct {
-	repository urlRepository
-	generator  generator
-	host       string
+	repository      urlRepository
+	generator       generator
+	normalizer      normalizer
+	blocklist       blocklist
+	host            string
+	defaultRedirect int
+	events          publisher
+	intn            func(n int) int
 }
 
-func NewService(repository urlRepository, generator generator, host string) *service {
+// NewService stores links created without a redirect code with defaultRedirect.
+// Creations and clicks are published to events when it is not nil.
+func NewService(repository urlRepository, generator generator, normalizer normalizer, blocklist blocklist, host string, defaultRedirect int, events publisher) *service {
 	return &service{
-		repository: repository,
-		generator:  generator,
-		host:       host,
+		repository:      repository,
+		generator:       generator,
+		normalizer:      normalizer,
+		blocklist:       blocklist,
+		host:            host,
+		defaultRedirect: defaultRedirect,
+		events:          events,
+		intn:            rand.Intn,
 	}
 }
 
-func (s *service) Shorten(ctx context.Context, url, userID string) (string, error) {
+func (s *service) Shorten(ctx context.Context, url, userID string, options models.LinkOptions) (string, error) {
+	ctx, span := tracing.Start(ctx, "service.Shorten")
+	defer span.End()
+
+	if !models.ValidRedirectCode(options.RedirectCode) {
+		return "", errs.ErrInvalidRedirectCode
+	}
+
+	normalizedURL, err := s.normalize(url)
+	if err != nil {
+		return "", err
+	}
+
+	if err = s.checkRules(options.Rules); err != nil {
+		return "", err
+	}
+
+	if err = s.checkVariants(options.Variants); err != nil {
+		return "", err
+	}
+
 	urlID, err := s.generator.Letters(idLength)
 	if err != nil {
+		span.RecordError(err)
 		log.WithError(err).
 			WithField("userID", userID).
 			WithField("url", url).Error("add url error")
 		return "", err
 	}
 
-	if err = s.repository.Add(ctx, urlID, url, userID); err != nil {
+	link := models.Link{
+		ID:            urlID,
+		OriginalURL:   url,
+		NormalizedURL: normalizedURL,
+		UserID:        userID,
+		RedirectCode:  s.redirectCode(options.RedirectCode),
+		Passthrough:   options.Passthrough,
+		Rules:         options.Rules,
+		Variants:      options.Variants,
+		Sticky:        options.Sticky,
+	}
+	if err = s.repository.Add(ctx, link); err != nil {
 		var uniqueErr *errs.NotUniqueURLErr
 		if errors.As(err, &uniqueErr) {
+			if err = s.sameSettings(ctx, uniqueErr.URLID, link.Options()); err != nil {
+				return "", err
+			}
 			return s.buildShortURL(uniqueErr.URLID), errs.ErrNotUniqueURL
 		}
 
+		span.RecordError(err)
 		log.WithError(err).
 			WithField("userID", userID).
 			WithField("urlID", urlID).
@@ -63,27 +140,215 @@ func (s *service) Shorten(ctx context.Context, url, userID string) (string, erro
 
 	}
 
+	s.publish(models.Event{
+		Type:        models.EventLinkCreated,
+		URLID:       urlID,
+		UserID:      userID,
+		OriginalURL: url,
+		ShortURL:    s.buildShortURL(urlID),
+	})
+
 	return s.buildShortURL(urlID), nil
 }
 
-// Return by id
+// Expand returns where a visit of the link goes, only passthrough links accept a path after the id
+func (s *service) Expand(ctx context.Context, urlID string, visit models.Visit) (models.Redirect, error) {
+	ctx, span := tracing.Start(ctx, "service.Expand")
+	defer span.End()
 
-func (s *service) Expand(ctx context.Context, urlID string) (string, error) {
-	url, err := s.repository.Get(ctx, urlID)
+	link, err := s.repository.Get(ctx, urlID)
 	if err != nil {
 		if errors.Is(err, errs.ErrURLNotFound) {
-			return "", errs.ErrURLNotFound
+			return models.Redirect{}, errs.ErrURLNotFound
 		}
+		span.RecordError(err)
 		log.WithError(err).WithField("urlID", urlID).Error("get url error")
-		return "", err
+		return models.Redirect{}, err
+	}
+
+	redirect := models.Redirect{URL: link.OriginalURL, Targeted: len(link.Rules) > 0}
+
+	matched := false
+	if redirect.Targeted {
+		redirect.URL, matched = link.Target(useragent.Parse(visit.UserAgent))
+	}
+
+	if !matched && len(link.Variants) > 0 {
+		redirect.VariantIndex = s.pickVariant(link, visit.Variant)
+		variant := link.Variants[redirect.VariantIndex]
+		redirect.URL, redirect.Variant, redirect.Sticky = variant.URL, variant.Name, link.Sticky
 	}
 
-	return url, nil
+	switch {
+	case link.Passthrough:
+		redirect.URL, err = passthrough(redirect.URL, visit.Path, visit.Query)
+		if err != nil {
+			span.RecordError(err)
+			log.WithError(err).WithField("urlID", urlID).Error("passthrough url error")
+			return models.Redirect{}, err
+		}
+	case visit.Path != "":
+		return models.Redirect{}, errs.ErrURLNotFound
+	}
+
+	// links stored before the code was resolved on creation have none
+	redirect.Code = s.redirectCode(link.RedirectCode)
+
+	s.publish(models.Event{
+		Type:        models.EventLinkClicked,
+		URLID:       link.ID,
+		UserID:      link.UserID,
+		OriginalURL: link.OriginalURL,
+		ShortURL:    s.buildShortURL(link.ID),
+		Target:      redirect.URL,
+		Variant:     redirect.Variant,
+		UserAgent:   visit.UserAgent,
+	})
+
+	return redirect, nil
+}
+
+// pickVariant keeps a returning visitor of a sticky link on the variant they got, others get a variant
+// chosen at random in proportion to the weights. It returns the position of the variant.
+func (s *service) pickVariant(link models.Link, previous string) int {
+	if link.Sticky && previous != "" {
+		if idx, ok := link.VariantIndex(previous); ok {
+			return idx
+		}
+	}
+
+	total := 0
+	for _, variant := range link.Variants {
+		total += variant.Weight
+	}
+
+	roll := s.intn(total)
+	for idx, variant := range link.Variants {
+		if roll < variant.Weight {
+			return idx
+		}
+		roll -= variant.Weight
+	}
+
+	return len(link.Variants) - 1
+}
+
+// Rules returns the targeting rules of a link the user owns
+func (s *service) Rules(ctx context.Context, urlID, userID string) ([]models.TargetRule, error) {
+	ctx, span := tracing.Start(ctx, "service.Rules")
+	defer span.End()
+
+	link, err := s.ownLink(ctx, urlID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return link.Rules, nil
+}
+
+// SetRules replaces the targeting rules of a link the user owns, an empty list removes them
+func (s *service) SetRules(ctx context.Context, urlID, userID string, rules []models.TargetRule) error {
+	ctx, span := tracing.Start(ctx, "service.SetRules")
+	defer span.End()
+
+	if err := s.checkRules(rules); err != nil {
+		return err
+	}
+
+	link, err := s.ownLink(ctx, urlID, userID)
+	if err != nil {
+		return err
+	}
+
+	link.Rules = rules
+	if err = s.repository.Update(ctx, link); err != nil {
+		span.RecordError(err)
+		log.WithError(err).WithField("urlID", urlID).Error("update link rules error")
+		return err
+	}
+
+	return nil
+}
+
+// ownLink returns an active link of the user, links of others are reported as not found
+func (s *service) ownLink(ctx context.Context, urlID, userID string) (models.Link, error) {
+	link, err := s.repository.GetLink(ctx, urlID)
+	if err != nil {
+		if !errors.Is(err, errs.ErrURLNotFound) {
+			log.WithError(err).WithField("urlID", urlID).Error("get link error")
+		}
+		return models.Link{}, err
+	}
+
+	if link.Deleted() || link.UserID != userID {
+		return models.Link{}, errs.ErrURLNotFound
+	}
+
+	return link, nil
+}
+
+// checkVariants refuses duplicate or unsafe names, weights out of range and destinations the blocklist refuses
+func (s *service) checkVariants(variants []models.Variant) error {
+	if len(variants) > maxVariants {
+		return fmt.Errorf("%w: at most %d allowed", errs.ErrInvalidVariants, maxVariants)
+	}
+
+	names := make(map[string]struct{}, len(variants))
+	total := 0
+	for idx := range variants {
+		name := variants[idx].Name
+		if !variantName.MatchString(name) {
+			return fmt.Errorf("%w: name %q must be 1 to 32 letters, digits, - or _", errs.ErrInvalidVariants, name)
+		}
+
+		if _, ok := names[name]; ok {
+			return fmt.Errorf("%w: duplicate name %q", errs.ErrInvalidVariants, name)
+		}
+		names[name] = struct{}{}
+
+		if variants[idx].Weight < 0 || variants[idx].Weight > maxVariantWeight {
+			return fmt.Errorf("%w: weight of %q must be from 0 to %d", errs.ErrInvalidVariants, name, maxVariantWeight)
+		}
+		total += variants[idx].Weight
+
+		if _, err := s.normalize(variants[idx].URL); err != nil {
+			return fmt.Errorf("variant %q: %w", name, err)
+		}
+	}
+
+	if len(variants) > 0 && total == 0 {
+		return fmt.Errorf("%w: total weight must be positive", errs.ErrInvalidVariants)
+	}
+
+	return nil
+}
+
+// checkRules refuses rules without a known condition and destinations the blocklist refuses
+func (s *service) checkRules(rules []models.TargetRule) error {
+	if len(rules) > maxTargetRules {
+		return fmt.Errorf("%w: at most %d allowed", errs.ErrTooManyTargetRules, maxTargetRules)
+	}
+
+	for idx := range rules {
+		if !rules[idx].Valid() {
+			return fmt.Errorf("rule %d: %w", idx+1, errs.ErrInvalidTargetRule)
+		}
+
+		if _, err := s.normalize(rules[idx].URL); err != nil {
+			return fmt.Errorf("rule %d: %w", idx+1, err)
+		}
+	}
+
+	return nil
 }
 
 func (s *service) FetchURLs(ctx context.Context, userID string) ([]models.UserURL, error) {
+	ctx, span := tracing.Start(ctx, "service.FetchURLs")
+	defer span.End()
+
 	urls, err := s.repository.FetchURLs(ctx, userID)
 	if err != nil {
+		span.RecordError(err)
 		log.WithError(err).WithField("urlID", userID).Error("get url list error")
 		return nil, err
 	}
@@ -95,11 +360,83 @@ func (s *service) FetchURLs(ctx context.Context, userID string) ([]models.UserUR
 	return urls, nil
 }
 
+// ExportURLs calls fn with every active link of the user as it is read, short urls built
+func (s *service) ExportURLs(ctx context.Context, userID string, fn func(url models.UserURL) error) error {
+	ctx, span := tracing.Start(ctx, "service.ExportURLs")
+	defer span.End()
+
+	err := s.repository.IterateURLs(ctx, userID, func(url models.UserURL) error {
+		url.ShortURL = s.buildShortURL(url.ShortURL)
+		return fn(url)
+	})
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	return nil
+}
+
+// addBatch stores the urls, the ones whose generated id turned out to be taken by another url
+// are stored again under new ids
+func (s *service) addBatch(ctx context.Context, urls []models.UserURL, userID string) ([]models.BatchResult, error) {
+	results, err := s.repository.AddBatch(ctx, urls, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 1; ; attempt++ {
+		taken := make([]int, 0)
+		for idx := range results {
+			if results[idx].ID == "" {
+				taken = append(taken, idx)
+			}
+		}
+
+		if len(taken) == 0 {
+			return results, nil
+		}
+		if attempt == batchIDAttempts {
+			return nil, errs.ErrURLIDConflict
+		}
+
+		retried := make([]models.UserURL, len(taken))
+		for pos, idx := range taken {
+			if urls[idx].ShortURL, err = s.generator.Letters(idLength); err != nil {
+				return nil, err
+			}
+			retried[pos] = urls[idx]
+		}
+
+		stored, err := s.repository.AddBatch(ctx, retried, userID)
+		if err != nil {
+			return nil, err
+		}
+
+		for pos, idx := range taken {
+			results[idx] = stored[pos]
+		}
+	}
+}
+
 func (s *service) ShortenBatch(ctx context.Context, originalURLs []models.OriginalURL, userID string) ([]models.UserURL, error) {
+	ctx, span := tracing.Start(ctx, "service.ShortenBatch")
+	defer span.End()
+
 	urls := make([]models.UserURL, len(originalURLs))
 	for idx := range urls {
+		if !models.ValidRedirectCode(originalURLs[idx].RedirectCode) {
+			return nil, fmt.Errorf("correlation id %s: %w", originalURLs[idx].CorrelationID, errs.ErrInvalidRedirectCode)
+		}
+
+		normalizedURL, err := s.normalize(originalURLs[idx].URL)
+		if err != nil {
+			return nil, fmt.Errorf("correlation id %s: %w", originalURLs[idx].CorrelationID, err)
+		}
+
 		urlID, err := s.generator.Letters(idLength)
 		if err != nil {
+			span.RecordError(err)
 			log.WithError(err).
 				WithField("userID", userID).
 				WithField("originalURLs", originalURLs).
@@ -110,11 +447,15 @@ func (s *service) ShortenBatch(ctx context.Context, originalURLs []models.Origin
 			CorrelationID: originalURLs[idx].CorrelationID,
 			ShortURL:      urlID,
 			OriginalURL:   originalURLs[idx].URL,
+			NormalizedURL: normalizedURL,
+			RedirectCode:  s.redirectCode(originalURLs[idx].RedirectCode),
+			Passthrough:   originalURLs[idx].Passthrough,
 		}
 	}
 
-	err := s.repository.AddBatch(ctx, urls, userID)
+	results, err := s.addBatch(ctx, urls, userID)
 	if err != nil {
+		span.RecordError(err)
 		log.WithError(err).
 			WithField("userID", userID).
 			WithField("originalURLs", originalURLs).
@@ -124,12 +465,81 @@ func (s *service) ShortenBatch(ctx context.Context, originalURLs []models.Origin
 	}
 
 	for idx := range urls {
-		urls[idx].ShortURL = s.buildShortURL(urls[idx].ShortURL)
+		urlID := results[idx].ID
+		urls[idx].ShortURL = s.buildShortURL(urlID)
+		urls[idx].Existing = !results[idx].Created
+		if urls[idx].Existing {
+			// the links created are kept, a retry without the url gets them as existing ones
+			options := models.LinkOptions{RedirectCode: urls[idx].RedirectCode, Passthrough: urls[idx].Passthrough}
+			if err = s.sameSettings(ctx, urlID, options); err != nil {
+				return nil, fmt.Errorf("correlation id %s: %w", urls[idx].CorrelationID, err)
+			}
+			continue
+		}
+
+		s.publish(models.Event{
+			Type:        models.EventLinkCreated,
+			URLID:       urlID,
+			UserID:      userID,
+			OriginalURL: urls[idx].OriginalURL,
+			ShortURL:    urls[idx].ShortURL,
+		})
 	}
 
 	return urls, nil
 }
 
+// publish stamps the event and hands it over, publishers must not block
+func (s *service) publish(event models.Event) {
+	if s.events == nil {
+		return
+	}
+
+	event.OccurredAt = time.Now()
+	s.events.Publish(event)
+}
+
+// sameSettings refuses to hand out the link already shortening a url when it redirects otherwise than asked,
+// a link whose claim is not stored yet can't be compared and is handed out
+func (s *service) sameSettings(ctx context.Context, urlID string, options models.LinkOptions) error {
+	stored, err := s.repository.GetLink(ctx, urlID)
+	if errors.Is(err, errs.ErrURLNotFound) {
+		return nil
+	}
+	if err != nil {
+		log.WithError(err).WithField("urlID", urlID).Error("get link error")
+		return err
+	}
+
+	storedOptions := stored.Options()
+	storedOptions.RedirectCode = s.redirectCode(storedOptions.RedirectCode)
+	if !storedOptions.Same(options) {
+		return fmt.Errorf("%w as %s", errs.ErrURLSettingsConflict, s.buildShortURL(urlID))
+	}
+
+	return nil
+}
+
+// redirectCode resolves a missing code to the default, the links keep it when the default changes later
+// and the redirects clients have cached stay right
+func (s *service) redirectCode(code int) int {
+	if code == 0 {
+		return s.defaultRedirect
+	}
+
+	return code
+}
+
+// normalize returns the canonical form of the url, blocked urls are refused even when they can't be normalized
+func (s *service) normalize(url string) (string, error) {
+	normalizedURL, err := s.normalizer.Normalize(url)
+	if blockErr := s.blocklist.Check(url, normalizedURL); blockErr != nil {
+		return "", blockErr
+	}
+
+	return normalizedURL, err
+}
+
 func (s *service) buildShortURL(id string) string {
 	return fmt.Sprintf("%s/%s", s.host, id)
 }
@@ -3,9 +3,13 @@ package urls
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/ChristinaFomenko/shortener/internal/app/models"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/url"
 	"testing"
 
 	mocks "github.com/ChristinaFomenko/shortener/internal/app/service/urls/mocks"
@@ -18,24 +22,86 @@ const (
 
 func Test_service_Shorten(t *testing.T) {
 	tests := []struct {
-		name     string
-		urlID    string
-		url      string
-		shortcut string
-		err      error
+		name          string
+		urlID         string
+		url           string
+		normalizedURL string
+		normalizeErr  error
+		blockErr      error
+		redirectCode  int
+		repoErr       error
+		stored        models.Link
+		shortcut      string
+		err           error
 	}{
 		{
-			name:     "success",
-			urlID:    "abcde",
-			url:      "yandex.ru",
-			shortcut: "http://localhost:8080/abcde",
+			name:          "success",
+			urlID:         "abcde",
+			url:           "https://Yandex.ru?utm_source=x",
+			normalizedURL: "https://yandex.ru/",
+			shortcut:      "http://localhost:8080/abcde",
+		},
+		{
+			name:          "permanent redirect",
+			urlID:         "abcde",
+			url:           "https://yandex.ru",
+			normalizedURL: "https://yandex.ru/",
+			redirectCode:  http.StatusPermanentRedirect,
+			shortcut:      "http://localhost:8080/abcde",
+		},
+		{
+			name:          "already shortened",
+			urlID:         "abcde",
+			url:           "https://yandex.ru/",
+			normalizedURL: "https://yandex.ru/",
+			repoErr:       errs.NewNotUniqueURLErr("qwert", "https://Yandex.ru", nil),
+			stored:        models.Link{ID: "qwert", OriginalURL: "https://Yandex.ru"},
+			shortcut:      "http://localhost:8080/qwert",
+			err:           errs.ErrNotUniqueURL,
+		},
+		{
+			name:          "already shortened with other settings",
+			urlID:         "abcde",
+			url:           "https://yandex.ru/",
+			normalizedURL: "https://yandex.ru/",
+			repoErr:       errs.NewNotUniqueURLErr("qwert", "https://Yandex.ru", nil),
+			stored:        models.Link{ID: "qwert", OriginalURL: "https://Yandex.ru", RedirectCode: http.StatusTemporaryRedirect, Passthrough: true},
+			err:           fmt.Errorf("%w as %s", errs.ErrURLSettingsConflict, "http://localhost:8080/qwert"),
+		},
+		{
+			name:          "repo err",
+			urlID:         "abcde",
+			url:           "https://yandex.ru",
+			normalizedURL: "https://yandex.ru/",
+			repoErr:       errors.New("test err"),
+			shortcut:      "",
+			err:           errors.New("test err"),
+		},
+		{
+			name:         "invalid url",
+			url:          "yandex",
+			normalizeErr: errs.ErrInvalidURL,
+			err:          errs.ErrInvalidURL,
 		},
 		{
-			name:     "success",
-			urlID:    "abcde",
-			url:      "yandex.ru",
-			shortcut: "",
-			err:      errors.New("test err"),
+			name:          "blocked url",
+			url:           "https://phishing.example",
+			normalizedURL: "https://phishing.example/",
+			blockErr:      errs.NewBlockedURLErr("https://phishing.example", "domain phishing.example is blocked"),
+			err:           errs.NewBlockedURLErr("https://phishing.example", "domain phishing.example is blocked"),
+		},
+		{
+			name:         "dangerous scheme",
+			url:          "javascript:alert(1)",
+			normalizeErr: errs.ErrInvalidURL,
+			blockErr:     errs.NewBlockedURLErr("javascript:alert(1)", "scheme javascript is not allowed"),
+			err:          errs.NewBlockedURLErr("javascript:alert(1)", "scheme javascript is not allowed"),
+		},
+		{
+			name:         "unsupported redirect code",
+			url:          "https://yandex.ru",
+			redirectCode: http.StatusSeeOther,
+			err:          errs.ErrInvalidRedirectCode,
 		},
 	}
 
@@ -45,36 +111,120 @@ func Test_service_Shorten(t *testing.T) {
 	defer ctrl.Finish()
 
 	for _, tt := range tests {
-		generatorMock := mocks.NewMockgenerator(ctrl)
-		generatorMock.EXPECT().Letters(idLength).Return(tt.urlID, nil)
+		t.Run(tt.name, func(t *testing.T) {
+			validCode := tt.err != errs.ErrInvalidRedirectCode
 
-		repositoryMock := mocks.NewMockurlRepository(ctrl)
-		repositoryMock.EXPECT().Add(ctx, tt.urlID, tt.url, defaultUserID).Return(tt.err)
+			normalizerMock := mocks.NewMocknormalizer(ctrl)
+			blocklistMock := mocks.NewMockblocklist(ctrl)
+			if validCode {
+				normalizerMock.EXPECT().Normalize(tt.url).Return(tt.normalizedURL, tt.normalizeErr)
+				blocklistMock.EXPECT().Check(tt.url, tt.normalizedURL).Return(tt.blockErr)
+			}
 
-		s := NewService(repositoryMock, generatorMock, host)
-		act, err := s.Shorten(ctx, tt.url, defaultUserID)
+			generatorMock := mocks.NewMockgenerator(ctrl)
+			repositoryMock := mocks.NewMockurlRepository(ctrl)
+			if validCode && tt.normalizeErr == nil && tt.blockErr == nil {
+				// links created without a code keep the default of the time
+				storedCode := tt.redirectCode
+				if storedCode == 0 {
+					storedCode = http.StatusTemporaryRedirect
+				}
 
-		assert.Equal(t, tt.err, err)
-		assert.Equal(t, tt.shortcut, act)
+				generatorMock.EXPECT().Letters(idLength).Return(tt.urlID, nil)
+				repositoryMock.EXPECT().Add(ctx, models.Link{
+					ID:            tt.urlID,
+					OriginalURL:   tt.url,
+					NormalizedURL: tt.normalizedURL,
+					UserID:        defaultUserID,
+					RedirectCode:  storedCode,
+				}).Return(tt.repoErr)
+			}
+			if tt.stored.ID != "" {
+				repositoryMock.EXPECT().GetLink(ctx, tt.stored.ID).Return(tt.stored, nil)
+			}
+
+			s := NewService(repositoryMock, generatorMock, normalizerMock, blocklistMock, host, http.StatusTemporaryRedirect, nil)
+			act, err := s.Shorten(ctx, tt.url, defaultUserID, models.LinkOptions{RedirectCode: tt.redirectCode})
+
+			assert.Equal(t, tt.err, err)
+			assert.Equal(t, tt.shortcut, act)
+		})
 	}
 }
 
 func Test_service_Expand(t *testing.T) {
+	appLink := models.Link{
+		ID:          "abcde",
+		OriginalURL: "https://app.example",
+		Rules: []models.TargetRule{
+			{OS: models.OSIOS, URL: "https://apps.apple.com/app/id1"},
+			{OS: models.OSAndroid, URL: "https://play.google.com/store/apps/details?id=app"},
+		},
+	}
+
 	tests := []struct {
 		name     string
-		url      string
+		link     models.Link
 		shortcut string
+		visit    models.Visit
+		redirect models.Redirect
 		err      error
 	}{
 		{
-			name:     "success",
-			url:      "yandex.ru",
+			name:     "default redirect",
+			link:     models.Link{ID: "abcde", OriginalURL: "yandex.ru"},
 			shortcut: "abcde",
+			redirect: models.Redirect{URL: "yandex.ru", Code: http.StatusTemporaryRedirect},
+			err:      nil,
+		},
+		{
+			name:     "link redirect",
+			link:     models.Link{ID: "abcde", OriginalURL: "yandex.ru", RedirectCode: http.StatusMovedPermanently},
+			shortcut: "abcde",
+			redirect: models.Redirect{URL: "yandex.ru", Code: http.StatusMovedPermanently},
+			err:      nil,
+		},
+		{
+			name:     "passthrough",
+			link:     models.Link{ID: "abcde", OriginalURL: "https://shop.example/catalog?ref=abc", Passthrough: true},
+			shortcut: "abcde",
+			visit:    models.Visit{Path: "/shoes/red", Query: url.Values{"size": {"42"}}},
+			redirect: models.Redirect{URL: "https://shop.example/catalog/shoes/red?ref=abc&size=42", Code: http.StatusTemporaryRedirect},
+			err:      nil,
+		},
+		{
+			name:     "path without passthrough",
+			link:     models.Link{ID: "abcde", OriginalURL: "https://shop.example/catalog"},
+			shortcut: "abcde",
+			visit:    models.Visit{Path: "/shoes"},
+			err:      errs.ErrURLNotFound,
+		},
+		{
+			name:     "query without passthrough",
+			link:     models.Link{ID: "abcde", OriginalURL: "https://shop.example/catalog"},
+			shortcut: "abcde",
+			visit:    models.Visit{Query: url.Values{"size": {"42"}}},
+			redirect: models.Redirect{URL: "https://shop.example/catalog", Code: http.StatusTemporaryRedirect},
+			err:      nil,
+		},
+		{
+			name:     "targeted",
+			link:     appLink,
+			shortcut: "abcde",
+			visit:    models.Visit{UserAgent: "Mozilla/5.0 (Linux; Android 14; Pixel 8) Chrome/120.0 Mobile Safari/537.36"},
+			redirect: models.Redirect{URL: "https://play.google.com/store/apps/details?id=app", Code: http.StatusTemporaryRedirect, Targeted: true},
+			err:      nil,
+		},
+		{
+			name:     "targeting fallback",
+			link:     appLink,
+			shortcut: "abcde",
+			visit:    models.Visit{UserAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) Chrome/120.0 Safari/537.36"},
+			redirect: models.Redirect{URL: "https://app.example", Code: http.StatusTemporaryRedirect, Targeted: true},
 			err:      nil,
 		},
 		{
 			name:     "error",
-			url:      "",
 			shortcut: "abcde",
 			err:      errors.New("test error"),
 		},
@@ -87,13 +237,272 @@ func Test_service_Expand(t *testing.T) {
 
 	for _, tt := range tests {
 		repositoryMock := mocks.NewMockurlRepository(ctrl)
-		repositoryMock.EXPECT().Get(ctx, tt.shortcut).Return(tt.url, tt.err)
+		repositoryMock.EXPECT().Get(ctx, tt.shortcut).Return(tt.link, tt.err)
 
-		s := NewService(repositoryMock, nil, host)
-		act, err := s.Expand(ctx, tt.shortcut)
+		s := NewService(repositoryMock, nil, nil, nil, host, http.StatusTemporaryRedirect, nil)
+		act, err := s.Expand(ctx, tt.shortcut, tt.visit)
 
 		assert.Equal(t, tt.err, err)
-		assert.Equal(t, tt.url, act)
+		assert.Equal(t, tt.redirect, act)
+	}
+}
+
+func Test_service_ExpandVariants(t *testing.T) {
+	split := models.Link{
+		ID:          "abcde",
+		OriginalURL: "https://example.com",
+		Variants: []models.Variant{
+			{Name: "a", URL: "https://example.com/a", Weight: 3},
+			{Name: "off", URL: "https://example.com/off", Weight: 0},
+			{Name: "b", URL: "https://example.com/b", Weight: 1},
+		},
+	}
+	sticky := split
+	sticky.Sticky = true
+
+	tests := []struct {
+		name     string
+		link     models.Link
+		visit    models.Visit
+		roll     int
+		redirect models.Redirect
+	}{
+		{
+			name:     "first share",
+			link:     split,
+			roll:     2,
+			redirect: models.Redirect{URL: "https://example.com/a", Code: http.StatusTemporaryRedirect, Variant: "a"},
+		},
+		{
+			name:     "last share",
+			link:     split,
+			roll:     3,
+			redirect: models.Redirect{URL: "https://example.com/b", Code: http.StatusTemporaryRedirect, Variant: "b", VariantIndex: 2},
+		},
+		{
+			name:     "cookie ignored without stickiness",
+			link:     split,
+			visit:    models.Visit{Variant: "b"},
+			roll:     0,
+			redirect: models.Redirect{URL: "https://example.com/a", Code: http.StatusTemporaryRedirect, Variant: "a"},
+		},
+		{
+			name:     "returning visitor",
+			link:     sticky,
+			visit:    models.Visit{Variant: "b"},
+			roll:     0,
+			redirect: models.Redirect{URL: "https://example.com/b", Code: http.StatusTemporaryRedirect, Variant: "b", VariantIndex: 2, Sticky: true},
+		},
+		{
+			name:     "returning to a switched off variant",
+			link:     sticky,
+			visit:    models.Visit{Variant: "off"},
+			roll:     3,
+			redirect: models.Redirect{URL: "https://example.com/b", Code: http.StatusTemporaryRedirect, Variant: "b", VariantIndex: 2, Sticky: true},
+		},
+	}
+
+	ctx := context.Background()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repositoryMock := mocks.NewMockurlRepository(ctrl)
+			repositoryMock.EXPECT().Get(ctx, "abcde").Return(tt.link, nil)
+
+			s := NewService(repositoryMock, nil, nil, nil, host, http.StatusTemporaryRedirect, nil)
+			s.intn = func(n int) int {
+				assert.Equal(t, 4, n)
+				return tt.roll
+			}
+
+			act, err := s.Expand(ctx, "abcde", tt.visit)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.redirect, act)
+		})
+	}
+}
+
+func Test_service_checkVariants(t *testing.T) {
+	tests := []struct {
+		name     string
+		variants []models.Variant
+		err      string
+	}{
+		{
+			name:     "valid",
+			variants: []models.Variant{{Name: "a", URL: "https://example.com/a", Weight: 1}, {Name: "b", URL: "https://example.com/b"}},
+		},
+		{
+			name:     "duplicate name",
+			variants: []models.Variant{{Name: "a", URL: "https://example.com/a", Weight: 1}, {Name: "a", URL: "https://example.com/b", Weight: 1}},
+			err:      "variants not valid: duplicate name \"a\"",
+		},
+		{
+			name:     "unsafe name",
+			variants: []models.Variant{{Name: "a;b", URL: "https://example.com/a", Weight: 1}},
+			err:      "variants not valid: name \"a;b\" must be 1 to 32 letters, digits, - or _",
+		},
+		{
+			name:     "negative weight",
+			variants: []models.Variant{{Name: "a", URL: "https://example.com/a", Weight: -1}},
+			err:      "variants not valid: weight of \"a\" must be from 0 to 1000",
+		},
+		{
+			name:     "no traffic",
+			variants: []models.Variant{{Name: "a", URL: "https://example.com/a"}},
+			err:      "variants not valid: total weight must be positive",
+		},
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			normalizerMock := mocks.NewMocknormalizer(ctrl)
+			normalizerMock.EXPECT().Normalize(gomock.Any()).DoAndReturn(func(url string) (string, error) {
+				return url, nil
+			}).AnyTimes()
+
+			blocklistMock := mocks.NewMockblocklist(ctrl)
+			blocklistMock.EXPECT().Check(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+
+			s := NewService(nil, nil, normalizerMock, blocklistMock, host, http.StatusTemporaryRedirect, nil)
+			err := s.checkVariants(tt.variants)
+
+			if tt.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, errs.ErrInvalidVariants)
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+}
+
+func Test_service_SetRules(t *testing.T) {
+	rules := []models.TargetRule{{OS: models.OSIOS, URL: "https://apps.apple.com/app/id1"}}
+
+	tests := []struct {
+		name     string
+		rules    []models.TargetRule
+		link     models.Link
+		blockErr error
+		err      error
+	}{
+		{
+			name:  "success",
+			rules: rules,
+			link:  models.Link{ID: "abcde", OriginalURL: "https://app.example", UserID: defaultUserID},
+		},
+		{
+			name:  "link of another user",
+			rules: rules,
+			link:  models.Link{ID: "abcde", OriginalURL: "https://app.example", UserID: "other"},
+			err:   errs.ErrURLNotFound,
+		},
+		{
+			name:     "blocked destination",
+			rules:    rules,
+			blockErr: errs.NewBlockedURLErr("https://apps.apple.com/app/id1", "domain apps.apple.com is blocked"),
+			err:      fmt.Errorf("rule 1: %w", errs.NewBlockedURLErr("https://apps.apple.com/app/id1", "domain apps.apple.com is blocked")),
+		},
+		{
+			name:  "rule without condition",
+			rules: []models.TargetRule{{URL: "https://apps.apple.com/app/id1"}},
+			err:   fmt.Errorf("rule 1: %w", errs.ErrInvalidTargetRule),
+		},
+	}
+
+	ctx := context.Background()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			normalizerMock := mocks.NewMocknormalizer(ctrl)
+			blocklistMock := mocks.NewMockblocklist(ctrl)
+			repositoryMock := mocks.NewMockurlRepository(ctrl)
+
+			if tt.rules[0].Valid() {
+				normalizerMock.EXPECT().Normalize(tt.rules[0].URL).Return(tt.rules[0].URL, nil)
+				blocklistMock.EXPECT().Check(tt.rules[0].URL, tt.rules[0].URL).Return(tt.blockErr)
+			}
+			if tt.link.ID != "" {
+				repositoryMock.EXPECT().GetLink(ctx, "abcde").Return(tt.link, nil)
+			}
+			if tt.err == nil {
+				updated := tt.link
+				updated.Rules = tt.rules
+				repositoryMock.EXPECT().Update(ctx, updated).Return(nil)
+			}
+
+			s := NewService(repositoryMock, nil, normalizerMock, blocklistMock, host, http.StatusTemporaryRedirect, nil)
+			err := s.SetRules(ctx, "abcde", defaultUserID, tt.rules)
+
+			assert.Equal(t, tt.err, err)
+		})
+	}
+}
+
+func Test_passthrough(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		path   string
+		query  url.Values
+		want   string
+	}{
+		{
+			name:   "path appended",
+			target: "https://example.com/docs",
+			path:   "/guide/intro",
+			want:   "https://example.com/docs/guide/intro",
+		},
+		{
+			name:   "target with trailing slash",
+			target: "https://example.com/docs/",
+			path:   "/guide/",
+			want:   "https://example.com/docs/guide/",
+		},
+		{
+			name:   "target without path",
+			target: "https://example.com",
+			path:   "/guide",
+			want:   "https://example.com/guide",
+		},
+		{
+			name:   "dot segments stay below the target",
+			target: "https://example.com/docs",
+			path:   "/../../admin",
+			want:   "https://example.com/docs/admin",
+		},
+		{
+			name:   "special characters escaped",
+			target: "https://example.com/docs",
+			path:   "/a b/c?d#e",
+			want:   "https://example.com/docs/a%20b/c%3Fd%23e",
+		},
+		{
+			name:   "target query wins",
+			target: "https://example.com/?ref=owner#top",
+			query:  url.Values{"ref": {"visitor"}, "q": {"go"}},
+			want:   "https://example.com/?q=go&ref=owner#top",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			act, err := passthrough(tt.target, tt.path, tt.query)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, act)
+		})
 	}
 }
 
@@ -133,7 +542,7 @@ func Test_service_FetchURLs(t *testing.T) {
 		repositoryMock := mocks.NewMockurlRepository(ctrl)
 		repositoryMock.EXPECT().FetchURLs(ctx, defaultUserID).Return(tt.urls, tt.err)
 
-		s := NewService(repositoryMock, nil, host)
+		s := NewService(repositoryMock, nil, nil, nil, host, http.StatusTemporaryRedirect, nil)
 		act, err := s.FetchURLs(ctx, defaultUserID)
 
 		assert.Equal(t, tt.err, err)
@@ -141,11 +550,41 @@ func Test_service_FetchURLs(t *testing.T) {
 	}
 }
 
+func Test_service_ExportURLs(t *testing.T) {
+	ctx := context.Background()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	repositoryMock := mocks.NewMockurlRepository(ctrl)
+	repositoryMock.EXPECT().IterateURLs(gomock.Any(), defaultUserID, gomock.Any()).DoAndReturn(
+		func(_ context.Context, _ string, fn func(url models.UserURL) error) error {
+			for _, urlID := range []string{"abcde", "qwerty"} {
+				if err := fn(models.UserURL{ShortURL: urlID, OriginalURL: "https://yandex.ru"}); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+
+	s := NewService(repositoryMock, nil, nil, nil, host, http.StatusTemporaryRedirect, nil)
+
+	var act []string
+	err := s.ExportURLs(ctx, defaultUserID, func(url models.UserURL) error {
+		act = append(act, url.ShortURL)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{host + "/abcde", host + "/qwerty"}, act)
+}
+
 func Test_service_ShortenBatch(t *testing.T) {
 	tests := []struct {
 		name         string
 		originalURLs []models.OriginalURL
 		urls         []models.UserURL
+		results      []models.BatchResult
+		stored       []models.Link
 		err          error
 		exp          []models.UserURL
 	}{
@@ -166,23 +605,37 @@ func Test_service_ShortenBatch(t *testing.T) {
 					CorrelationID: "1",
 					ShortURL:      "abcde",
 					OriginalURL:   "https://yandex.ru",
+					NormalizedURL: "https://yandex.ru/",
+					RedirectCode:  http.StatusTemporaryRedirect,
 				},
 				{
 					CorrelationID: "2",
 					ShortURL:      "qwerty",
 					OriginalURL:   "https://github.com",
+					NormalizedURL: "https://github.com/",
+					RedirectCode:  http.StatusTemporaryRedirect,
 				},
 			},
+			results: []models.BatchResult{
+				{ID: "abcde", Created: true},
+				{ID: "stored"},
+			},
+			stored: []models.Link{{ID: "stored", OriginalURL: "https://github.com"}},
 			exp: []models.UserURL{
 				{
 					CorrelationID: "1",
 					ShortURL:      "http://localhost:8080/abcde",
 					OriginalURL:   "https://yandex.ru",
+					NormalizedURL: "https://yandex.ru/",
+					RedirectCode:  http.StatusTemporaryRedirect,
 				},
 				{
 					CorrelationID: "2",
-					ShortURL:      "http://localhost:8080/qwerty",
+					ShortURL:      "http://localhost:8080/stored",
 					OriginalURL:   "https://github.com",
+					NormalizedURL: "https://github.com/",
+					RedirectCode:  http.StatusTemporaryRedirect,
+					Existing:      true,
 				},
 			},
 			err: nil,
@@ -204,11 +657,15 @@ func Test_service_ShortenBatch(t *testing.T) {
 					CorrelationID: "1",
 					ShortURL:      "abcde",
 					OriginalURL:   "https://yandex.ru",
+					NormalizedURL: "https://yandex.ru/",
+					RedirectCode:  http.StatusTemporaryRedirect,
 				},
 				{
 					CorrelationID: "2",
 					ShortURL:      "qwerty",
 					OriginalURL:   "https://github.com",
+					NormalizedURL: "https://github.com/",
+					RedirectCode:  http.StatusTemporaryRedirect,
 				},
 			},
 			err: errors.New("test err"),
@@ -222,17 +679,117 @@ func Test_service_ShortenBatch(t *testing.T) {
 
 	for _, tt := range tests {
 		repositoryMock := mocks.NewMockurlRepository(ctrl)
-		repositoryMock.EXPECT().AddBatch(ctx, tt.urls, defaultUserID).Return(tt.err)
+		repositoryMock.EXPECT().AddBatch(ctx, tt.urls, defaultUserID).Return(tt.results, tt.err)
+		for _, link := range tt.stored {
+			repositoryMock.EXPECT().GetLink(ctx, link.ID).Return(link, nil)
+		}
 
 		generatorMock := mocks.NewMockgenerator(ctrl)
+		normalizerMock := mocks.NewMocknormalizer(ctrl)
+		blocklistMock := mocks.NewMockblocklist(ctrl)
 		for _, url := range tt.urls {
 			generatorMock.EXPECT().Letters(idLength).Return(url.ShortURL, nil)
+			normalizerMock.EXPECT().Normalize(url.OriginalURL).Return(url.NormalizedURL, nil)
+			blocklistMock.EXPECT().Check(url.OriginalURL, url.NormalizedURL).Return(nil)
 		}
 
-		s := NewService(repositoryMock, generatorMock, host)
+		s := NewService(repositoryMock, generatorMock, normalizerMock, blocklistMock, host, http.StatusTemporaryRedirect, nil)
 		act, err := s.ShortenBatch(ctx, tt.originalURLs, defaultUserID)
 
 		assert.Equal(t, tt.err, err)
 		assert.Equal(t, tt.exp, act)
 	}
 }
+
+func Test_service_ShortenBatch_TakenID(t *testing.T) {
+	tests := []struct {
+		name     string
+		retries  []models.BatchResult
+		expURL   string
+		expError error
+	}{
+		{
+			name:    "retried under a new id",
+			retries: []models.BatchResult{{ID: "fresh", Created: true}},
+			expURL:  "http://localhost:8080/fresh",
+		},
+		{
+			name:     "attempts exhausted",
+			retries:  []models.BatchResult{{}, {}},
+			expError: errs.ErrURLIDConflict,
+		},
+	}
+
+	ctx := context.Background()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	batchURL := models.UserURL{
+		CorrelationID: "1",
+		ShortURL:      "taken",
+		OriginalURL:   "https://yandex.ru",
+		NormalizedURL: "https://yandex.ru/",
+		RedirectCode:  http.StatusTemporaryRedirect,
+	}
+
+	for _, tt := range tests {
+		repositoryMock := mocks.NewMockurlRepository(ctrl)
+		generatorMock := mocks.NewMockgenerator(ctrl)
+		normalizerMock := mocks.NewMocknormalizer(ctrl)
+		blocklistMock := mocks.NewMockblocklist(ctrl)
+
+		generatorMock.EXPECT().Letters(idLength).Return(batchURL.ShortURL, nil)
+		normalizerMock.EXPECT().Normalize(batchURL.OriginalURL).Return(batchURL.NormalizedURL, nil)
+		blocklistMock.EXPECT().Check(batchURL.OriginalURL, batchURL.NormalizedURL).Return(nil)
+
+		calls := []*gomock.Call{repositoryMock.EXPECT().AddBatch(ctx, []models.UserURL{batchURL}, defaultUserID).Return([]models.BatchResult{{}}, nil)}
+		for idx, result := range tt.retries {
+			retried := batchURL
+			retried.ShortURL = fmt.Sprintf("retry%d", idx)
+			if result.ID != "" {
+				retried.ShortURL = result.ID
+			}
+
+			generatorMock.EXPECT().Letters(idLength).Return(retried.ShortURL, nil)
+			calls = append(calls, repositoryMock.EXPECT().AddBatch(ctx, []models.UserURL{retried}, defaultUserID).Return([]models.BatchResult{result}, nil))
+		}
+		gomock.InOrder(calls...)
+
+		s := NewService(repositoryMock, generatorMock, normalizerMock, blocklistMock, host, http.StatusTemporaryRedirect, nil)
+		act, err := s.ShortenBatch(ctx, []models.OriginalURL{{CorrelationID: "1", URL: batchURL.OriginalURL}}, defaultUserID)
+
+		assert.ErrorIs(t, err, tt.expError, tt.name)
+		if tt.expError == nil {
+			assert.Len(t, act, 1, tt.name)
+			assert.Equal(t, tt.expURL, act[0].ShortURL, tt.name)
+		}
+	}
+}
+
+func Test_service_PublishesEvents(t *testing.T) {
+	ctx := context.Background()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	link := models.Link{ID: "abcde", OriginalURL: "https://example.com", UserID: "user"}
+
+	repositoryMock := mocks.NewMockurlRepository(ctrl)
+	repositoryMock.EXPECT().Get(ctx, "abcde").Return(link, nil)
+
+	var event models.Event
+	publisherMock := mocks.NewMockpublisher(ctrl)
+	publisherMock.EXPECT().Publish(gomock.Any()).Do(func(e models.Event) { event = e })
+
+	s := NewService(repositoryMock, nil, nil, nil, host, http.StatusTemporaryRedirect, publisherMock)
+	_, err := s.Expand(ctx, "abcde", models.Visit{UserAgent: "curl/8.0"})
+	assert.NoError(t, err)
+
+	assert.Equal(t, models.EventLinkClicked, event.Type)
+	assert.Equal(t, "user", event.UserID)
+	assert.Equal(t, host+"/abcde", event.ShortURL)
+	assert.Equal(t, "https://example.com", event.Target)
+	assert.Equal(t, "curl/8.0", event.UserAgent)
+	assert.False(t, event.OccurredAt.IsZero())
+}
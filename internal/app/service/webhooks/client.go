@@ -0,0 +1,62 @@
+package webhooks
+
+import (
+	"fmt"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// NewClient makes the client deliveries are sent with. It connects to public addresses only, whatever
+// the host of a webhook resolves to at the time, so that webhooks can't reach the services around the
+// shortener. Proxies are not used, they would hide the address connected to.
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   timeout,
+		KeepAlive: 30 * time.Second,
+		Control:   publicOnly,
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+	}
+}
+
+// publicOnly refuses connections to addresses checkHost refuses, it is called with the resolved address
+func publicOnly(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	return checkHost(host)
+}
+
+// checkHost refuses loopback, private, link-local, multicast and unspecified addresses and the names of
+// the local host. Other names are checked once resolved, when a delivery connects.
+func checkHost(host string) error {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("%w: %s is not a public host", errs.ErrInvalidWebhook, host)
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil
+	}
+
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return fmt.Errorf("%w: %s is not a public address", errs.ErrInvalidWebhook, host)
+	}
+
+	return nil
+}
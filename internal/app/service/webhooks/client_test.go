@@ -0,0 +1,47 @@
+package webhooks
+
+import (
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewClient(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	req, err := http.NewRequest(http.MethodPost, receiver.URL, nil)
+	require.NoError(t, err)
+
+	_, err = NewClient(time.Second).Do(req)
+	assert.ErrorIs(t, err, errs.ErrInvalidWebhook)
+}
+
+func Test_publicOnly(t *testing.T) {
+	tests := []struct {
+		address string
+		err     error
+	}{
+		{address: "93.184.216.34:443"},
+		{address: "[2606:2800:220:1:248:1893:25c8:1946]:443"},
+		{address: "127.0.0.1:80", err: errs.ErrInvalidWebhook},
+		{address: "[::1]:80", err: errs.ErrInvalidWebhook},
+		{address: "172.16.0.1:80", err: errs.ErrInvalidWebhook},
+		{address: "[fd00::1]:80", err: errs.ErrInvalidWebhook},
+		{address: "169.254.169.254:80", err: errs.ErrInvalidWebhook},
+		{address: "[fe80::1]:80", err: errs.ErrInvalidWebhook},
+		{address: "0.0.0.0:80", err: errs.ErrInvalidWebhook},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			assert.ErrorIs(t, publicOnly("tcp", tt.address, nil), tt.err)
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ChristinaFomenko/shortener/internal/app/models"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	batchSize     = 100
+	pollInterval  = time.Second
+	maxBackoff    = time.Hour
+	maxErrorSize  = 256
+	drainBodySize = 4 << 10
+)
+
+type queue interface {
+	Webhook(ctx context.Context, hookID string) (models.Webhook, error)
+	Due(ctx context.Context, now time.Time, limit int) ([]models.Delivery, error)
+	Reschedule(ctx context.Context, delivery models.Delivery) error
+	Complete(ctx context.Context, deliveryID string) error
+	Bury(ctx context.Context, delivery models.Delivery) error
+}
+
+type dispatcher struct {
+	queue       queue
+	client      *http.Client
+	workers     int
+	maxAttempts int
+	backoff     time.Duration
+	wake        chan struct{}
+	now         func() time.Time
+}
+
+// NewDispatcher sends queued deliveries with up to workers requests at a time. A failed delivery
+// is retried after backoff, doubled with every attempt up to an hour, and moved to the dead
+// letters after maxAttempts.
+func NewDispatcher(queue queue, client *http.Client, workers, maxAttempts int, backoff time.Duration) *dispatcher {
+	return &dispatcher{
+		queue:       queue,
+		client:      client,
+		workers:     workers,
+		maxAttempts: maxAttempts,
+		backoff:     backoff,
+		wake:        make(chan struct{}, 1),
+		now:         time.Now,
+	}
+}
+
+// Wake makes the dispatcher look for due deliveries without waiting for the next poll
+func (d *dispatcher) Wake() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run sends due deliveries until ctx is done
+func (d *dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		for d.dispatch(ctx) == batchSize && ctx.Err() == nil {
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// dispatch sends one batch of due deliveries and returns its size
+func (d *dispatcher) dispatch(ctx context.Context) int {
+	due, err := d.queue.Due(ctx, d.now(), batchSize)
+	if err != nil {
+		log.WithError(err).Error("get due webhook deliveries error")
+		return 0
+	}
+
+	jobs := make(chan models.Delivery)
+	var wg sync.WaitGroup
+	for i := 0; i < d.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for delivery := range jobs {
+				d.attempt(ctx, delivery)
+			}
+		}()
+	}
+
+	for _, delivery := range due {
+		jobs <- delivery
+	}
+	close(jobs)
+	wg.Wait()
+
+	return len(due)
+}
+
+func (d *dispatcher) attempt(ctx context.Context, delivery models.Delivery) {
+	logger := log.WithField("deliveryID", delivery.ID).WithField("webhookID", delivery.WebhookID)
+
+	hook, err := d.queue.Webhook(ctx, delivery.WebhookID)
+	if errors.Is(err, errs.ErrWebhookNotFound) {
+		if err = d.queue.Complete(ctx, delivery.ID); err != nil {
+			logger.WithError(err).Error("drop webhook delivery error")
+		}
+		return
+	}
+	if err != nil {
+		logger.WithError(err).Error("get webhook error")
+		return
+	}
+
+	err = d.send(ctx, hook, delivery)
+	if ctx.Err() != nil {
+		// shutting down, the attempt is not counted and the delivery stays due
+		return
+	}
+
+	if err == nil {
+		if err = d.queue.Complete(ctx, delivery.ID); err != nil {
+			logger.WithError(err).Error("complete webhook delivery error")
+		}
+		return
+	}
+
+	delivery.Attempts++
+	delivery.LastError = truncate(err.Error(), maxErrorSize)
+	delivery.NextAttempt = d.now().Add(d.delay(delivery.Attempts))
+
+	if delivery.Attempts >= d.maxAttempts {
+		logger.WithError(err).WithField("attempts", delivery.Attempts).Warn("webhook delivery failed, moved to dead letters")
+		if err = d.queue.Bury(ctx, delivery); err != nil {
+			logger.WithError(err).Error("bury webhook delivery error")
+		}
+		return
+	}
+
+	logger.WithError(err).WithField("attempts", delivery.Attempts).Info("webhook delivery failed, will retry")
+	if err = d.queue.Reschedule(ctx, delivery); err != nil {
+		logger.WithError(err).Error("reschedule webhook delivery error")
+	}
+}
+
+func (d *dispatcher) send(ctx context.Context, hook models.Webhook, delivery models.Delivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderDelivery, delivery.ID)
+	req.Header.Set(HeaderSignature, Sign(hook.Secret, d.now(), delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer func(body io.ReadCloser) {
+		// reading a bit of the body lets the connection be reused
+		_, _ = io.CopyN(io.Discard, body, drainBodySize)
+		_ = body.Close()
+	}(resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// delay returns the wait before the attempt after the given number of failed ones
+func (d *dispatcher) delay(attempts int) time.Duration {
+	delay := d.backoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+
+	if delay > maxBackoff {
+		return maxBackoff
+	}
+
+	return delay
+}
+
+func truncate(s string, size int) string {
+	if len(s) <= size {
+		return s
+	}
+
+	return s[:size]
+}
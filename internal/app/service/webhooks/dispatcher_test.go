@@ -0,0 +1,118 @@
+package webhooks
+
+import (
+	"context"
+	"github.com/ChristinaFomenko/shortener/internal/app/models"
+	repositoryWebhooks "github.com/ChristinaFomenko/shortener/internal/app/repository/webhooks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDispatcher_Deliver(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+	payload := []byte(`{"id":"delivery","type":"link.clicked"}`)
+
+	received := make(chan *http.Request, 1)
+	var body []byte
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		received <- r
+	}))
+	defer receiver.Close()
+
+	repo, err := repositoryWebhooks.NewRepo("")
+	require.NoError(t, err)
+	require.NoError(t, repo.AddWebhook(ctx, models.Webhook{ID: "hook", UserID: "user", URL: receiver.URL, Secret: "secret"}))
+	require.NoError(t, repo.Enqueue(ctx, []models.Delivery{
+		{ID: "delivery", WebhookID: "hook", UserID: "user", Event: models.EventLinkClicked, Payload: payload, NextAttempt: now},
+	}))
+
+	d := NewDispatcher(repo, receiver.Client(), 2, 3, time.Second)
+	d.now = func() time.Time { return now }
+
+	assert.Equal(t, 1, d.dispatch(ctx))
+
+	r := <-received
+	assert.Equal(t, payload, body)
+	assert.Equal(t, models.EventLinkClicked, r.Header.Get(HeaderEvent))
+	assert.Equal(t, "delivery", r.Header.Get(HeaderDelivery))
+	assert.Equal(t, Sign("secret", now, payload), r.Header.Get(HeaderSignature))
+	assert.Equal(t, 0, repo.QueueLen())
+}
+
+func TestDispatcher_RetryAndBury(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+
+	var calls int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer receiver.Close()
+
+	repo, err := repositoryWebhooks.NewRepo("")
+	require.NoError(t, err)
+	require.NoError(t, repo.AddWebhook(ctx, models.Webhook{ID: "hook", UserID: "user", URL: receiver.URL, Secret: "secret"}))
+	require.NoError(t, repo.Enqueue(ctx, []models.Delivery{
+		{ID: "delivery", WebhookID: "hook", UserID: "user", Payload: []byte(`{}`), NextAttempt: now},
+	}))
+
+	d := NewDispatcher(repo, receiver.Client(), 1, 3, time.Second)
+	d.now = func() time.Time { return now }
+
+	assert.Equal(t, 1, d.dispatch(ctx))
+
+	due, err := repo.Due(ctx, now.Add(time.Second), 10)
+	require.NoError(t, err)
+	require.Len(t, due, 1)
+	assert.Equal(t, 1, due[0].Attempts)
+	assert.Equal(t, now.Add(time.Second), due[0].NextAttempt)
+	assert.Equal(t, "unexpected status 503", due[0].LastError)
+
+	// not due before the backoff is over
+	assert.Equal(t, 0, d.dispatch(ctx))
+
+	now = now.Add(time.Second)
+	assert.Equal(t, 1, d.dispatch(ctx))
+
+	now = now.Add(2 * time.Second)
+	assert.Equal(t, 1, d.dispatch(ctx))
+
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+	assert.Equal(t, 0, repo.QueueLen())
+
+	dead, err := repo.DeadLetters(ctx, "user")
+	require.NoError(t, err)
+	require.Len(t, dead, 1)
+	assert.Equal(t, 3, dead[0].Attempts)
+}
+
+func TestDispatcher_DeletedWebhook(t *testing.T) {
+	ctx := context.Background()
+
+	repo, err := repositoryWebhooks.NewRepo("")
+	require.NoError(t, err)
+	require.NoError(t, repo.Enqueue(ctx, []models.Delivery{{ID: "delivery", WebhookID: "gone", NextAttempt: time.Now()}}))
+
+	d := NewDispatcher(repo, http.DefaultClient, 1, 3, time.Second)
+
+	assert.Equal(t, 1, d.dispatch(ctx))
+	assert.Equal(t, 0, repo.QueueLen())
+}
+
+func Test_dispatcher_delay(t *testing.T) {
+	d := NewDispatcher(nil, nil, 1, 100, 30*time.Second)
+
+	assert.Equal(t, 30*time.Second, d.delay(1))
+	assert.Equal(t, time.Minute, d.delay(2))
+	assert.Equal(t, 8*time.Minute, d.delay(5))
+	assert.Equal(t, time.Hour, d.delay(50))
+}
@@ -0,0 +1,249 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: webhooks.go
+
+// Package mock_webhooks is a generated GoMock package.
+package mock_webhooks
+
+import (
+	context "context"
+	reflect "reflect"
+	time "time"
+
+	models "github.com/ChristinaFomenko/shortener/internal/app/models"
+	gomock "github.com/golang/mock/gomock"
+)
+
+// Mockrepository is a mock of repository interface.
+type Mockrepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockrepositoryMockRecorder
+}
+
+// MockrepositoryMockRecorder is the mock recorder for Mockrepository.
+type MockrepositoryMockRecorder struct {
+	mock *Mockrepository
+}
+
+// NewMockrepository creates a new mock instance.
+func NewMockrepository(ctrl *gomock.Controller) *Mockrepository {
+	mock := &Mockrepository{ctrl: ctrl}
+	mock.recorder = &MockrepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *Mockrepository) EXPECT() *MockrepositoryMockRecorder {
+	return m.recorder
+}
+
+// AddWebhook mocks base method.
+func (m *Mockrepository) AddWebhook(ctx context.Context, hook models.Webhook) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddWebhook", ctx, hook)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddWebhook indicates an expected call of AddWebhook.
+func (mr *MockrepositoryMockRecorder) AddWebhook(ctx, hook interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddWebhook", reflect.TypeOf((*Mockrepository)(nil).AddWebhook), ctx, hook)
+}
+
+// DeadLetters mocks base method.
+func (m *Mockrepository) DeadLetters(ctx context.Context, userID string) ([]models.Delivery, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeadLetters", ctx, userID)
+	ret0, _ := ret[0].([]models.Delivery)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeadLetters indicates an expected call of DeadLetters.
+func (mr *MockrepositoryMockRecorder) DeadLetters(ctx, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeadLetters", reflect.TypeOf((*Mockrepository)(nil).DeadLetters), ctx, userID)
+}
+
+// DeleteWebhook mocks base method.
+func (m *Mockrepository) DeleteWebhook(ctx context.Context, userID, hookID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteWebhook", ctx, userID, hookID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteWebhook indicates an expected call of DeleteWebhook.
+func (mr *MockrepositoryMockRecorder) DeleteWebhook(ctx, userID, hookID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteWebhook", reflect.TypeOf((*Mockrepository)(nil).DeleteWebhook), ctx, userID, hookID)
+}
+
+// Enqueue mocks base method.
+func (m *Mockrepository) Enqueue(ctx context.Context, deliveries []models.Delivery) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Enqueue", ctx, deliveries)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Enqueue indicates an expected call of Enqueue.
+func (mr *MockrepositoryMockRecorder) Enqueue(ctx, deliveries interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Enqueue", reflect.TypeOf((*Mockrepository)(nil).Enqueue), ctx, deliveries)
+}
+
+// Requeue mocks base method.
+func (m *Mockrepository) Requeue(ctx context.Context, userID, deliveryID string, now time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Requeue", ctx, userID, deliveryID, now)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Requeue indicates an expected call of Requeue.
+func (mr *MockrepositoryMockRecorder) Requeue(ctx, userID, deliveryID, now interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Requeue", reflect.TypeOf((*Mockrepository)(nil).Requeue), ctx, userID, deliveryID, now)
+}
+
+// Subscribers mocks base method.
+func (m *Mockrepository) Subscribers(ctx context.Context, event models.Event) ([]models.Webhook, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Subscribers", ctx, event)
+	ret0, _ := ret[0].([]models.Webhook)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Subscribers indicates an expected call of Subscribers.
+func (mr *MockrepositoryMockRecorder) Subscribers(ctx, event interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Subscribers", reflect.TypeOf((*Mockrepository)(nil).Subscribers), ctx, event)
+}
+
+// Webhooks mocks base method.
+func (m *Mockrepository) Webhooks(ctx context.Context, userID string) ([]models.Webhook, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Webhooks", ctx, userID)
+	ret0, _ := ret[0].([]models.Webhook)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Webhooks indicates an expected call of Webhooks.
+func (mr *MockrepositoryMockRecorder) Webhooks(ctx, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Webhooks", reflect.TypeOf((*Mockrepository)(nil).Webhooks), ctx, userID)
+}
+
+// MocklinkRepository is a mock of linkRepository interface.
+type MocklinkRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MocklinkRepositoryMockRecorder
+}
+
+// MocklinkRepositoryMockRecorder is the mock recorder for MocklinkRepository.
+type MocklinkRepositoryMockRecorder struct {
+	mock *MocklinkRepository
+}
+
+// NewMocklinkRepository creates a new mock instance.
+func NewMocklinkRepository(ctrl *gomock.Controller) *MocklinkRepository {
+	mock := &MocklinkRepository{ctrl: ctrl}
+	mock.recorder = &MocklinkRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MocklinkRepository) EXPECT() *MocklinkRepositoryMockRecorder {
+	return m.recorder
+}
+
+// GetLink mocks base method.
+func (m *MocklinkRepository) GetLink(ctx context.Context, urlID string) (models.Link, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetLink", ctx, urlID)
+	ret0, _ := ret[0].(models.Link)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetLink indicates an expected call of GetLink.
+func (mr *MocklinkRepositoryMockRecorder) GetLink(ctx, urlID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetLink", reflect.TypeOf((*MocklinkRepository)(nil).GetLink), ctx, urlID)
+}
+
+// Mockgenerator is a mock of generator interface.
+type Mockgenerator struct {
+	ctrl     *gomock.Controller
+	recorder *MockgeneratorMockRecorder
+}
+
+// MockgeneratorMockRecorder is the mock recorder for Mockgenerator.
+type MockgeneratorMockRecorder struct {
+	mock *Mockgenerator
+}
+
+// NewMockgenerator creates a new mock instance.
+func NewMockgenerator(ctrl *gomock.Controller) *Mockgenerator {
+	mock := &Mockgenerator{ctrl: ctrl}
+	mock.recorder = &MockgeneratorMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *Mockgenerator) EXPECT() *MockgeneratorMockRecorder {
+	return m.recorder
+}
+
+// Letters mocks base method.
+func (m *Mockgenerator) Letters(n int64) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Letters", n)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Letters indicates an expected call of Letters.
+func (mr *MockgeneratorMockRecorder) Letters(n interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Letters", reflect.TypeOf((*Mockgenerator)(nil).Letters), n)
+}
+
+// Mockwaker is a mock of waker interface.
+type Mockwaker struct {
+	ctrl     *gomock.Controller
+	recorder *MockwakerMockRecorder
+}
+
+// MockwakerMockRecorder is the mock recorder for Mockwaker.
+type MockwakerMockRecorder struct {
+	mock *Mockwaker
+}
+
+// NewMockwaker creates a new mock instance.
+func NewMockwaker(ctrl *gomock.Controller) *Mockwaker {
+	mock := &Mockwaker{ctrl: ctrl}
+	mock.recorder = &MockwakerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *Mockwaker) EXPECT() *MockwakerMockRecorder {
+	return m.recorder
+}
+
+// Wake mocks base method.
+func (m *Mockwaker) Wake() {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Wake")
+}
+
+// Wake indicates an expected call of Wake.
+func (mr *MockwakerMockRecorder) Wake() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Wake", reflect.TypeOf((*Mockwaker)(nil).Wake))
+}
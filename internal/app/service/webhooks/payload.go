@@ -0,0 +1,75 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/ChristinaFomenko/shortener/internal/app/models"
+	"strconv"
+	"time"
+)
+
+// headers of a delivery, receivers check the signature against the raw body
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+type linkPayload struct {
+	ID          string `json:"id"`
+	ShortURL    string `json:"short_url"`
+	OriginalURL string `json:"original_url"`
+}
+
+type clickPayload struct {
+	Target    string `json:"target"`
+	Variant   string `json:"variant,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+}
+
+type payload struct {
+	ID         string        `json:"id"`
+	Type       string        `json:"type"`
+	OccurredAt time.Time     `json:"occurred_at"`
+	Link       linkPayload   `json:"link"`
+	Click      *clickPayload `json:"click,omitempty"`
+}
+
+func toPayload(deliveryID string, event models.Event) payload {
+	p := payload{
+		ID:         deliveryID,
+		Type:       event.Type,
+		OccurredAt: event.OccurredAt.UTC(),
+		Link: linkPayload{
+			ID:          event.URLID,
+			ShortURL:    event.ShortURL,
+			OriginalURL: event.OriginalURL,
+		},
+	}
+
+	if event.Type == models.EventLinkClicked {
+		p.Click = &clickPayload{
+			Target:    event.Target,
+			Variant:   event.Variant,
+			UserAgent: event.UserAgent,
+		}
+	}
+
+	return p
+}
+
+// Sign returns the signature header of a body sent at the time: "t=<unix seconds>,v1=<hex hmac>",
+// the HMAC-SHA256 with the webhook secret is taken over "<unix seconds>.<body>" so a captured
+// delivery can't be replayed with another timestamp
+func Sign(secret string, at time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return fmt.Sprintf("t=%s,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
@@ -0,0 +1,253 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ChristinaFomenko/shortener/internal/app/models"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+//go:generate mockgen -source=webhooks.go -destination=mocks/webhooks.go
+
+const (
+	idLength      int64 = 12
+	secretLength        = 32
+	maxUserHooks        = 20
+	maxWebhookURL       = 2000
+)
+
+type repository interface {
+	AddWebhook(ctx context.Context, hook models.Webhook) error
+	DeleteWebhook(ctx context.Context, userID, hookID string) error
+	Webhooks(ctx context.Context, userID string) ([]models.Webhook, error)
+	Subscribers(ctx context.Context, event models.Event) ([]models.Webhook, error)
+	Enqueue(ctx context.Context, deliveries []models.Delivery) error
+	DeadLetters(ctx context.Context, userID string) ([]models.Delivery, error)
+	Requeue(ctx context.Context, userID, deliveryID string, now time.Time) error
+}
+
+type linkRepository interface {
+	GetLink(ctx context.Context, urlID string) (models.Link, error)
+}
+
+type generator interface {
+	Letters(n int64) (string, error)
+}
+
+type waker interface {
+	Wake()
+}
+
+type service struct {
+	repository repository
+	links      linkRepository
+	generator  generator
+	dispatcher waker
+	events     chan models.Event
+	dropped    uint64
+}
+
+// NewService registers webhooks and queues their deliveries. Events are buffered up to bufferSize
+// and queued in the background, so publishing never waits for the storage.
+func NewService(repository repository, links linkRepository, generator generator, dispatcher waker, bufferSize int) *service {
+	return &service{
+		repository: repository,
+		links:      links,
+		generator:  generator,
+		dispatcher: dispatcher,
+		events:     make(chan models.Event, bufferSize),
+	}
+}
+
+// Register adds a webhook for all links of the user or, with URLID set, one link they own.
+// The returned webhook carries the generated secret its payloads are signed with.
+func (s *service) Register(ctx context.Context, hook models.Webhook) (models.Webhook, error) {
+	if err := validateWebhook(hook); err != nil {
+		return models.Webhook{}, err
+	}
+
+	if hook.URLID != "" {
+		link, err := s.links.GetLink(ctx, hook.URLID)
+		if err != nil && !errors.Is(err, errs.ErrURLNotFound) {
+			return models.Webhook{}, err
+		}
+		if err != nil || link.Deleted() || link.UserID != hook.UserID {
+			return models.Webhook{}, errs.ErrURLNotFound
+		}
+	}
+
+	hooks, err := s.repository.Webhooks(ctx, hook.UserID)
+	if err != nil {
+		return models.Webhook{}, err
+	}
+
+	if len(hooks) >= maxUserHooks {
+		return models.Webhook{}, fmt.Errorf("%w: at most %d webhooks per user", errs.ErrInvalidWebhook, maxUserHooks)
+	}
+
+	if hook.ID, err = s.generator.Letters(idLength); err != nil {
+		return models.Webhook{}, err
+	}
+
+	if hook.Secret, err = newSecret(); err != nil {
+		return models.Webhook{}, err
+	}
+
+	hook.CreatedAt = time.Now()
+	if err = s.repository.AddWebhook(ctx, hook); err != nil {
+		log.WithError(err).WithField("userID", hook.UserID).Error("add webhook error")
+		return models.Webhook{}, err
+	}
+
+	return hook, nil
+}
+
+func (s *service) Webhooks(ctx context.Context, userID string) ([]models.Webhook, error) {
+	return s.repository.Webhooks(ctx, userID)
+}
+
+func (s *service) Delete(ctx context.Context, userID, hookID string) error {
+	return s.repository.DeleteWebhook(ctx, userID, hookID)
+}
+
+func (s *service) DeadLetters(ctx context.Context, userID string) ([]models.Delivery, error) {
+	return s.repository.DeadLetters(ctx, userID)
+}
+
+// Retry sends a dead letter again with fresh attempts
+func (s *service) Retry(ctx context.Context, userID, deliveryID string) error {
+	if err := s.repository.Requeue(ctx, userID, deliveryID, time.Now()); err != nil {
+		return err
+	}
+
+	s.dispatcher.Wake()
+
+	return nil
+}
+
+// Publish hands the event over without waiting, it is dropped when the buffer is full
+func (s *service) Publish(event models.Event) {
+	select {
+	case s.events <- event:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+		log.WithField("event", event.Type).WithField("urlID", event.URLID).Warn("webhook event buffer full, event dropped")
+	}
+}
+
+// Pending returns the number of published events not queued yet
+func (s *service) Pending() int {
+	return len(s.events)
+}
+
+// Capacity returns how many published events may wait to be queued
+func (s *service) Capacity() int {
+	return cap(s.events)
+}
+
+// Dropped returns the number of events lost to a full buffer
+func (s *service) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Run queues deliveries of published events until ctx is done, then queues what is left in the buffer
+func (s *service) Run(ctx context.Context) {
+	for {
+		select {
+		case event := <-s.events:
+			s.enqueue(event)
+		case <-ctx.Done():
+			for {
+				select {
+				case event := <-s.events:
+					s.enqueue(event)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// enqueue runs detached from request contexts, a click must be queued after its request is gone
+func (s *service) enqueue(event models.Event) {
+	ctx := context.Background()
+
+	hooks, err := s.repository.Subscribers(ctx, event)
+	if err != nil {
+		log.WithError(err).WithField("event", event.Type).Error("find webhook subscribers error")
+		return
+	}
+
+	if len(hooks) == 0 {
+		return
+	}
+
+	deliveries := make([]models.Delivery, 0, len(hooks))
+	for _, hook := range hooks {
+		id, err := s.generator.Letters(idLength)
+		if err != nil {
+			log.WithError(err).Error("generate delivery id error")
+			return
+		}
+
+		payload, err := json.Marshal(toPayload(id, event))
+		if err != nil {
+			log.WithError(err).WithField("event", event.Type).Error("marshal webhook payload error")
+			return
+		}
+
+		deliveries = append(deliveries, models.Delivery{
+			ID:          id,
+			WebhookID:   hook.ID,
+			UserID:      hook.UserID,
+			Event:       event.Type,
+			Payload:     payload,
+			NextAttempt: event.OccurredAt,
+			CreatedAt:   time.Now(),
+		})
+	}
+
+	if err = s.repository.Enqueue(ctx, deliveries); err != nil {
+		log.WithError(err).WithField("event", event.Type).Error("enqueue webhook deliveries error")
+		return
+	}
+
+	s.dispatcher.Wake()
+}
+
+func validateWebhook(hook models.Webhook) error {
+	u, err := url.Parse(hook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || len(hook.URL) > maxWebhookURL {
+		return fmt.Errorf("%w: absolute http or https url expected", errs.ErrInvalidWebhook)
+	}
+
+	if err = checkHost(u.Hostname()); err != nil {
+		return err
+	}
+
+	for _, name := range hook.Events {
+		if !models.ValidEvent(name) {
+			return fmt.Errorf("%w: unknown event %q", errs.ErrInvalidWebhook, name)
+		}
+	}
+
+	return nil
+}
+
+func newSecret() (string, error) {
+	secret := make([]byte, secretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("webhook secret generation error: %w", err)
+	}
+
+	return hex.EncodeToString(secret), nil
+}
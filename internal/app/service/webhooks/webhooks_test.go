@@ -0,0 +1,175 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/ChristinaFomenko/shortener/internal/app/models"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+
+	mocks "github.com/ChristinaFomenko/shortener/internal/app/service/webhooks/mocks"
+)
+
+func Test_service_Register(t *testing.T) {
+	tests := []struct {
+		name string
+		hook models.Webhook
+		link models.Link
+		err  error
+	}{
+		{
+			name: "account",
+			hook: models.Webhook{UserID: "user", URL: "https://example.com/hook"},
+		},
+		{
+			name: "own link",
+			hook: models.Webhook{UserID: "user", URLID: "abcde", URL: "http://example.com:9000/hook", Events: []string{models.EventLinkClicked}},
+			link: models.Link{ID: "abcde", UserID: "user"},
+		},
+		{
+			name: "link of another user",
+			hook: models.Webhook{UserID: "user", URLID: "abcde", URL: "https://example.com/hook"},
+			link: models.Link{ID: "abcde", UserID: "other"},
+			err:  errs.ErrURLNotFound,
+		},
+		{
+			name: "not http",
+			hook: models.Webhook{UserID: "user", URL: "ftp://example.com/hook"},
+			err:  errs.ErrInvalidWebhook,
+		},
+		{
+			name: "loopback",
+			hook: models.Webhook{UserID: "user", URL: "http://127.0.0.1:9000/hook"},
+			err:  errs.ErrInvalidWebhook,
+		},
+		{
+			name: "local host name",
+			hook: models.Webhook{UserID: "user", URL: "http://LOCALHOST./hook"},
+			err:  errs.ErrInvalidWebhook,
+		},
+		{
+			name: "private",
+			hook: models.Webhook{UserID: "user", URL: "http://10.0.0.5/hook"},
+			err:  errs.ErrInvalidWebhook,
+		},
+		{
+			name: "link-local",
+			hook: models.Webhook{UserID: "user", URL: "http://169.254.169.254/latest/meta-data"},
+			err:  errs.ErrInvalidWebhook,
+		},
+		{
+			name: "unspecified ipv6",
+			hook: models.Webhook{UserID: "user", URL: "http://[::]:8080/hook"},
+			err:  errs.ErrInvalidWebhook,
+		},
+		{
+			name: "ipv4 mapped private",
+			hook: models.Webhook{UserID: "user", URL: "http://[::ffff:192.168.1.1]/hook"},
+			err:  errs.ErrInvalidWebhook,
+		},
+		{
+			name: "unknown event",
+			hook: models.Webhook{UserID: "user", URL: "https://example.com/hook", Events: []string{"link.deleted"}},
+			err:  errs.ErrInvalidWebhook,
+		},
+	}
+
+	ctx := context.Background()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repositoryMock := mocks.NewMockrepository(ctrl)
+			linksMock := mocks.NewMocklinkRepository(ctrl)
+			generatorMock := mocks.NewMockgenerator(ctrl)
+
+			if tt.hook.URLID != "" {
+				linksMock.EXPECT().GetLink(ctx, tt.hook.URLID).Return(tt.link, nil)
+			}
+			if tt.err == nil {
+				repositoryMock.EXPECT().Webhooks(ctx, tt.hook.UserID).Return(nil, nil)
+				generatorMock.EXPECT().Letters(idLength).Return("hookid", nil)
+				repositoryMock.EXPECT().AddWebhook(ctx, gomock.Any()).Return(nil)
+			}
+
+			s := NewService(repositoryMock, linksMock, generatorMock, nil, 1)
+			act, err := s.Register(ctx, tt.hook)
+
+			assert.ErrorIs(t, err, tt.err)
+			if tt.err == nil {
+				assert.Equal(t, "hookid", act.ID)
+				assert.Len(t, act.Secret, 2*secretLength)
+			}
+		})
+	}
+}
+
+func Test_service_Publish(t *testing.T) {
+	s := NewService(nil, nil, nil, nil, 1)
+
+	s.Publish(models.Event{Type: models.EventLinkClicked})
+	s.Publish(models.Event{Type: models.EventLinkClicked})
+
+	assert.Equal(t, 1, s.Pending())
+	assert.Equal(t, uint64(1), s.Dropped())
+}
+
+func Test_service_Run(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	event := models.Event{
+		Type:        models.EventLinkClicked,
+		URLID:       "abcde",
+		UserID:      "user",
+		OriginalURL: "https://example.com",
+		ShortURL:    "http://localhost:8080/abcde",
+		Target:      "https://example.com/b",
+		Variant:     "b",
+		OccurredAt:  time.Now(),
+	}
+
+	repositoryMock := mocks.NewMockrepository(ctrl)
+	generatorMock := mocks.NewMockgenerator(ctrl)
+	wakerMock := mocks.NewMockwaker(ctrl)
+
+	var queued []models.Delivery
+	repositoryMock.EXPECT().Subscribers(gomock.Any(), event).Return([]models.Webhook{{ID: "hook", UserID: "user"}}, nil)
+	generatorMock.EXPECT().Letters(idLength).Return("delivery", nil)
+	repositoryMock.EXPECT().Enqueue(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, deliveries []models.Delivery) error {
+		queued = deliveries
+		return nil
+	})
+	wakerMock.EXPECT().Wake()
+
+	s := NewService(repositoryMock, nil, generatorMock, wakerMock, 1)
+	s.Publish(event)
+
+	// a cancelled context still queues what was published before
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.Run(ctx)
+
+	require.Len(t, queued, 1)
+	assert.Equal(t, "hook", queued[0].WebhookID)
+	assert.Equal(t, models.EventLinkClicked, queued[0].Event)
+
+	var payload map[string]interface{}
+	require.NoError(t, json.Unmarshal(queued[0].Payload, &payload))
+	assert.Equal(t, "delivery", payload["id"])
+	assert.Equal(t, map[string]interface{}{
+		"id":           "abcde",
+		"short_url":    "http://localhost:8080/abcde",
+		"original_url": "https://example.com",
+	}, payload["link"])
+	assert.Equal(t, map[string]interface{}{
+		"target":  "https://example.com/b",
+		"variant": "b",
+	}, payload["click"])
+}
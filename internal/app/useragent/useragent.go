@@ -0,0 +1,64 @@
+package useragent
+
+import (
+	"github.com/ChristinaFomenko/shortener/internal/app/models"
+	"strings"
+)
+
+// botMarkers are found in the User-Agent of crawlers and link preview fetchers
+var botMarkers = []string{
+	"bot", "crawler", "spider", "slurp", "crawl", "mediapartners", "facebookexternalhit",
+	"embedly", "preview", "whatsapp", "telegram", "headlesschrome", "lighthouse",
+}
+
+// Parse tells the operating system and device class of the client from its User-Agent,
+// an empty or unknown one is a desktop client of an unknown os
+func Parse(userAgent string) models.Client {
+	ua := strings.ToLower(userAgent)
+
+	client := models.Client{
+		OS:     parseOS(ua),
+		Device: models.DeviceDesktop,
+	}
+
+	switch {
+	case isBot(ua):
+		client.Device = models.DeviceBot
+	case strings.Contains(ua, "ipad") || strings.Contains(ua, "tablet"):
+		client.Device = models.DeviceTablet
+	case client.OS == models.OSAndroid && !strings.Contains(ua, "mobile"):
+		// android tablets leave out the Mobile token phones send
+		client.Device = models.DeviceTablet
+	case strings.Contains(ua, "mobile") || strings.Contains(ua, "iphone") || strings.Contains(ua, "ipod"):
+		client.Device = models.DeviceMobile
+	}
+
+	return client
+}
+
+func parseOS(ua string) string {
+	switch {
+	case strings.Contains(ua, "iphone") || strings.Contains(ua, "ipad") || strings.Contains(ua, "ipod"):
+		return models.OSIOS
+	case strings.Contains(ua, "android"):
+		return models.OSAndroid
+	case strings.Contains(ua, "windows"):
+		return models.OSWindows
+	case strings.Contains(ua, "mac os x") || strings.Contains(ua, "macintosh"):
+		return models.OSMacOS
+	case strings.Contains(ua, "linux") || strings.Contains(ua, "x11"):
+		return models.OSLinux
+	}
+
+	return ""
+}
+
+func isBot(ua string) bool {
+	for _, marker := range botMarkers {
+		if strings.Contains(ua, marker) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,66 @@
+package useragent
+
+import (
+	"github.com/ChristinaFomenko/shortener/internal/app/models"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name      string
+		userAgent string
+		want      models.Client
+	}{
+		{
+			name:      "iphone",
+			userAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1",
+			want:      models.Client{OS: models.OSIOS, Device: models.DeviceMobile},
+		},
+		{
+			name:      "ipad",
+			userAgent: "Mozilla/5.0 (iPad; CPU OS 16_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.6 Mobile/15E148 Safari/604.1",
+			want:      models.Client{OS: models.OSIOS, Device: models.DeviceTablet},
+		},
+		{
+			name:      "android phone",
+			userAgent: "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Mobile Safari/537.36",
+			want:      models.Client{OS: models.OSAndroid, Device: models.DeviceMobile},
+		},
+		{
+			name:      "android tablet",
+			userAgent: "Mozilla/5.0 (Linux; Android 13; SM-X200) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Safari/537.36",
+			want:      models.Client{OS: models.OSAndroid, Device: models.DeviceTablet},
+		},
+		{
+			name:      "windows desktop",
+			userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Safari/537.36",
+			want:      models.Client{OS: models.OSWindows, Device: models.DeviceDesktop},
+		},
+		{
+			name:      "mac desktop",
+			userAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 14_1) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Safari/605.1.15",
+			want:      models.Client{OS: models.OSMacOS, Device: models.DeviceDesktop},
+		},
+		{
+			name:      "crawler",
+			userAgent: "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			want:      models.Client{Device: models.DeviceBot},
+		},
+		{
+			name:      "mobile crawler",
+			userAgent: "Mozilla/5.0 (Linux; Android 6.0.1; Nexus 5X) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Mobile Safari/537.36 (compatible; Googlebot/2.1)",
+			want:      models.Client{OS: models.OSAndroid, Device: models.DeviceBot},
+		},
+		{
+			name: "empty",
+			want: models.Client{Device: models.DeviceDesktop},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Parse(tt.userAgent))
+		})
+	}
+}
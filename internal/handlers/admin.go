@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/ChristinaFomenko/shortener/internal/app/service/moderation"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+)
+
+//go:generate mockgen -source=admin.go -destination=mocks/admin.go
+
+type moderationService interface {
+	DisableBlocked(ctx context.Context) (moderation.Report, error)
+}
+
+type adminHandler struct {
+	moderationService moderationService
+}
+
+func NewAdmin(moderationService moderationService) *adminHandler {
+	return &adminHandler{
+		moderationService: moderationService,
+	}
+}
+
+// DisableBlocked applies the current blocklist to the links shortened before
+func (h *adminHandler) DisableBlocked(w http.ResponseWriter, r *http.Request) {
+	report, err := h.moderationService.DisableBlocked(r.Context())
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	body, err := json.Marshal(toDisableBlockedReply(report))
+	if err != nil {
+		log.WithError(err).Error("marshal disable blocked response error")
+		serverError(w, err)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write(body); err != nil {
+		log.WithError(err).Error("write response error")
+	}
+}
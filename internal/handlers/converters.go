@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"github.com/ChristinaFomenko/shortener/internal/app/models"
+	"github.com/ChristinaFomenko/shortener/internal/app/service/moderation"
 )
 
 func toGetUrlsReply(model []models.UserURL) []GetUrlsReply {
@@ -24,6 +25,8 @@ func toShortenBatchRequest(model []ShortenBatchRequest) []models.OriginalURL {
 		reply[idx] = models.OriginalURL{
 			CorrelationID: m.CorrelationID,
 			URL:           m.OriginalURL,
+			RedirectCode:  m.RedirectCode,
+			Passthrough:   m.Passthrough,
 		}
 	}
 
@@ -37,6 +40,118 @@ func toShortenBatchReply(model []models.UserURL) []ShortenBatchReply {
 		reply[idx] = ShortenBatchReply{
 			CorrelationID: m.CorrelationID,
 			ShortURL:      m.ShortURL,
+			Existing:      m.Existing,
+		}
+	}
+
+	return reply
+}
+
+func toDisableBlockedReply(model moderation.Report) DisableBlockedReply {
+	reply := DisableBlockedReply{
+		Checked:  model.Checked,
+		Disabled: make([]DisabledLinkReply, len(model.Disabled)),
+	}
+
+	for idx, m := range model.Disabled {
+		reply.Disabled[idx] = DisabledLinkReply{
+			ID:          m.URLID,
+			OriginalURL: m.OriginalURL,
+			Reason:      m.Reason,
+		}
+	}
+
+	return reply
+}
+
+func toTargetRules(model []TargetRuleRequest) []models.TargetRule {
+	if len(model) == 0 {
+		return nil
+	}
+
+	rules := make([]models.TargetRule, len(model))
+
+	for idx, m := range model {
+		rules[idx] = models.TargetRule{
+			OS:     m.OS,
+			Device: m.Device,
+			URL:    m.URL,
+		}
+	}
+
+	return rules
+}
+
+func toTargetRulesReply(model []models.TargetRule) []TargetRuleReply {
+	reply := make([]TargetRuleReply, len(model))
+
+	for idx, m := range model {
+		reply[idx] = TargetRuleReply{
+			OS:     m.OS,
+			Device: m.Device,
+			URL:    m.URL,
+		}
+	}
+
+	return reply
+}
+
+func toVariants(model []VariantRequest) []models.Variant {
+	if len(model) == 0 {
+		return nil
+	}
+
+	variants := make([]models.Variant, len(model))
+
+	for idx, m := range model {
+		variants[idx] = models.Variant{
+			Name:   m.Name,
+			URL:    m.URL,
+			Weight: m.Weight,
+		}
+	}
+
+	return variants
+}
+
+func toWebhook(req WebhookRequest, userID string) models.Webhook {
+	return models.Webhook{
+		UserID: userID,
+		URLID:  req.URLID,
+		URL:    req.URL,
+		Events: req.Events,
+	}
+}
+
+// toWebhooksReply leaves the secrets out, they are only shown once when a webhook is registered
+func toWebhooksReply(model []models.Webhook) []WebhookReply {
+	reply := make([]WebhookReply, len(model))
+
+	for idx, m := range model {
+		reply[idx] = WebhookReply{
+			ID:        m.ID,
+			URL:       m.URL,
+			URLID:     m.URLID,
+			Events:    m.Events,
+			CreatedAt: m.CreatedAt,
+		}
+	}
+
+	return reply
+}
+
+func toDeadLettersReply(model []models.Delivery) []DeadLetterReply {
+	reply := make([]DeadLetterReply, len(model))
+
+	for idx, m := range model {
+		reply[idx] = DeadLetterReply{
+			ID:        m.ID,
+			WebhookID: m.WebhookID,
+			Event:     m.Event,
+			Payload:   m.Payload,
+			Attempts:  m.Attempts,
+			LastError: m.LastError,
+			CreatedAt: m.CreatedAt,
 		}
 	}
 
@@ -8,19 +8,27 @@ import (
 	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
 	"github.com/asaskevich/govalidator"
 	"github.com/go-chi/chi/v5"
-	_ "github.com/jackc/pgx/v4/stdlib"
 	log "github.com/sirupsen/logrus"
 	"io"
+	"math"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 )
 
 //go:generate mockgen -source=handlers.go -destination=mocks/mocks.go
 
+const variantCookieMaxAge = 30 * 24 * 60 * 60
+
 type service interface {
-	Shorten(ctx context.Context, url string, userID string) (string, error)
-	Expand(ctx context.Context, id string) (string, error)
+	Shorten(ctx context.Context, url string, userID string, options models.LinkOptions) (string, error)
+	Expand(ctx context.Context, id string, visit models.Visit) (models.Redirect, error)
 	FetchURLs(ctx context.Context, userID string) ([]models.UserURL, error)
+	ExportURLs(ctx context.Context, userID string, fn func(url models.UserURL) error) error
 	ShortenBatch(ctx context.Context, originalURLs []models.OriginalURL, userID string) ([]models.UserURL, error)
+	Rules(ctx context.Context, urlID, userID string) ([]models.TargetRule, error)
+	SetRules(ctx context.Context, urlID, userID string, rules []models.TargetRule) error
 }
 
 type auth interface {
@@ -50,20 +58,41 @@ func (h *handler) Shorten(w http.ResponseWriter, r *http.Request) {
 	bytes, err := io.ReadAll(r.Body)
 	if err != nil {
 		log.Error(err)
-		http.Error(w, "failed to validate struct", 400)
+		bodyError(w, err)
 		return
 	}
 
-	userID := h.auth.UserID(r.Context())
+	url := strings.TrimSpace(string(bytes))
+	if !isLink(url) {
+		http.Error(w, "url not valid", http.StatusBadRequest)
+		return
+	}
 
-	url := string(bytes)
+	options := models.LinkOptions{}
+	if redirect := r.URL.Query().Get("redirect"); redirect != "" {
+		if options.RedirectCode, err = strconv.Atoi(redirect); err != nil {
+			http.Error(w, errs.ErrInvalidRedirectCode.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if passthrough := r.URL.Query().Get("passthrough"); passthrough != "" {
+		if options.Passthrough, err = strconv.ParseBool(passthrough); err != nil {
+			http.Error(w, "passthrough must be true or false", http.StatusBadRequest)
+			return
+		}
+	}
+
+	userID := h.auth.UserID(r.Context())
 
 	statusCode := http.StatusCreated
 
-	shortcut, err := h.service.Shorten(r.Context(), url, userID)
+	shortcut, err := h.service.Shorten(r.Context(), url, userID, options)
 	if err != nil {
+		if rejected(w, err) {
+			return
+		}
 		if !errors.Is(err, errs.ErrNotUniqueURL) {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			serverError(w, err)
 			return
 		}
 		statusCode = http.StatusConflict
@@ -78,7 +107,7 @@ func (h *handler) Shorten(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// Expand Returns full URL by ID of shorted one
+// Expand Returns full URL by ID of shorted one, passthrough links get the path and query after the id too
 func (h *handler) Expand(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	if id == "" {
@@ -86,24 +115,86 @@ func (h *handler) Expand(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	url, err := h.service.Expand(r.Context(), id)
+	visitPath, err := pathAfter(r, id)
+	if err != nil {
+		http.Error(w, "path not valid", http.StatusBadRequest)
+		return
+	}
+
+	visit := models.Visit{
+		Path:      visitPath,
+		Query:     r.URL.Query(),
+		UserAgent: r.UserAgent(),
+	}
+	if cookie, err := r.Cookie(variantCookie(id)); err == nil {
+		visit.Variant = cookie.Value
+	}
+
+	redirect, err := h.service.Expand(r.Context(), id, visit)
 	if err != nil {
 		if errors.Is(err, errs.ErrURLNotFound) {
 			http.Error(w, "url not found", http.StatusNoContent)
 			return
 		}
 
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		serverError(w, err)
 		return
 	}
 
-	w.Header().Set("Location", url)
-	w.WriteHeader(http.StatusTemporaryRedirect)
+	// permanent redirects may be cached by browsers and proxies, temporary ones must reach us every time.
+	// A variant is picked per visit unless the link is sticky, then it is this visitor's only
+	// and shared caches must not hand it to others.
+	switch {
+	case !models.PermanentRedirect(redirect.Code), redirect.Variant != "" && !redirect.Sticky:
+		w.Header().Set("Cache-Control", "private, no-store")
+	case redirect.Variant != "":
+		w.Header().Set("Cache-Control", "private, max-age=86400")
+	default:
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+	}
+
+	if redirect.Targeted {
+		w.Header().Add("Vary", "User-Agent")
+	}
+
+	if redirect.Sticky && redirect.Variant != visit.Variant {
+		http.SetCookie(w, &http.Cookie{
+			Name:     variantCookie(id),
+			Value:    redirect.Variant,
+			Path:     "/" + id,
+			MaxAge:   variantCookieMaxAge,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+
+	w.Header().Set("Location", redirect.URL)
+	w.WriteHeader(redirect.Code)
+}
+
+// variantCookie names the cookie remembering the variant of a sticky link the visitor got
+func variantCookie(id string) string {
+	return "v_" + id
 }
+
+// pathAfter returns the unescaped path following the id. It is read from the request url because
+// the URLFormat middleware strips file extensions from the routing path.
+func pathAfter(r *http.Request, id string) (string, error) {
+	escapedPath := r.URL.EscapedPath()
+
+	prefix := "/" + id + "/"
+	idx := strings.Index(escapedPath, prefix)
+	if idx < 0 {
+		return "", nil
+	}
+
+	return url.PathUnescape(escapedPath[idx+len(prefix)-1:])
+}
+
 func (h *handler) APIJSONShorten(w http.ResponseWriter, r *http.Request) {
 	b, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, err.Error(), 400)
+		bodyError(w, err)
 		return
 	}
 
@@ -114,7 +205,7 @@ func (h *handler) APIJSONShorten(w http.ResponseWriter, r *http.Request) {
 	}
 
 	ok, err := govalidator.ValidateStruct(req)
-	if err != nil || !ok {
+	if err != nil || !ok || !validRules(req.Rules) || !validVariants(req.Variants) {
 		http.Error(w, "request in not valid", http.StatusBadRequest)
 		return
 	}
@@ -123,30 +214,38 @@ func (h *handler) APIJSONShorten(w http.ResponseWriter, r *http.Request) {
 
 	statusCode := http.StatusCreated
 
-	shortcut, err := h.service.Shorten(r.Context(), req.URL, userID)
+	shortcut, err := h.service.Shorten(r.Context(), req.URL, userID, models.LinkOptions{
+		RedirectCode: req.RedirectCode,
+		Passthrough:  req.Passthrough,
+		Rules:        toTargetRules(req.Rules),
+		Variants:     toVariants(req.Variants),
+		Sticky:       req.Sticky,
+	})
 	if err != nil {
+		if rejected(w, err) {
+			return
+		}
 		if !errors.Is(err, errs.ErrNotUniqueURL) {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			serverError(w, err)
 			return
 		}
 		statusCode = http.StatusConflict
 	}
 
-	w.Header().Set("content-type", "application/json")
-	w.WriteHeader(statusCode)
-
 	resp := ShortenReply{ShortenURLResult: shortcut}
 	marshal, err := json.Marshal(&resp)
 	if err != nil {
 		log.WithError(err).WithField("resp", resp).Error("marshal response error")
-		http.Error(w, err.Error(), 400)
+		serverError(w, err)
 		return
 	}
 
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(statusCode)
+
 	_, err = w.Write(marshal)
 	if err != nil {
 		log.WithError(err).WithField("shortcut", shortcut).Error("write response error")
-		http.Error(w, err.Error(), 500)
 		return
 	}
 }
@@ -156,7 +255,7 @@ func (h *handler) FetchURLs(w http.ResponseWriter, r *http.Request) {
 	urls, err := h.service.FetchURLs(r.Context(), userID)
 	if err != nil {
 		log.WithError(err).Error("get urls error")
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		serverError(w, err)
 		return
 	}
 
@@ -165,22 +264,22 @@ func (h *handler) FetchURLs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.Header().Set("content-type", "application/json")
-	w.WriteHeader(http.StatusOK)
-
 	resp := toGetUrlsReply(urls)
 	body, err := json.Marshal(&resp)
 	if err != nil {
 		log.WithError(err).WithField("resp", urls).Error("marshal urls response error")
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		serverError(w, err)
+		return
 	}
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
 	_, err = w.Write(body)
 	if err != nil {
 		log.WithError(err).WithField("resp", urls).Error("write response error")
-		http.Error(w, err.Error(), 500)
 		return
 	}
-
 }
 
 func (h *handler) Ping(w http.ResponseWriter, r *http.Request) {
@@ -195,7 +294,7 @@ func (h *handler) Ping(w http.ResponseWriter, r *http.Request) {
 func (h *handler) ShortenBatch(w http.ResponseWriter, r *http.Request) {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		bodyError(w, err)
 		return
 	}
 
@@ -222,25 +321,74 @@ func (h *handler) ShortenBatch(w http.ResponseWriter, r *http.Request) {
 
 	urls, err := h.service.ShortenBatch(r.Context(), originalUrls, userID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		if rejected(w, err) {
+			return
+		}
+		serverError(w, err)
 		return
 	}
 
-	w.Header().Set("content-type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-
 	resp := toShortenBatchReply(urls)
 	marshal, err := json.Marshal(&resp)
 	if err != nil {
 		log.WithError(err).WithField("resp", resp).Error("marshal response error")
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		serverError(w, err)
 		return
 	}
 
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
 	_, err = w.Write(marshal)
 	if err != nil {
 		log.WithError(err).WithField("urls", urls).Error("write response error")
-		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 }
+
+// bodyError answers a request whose body couldn't be read, malformed encodings are client errors too
+func bodyError(w http.ResponseWriter, err error) {
+	http.Error(w, err.Error(), bodyErrorStatus(err))
+}
+
+func bodyErrorStatus(err error) int {
+	if errors.Is(err, errs.ErrBodyTooLarge) || errors.Is(err, errs.ErrCompressionRatio) {
+		return http.StatusRequestEntityTooLarge
+	}
+
+	return http.StatusBadRequest
+}
+
+// serverError answers 503 with a retry hint while the storage is down, 500 otherwise.
+// The details stay in the log, they are of no use to clients and tell about our internals.
+func serverError(w http.ResponseWriter, err error) {
+	var unavailableErr *errs.UnavailableErr
+	if errors.As(err, &unavailableErr) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(unavailableErr.RetryAfter.Seconds()))))
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+
+	log.WithError(err).Error("request failed")
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
+
+// rejected answers urls the service refused to shorten, blocked ones and ones shortened already with
+// other settings get the reason with 422
+func rejected(w http.ResponseWriter, err error) bool {
+	var blockedErr *errs.BlockedURLErr
+	switch {
+	case errors.As(err, &blockedErr):
+		http.Error(w, blockedErr.Error(), http.StatusUnprocessableEntity)
+	case errors.Is(err, errs.ErrURLSettingsConflict):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	case errors.Is(err, errs.ErrInvalidURL), errors.Is(err, errs.ErrInvalidRedirectCode),
+		errors.Is(err, errs.ErrInvalidTargetRule), errors.Is(err, errs.ErrTooManyTargetRules),
+		errors.Is(err, errs.ErrInvalidVariants):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		return false
+	}
+
+	return true
+}
@@ -3,7 +3,10 @@ package handlers
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"github.com/ChristinaFomenko/shortener/internal/app/models"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
 	"github.com/go-chi/chi/v5"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
@@ -11,7 +14,9 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
+	"time"
 
 	mock "github.com/ChristinaFomenko/shortener/internal/handlers/mocks"
 )
@@ -25,11 +30,14 @@ func TestShortenHandler(t *testing.T) {
 		shortcut    string
 	}
 	tests := []struct {
-		name     string
-		request  string
-		url      string
-		shortcut string
-		want     want
+		name       string
+		request    string
+		url        string
+		shortcut   string
+		options    models.LinkOptions
+		serviceErr error
+		invalid    bool
+		want       want
 	}{
 		{
 			name:     "success",
@@ -42,6 +50,85 @@ func TestShortenHandler(t *testing.T) {
 			},
 			request: "/",
 		},
+		{
+			name:     "permanent redirect",
+			url:      "https://yandex.ru",
+			shortcut: "http://localhost:8080/abcde",
+			options:  models.LinkOptions{RedirectCode: http.StatusMovedPermanently},
+			want: want{
+				contentType: "text/plain; charset=utf-8",
+				statusCode:  201,
+				shortcut:    "http://localhost:8080/abcde",
+			},
+			request: "/?redirect=301",
+		},
+		{
+			name:    "redirect not a number",
+			url:     "https://yandex.ru",
+			invalid: true,
+			want: want{
+				contentType: "text/plain; charset=utf-8",
+				statusCode:  400,
+				shortcut:    "redirect code must be one of 301, 302, 307, 308\n",
+			},
+			request: "/?redirect=permanent",
+		},
+		{
+			name:       "unsupported redirect",
+			url:        "https://yandex.ru",
+			options:    models.LinkOptions{RedirectCode: http.StatusSeeOther},
+			serviceErr: errs.ErrInvalidRedirectCode,
+			want: want{
+				contentType: "text/plain; charset=utf-8",
+				statusCode:  400,
+				shortcut:    "redirect code must be one of 301, 302, 307, 308\n",
+			},
+			request: "/?redirect=303",
+		},
+		{
+			name:    "not a url",
+			url:     "not a url",
+			invalid: true,
+			want: want{
+				contentType: "text/plain; charset=utf-8",
+				statusCode:  400,
+				shortcut:    "url not valid\n",
+			},
+			request: "/",
+		},
+		{
+			name:       "blocked url",
+			url:        "javascript:alert(1)",
+			serviceErr: errs.NewBlockedURLErr("javascript:alert(1)", "scheme javascript is not allowed"),
+			want: want{
+				contentType: "text/plain; charset=utf-8",
+				statusCode:  422,
+				shortcut:    "url is blocked: scheme javascript is not allowed\n",
+			},
+			request: "/",
+		},
+		{
+			name:       "shortened with other settings",
+			url:        "https://yandex.ru",
+			serviceErr: fmt.Errorf("%w as %s", errs.ErrURLSettingsConflict, "http://localhost:8080/qwert"),
+			want: want{
+				contentType: "text/plain; charset=utf-8",
+				statusCode:  422,
+				shortcut:    "url is already shortened with other settings as http://localhost:8080/qwert\n",
+			},
+			request: "/",
+		},
+		{
+			name:       "url rejected by normalization",
+			url:        "https://xn--a.com",
+			serviceErr: errs.ErrInvalidURL,
+			want: want{
+				contentType: "text/plain; charset=utf-8",
+				statusCode:  400,
+				shortcut:    "url not valid\n",
+			},
+			request: "/",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -51,10 +138,11 @@ func TestShortenHandler(t *testing.T) {
 			defer ctrl.Finish()
 
 			serviceMock := mock.NewMockservice(ctrl)
-			serviceMock.EXPECT().Shorten(ctx, tt.url, defaultUserID).Return(tt.shortcut, nil)
-
 			authMock := mock.NewMockauth(ctrl)
-			authMock.EXPECT().UserID(gomock.Any()).Return(defaultUserID)
+			if !tt.invalid {
+				serviceMock.EXPECT().Shorten(ctx, tt.url, defaultUserID, tt.options).Return(tt.shortcut, tt.serviceErr)
+				authMock.EXPECT().UserID(gomock.Any()).Return(defaultUserID)
+			}
 
 			httpHandler := New(serviceMock, authMock, nil)
 
@@ -94,6 +182,7 @@ func TestAPIJSONShorten_Success(t *testing.T) {
 		url      string
 		body     string
 		shortcut string
+		options  models.LinkOptions
 		want     want
 	}{
 		{
@@ -108,6 +197,19 @@ func TestAPIJSONShorten_Success(t *testing.T) {
 			},
 			request: "/api/shorten",
 		},
+		{
+			name:     "redirect code",
+			url:      "https://yandex.ru",
+			body:     "{\"url\":\"https://yandex.ru\",\"redirect_code\":308}",
+			shortcut: "http://localhost:8080/abcde",
+			options:  models.LinkOptions{RedirectCode: http.StatusPermanentRedirect},
+			want: want{
+				contentType: "application/json",
+				statusCode:  201,
+				response:    "{\"result\":\"http://localhost:8080/abcde\"}",
+			},
+			request: "/api/shorten",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -117,7 +219,7 @@ func TestAPIJSONShorten_Success(t *testing.T) {
 			defer ctrl.Finish()
 
 			serviceMock := mock.NewMockservice(ctrl)
-			serviceMock.EXPECT().Shorten(ctx, tt.url, defaultUserID).Return(tt.shortcut, nil)
+			serviceMock.EXPECT().Shorten(ctx, tt.url, defaultUserID, tt.options).Return(tt.shortcut, nil)
 
 			authMock := mock.NewMockauth(ctrl)
 			authMock.EXPECT().UserID(gomock.Any()).Return(defaultUserID)
@@ -220,15 +322,20 @@ func TestAPIJSONShorten_BadRequest(t *testing.T) {
 
 func TestExpandHandler_Success(t *testing.T) {
 	type want struct {
-		contentType string
-		statusCode  int
-		response    string
-		location    string
+		contentType  string
+		statusCode   int
+		response     string
+		location     string
+		cacheControl string
+		vary         string
+		cookie       string
 	}
 	tests := []struct {
 		name     string
 		request  string
-		url      string
+		cookie   *http.Cookie
+		redirect models.Redirect
+		visit    models.Visit
 		urlID    string
 		shortcut string
 		err      error
@@ -236,14 +343,132 @@ func TestExpandHandler_Success(t *testing.T) {
 	}{
 		{
 			name:     "success",
-			url:      "https://yandex.ru",
+			redirect: models.Redirect{URL: "https://yandex.ru", Code: http.StatusTemporaryRedirect},
+			visit:    models.Visit{Query: url.Values{}},
+			urlID:    "abc",
+			shortcut: "http://localhost:8080/abc",
+			err:      nil,
+			want: want{
+				contentType:  "",
+				statusCode:   307,
+				response:     "",
+				location:     "https://yandex.ru",
+				cacheControl: "private, no-store",
+			},
+			request: "/",
+		},
+		{
+			name:     "permanent",
+			redirect: models.Redirect{URL: "https://yandex.ru", Code: http.StatusMovedPermanently},
+			visit:    models.Visit{Query: url.Values{}},
 			urlID:    "abc",
 			shortcut: "http://localhost:8080/abc",
 			err:      nil,
 			want: want{
-				contentType: "",
-				statusCode:  307,
-				response:    "",
+				contentType:  "",
+				statusCode:   301,
+				response:     "",
+				location:     "https://yandex.ru",
+				cacheControl: "public, max-age=86400",
+			},
+			request: "/",
+		},
+		{
+			name:     "found",
+			redirect: models.Redirect{URL: "https://yandex.ru", Code: http.StatusFound},
+			visit:    models.Visit{Query: url.Values{}},
+			urlID:    "abc",
+			shortcut: "http://localhost:8080/abc",
+			err:      nil,
+			want: want{
+				contentType:  "",
+				statusCode:   302,
+				response:     "",
+				location:     "https://yandex.ru",
+				cacheControl: "private, no-store",
+			},
+			request: "/",
+		},
+		{
+			name:     "passthrough",
+			redirect: models.Redirect{URL: "https://shop.example/shoes/red%20one.html?size=42", Code: http.StatusFound},
+			visit:    models.Visit{Path: "/shoes/red one.html", Query: url.Values{"size": {"42"}}},
+			urlID:    "abc",
+			shortcut: "http://localhost:8080/abc",
+			err:      nil,
+			want: want{
+				contentType:  "",
+				statusCode:   302,
+				response:     "",
+				location:     "https://shop.example/shoes/red%20one.html?size=42",
+				cacheControl: "private, no-store",
+			},
+			request: "/abc/shoes/red%20one.html?size=42",
+		},
+		{
+			name:     "targeted",
+			redirect: models.Redirect{URL: "https://apps.apple.com/app/id1", Code: http.StatusFound, Targeted: true},
+			visit:    models.Visit{Query: url.Values{}},
+			urlID:    "abc",
+			shortcut: "http://localhost:8080/abc",
+			err:      nil,
+			want: want{
+				contentType:  "",
+				statusCode:   302,
+				response:     "",
+				location:     "https://apps.apple.com/app/id1",
+				cacheControl: "private, no-store",
+				vary:         "User-Agent",
+			},
+			request: "/",
+		},
+		{
+			name:     "new sticky visitor",
+			redirect: models.Redirect{URL: "https://example.com/b", Code: http.StatusMovedPermanently, Variant: "b", Sticky: true},
+			visit:    models.Visit{Query: url.Values{}},
+			urlID:    "abc",
+			shortcut: "http://localhost:8080/abc",
+			err:      nil,
+			want: want{
+				contentType:  "",
+				statusCode:   301,
+				response:     "",
+				location:     "https://example.com/b",
+				cacheControl: "private, max-age=86400",
+				cookie:       "v_abc=b; Path=/abc; Max-Age=2592000; HttpOnly; SameSite=Lax",
+			},
+			request: "/",
+		},
+		{
+			name:     "split permanent redirect",
+			redirect: models.Redirect{URL: "https://example.com/a", Code: http.StatusMovedPermanently, Variant: "a"},
+			visit:    models.Visit{Query: url.Values{}},
+			urlID:    "abc",
+			shortcut: "http://localhost:8080/abc",
+			err:      nil,
+			want: want{
+				contentType:  "",
+				statusCode:   301,
+				response:     "",
+				location:     "https://example.com/a",
+				cacheControl: "private, no-store",
+			},
+			request: "/",
+		},
+		{
+			name:     "returning sticky visitor",
+			cookie:   &http.Cookie{Name: "v_abc", Value: "b"},
+			redirect: models.Redirect{URL: "https://example.com/b", Code: http.StatusFound, Variant: "b", Sticky: true},
+			visit:    models.Visit{Query: url.Values{}, Variant: "b"},
+			urlID:    "abc",
+			shortcut: "http://localhost:8080/abc",
+			err:      nil,
+			want: want{
+				contentType:  "",
+				statusCode:   302,
+				response:     "",
+				location:     "https://example.com/b",
+				cacheControl: "private, no-store",
 			},
 			request: "/",
 		},
@@ -254,11 +479,14 @@ func TestExpandHandler_Success(t *testing.T) {
 			defer ctrl.Finish()
 
 			urlsSrvMock := mock.NewMockservice(ctrl)
-			urlsSrvMock.EXPECT().Expand(gomock.Any(), tt.urlID).Return(tt.url, tt.err)
+			urlsSrvMock.EXPECT().Expand(gomock.Any(), tt.urlID, tt.visit).Return(tt.redirect, tt.err)
 
 			httpHandler := New(urlsSrvMock, nil, nil)
 
 			request := httptest.NewRequest(http.MethodGet, tt.request, nil)
+			if tt.cookie != nil {
+				request.AddCookie(tt.cookie)
+			}
 			rctx := chi.NewRouteContext()
 			rctx.URLParams.Add("id", tt.urlID)
 
@@ -273,6 +501,10 @@ func TestExpandHandler_Success(t *testing.T) {
 
 			assert.Equal(t, tt.want.statusCode, result.StatusCode)
 			assert.Equal(t, tt.want.contentType, result.Header.Get("Content-Type"))
+			assert.Equal(t, tt.want.location, result.Header.Get("Location"))
+			assert.Equal(t, tt.want.cacheControl, result.Header.Get("Cache-Control"))
+			assert.Equal(t, tt.want.vary, result.Header.Get("Vary"))
+			assert.Equal(t, tt.want.cookie, result.Header.Get("Set-Cookie"))
 
 			userResult, err := ioutil.ReadAll(result.Body)
 			require.NoError(t, err)
@@ -286,6 +518,41 @@ func TestExpandHandler_Success(t *testing.T) {
 	}
 }
 
+func Test_serverError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		statusCode int
+		retryAfter string
+		body       string
+	}{
+		{
+			name:       "storage unavailable",
+			err:        fmt.Errorf("expand: %w", errs.NewUnavailableErr(2500*time.Millisecond)),
+			statusCode: http.StatusServiceUnavailable,
+			retryAfter: "3",
+			body:       "Service Unavailable\n",
+		},
+		{
+			name:       "other error",
+			err:        errors.New("dial tcp 10.0.0.5:5432: connection refused"),
+			statusCode: http.StatusInternalServerError,
+			body:       "Internal Server Error\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			serverError(w, tt.err)
+
+			assert.Equal(t, tt.statusCode, w.Code)
+			assert.Equal(t, tt.retryAfter, w.Header().Get("Retry-After"))
+			assert.Equal(t, tt.body, w.Body.String())
+		})
+	}
+}
+
 func Test_handler_FetchURLs_Success(t *testing.T) {
 	type want struct {
 		contentType string
@@ -439,3 +706,60 @@ func Test_handler_Ping(t *testing.T) {
 //		})
 //	}
 //}
+
+type failingReader struct {
+	err error
+}
+
+func (r failingReader) Read(_ []byte) (int, error) {
+	return 0, r.err
+}
+
+func Test_handler_BodyErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		statusCode int
+	}{
+		{
+			name:       "body too large",
+			err:        fmt.Errorf("%w: limit is 10 bytes", errs.ErrBodyTooLarge),
+			statusCode: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:       "compression ratio too high",
+			err:        fmt.Errorf("%w: limit is 100", errs.ErrCompressionRatio),
+			statusCode: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:       "malformed encoding",
+			err:        fmt.Errorf("%w: unexpected EOF", errs.ErrMalformedEncoding),
+			statusCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			authMock := mock.NewMockauth(ctrl)
+			authMock.EXPECT().UserID(gomock.Any()).Return(defaultUserID).AnyTimes()
+
+			h := New(mock.NewMockservice(ctrl), authMock, nil)
+			for path, handle := range map[string]http.HandlerFunc{
+				"/":                  h.Shorten,
+				"/api/shorten":       h.APIJSONShorten,
+				"/api/shorten/batch": h.ShortenBatch,
+			} {
+				request := httptest.NewRequest(http.MethodPost, path, failingReader{err: tt.err})
+				w := httptest.NewRecorder()
+
+				handle(w, request)
+				result := w.Result()
+				_ = result.Body.Close()
+
+				assert.Equal(t, tt.statusCode, result.StatusCode, path)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/ChristinaFomenko/shortener/internal/app/service/health"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+)
+
+//go:generate mockgen -source=health.go -destination=mocks/health.go
+
+type healthService interface {
+	Ready(ctx context.Context) health.Report
+}
+
+type healthHandler struct {
+	healthService healthService
+}
+
+func NewHealth(healthService healthService) *healthHandler {
+	return &healthHandler{
+		healthService: healthService,
+	}
+}
+
+// Liveness reports that the process is up, it never touches dependencies
+func (h *healthHandler) Liveness(w http.ResponseWriter, _ *http.Request) {
+	writeHealth(w, http.StatusOK, map[string]string{"status": "alive"})
+}
+
+// Readiness reports whether the instance can serve traffic with a breakdown per dependency
+func (h *healthHandler) Readiness(w http.ResponseWriter, r *http.Request) {
+	report := h.healthService.Ready(r.Context())
+
+	statusCode := http.StatusOK
+	if !report.Ready() {
+		statusCode = http.StatusServiceUnavailable
+		log.WithField("report", report).Warn("instance is not ready")
+	}
+
+	writeHealth(w, statusCode, report)
+}
+
+func writeHealth(w http.ResponseWriter, statusCode int, resp interface{}) {
+	body, err := json.Marshal(resp)
+	if err != nil {
+		log.WithError(err).Error("marshal health response error")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(statusCode)
+	if _, err = w.Write(body); err != nil {
+		log.WithError(err).Error("write response error")
+	}
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"github.com/ChristinaFomenko/shortener/internal/app/service/health"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	mock "github.com/ChristinaFomenko/shortener/internal/handlers/mocks"
+)
+
+func Test_healthHandler_Liveness(t *testing.T) {
+	request := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	w := httptest.NewRecorder()
+
+	NewHealth(nil).Liveness(w, request)
+	result := w.Result()
+	defer result.Body.Close()
+
+	body, err := ioutil.ReadAll(result.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, result.StatusCode)
+	assert.JSONEq(t, `{"status":"alive"}`, string(body))
+}
+
+func Test_healthHandler_Readiness(t *testing.T) {
+	type want struct {
+		statusCode int
+		response   string
+	}
+	tests := []struct {
+		name   string
+		report health.Report
+		want   want
+	}{
+		{
+			name: "ready",
+			report: health.Report{
+				Status: health.StatusReady,
+				Checks: map[string]health.CheckResult{"database": {Status: health.StatusOK, LatencyMS: 1.5}},
+			},
+			want: want{
+				statusCode: 200,
+				response:   `{"status":"ready","checks":{"database":{"status":"ok","latency_ms":1.5}}}`,
+			},
+		},
+		{
+			name: "dependency fails",
+			report: health.Report{
+				Status: health.StatusNotReady,
+				Checks: map[string]health.CheckResult{"database": {Status: health.StatusFail, LatencyMS: 2, Error: "connection refused"}},
+			},
+			want: want{
+				statusCode: 503,
+				response:   `{"status":"not_ready","checks":{"database":{"status":"fail","latency_ms":2,"error":"connection refused"}}}`,
+			},
+		},
+		{
+			name: "degraded",
+			report: health.Report{
+				Status: health.StatusReadyDegraded,
+				Checks: map[string]health.CheckResult{"database": {Status: health.StatusDegraded, LatencyMS: 2, Error: "unavailable"}},
+			},
+			want: want{
+				statusCode: 200,
+				response:   `{"status":"degraded","checks":{"database":{"status":"degraded","latency_ms":2,"error":"unavailable"}}}`,
+			},
+		},
+		{
+			name:   "shutting down",
+			report: health.Report{Status: health.StatusShuttingDown, Checks: map[string]health.CheckResult{}},
+			want: want{
+				statusCode: 503,
+				response:   `{"status":"shutting_down","checks":{}}`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			healthMock := mock.NewMockhealthService(ctrl)
+			healthMock.EXPECT().Ready(gomock.Any()).Return(tt.report)
+
+			request := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+			w := httptest.NewRecorder()
+
+			NewHealth(healthMock).Readiness(w, request)
+			result := w.Result()
+			defer result.Body.Close()
+
+			body, err := ioutil.ReadAll(result.Body)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.want.statusCode, result.StatusCode)
+			assert.Equal(t, "application/json", result.Header.Get("Content-Type"))
+			assert.JSONEq(t, tt.want.response, string(body))
+		})
+	}
+}
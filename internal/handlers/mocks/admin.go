@@ -0,0 +1,51 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: admin.go
+
+// Package mock_handlers is a generated GoMock package.
+package mock_handlers
+
+import (
+	context "context"
+	reflect "reflect"
+
+	moderation "github.com/ChristinaFomenko/shortener/internal/app/service/moderation"
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockmoderationService is a mock of moderationService interface.
+type MockmoderationService struct {
+	ctrl     *gomock.Controller
+	recorder *MockmoderationServiceMockRecorder
+}
+
+// MockmoderationServiceMockRecorder is the mock recorder for MockmoderationService.
+type MockmoderationServiceMockRecorder struct {
+	mock *MockmoderationService
+}
+
+// NewMockmoderationService creates a new mock instance.
+func NewMockmoderationService(ctrl *gomock.Controller) *MockmoderationService {
+	mock := &MockmoderationService{ctrl: ctrl}
+	mock.recorder = &MockmoderationServiceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockmoderationService) EXPECT() *MockmoderationServiceMockRecorder {
+	return m.recorder
+}
+
+// DisableBlocked mocks base method.
+func (m *MockmoderationService) DisableBlocked(ctx context.Context) (moderation.Report, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DisableBlocked", ctx)
+	ret0, _ := ret[0].(moderation.Report)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DisableBlocked indicates an expected call of DisableBlocked.
+func (mr *MockmoderationServiceMockRecorder) DisableBlocked(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DisableBlocked", reflect.TypeOf((*MockmoderationService)(nil).DisableBlocked), ctx)
+}
@@ -0,0 +1,50 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: health.go
+
+// Package mock_handlers is a generated GoMock package.
+package mock_handlers
+
+import (
+	context "context"
+	reflect "reflect"
+
+	health "github.com/ChristinaFomenko/shortener/internal/app/service/health"
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockhealthService is a mock of healthService interface.
+type MockhealthService struct {
+	ctrl     *gomock.Controller
+	recorder *MockhealthServiceMockRecorder
+}
+
+// MockhealthServiceMockRecorder is the mock recorder for MockhealthService.
+type MockhealthServiceMockRecorder struct {
+	mock *MockhealthService
+}
+
+// NewMockhealthService creates a new mock instance.
+func NewMockhealthService(ctrl *gomock.Controller) *MockhealthService {
+	mock := &MockhealthService{ctrl: ctrl}
+	mock.recorder = &MockhealthServiceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockhealthService) EXPECT() *MockhealthServiceMockRecorder {
+	return m.recorder
+}
+
+// Ready mocks base method.
+func (m *MockhealthService) Ready(ctx context.Context) health.Report {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Ready", ctx)
+	ret0, _ := ret[0].(health.Report)
+	return ret0
+}
+
+// Ready indicates an expected call of Ready.
+func (mr *MockhealthServiceMockRecorder) Ready(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Ready", reflect.TypeOf((*MockhealthService)(nil).Ready), ctx)
+}
@@ -36,18 +36,32 @@ func (m *Mockservice) EXPECT() *MockserviceMockRecorder {
 }
 
 // Expand mocks base method.
-func (m *Mockservice) Expand(ctx context.Context, id string) (string, error) {
+func (m *Mockservice) Expand(ctx context.Context, id string, visit models.Visit) (models.Redirect, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Expand", ctx, id)
-	ret0, _ := ret[0].(string)
+	ret := m.ctrl.Call(m, "Expand", ctx, id, visit)
+	ret0, _ := ret[0].(models.Redirect)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Expand indicates an expected call of Expand.
-func (mr *MockserviceMockRecorder) Expand(ctx, id interface{}) *gomock.Call {
+func (mr *MockserviceMockRecorder) Expand(ctx, id, visit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Expand", reflect.TypeOf((*Mockservice)(nil).Expand), ctx, id, visit)
+}
+
+// ExportURLs mocks base method.
+func (m *Mockservice) ExportURLs(ctx context.Context, userID string, fn func(models.UserURL) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ExportURLs", ctx, userID, fn)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ExportURLs indicates an expected call of ExportURLs.
+func (mr *MockserviceMockRecorder) ExportURLs(ctx, userID, fn interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Expand", reflect.TypeOf((*Mockservice)(nil).Expand), ctx, id)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExportURLs", reflect.TypeOf((*Mockservice)(nil).ExportURLs), ctx, userID, fn)
 }
 
 // FetchURLs mocks base method.
@@ -65,19 +79,48 @@ func (mr *MockserviceMockRecorder) FetchURLs(ctx, userID interface{}) *gomock.Ca
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FetchURLs", reflect.TypeOf((*Mockservice)(nil).FetchURLs), ctx, userID)
 }
 
+// Rules mocks base method.
+func (m *Mockservice) Rules(ctx context.Context, urlID, userID string) ([]models.TargetRule, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Rules", ctx, urlID, userID)
+	ret0, _ := ret[0].([]models.TargetRule)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Rules indicates an expected call of Rules.
+func (mr *MockserviceMockRecorder) Rules(ctx, urlID, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Rules", reflect.TypeOf((*Mockservice)(nil).Rules), ctx, urlID, userID)
+}
+
+// SetRules mocks base method.
+func (m *Mockservice) SetRules(ctx context.Context, urlID, userID string, rules []models.TargetRule) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetRules", ctx, urlID, userID, rules)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetRules indicates an expected call of SetRules.
+func (mr *MockserviceMockRecorder) SetRules(ctx, urlID, userID, rules interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetRules", reflect.TypeOf((*Mockservice)(nil).SetRules), ctx, urlID, userID, rules)
+}
+
 // Shorten mocks base method.
-func (m *Mockservice) Shorten(ctx context.Context, url, userID string) (string, error) {
+func (m *Mockservice) Shorten(ctx context.Context, url, userID string, options models.LinkOptions) (string, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Shorten", ctx, url, userID)
+	ret := m.ctrl.Call(m, "Shorten", ctx, url, userID, options)
 	ret0, _ := ret[0].(string)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Shorten indicates an expected call of Shorten.
-func (mr *MockserviceMockRecorder) Shorten(ctx, url, userID interface{}) *gomock.Call {
+func (mr *MockserviceMockRecorder) Shorten(ctx, url, userID, options interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Shorten", reflect.TypeOf((*Mockservice)(nil).Shorten), ctx, url, userID)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Shorten", reflect.TypeOf((*Mockservice)(nil).Shorten), ctx, url, userID, options)
 }
 
 // ShortenBatch mocks base method.
@@ -1,7 +1,51 @@
 package handlers
 
+import (
+	"encoding/json"
+	"github.com/asaskevich/govalidator"
+	"regexp"
+	"time"
+)
+
+// schemePattern matches an explicit url scheme, such urls are left to the service to accept or block
+var schemePattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*:`)
+
+func init() {
+	govalidator.TagMap["link"] = isLink
+}
+
+// isLink passes urls and anything with a scheme, so blocked schemes get their own answer
+func isLink(str string) bool {
+	return govalidator.IsURL(str) || schemePattern.MatchString(str)
+}
+
 type ShortenRequest struct {
-	URL string `json:"url" valid:"url,required"`
+	URL          string              `json:"url" valid:"link,required"`
+	RedirectCode int                 `json:"redirect_code,omitempty"`
+	Passthrough  bool                `json:"passthrough,omitempty"`
+	Rules        []TargetRuleRequest `json:"rules,omitempty"`
+	Variants     []VariantRequest    `json:"variants,omitempty"`
+	Sticky       bool                `json:"sticky,omitempty"`
+}
+
+// VariantRequest is one destination of a split link, it gets weight shares of the traffic
+type VariantRequest struct {
+	Name   string `json:"name" valid:"required"`
+	URL    string `json:"url" valid:"link,required"`
+	Weight int    `json:"weight"`
+}
+
+// TargetRuleRequest sends clients matching the os and device class to url, an empty condition matches any
+type TargetRuleRequest struct {
+	OS     string `json:"os,omitempty" valid:"in(ios|android|windows|macos|linux)"`
+	Device string `json:"device,omitempty" valid:"in(mobile|tablet|desktop|bot)"`
+	URL    string `json:"url" valid:"link,required"`
+}
+
+type TargetRuleReply struct {
+	OS     string `json:"os,omitempty"`
+	Device string `json:"device,omitempty"`
+	URL    string `json:"url"`
 }
 
 type ShortenReply struct {
@@ -15,10 +59,67 @@ type GetUrlsReply struct {
 
 type ShortenBatchRequest struct {
 	CorrelationID string `json:"correlation_id" valid:"required"`
-	OriginalURL   string `json:"original_url" valid:"url,required"`
+	OriginalURL   string `json:"original_url" valid:"link,required"`
+	RedirectCode  int    `json:"redirect_code,omitempty"`
+	Passthrough   bool   `json:"passthrough,omitempty"`
 }
 
 type ShortenBatchReply struct {
 	CorrelationID string `json:"correlation_id"`
 	ShortURL      string `json:"short_url"`
+	Existing      bool   `json:"existing,omitempty"`
+}
+
+type ImportLineReply struct {
+	Line        int    `json:"line"`
+	OriginalURL string `json:"original_url"`
+	ShortURL    string `json:"short_url,omitempty"`
+	Status      string `json:"status"`
+	Error       string `json:"error,omitempty"`
+}
+
+// ImportReply tells what became of every line read, Incomplete when the file couldn't be read to the end.
+// It is streamed, the results come first.
+type ImportReply struct {
+	Results    []ImportLineReply `json:"results"`
+	Created    int               `json:"created"`
+	Existed    int               `json:"existed"`
+	Failed     int               `json:"failed"`
+	Incomplete bool              `json:"incomplete,omitempty"`
+}
+
+type DisabledLinkReply struct {
+	ID          string `json:"id"`
+	OriginalURL string `json:"original_url"`
+	Reason      string `json:"reason"`
+}
+
+type DisableBlockedReply struct {
+	Checked  int                 `json:"checked"`
+	Disabled []DisabledLinkReply `json:"disabled"`
+}
+
+type WebhookRequest struct {
+	URL    string   `json:"url"`
+	URLID  string   `json:"url_id,omitempty"`
+	Events []string `json:"events,omitempty"`
+}
+
+type WebhookReply struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	URLID     string    `json:"url_id,omitempty"`
+	Events    []string  `json:"events,omitempty"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type DeadLetterReply struct {
+	ID        string          `json:"id"`
+	WebhookID string          `json:"webhook_id"`
+	Event     string          `json:"event"`
+	Payload   json.RawMessage `json:"payload"`
+	Attempts  int             `json:"attempts"`
+	LastError string          `json:"last_error"`
+	CreatedAt time.Time       `json:"created_at"`
 }
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	"github.com/asaskevich/govalidator"
+	"github.com/go-chi/chi/v5"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+)
+
+// Rules returns the targeting rules of the caller's link in the order they are evaluated
+func (h *handler) Rules(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	userID := h.auth.UserID(r.Context())
+
+	rules, err := h.service.Rules(r.Context(), id, userID)
+	if err != nil {
+		if errors.Is(err, errs.ErrURLNotFound) {
+			http.Error(w, "url not found", http.StatusNotFound)
+			return
+		}
+		serverError(w, err)
+		return
+	}
+
+	resp := toTargetRulesReply(rules)
+	body, err := json.Marshal(&resp)
+	if err != nil {
+		log.WithError(err).WithField("resp", resp).Error("marshal rules response error")
+		serverError(w, err)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write(body); err != nil {
+		log.WithError(err).WithField("urlID", id).Error("write response error")
+	}
+}
+
+// SetRules replaces the targeting rules of the caller's link with the list in the body
+func (h *handler) SetRules(w http.ResponseWriter, r *http.Request) {
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		bodyError(w, err)
+		return
+	}
+
+	var req []TargetRuleRequest
+	if err = json.Unmarshal(b, &req); err != nil || !validRules(req) {
+		http.Error(w, "request in not valid", http.StatusBadRequest)
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	userID := h.auth.UserID(r.Context())
+
+	err = h.service.SetRules(r.Context(), id, userID, toTargetRules(req))
+	if err != nil {
+		if rejected(w, err) {
+			return
+		}
+		if errors.Is(err, errs.ErrURLNotFound) {
+			http.Error(w, "url not found", http.StatusNotFound)
+			return
+		}
+		serverError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func validRules(rules []TargetRuleRequest) bool {
+	for idx := range rules {
+		if ok, err := govalidator.ValidateStruct(rules[idx]); err != nil || !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+func validVariants(variants []VariantRequest) bool {
+	for idx := range variants {
+		if ok, err := govalidator.ValidateStruct(variants[idx]); err != nil || !ok {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"github.com/ChristinaFomenko/shortener/internal/app/models"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	mock "github.com/ChristinaFomenko/shortener/internal/handlers/mocks"
+)
+
+func Test_handler_Rules(t *testing.T) {
+	type want struct {
+		statusCode int
+		response   string
+	}
+	tests := []struct {
+		name  string
+		rules []models.TargetRule
+		err   error
+		want  want
+	}{
+		{
+			name: "success",
+			rules: []models.TargetRule{
+				{OS: models.OSIOS, URL: "https://apps.apple.com/app/id1"},
+				{OS: models.OSAndroid, Device: models.DeviceMobile, URL: "https://play.google.com/store/apps/details?id=app"},
+			},
+			want: want{
+				statusCode: 200,
+				response:   "[{\"os\":\"ios\",\"url\":\"https://apps.apple.com/app/id1\"},{\"os\":\"android\",\"device\":\"mobile\",\"url\":\"https://play.google.com/store/apps/details?id=app\"}]",
+			},
+		},
+		{
+			name: "no rules",
+			want: want{
+				statusCode: 200,
+				response:   "[]",
+			},
+		},
+		{
+			name: "link of another user",
+			err:  errs.ErrURLNotFound,
+			want: want{
+				statusCode: 404,
+				response:   "url not found\n",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			serviceMock := mock.NewMockservice(ctrl)
+			serviceMock.EXPECT().Rules(gomock.Any(), "abc", defaultUserID).Return(tt.rules, tt.err)
+
+			authMock := mock.NewMockauth(ctrl)
+			authMock.EXPECT().UserID(gomock.Any()).Return(defaultUserID)
+
+			httpHandler := New(serviceMock, authMock, nil)
+
+			request := withURLParam(httptest.NewRequest(http.MethodGet, "/api/user/urls/abc/rules", nil), "id", "abc")
+			writer := httptest.NewRecorder()
+			http.HandlerFunc(httpHandler.Rules).ServeHTTP(writer, request)
+			result := writer.Result()
+
+			assert.Equal(t, tt.want.statusCode, result.StatusCode)
+
+			body, err := ioutil.ReadAll(result.Body)
+			require.NoError(t, err)
+			require.NoError(t, result.Body.Close())
+
+			assert.Equal(t, tt.want.response, string(body))
+		})
+	}
+}
+
+func Test_handler_SetRules(t *testing.T) {
+	type want struct {
+		statusCode int
+		response   string
+	}
+	tests := []struct {
+		name       string
+		body       string
+		rules      []models.TargetRule
+		callsSrv   bool
+		serviceErr error
+		want       want
+	}{
+		{
+			name:     "success",
+			body:     "[{\"os\":\"ios\",\"url\":\"https://apps.apple.com/app/id1\"},{\"device\":\"bot\",\"url\":\"https://example.com/preview\"}]",
+			rules:    []models.TargetRule{{OS: models.OSIOS, URL: "https://apps.apple.com/app/id1"}, {Device: models.DeviceBot, URL: "https://example.com/preview"}},
+			callsSrv: true,
+			want: want{
+				statusCode: 204,
+			},
+		},
+		{
+			name:     "clear rules",
+			body:     "[]",
+			callsSrv: true,
+			want: want{
+				statusCode: 204,
+			},
+		},
+		{
+			name: "unknown os",
+			body: "[{\"os\":\"symbian\",\"url\":\"https://example.com\"}]",
+			want: want{
+				statusCode: 400,
+				response:   "request in not valid\n",
+			},
+		},
+		{
+			name:       "rule without condition",
+			body:       "[{\"url\":\"https://example.com\"}]",
+			rules:      []models.TargetRule{{URL: "https://example.com"}},
+			callsSrv:   true,
+			serviceErr: errs.ErrInvalidTargetRule,
+			want: want{
+				statusCode: 400,
+				response:   "targeting rule needs a known os or device class\n",
+			},
+		},
+		{
+			name:       "blocked destination",
+			body:       "[{\"os\":\"android\",\"url\":\"https://phishing.example\"}]",
+			rules:      []models.TargetRule{{OS: models.OSAndroid, URL: "https://phishing.example"}},
+			callsSrv:   true,
+			serviceErr: errs.NewBlockedURLErr("https://phishing.example", "domain phishing.example is blocked"),
+			want: want{
+				statusCode: 422,
+				response:   "url is blocked: domain phishing.example is blocked\n",
+			},
+		},
+		{
+			name:       "link of another user",
+			body:       "[]",
+			callsSrv:   true,
+			serviceErr: errs.ErrURLNotFound,
+			want: want{
+				statusCode: 404,
+				response:   "url not found\n",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			serviceMock := mock.NewMockservice(ctrl)
+			authMock := mock.NewMockauth(ctrl)
+			if tt.callsSrv {
+				serviceMock.EXPECT().SetRules(gomock.Any(), "abc", defaultUserID, tt.rules).Return(tt.serviceErr)
+				authMock.EXPECT().UserID(gomock.Any()).Return(defaultUserID)
+			}
+
+			httpHandler := New(serviceMock, authMock, nil)
+
+			request := withURLParam(httptest.NewRequest(http.MethodPut, "/api/user/urls/abc/rules", bytes.NewBufferString(tt.body)), "id", "abc")
+			writer := httptest.NewRecorder()
+			http.HandlerFunc(httpHandler.SetRules).ServeHTTP(writer, request)
+			result := writer.Result()
+
+			assert.Equal(t, tt.want.statusCode, result.StatusCode)
+
+			body, err := ioutil.ReadAll(result.Body)
+			require.NoError(t, err)
+			require.NoError(t, result.Body.Close())
+
+			assert.Equal(t, tt.want.response, string(body))
+		})
+	}
+}
+
+func withURLParam(r *http.Request, key, value string) *http.Request {
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add(key, value)
+
+	return r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+}
@@ -0,0 +1,434 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ChristinaFomenko/shortener/internal/app/models"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	formatCSV   = "text/csv"
+	formatJSONL = "application/x-ndjson"
+
+	maxImportLineSize = 1 << 20
+
+	importStatusCreated   = "created"
+	importStatusExists    = "exists"
+	importStatusInvalid   = "invalid"
+	importStatusBlocked   = "blocked"
+	importStatusFailed    = "failed"
+	importStatusMalformed = "malformed"
+)
+
+var csvHeader = []string{"short_url", "original_url"}
+
+// transferFormats maps accepted media types onto the export/import formats
+var transferFormats = map[string]string{
+	"text/csv":              formatCSV,
+	"application/x-ndjson":  formatJSONL,
+	"application/jsonl":     formatJSONL,
+	"application/jsonlines": formatJSONL,
+}
+
+// ExportURLs streams the caller's links as CSV or JSON Lines depending on Accept, every row is written
+// as it is read. A failure before the first row is answered with an error, a later one cuts the export short.
+func (h *handler) ExportURLs(w http.ResponseWriter, r *http.Request) {
+	format, ok := negotiateExportFormat(r.Header.Get("Accept"))
+	if !ok {
+		http.Error(w, "supported formats: text/csv, application/x-ndjson", http.StatusNotAcceptable)
+		return
+	}
+
+	userID := h.auth.UserID(r.Context())
+	export := &exporter{w: w, format: format}
+
+	err := h.service.ExportURLs(r.Context(), userID, export.write)
+	if err == nil {
+		err = export.finish()
+	}
+	if err != nil {
+		log.WithError(err).WithField("userID", userID).Error("export urls error")
+		if !export.started {
+			serverError(w, err)
+		}
+	}
+}
+
+// ImportURLs shortens every link of an uploaded CSV or JSON Lines file and streams the result per line,
+// the counters follow the results. A file that can't be read to the end is reported up to the failing line,
+// the lines before it are imported; the status tells the error only when no line was answered before it.
+func (h *handler) ImportURLs(w http.ResponseWriter, r *http.Request) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		http.Error(w, "content type not specified", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	format, ok := transferFormats[mediaType]
+	if !ok {
+		http.Error(w, "supported formats: text/csv, application/x-ndjson", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	userID := h.auth.UserID(r.Context())
+	reply := &importWriter{w: w}
+
+	importLine := func(line int, originalURL string, parseErr error) {
+		result := ImportLineReply{Line: line, OriginalURL: originalURL}
+
+		switch {
+		case parseErr != nil:
+			result.Status = importStatusMalformed
+			result.Error = parseErr.Error()
+		case !isLink(originalURL):
+			result.Status = importStatusInvalid
+			result.Error = "url not valid"
+		default:
+			shortcut, err := h.service.Shorten(r.Context(), originalURL, userID, models.LinkOptions{})
+			var blockedErr *errs.BlockedURLErr
+			switch {
+			case err == nil:
+				result.Status = importStatusCreated
+				result.ShortURL = shortcut
+			case errors.Is(err, errs.ErrNotUniqueURL):
+				result.Status = importStatusExists
+				result.ShortURL = shortcut
+			case errors.Is(err, errs.ErrInvalidURL):
+				result.Status = importStatusInvalid
+				result.Error = err.Error()
+			case errors.As(err, &blockedErr):
+				result.Status = importStatusBlocked
+				result.Error = blockedErr.Error()
+			default:
+				log.WithError(err).WithField("line", line).Error("import line error")
+				result.Status = importStatusFailed
+				result.Error = http.StatusText(http.StatusInternalServerError)
+			}
+		}
+
+		reply.add(http.StatusOK, result)
+	}
+
+	switch format {
+	case formatCSV:
+		err = readCSV(r.Body, importLine)
+	default:
+		err = readJSONL(r.Body, importLine)
+	}
+	if err != nil {
+		log.WithError(err).WithField("userID", userID).Error("read import error")
+
+		result := ImportLineReply{Status: importStatusMalformed, Error: err.Error()}
+		var lineErr *lineError
+		if errors.As(err, &lineErr) {
+			result.Line = lineErr.Line
+			result.Error = lineErr.Err.Error()
+		}
+		reply.add(bodyErrorStatus(err), result)
+		reply.Incomplete = true
+	}
+
+	if err = reply.finish(); err != nil {
+		log.WithError(err).WithField("userID", userID).Error("write response error")
+	}
+}
+
+// importWriter streams an ImportReply: the results as they come, the counters once the file is read.
+// The status goes out with the first result.
+type importWriter struct {
+	ImportReply
+	w       http.ResponseWriter
+	started bool
+	err     error
+}
+
+func (iw *importWriter) add(statusCode int, result ImportLineReply) {
+	switch result.Status {
+	case importStatusCreated:
+		iw.Created++
+	case importStatusExists:
+		iw.Existed++
+	default:
+		iw.Failed++
+	}
+
+	if iw.err != nil {
+		return
+	}
+
+	body, err := json.Marshal(&result)
+	if err != nil {
+		iw.err = err
+		return
+	}
+
+	separator := ","
+	if !iw.started {
+		iw.w.Header().Set("content-type", "application/json")
+		iw.w.WriteHeader(statusCode)
+		iw.started = true
+		separator = `{"results":[`
+	}
+
+	if _, err = io.WriteString(iw.w, separator); err == nil {
+		_, err = iw.w.Write(body)
+	}
+	iw.err = err
+}
+
+func (iw *importWriter) finish() error {
+	if iw.err != nil {
+		return iw.err
+	}
+
+	if !iw.started {
+		iw.w.Header().Set("content-type", "application/json")
+		iw.w.WriteHeader(http.StatusOK)
+		if _, err := io.WriteString(iw.w, `{"results":[`); err != nil {
+			return err
+		}
+	}
+
+	_, err := fmt.Fprintf(iw.w, `],"created":%d,"existed":%d,"failed":%d`, iw.Created, iw.Existed, iw.Failed)
+	if err == nil && iw.Incomplete {
+		_, err = io.WriteString(iw.w, `,"incomplete":true`)
+	}
+	if err == nil {
+		_, err = io.WriteString(iw.w, "}")
+	}
+
+	return err
+}
+
+// exporter encodes the rows of an export as they come, the headers go out with the first one
+type exporter struct {
+	w       http.ResponseWriter
+	format  string
+	csv     *csv.Writer
+	json    *json.Encoder
+	started bool
+}
+
+func (e *exporter) start() error {
+	e.w.Header().Set("content-type", e.format)
+	e.w.Header().Set("content-disposition", fmt.Sprintf("attachment; filename=%q", exportFileName(e.format)))
+	e.w.WriteHeader(http.StatusOK)
+	e.started = true
+
+	if e.format != formatCSV {
+		e.json = json.NewEncoder(e.w)
+		return nil
+	}
+
+	e.csv = csv.NewWriter(e.w)
+
+	return e.csv.Write(csvHeader)
+}
+
+func (e *exporter) write(url models.UserURL) error {
+	if !e.started {
+		if err := e.start(); err != nil {
+			return err
+		}
+	}
+
+	if e.csv != nil {
+		return e.csv.Write([]string{url.ShortURL, url.OriginalURL})
+	}
+
+	return e.json.Encode(&GetUrlsReply{ShortURL: url.ShortURL, OriginalURL: url.OriginalURL})
+}
+
+func (e *exporter) finish() error {
+	if !e.started {
+		if err := e.start(); err != nil {
+			return err
+		}
+	}
+
+	if e.csv != nil {
+		e.csv.Flush()
+		return e.csv.Error()
+	}
+
+	return nil
+}
+
+// acceptRange is a media range of an Accept header with its weight
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+// negotiateExportFormat picks the format of the range weighted most, ranges of the same weight in the order given
+func negotiateExportFormat(accept string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return formatJSONL, true
+	}
+
+	ranges := make([]acceptRange, 0)
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+
+		ranges = append(ranges, acceptRange{mediaType: mediaType, q: q})
+	}
+
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].q > ranges[j].q
+	})
+
+	for _, acceptable := range ranges {
+		if format, ok := transferFormats[acceptable.mediaType]; ok {
+			return format, true
+		}
+
+		switch acceptable.mediaType {
+		case "*/*", "application/*":
+			return formatJSONL, true
+		case "text/*":
+			return formatCSV, true
+		}
+	}
+
+	return "", false
+}
+
+func exportFileName(format string) string {
+	if format == formatCSV {
+		return "urls.csv"
+	}
+
+	return "urls.jsonl"
+}
+
+// lineError stops reading an import at Line
+type lineError struct {
+	Line int
+	Err  error
+}
+
+func (e *lineError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *lineError) Unwrap() error {
+	return e.Err
+}
+
+// readCSV reads records one by one; the header row must contain an original_url column.
+// A record that can't be parsed ends the file, the reader can't tell where the next one starts.
+func readCSV(r io.Reader, fn func(line int, originalURL string, parseErr error)) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return &lineError{Line: 1, Err: errors.New("csv header not specified")}
+		}
+		return &lineError{Line: 1, Err: csvError(err)}
+	}
+
+	column := -1
+	for idx := range header {
+		if strings.TrimSpace(header[idx]) == "original_url" {
+			column = idx
+		}
+	}
+	if column < 0 {
+		return &lineError{Line: 1, Err: errors.New("csv header has no original_url column")}
+	}
+
+	line := 1
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				return &lineError{Line: parseErr.StartLine, Err: csvError(err)}
+			}
+			return &lineError{Line: line + 1, Err: err}
+		}
+
+		line, _ = reader.FieldPos(0)
+
+		if column >= len(record) {
+			fn(line, "", nil)
+			continue
+		}
+
+		fn(line, strings.TrimSpace(record[column]), nil)
+	}
+}
+
+// csvError keeps the reason of a parse error, its position is told by the line
+func csvError(err error) error {
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return fmt.Errorf("malformed csv: %w", parseErr.Err)
+	}
+
+	return err
+}
+
+// readJSONL reads one json object per line, empty lines are skipped and malformed ones reported
+func readJSONL(r io.Reader, fn func(line int, originalURL string, parseErr error)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxImportLineSize)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+
+		data := scanner.Bytes()
+		if len(strings.TrimSpace(string(data))) == 0 {
+			continue
+		}
+
+		var record GetUrlsReply
+		if err := json.Unmarshal(data, &record); err != nil {
+			fn(line, "", fmt.Errorf("malformed json: %w", err))
+			continue
+		}
+
+		fn(line, strings.TrimSpace(record.OriginalURL), nil)
+	}
+
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			err = fmt.Errorf("line longer than %d bytes", maxImportLineSize)
+		}
+		return &lineError{Line: line + 1, Err: err}
+	}
+
+	return nil
+}
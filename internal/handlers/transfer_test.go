@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"github.com/ChristinaFomenko/shortener/internal/app/models"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	mock "github.com/ChristinaFomenko/shortener/internal/handlers/mocks"
+)
+
+func Test_handler_ExportURLs(t *testing.T) {
+	type want struct {
+		contentType string
+		statusCode  int
+		response    string
+	}
+	urls := []models.UserURL{
+		{
+			ShortURL:    "http://localhost:8080/abcde",
+			OriginalURL: "https://yandex.ru",
+		},
+		{
+			ShortURL:    "http://localhost:8080/qwerty",
+			OriginalURL: "https://github.com",
+		},
+	}
+	tests := []struct {
+		name   string
+		accept string
+		want   want
+	}{
+		{
+			name:   "csv",
+			accept: "text/csv",
+			want: want{
+				contentType: "text/csv",
+				statusCode:  200,
+				response:    "short_url,original_url\nhttp://localhost:8080/abcde,https://yandex.ru\nhttp://localhost:8080/qwerty,https://github.com\n",
+			},
+		},
+		{
+			name:   "json lines",
+			accept: "application/x-ndjson",
+			want: want{
+				contentType: "application/x-ndjson",
+				statusCode:  200,
+				response:    "{\"short_url\":\"http://localhost:8080/abcde\",\"original_url\":\"https://yandex.ru\"}\n{\"short_url\":\"http://localhost:8080/qwerty\",\"original_url\":\"https://github.com\"}\n",
+			},
+		},
+		{
+			name:   "weighted accept",
+			accept: "application/x-ndjson;q=0, text/csv;q=0.5",
+			want: want{
+				contentType: "text/csv",
+				statusCode:  200,
+				response:    "short_url,original_url\nhttp://localhost:8080/abcde,https://yandex.ru\nhttp://localhost:8080/qwerty,https://github.com\n",
+			},
+		},
+		{
+			name:   "most weighted accept",
+			accept: "text/csv;q=0.5, application/x-ndjson;q=0.9",
+			want: want{
+				contentType: "application/x-ndjson",
+				statusCode:  200,
+				response:    "{\"short_url\":\"http://localhost:8080/abcde\",\"original_url\":\"https://yandex.ru\"}\n{\"short_url\":\"http://localhost:8080/qwerty\",\"original_url\":\"https://github.com\"}\n",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			serviceMock := mock.NewMockservice(ctrl)
+			serviceMock.EXPECT().ExportURLs(gomock.Any(), defaultUserID, gomock.Any()).DoAndReturn(
+				func(_ context.Context, _ string, fn func(url models.UserURL) error) error {
+					for _, url := range urls {
+						if err := fn(url); err != nil {
+							return err
+						}
+					}
+					return nil
+				})
+
+			authMock := mock.NewMockauth(ctrl)
+			authMock.EXPECT().UserID(gomock.Any()).Return(defaultUserID)
+
+			httpHandler := New(serviceMock, authMock, nil)
+
+			request := httptest.NewRequest(http.MethodGet, "/api/user/urls/export", nil)
+			request.Header.Set("Accept", tt.accept)
+
+			w := httptest.NewRecorder()
+			h := http.HandlerFunc(httpHandler.ExportURLs)
+			h.ServeHTTP(w, request)
+			result := w.Result()
+
+			assert.Equal(t, tt.want.statusCode, result.StatusCode)
+			assert.Equal(t, tt.want.contentType, result.Header.Get("Content-Type"))
+
+			body, err := ioutil.ReadAll(result.Body)
+			require.NoError(t, err)
+			err = result.Body.Close()
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.want.response, string(body))
+		})
+	}
+}
+
+func Test_handler_ExportURLs_Failed(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	serviceMock := mock.NewMockservice(ctrl)
+	serviceMock.EXPECT().ExportURLs(gomock.Any(), defaultUserID, gomock.Any()).Return(errs.ErrStorageUnavailable)
+
+	authMock := mock.NewMockauth(ctrl)
+	authMock.EXPECT().UserID(gomock.Any()).Return(defaultUserID)
+
+	httpHandler := New(serviceMock, authMock, nil)
+
+	request := httptest.NewRequest(http.MethodGet, "/api/user/urls/export", nil)
+	request.Header.Set("Accept", "text/csv")
+
+	w := httptest.NewRecorder()
+	h := http.HandlerFunc(httpHandler.ExportURLs)
+	h.ServeHTTP(w, request)
+	result := w.Result()
+	defer result.Body.Close()
+
+	assert.NotEqual(t, http.StatusOK, result.StatusCode)
+	assert.Empty(t, result.Header.Get("Content-Disposition"))
+}
+
+func Test_handler_ExportURLs_NotAcceptable(t *testing.T) {
+	httpHandler := New(nil, nil, nil)
+
+	request := httptest.NewRequest(http.MethodGet, "/api/user/urls/export", nil)
+	request.Header.Set("Accept", "image/png")
+
+	w := httptest.NewRecorder()
+	h := http.HandlerFunc(httpHandler.ExportURLs)
+	h.ServeHTTP(w, request)
+	result := w.Result()
+	defer result.Body.Close()
+
+	assert.Equal(t, http.StatusNotAcceptable, result.StatusCode)
+}
+
+func Test_handler_ImportURLs(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		response    string
+	}{
+		{
+			name:        "csv",
+			contentType: "text/csv; charset=utf-8",
+			body:        "short_url,original_url\nhttp://old/abcde,https://yandex.ru\nhttp://old/qwerty,https://github.com\nhttp://old/zxcvb,qwerty\n",
+			response:    "{\"results\":[{\"line\":2,\"original_url\":\"https://yandex.ru\",\"short_url\":\"http://localhost:8080/abcde\",\"status\":\"created\"},{\"line\":3,\"original_url\":\"https://github.com\",\"short_url\":\"http://localhost:8080/qwerty\",\"status\":\"exists\"},{\"line\":4,\"original_url\":\"qwerty\",\"status\":\"invalid\",\"error\":\"url not valid\"}],\"created\":1,\"existed\":1,\"failed\":1}",
+		},
+		{
+			name:        "json lines",
+			contentType: "application/x-ndjson",
+			body:        "{\"original_url\":\"https://yandex.ru\"}\n\n{\"original_url\":\"https://github.com\"}\n{\"original_url\":\"qwerty\"}\n",
+			response:    "{\"results\":[{\"line\":1,\"original_url\":\"https://yandex.ru\",\"short_url\":\"http://localhost:8080/abcde\",\"status\":\"created\"},{\"line\":3,\"original_url\":\"https://github.com\",\"short_url\":\"http://localhost:8080/qwerty\",\"status\":\"exists\"},{\"line\":4,\"original_url\":\"qwerty\",\"status\":\"invalid\",\"error\":\"url not valid\"}],\"created\":1,\"existed\":1,\"failed\":1}",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			serviceMock := mock.NewMockservice(ctrl)
+			serviceMock.EXPECT().Shorten(gomock.Any(), "https://yandex.ru", defaultUserID, models.LinkOptions{}).Return("http://localhost:8080/abcde", nil)
+			serviceMock.EXPECT().Shorten(gomock.Any(), "https://github.com", defaultUserID, models.LinkOptions{}).Return("http://localhost:8080/qwerty", errs.ErrNotUniqueURL)
+
+			authMock := mock.NewMockauth(ctrl)
+			authMock.EXPECT().UserID(gomock.Any()).Return(defaultUserID)
+
+			httpHandler := New(serviceMock, authMock, nil)
+
+			request := httptest.NewRequest(http.MethodPost, "/api/user/urls/import", bytes.NewBufferString(tt.body))
+			request.Header.Set("Content-Type", tt.contentType)
+
+			w := httptest.NewRecorder()
+			h := http.HandlerFunc(httpHandler.ImportURLs)
+			h.ServeHTTP(w, request)
+			result := w.Result()
+
+			assert.Equal(t, http.StatusOK, result.StatusCode)
+			assert.Equal(t, "application/json", result.Header.Get("Content-Type"))
+
+			body, err := ioutil.ReadAll(result.Body)
+			require.NoError(t, err)
+			err = result.Body.Close()
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.response, string(body))
+		})
+	}
+}
+
+func Test_handler_ImportURLs_Incomplete(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        io.Reader
+		statusCode  int
+		response    string
+	}{
+		{
+			name:        "malformed json line",
+			contentType: "application/x-ndjson",
+			body:        bytes.NewBufferString("{\"original_url\":\"https://yandex.ru\"}\n{\"original_url\":\n"),
+			statusCode:  http.StatusOK,
+			response:    "{\"results\":[{\"line\":1,\"original_url\":\"https://yandex.ru\",\"short_url\":\"http://localhost:8080/abcde\",\"status\":\"created\"},{\"line\":2,\"original_url\":\"\",\"status\":\"malformed\",\"error\":\"malformed json: unexpected end of JSON input\"}],\"created\":1,\"existed\":0,\"failed\":1}",
+		},
+		{
+			name:        "malformed csv record",
+			contentType: "text/csv",
+			body:        bytes.NewBufferString("original_url\nhttps://yandex.ru\n\"https://github.com\n"),
+			statusCode:  http.StatusOK,
+			response:    "{\"results\":[{\"line\":2,\"original_url\":\"https://yandex.ru\",\"short_url\":\"http://localhost:8080/abcde\",\"status\":\"created\"},{\"line\":3,\"original_url\":\"\",\"status\":\"malformed\",\"error\":\"malformed csv: extraneous or missing \\\" in quoted-field\"}],\"created\":1,\"existed\":0,\"failed\":1,\"incomplete\":true}",
+		},
+		{
+			name:        "body too large",
+			contentType: "application/x-ndjson",
+			body:        io.MultiReader(bytes.NewBufferString("{\"original_url\":\"https://yandex.ru\"}\n"), failingReader{err: errs.ErrBodyTooLarge}),
+			statusCode:  http.StatusOK,
+			response:    "{\"results\":[{\"line\":1,\"original_url\":\"https://yandex.ru\",\"short_url\":\"http://localhost:8080/abcde\",\"status\":\"created\"},{\"line\":2,\"original_url\":\"\",\"status\":\"malformed\",\"error\":\"request body too large\"}],\"created\":1,\"existed\":0,\"failed\":1,\"incomplete\":true}",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			serviceMock := mock.NewMockservice(ctrl)
+			serviceMock.EXPECT().Shorten(gomock.Any(), "https://yandex.ru", defaultUserID, models.LinkOptions{}).Return("http://localhost:8080/abcde", nil)
+
+			authMock := mock.NewMockauth(ctrl)
+			authMock.EXPECT().UserID(gomock.Any()).Return(defaultUserID)
+
+			httpHandler := New(serviceMock, authMock, nil)
+
+			request := httptest.NewRequest(http.MethodPost, "/api/user/urls/import", tt.body)
+			request.Header.Set("Content-Type", tt.contentType)
+
+			w := httptest.NewRecorder()
+			h := http.HandlerFunc(httpHandler.ImportURLs)
+			h.ServeHTTP(w, request)
+			result := w.Result()
+
+			assert.Equal(t, tt.statusCode, result.StatusCode)
+
+			body, err := ioutil.ReadAll(result.Body)
+			require.NoError(t, err)
+			err = result.Body.Close()
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.response, string(body))
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/ChristinaFomenko/shortener/internal/app/models"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	"github.com/go-chi/chi/v5"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+)
+
+//go:generate mockgen -source=webhooks.go -destination=mocks/webhooks.go
+
+type webhookService interface {
+	Register(ctx context.Context, hook models.Webhook) (models.Webhook, error)
+	Webhooks(ctx context.Context, userID string) ([]models.Webhook, error)
+	Delete(ctx context.Context, userID, hookID string) error
+	DeadLetters(ctx context.Context, userID string) ([]models.Delivery, error)
+	Retry(ctx context.Context, userID, deliveryID string) error
+}
+
+type webhooksHandler struct {
+	service webhookService
+	auth    auth
+}
+
+func NewWebhooks(service webhookService, userAuth auth) *webhooksHandler {
+	return &webhooksHandler{
+		service: service,
+		auth:    userAuth,
+	}
+}
+
+// Register adds a webhook for all links of the caller or, with url_id, for one of them.
+// The reply carries the signing secret, it is not shown again.
+func (h *webhooksHandler) Register(w http.ResponseWriter, r *http.Request) {
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		bodyError(w, err)
+		return
+	}
+
+	var req WebhookRequest
+	if err = json.Unmarshal(b, &req); err != nil {
+		http.Error(w, "request in not valid", http.StatusBadRequest)
+		return
+	}
+
+	userID := h.auth.UserID(r.Context())
+
+	hook, err := h.service.Register(r.Context(), toWebhook(req, userID))
+	if err != nil {
+		switch {
+		case errors.Is(err, errs.ErrInvalidWebhook):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, errs.ErrURLNotFound):
+			http.Error(w, "url not found", http.StatusNotFound)
+		default:
+			serverError(w, err)
+		}
+		return
+	}
+
+	reply := toWebhooksReply([]models.Webhook{hook})[0]
+	reply.Secret = hook.Secret
+
+	writeJSON(w, http.StatusCreated, reply)
+}
+
+// Webhooks lists the caller's webhooks without their secrets
+func (h *webhooksHandler) Webhooks(w http.ResponseWriter, r *http.Request) {
+	userID := h.auth.UserID(r.Context())
+
+	hooks, err := h.service.Webhooks(r.Context(), userID)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, toWebhooksReply(hooks))
+}
+
+// Delete removes the caller's webhook, deliveries still queued for it are dropped
+func (h *webhooksHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	userID := h.auth.UserID(r.Context())
+
+	err := h.service.Delete(r.Context(), userID, chi.URLParam(r, "id"))
+	if err != nil {
+		if errors.Is(err, errs.ErrWebhookNotFound) {
+			http.Error(w, "webhook not found", http.StatusNotFound)
+			return
+		}
+		serverError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeadLetters lists the caller's deliveries that ran out of attempts
+func (h *webhooksHandler) DeadLetters(w http.ResponseWriter, r *http.Request) {
+	userID := h.auth.UserID(r.Context())
+
+	dead, err := h.service.DeadLetters(r.Context(), userID)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, toDeadLettersReply(dead))
+}
+
+// Retry puts a dead letter of the caller back into the queue
+func (h *webhooksHandler) Retry(w http.ResponseWriter, r *http.Request) {
+	userID := h.auth.UserID(r.Context())
+
+	err := h.service.Retry(r.Context(), userID, chi.URLParam(r, "id"))
+	if err != nil {
+		switch {
+		case errors.Is(err, errs.ErrDeliveryNotFound):
+			http.Error(w, "delivery not found", http.StatusNotFound)
+		case errors.Is(err, errs.ErrWebhookNotFound):
+			http.Error(w, "webhook of the delivery was deleted", http.StatusConflict)
+		default:
+			serverError(w, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func writeJSON(w http.ResponseWriter, status int, reply interface{}) {
+	body, err := json.Marshal(reply)
+	if err != nil {
+		log.WithError(err).Error("marshal response error")
+		serverError(w, err)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(status)
+	if _, err = w.Write(body); err != nil {
+		log.WithError(err).Error("write response error")
+	}
+}
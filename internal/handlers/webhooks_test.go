@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/ChristinaFomenko/shortener/internal/app/models"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	mock "github.com/ChristinaFomenko/shortener/internal/handlers/mocks"
+)
+
+func Test_webhooksHandler_Register(t *testing.T) {
+	type want struct {
+		statusCode int
+		response   string
+	}
+	createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name       string
+		body       string
+		hook       models.Webhook
+		callsSrv   bool
+		serviceErr error
+		want       want
+	}{
+		{
+			name:     "success",
+			body:     "{\"url\":\"https://example.com/hook\",\"url_id\":\"abc\",\"events\":[\"link.clicked\"]}",
+			hook:     models.Webhook{ID: "hookid", UserID: defaultUserID, URLID: "abc", URL: "https://example.com/hook", Events: []string{models.EventLinkClicked}, Secret: "secret", CreatedAt: createdAt},
+			callsSrv: true,
+			want: want{
+				statusCode: 201,
+				response:   "{\"id\":\"hookid\",\"url\":\"https://example.com/hook\",\"url_id\":\"abc\",\"events\":[\"link.clicked\"],\"secret\":\"secret\",\"created_at\":\"2024-01-02T03:04:05Z\"}",
+			},
+		},
+		{
+			name: "bad json",
+			body: "{\"url\":",
+			want: want{
+				statusCode: 400,
+				response:   "request in not valid\n",
+			},
+		},
+		{
+			name:       "invalid webhook",
+			body:       "{\"url\":\"ftp://example.com/hook\"}",
+			callsSrv:   true,
+			serviceErr: fmt.Errorf("%w: absolute http or https url expected", errs.ErrInvalidWebhook),
+			want: want{
+				statusCode: 400,
+				response:   "webhook not valid: absolute http or https url expected\n",
+			},
+		},
+		{
+			name:       "link of another user",
+			body:       "{\"url\":\"https://example.com/hook\",\"url_id\":\"abc\"}",
+			callsSrv:   true,
+			serviceErr: errs.ErrURLNotFound,
+			want: want{
+				statusCode: 404,
+				response:   "url not found\n",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			serviceMock := mock.NewMockwebhookService(ctrl)
+			authMock := mock.NewMockauth(ctrl)
+			if tt.callsSrv {
+				authMock.EXPECT().UserID(gomock.Any()).Return(defaultUserID)
+				serviceMock.EXPECT().Register(gomock.Any(), gomock.Any()).Return(tt.hook, tt.serviceErr)
+			}
+
+			httpHandler := NewWebhooks(serviceMock, authMock)
+
+			request := httptest.NewRequest(http.MethodPost, "/api/user/webhooks", bytes.NewBufferString(tt.body))
+			writer := httptest.NewRecorder()
+			http.HandlerFunc(httpHandler.Register).ServeHTTP(writer, request)
+			result := writer.Result()
+
+			assert.Equal(t, tt.want.statusCode, result.StatusCode)
+
+			body, err := ioutil.ReadAll(result.Body)
+			require.NoError(t, err)
+			require.NoError(t, result.Body.Close())
+
+			assert.Equal(t, tt.want.response, string(body))
+		})
+	}
+}
+
+func Test_webhooksHandler_Webhooks(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	serviceMock := mock.NewMockwebhookService(ctrl)
+	serviceMock.EXPECT().Webhooks(gomock.Any(), defaultUserID).Return([]models.Webhook{
+		{ID: "hookid", UserID: defaultUserID, URL: "https://example.com/hook", Secret: "secret", CreatedAt: createdAt},
+	}, nil)
+
+	authMock := mock.NewMockauth(ctrl)
+	authMock.EXPECT().UserID(gomock.Any()).Return(defaultUserID)
+
+	request := httptest.NewRequest(http.MethodGet, "/api/user/webhooks", nil)
+	writer := httptest.NewRecorder()
+	http.HandlerFunc(NewWebhooks(serviceMock, authMock).Webhooks).ServeHTTP(writer, request)
+	result := writer.Result()
+
+	body, err := ioutil.ReadAll(result.Body)
+	require.NoError(t, err)
+	require.NoError(t, result.Body.Close())
+
+	assert.Equal(t, http.StatusOK, result.StatusCode)
+	assert.Equal(t, "[{\"id\":\"hookid\",\"url\":\"https://example.com/hook\",\"created_at\":\"2024-01-02T03:04:05Z\"}]", string(body))
+}
+
+func Test_webhooksHandler_Retry(t *testing.T) {
+	tests := []struct {
+		name       string
+		serviceErr error
+		statusCode int
+	}{
+		{
+			name:       "success",
+			statusCode: 202,
+		},
+		{
+			name:       "unknown delivery",
+			serviceErr: errs.ErrDeliveryNotFound,
+			statusCode: 404,
+		},
+		{
+			name:       "webhook deleted",
+			serviceErr: errs.ErrWebhookNotFound,
+			statusCode: 409,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			serviceMock := mock.NewMockwebhookService(ctrl)
+			serviceMock.EXPECT().Retry(gomock.Any(), defaultUserID, "delivery").Return(tt.serviceErr)
+
+			authMock := mock.NewMockauth(ctrl)
+			authMock.EXPECT().UserID(gomock.Any()).Return(defaultUserID)
+
+			request := withURLParam(httptest.NewRequest(http.MethodPost, "/api/user/webhooks/dead/delivery/retry", nil), "id", "delivery")
+			writer := httptest.NewRecorder()
+			http.HandlerFunc(NewWebhooks(serviceMock, authMock).Retry).ServeHTTP(writer, request)
+			result := writer.Result()
+			require.NoError(t, result.Body.Close())
+
+			assert.Equal(t, tt.statusCode, result.StatusCode)
+		})
+	}
+}
@@ -1,49 +1,348 @@
 package middlewares
 
 import (
+	"bufio"
+	"compress/flate"
 	"compress/gzip"
+	"errors"
 	"fmt"
+	"github.com/andybalholm/brotli"
 	"io"
+	"mime"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 )
 
-type gzipWriter struct {
-	http.ResponseWriter
-	Writer io.Writer
+const (
+	encodingBrotli  = "br"
+	encodingGzip    = "gzip"
+	encodingDeflate = "deflate"
+
+	// minCompressSize is the body size below which compression costs more than it saves
+	minCompressSize = 512
+
+	gzipLevel    = gzip.BestSpeed
+	deflateLevel = flate.BestSpeed
+	brotliLevel  = 4
+)
+
+// supportedEncodings are ordered by preference, it breaks ties between equal q-values
+var supportedEncodings = []string{encodingBrotli, encodingGzip, encodingDeflate}
+
+// compressibleTypes are media types worth compressing besides text/*, +json and +xml
+var compressibleTypes = map[string]struct{}{
+	"application/json":       {},
+	"application/x-ndjson":   {},
+	"application/javascript": {},
+	"application/xml":        {},
+	"image/svg+xml":          {},
+}
+
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
 }
 
 type compressor struct {
-	gz *gzip.Writer
+	pools   map[string]*sync.Pool
+	minSize int
 }
 
 func NewCompressor() (*compressor, error) {
-	gz, err := gzip.NewWriterLevel(nil, gzip.BestSpeed)
+	gz, err := gzip.NewWriterLevel(nil, gzipLevel)
 	if err != nil {
-		return nil, fmt.Errorf("init compressor error: %w", err)
+		return nil, fmt.Errorf("init gzip compressor error: %w", err)
 	}
 
-	return &compressor{gz: gz}, nil
+	fl, err := flate.NewWriter(nil, deflateLevel)
+	if err != nil {
+		return nil, fmt.Errorf("init deflate compressor error: %w", err)
+	}
+
+	c := &compressor{
+		pools: map[string]*sync.Pool{
+			encodingGzip: {New: func() interface{} {
+				w, _ := gzip.NewWriterLevel(nil, gzipLevel)
+				return w
+			}},
+			encodingDeflate: {New: func() interface{} {
+				w, _ := flate.NewWriter(nil, deflateLevel)
+				return w
+			}},
+			encodingBrotli: {New: func() interface{} {
+				return brotli.NewWriterLevel(nil, brotliLevel)
+			}},
+		},
+		minSize: minCompressSize,
+	}
+
+	c.pools[encodingGzip].Put(gz)
+	c.pools[encodingDeflate].Put(fl)
+
+	return c, nil
 }
 
+// Compressing encodes responses with the best encoding the client accepts, every writer
+// comes from a pool, so concurrent requests never share one
 func (c *compressor) Compressing(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		c.gz.Reset(w)
-		defer func(gz *gzip.Writer) {
-			_ = gz.Close()
-		}(c.gz)
-
-		w.Header().Set("Content-Encoding", "gzip")
+		cw := &compressWriter{
+			ResponseWriter: w,
+			compressor:     c,
+			encoding:       encoding,
+		}
+		defer func() {
+			_ = cw.Close()
+		}()
 
-		next.ServeHTTP(gzipWriter{ResponseWriter: w, Writer: c.gz}, r)
+		next.ServeHTTP(cw, r)
 	})
 }
 
-func (gw gzipWriter) Write(b []byte) (int, error) {
-	return gw.Writer.Write(b)
+func (c *compressor) encoder(encoding string, w io.Writer) encoder {
+	enc := c.pools[encoding].Get().(encoder)
+	enc.Reset(w)
+
+	return enc
+}
+
+func (c *compressor) release(encoding string, enc encoder) {
+	enc.Reset(nil)
+	c.pools[encoding].Put(enc)
+}
+
+// negotiateEncoding picks the supported encoding with the highest q-value,
+// an empty result means the response goes as is
+func negotiateEncoding(acceptEncoding string) string {
+	if strings.TrimSpace(acceptEncoding) == "" {
+		return ""
+	}
+
+	qualities := map[string]float64{}
+	wildcard := -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, q, ok := parseCoding(part)
+		if !ok {
+			continue
+		}
+
+		if name == "*" {
+			wildcard = q
+			continue
+		}
+
+		qualities[name] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, encoding := range supportedEncodings {
+		q, ok := qualities[encoding]
+		if !ok {
+			q = wildcard
+		}
+
+		if q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+
+	return best
+}
+
+func parseCoding(part string) (string, float64, bool) {
+	fields := strings.Split(part, ";")
+	name := strings.ToLower(strings.TrimSpace(fields[0]))
+	if name == "" {
+		return "", 0, false
+	}
+
+	q := 1.0
+	for _, param := range fields[1:] {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(kv) != 2 || strings.ToLower(strings.TrimSpace(kv[0])) != "q" {
+			continue
+		}
+
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+		if err != nil || parsed < 0 || parsed > 1 {
+			return "", 0, false
+		}
+		q = parsed
+	}
+
+	return name, q, true
+}
+
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	if strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "+json") ||
+		strings.HasSuffix(mediaType, "+xml") {
+		return true
+	}
+
+	_, ok := compressibleTypes[mediaType]
+
+	return ok
+}
+
+// compressWriter buffers the beginning of a response until it knows whether compression pays off
+type compressWriter struct {
+	http.ResponseWriter
+	compressor *compressor
+	encoding   string
+
+	status  int
+	buf     []byte
+	decided bool
+	enc     encoder
+}
+
+func (cw *compressWriter) WriteHeader(statusCode int) {
+	if cw.decided || cw.status != 0 {
+		return
+	}
+
+	cw.status = statusCode
+	if !bodyAllowed(statusCode) {
+		cw.decide(false)
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+
+	if cw.decided {
+		if cw.enc != nil {
+			return cw.enc.Write(b)
+		}
+		return cw.ResponseWriter.Write(b)
+	}
+
+	cw.buf = append(cw.buf, b...)
+	if len(cw.buf) >= cw.compressor.minSize {
+		if err := cw.start(true); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(b), nil
+}
+
+// Flush sends what is buffered, a streamed response is compressed no matter how small the first chunk is
+func (cw *compressWriter) Flush() {
+	if !cw.decided && cw.status != 0 {
+		if err := cw.start(true); err != nil {
+			return
+		}
+	}
+
+	if cw.enc != nil {
+		if err := cw.enc.Flush(); err != nil {
+			return
+		}
+	}
+
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := cw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+
+	return hijacker.Hijack()
+}
+
+func (cw *compressWriter) Close() error {
+	if !cw.decided {
+		if cw.status == 0 {
+			return nil
+		}
+
+		// the whole body is buffered and it is too small to be worth compressing
+		if err := cw.start(len(cw.buf) >= cw.compressor.minSize); err != nil {
+			return err
+		}
+	}
+
+	if cw.enc == nil {
+		return nil
+	}
+
+	err := cw.enc.Close()
+	cw.compressor.release(cw.encoding, cw.enc)
+	cw.enc = nil
+
+	return err
+}
+
+// start writes the header and the buffered body, compressing them when allowed
+func (cw *compressWriter) start(compress bool) error {
+	cw.decide(compress)
+
+	if len(cw.buf) == 0 {
+		return nil
+	}
+
+	var err error
+	if cw.enc != nil {
+		_, err = cw.enc.Write(cw.buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(cw.buf)
+	}
+	cw.buf = nil
+
+	return err
+}
+
+func (cw *compressWriter) decide(compress bool) {
+	cw.decided = true
+
+	header := cw.Header()
+	if header.Get("Content-Type") == "" && len(cw.buf) > 0 {
+		header.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+
+	if compress && bodyAllowed(cw.status) && header.Get("Content-Encoding") == "" && compressible(header.Get("Content-Type")) {
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", cw.encoding)
+		cw.enc = cw.compressor.encoder(cw.encoding, cw.ResponseWriter)
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+}
+
+// bodyAllowed reports whether a response with the status is worth compressing,
+// informational, no content and redirect responses carry no meaningful body
+func bodyAllowed(statusCode int) bool {
+	switch {
+	case statusCode < http.StatusOK,
+		statusCode == http.StatusNoContent,
+		statusCode >= http.StatusMultipleChoices && statusCode < http.StatusBadRequest:
+		return false
+	}
+
+	return true
 }
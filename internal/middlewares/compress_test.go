@@ -0,0 +1,190 @@
+package middlewares
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func Test_negotiateEncoding(t *testing.T) {
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		want           string
+	}{
+		{name: "empty", acceptEncoding: "", want: ""},
+		{name: "gzip", acceptEncoding: "gzip", want: "gzip"},
+		{name: "server preference on ties", acceptEncoding: "gzip, deflate, br", want: "br"},
+		{name: "q-values", acceptEncoding: "br;q=0.5, gzip;q=0.8, deflate;q=0.1", want: "gzip"},
+		{name: "refused encoding", acceptEncoding: "gzip;q=0, deflate", want: "deflate"},
+		{name: "wildcard", acceptEncoding: "*", want: "br"},
+		{name: "wildcard with exclusion", acceptEncoding: "*;q=0.5, br;q=0", want: "gzip"},
+		{name: "identity only", acceptEncoding: "identity", want: ""},
+		{name: "everything refused", acceptEncoding: "*;q=0", want: ""},
+		{name: "malformed q-value", acceptEncoding: "br;q=abc, gzip", want: "gzip"},
+		{name: "case insensitive", acceptEncoding: "GZIP;Q=1", want: "gzip"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, negotiateEncoding(tt.acceptEncoding))
+		})
+	}
+}
+
+func Test_compressor_Compressing(t *testing.T) {
+	large := strings.Repeat(`{"short_url":"http://localhost:8080/abcde"}`, 50)
+
+	type want struct {
+		statusCode      int
+		contentEncoding string
+	}
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		handler        http.HandlerFunc
+		want           want
+	}{
+		{
+			name:           "large json is compressed",
+			acceptEncoding: "gzip",
+			handler:        respond(http.StatusOK, "application/json", large),
+			want:           want{statusCode: 200, contentEncoding: "gzip"},
+		},
+		{
+			name:           "brotli",
+			acceptEncoding: "br",
+			handler:        respond(http.StatusCreated, "application/json", large),
+			want:           want{statusCode: 201, contentEncoding: "br"},
+		},
+		{
+			name:           "deflate",
+			acceptEncoding: "deflate",
+			handler:        respond(http.StatusOK, "text/plain; charset=utf-8", large),
+			want:           want{statusCode: 200, contentEncoding: "deflate"},
+		},
+		{
+			name:           "tiny body is sent as is",
+			acceptEncoding: "gzip",
+			handler:        respond(http.StatusCreated, "text/plain", "http://localhost:8080/abcde"),
+			want:           want{statusCode: 201},
+		},
+		{
+			name:           "non compressible type",
+			acceptEncoding: "gzip",
+			handler:        respond(http.StatusOK, "image/png", large),
+			want:           want{statusCode: 200},
+		},
+		{
+			name:           "redirect",
+			acceptEncoding: "gzip",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Location", "https://yandex.ru")
+				w.WriteHeader(http.StatusTemporaryRedirect)
+			},
+			want: want{statusCode: 307},
+		},
+		{
+			name:           "no content",
+			acceptEncoding: "gzip",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			},
+			want: want{statusCode: 204},
+		},
+		{
+			name:           "client does not accept compression",
+			acceptEncoding: "",
+			handler:        respond(http.StatusOK, "application/json", large),
+			want:           want{statusCode: 200},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewCompressor()
+			require.NoError(t, err)
+
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			w := httptest.NewRecorder()
+
+			c.Compressing(tt.handler).ServeHTTP(w, request)
+			result := w.Result()
+			defer result.Body.Close()
+
+			assert.Equal(t, tt.want.statusCode, result.StatusCode)
+			assert.Equal(t, tt.want.contentEncoding, result.Header.Get("Content-Encoding"))
+			assert.Equal(t, "Accept-Encoding", result.Header.Get("Vary"))
+
+			body, err := ioutil.ReadAll(decode(t, tt.want.contentEncoding, result.Body))
+			require.NoError(t, err)
+
+			recorded := httptest.NewRecorder()
+			tt.handler(recorded, request)
+			assert.Equal(t, recorded.Body.String(), string(body))
+		})
+	}
+}
+
+func Test_compressor_Concurrent(t *testing.T) {
+	c, err := NewCompressor()
+	require.NoError(t, err)
+
+	handler := c.Compressing(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte(strings.Repeat(r.URL.Query().Get("id"), 1000)))
+	}))
+
+	var wg sync.WaitGroup
+	for idx := 0; idx < 50; idx++ {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+
+			encoding := supportedEncodings[idx%len(supportedEncodings)]
+			id := fmt.Sprintf("<%d>", idx)
+			request := httptest.NewRequest(http.MethodGet, "/?id="+id, nil)
+			request.Header.Set("Accept-Encoding", encoding)
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, request)
+
+			body, err := ioutil.ReadAll(decode(t, encoding, w.Body))
+			assert.NoError(t, err)
+			assert.Equal(t, strings.Repeat(id, 1000), string(body))
+		}(idx)
+	}
+	wg.Wait()
+}
+
+func respond(statusCode int, contentType, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(statusCode)
+		_, _ = w.Write([]byte(body))
+	}
+}
+
+func decode(t *testing.T, encoding string, body io.Reader) io.Reader {
+	switch encoding {
+	case encodingGzip:
+		gz, err := gzip.NewReader(body)
+		require.NoError(t, err)
+		return gz
+	case encodingDeflate:
+		return flate.NewReader(body)
+	case encodingBrotli:
+		return brotli.NewReader(body)
+	}
+
+	return body
+}
@@ -1,39 +1,182 @@
 package middlewares
 
 import (
-	"bytes"
+	"compress/flate"
 	"compress/gzip"
+	"errors"
+	"fmt"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	"github.com/andybalholm/brotli"
 	"io"
-	"io/ioutil"
 	"net/http"
+	"strings"
 )
 
-func Decompressing(next http.Handler) http.Handler {
+// ratioCheckFloor is the decompressed size from which the compression ratio is enforced,
+// small bodies of repeated text legitimately compress far better than any sane limit
+const ratioCheckFloor = 64 << 10
+
+type decompressor struct {
+	maxSize  int64
+	maxRatio int64
+}
+
+// NewDecompressor limits request bodies to maxSize bytes after decoding and refuses
+// compressed bodies that expand more than maxRatio times, zero disables a limit
+func NewDecompressor(maxSize, maxRatio int64) *decompressor {
+	return &decompressor{
+		maxSize:  maxSize,
+		maxRatio: maxRatio,
+	}
+}
+
+// Decompressing decodes the request body on the fly, so handlers read it as a stream
+// and never hold more than the limits allow
+func (d *decompressor) Decompressing(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var reader io.Reader
+		codings := contentCodings(r.Header.Get("Content-Encoding"))
 
-		if r.Header.Get(`Content-Encoding`) == `gzip` {
-			gz, err := gzip.NewReader(r.Body)
+		source := &countingReader{reader: r.Body}
+		body := &limitedBody{
+			source:   source,
+			closer:   r.Body,
+			maxSize:  d.maxSize,
+			maxRatio: d.maxRatio,
+		}
+
+		var reader io.Reader = source
+		// codings are listed in the order they were applied
+		for idx := len(codings) - 1; idx >= 0; idx-- {
+			decoder, err := newDecoder(codings[idx], reader)
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				if errors.Is(err, errs.ErrMalformedEncoding) {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
 				return
 			}
 
-			reader = gz
-			defer func(gz *gzip.Reader) {
-				_ = gz.Close()
-			}(gz)
-		} else {
-			reader = r.Body
+			if closer, ok := decoder.(io.Closer); ok {
+				body.decoders = append(body.decoders, closer)
+			}
+			reader = decoder
 		}
+		body.reader = reader
+		body.compressed = len(codings) > 0
 
-		body, err := io.ReadAll(reader)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+		if body.compressed {
+			r.Header.Del("Content-Encoding")
+			r.Header.Del("Content-Length")
+			r.ContentLength = -1
 		}
 
-		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		r.Body = body
 		next.ServeHTTP(w, r)
 	})
 }
+
+// BodyLimit replaces the size limit of Decompressing for the routes it wraps, zero disables it
+func BodyLimit(maxSize int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if body, ok := r.Body.(*limitedBody); ok {
+				body.maxSize = maxSize
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func contentCodings(header string) []string {
+	var codings []string
+	for _, coding := range strings.Split(header, ",") {
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" || coding == "identity" {
+			continue
+		}
+
+		codings = append(codings, coding)
+	}
+
+	return codings
+}
+
+func newDecoder(coding string, r io.Reader) (io.Reader, error) {
+	switch coding {
+	case encodingGzip, "x-gzip":
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errs.ErrMalformedEncoding, err)
+		}
+		return gz, nil
+	case encodingDeflate:
+		return flate.NewReader(r), nil
+	case encodingBrotli:
+		return brotli.NewReader(r), nil
+	}
+
+	return nil, fmt.Errorf("content encoding %q is not supported", coding)
+}
+
+// countingReader counts the bytes received from the client and remembers their read error,
+// so it isn't mistaken for a decoding failure
+type countingReader struct {
+	reader io.Reader
+	read   int64
+	err    error
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.read += int64(n)
+	if err != nil && err != io.EOF {
+		c.err = err
+	}
+
+	return n, err
+}
+
+type limitedBody struct {
+	reader     io.Reader
+	source     *countingReader
+	closer     io.Closer
+	decoders   []io.Closer
+	compressed bool
+
+	maxSize  int64
+	maxRatio int64
+	read     int64
+	err      error
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+
+	n, err := b.reader.Read(p)
+	b.read += int64(n)
+
+	switch {
+	case b.maxSize > 0 && b.read > b.maxSize:
+		b.err = fmt.Errorf("%w: limit is %d bytes", errs.ErrBodyTooLarge, b.maxSize)
+	case b.compressed && b.maxRatio > 0 && b.read > ratioCheckFloor && b.read > b.source.read*b.maxRatio:
+		b.err = fmt.Errorf("%w: limit is %d", errs.ErrCompressionRatio, b.maxRatio)
+	case err != nil && err != io.EOF && b.compressed && err != b.source.err:
+		b.err = fmt.Errorf("%w: %v", errs.ErrMalformedEncoding, err)
+	default:
+		return n, err
+	}
+
+	return 0, b.err
+}
+
+func (b *limitedBody) Close() error {
+	for _, decoder := range b.decoders {
+		_ = decoder.Close()
+	}
+
+	return b.closer.Close()
+}
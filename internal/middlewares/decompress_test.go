@@ -0,0 +1,211 @@
+package middlewares
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/hex"
+	"errors"
+	errs "github.com/ChristinaFomenko/shortener/pkg/errors"
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_decompressor_Decompressing(t *testing.T) {
+	const maxSize = 1 << 20
+
+	payload := `{"url":"https://yandex.ru"}`
+	bomb := strings.Repeat("a", 512<<10)
+
+	random := make([]byte, maxSize/2+1)
+	_, err := rand.New(rand.NewSource(1)).Read(random)
+	require.NoError(t, err)
+	incompressible := hex.EncodeToString(random)
+
+	type want struct {
+		statusCode int
+		body       string
+		err        error
+	}
+	tests := []struct {
+		name            string
+		contentEncoding string
+		body            []byte
+		want            want
+	}{
+		{
+			name: "plain",
+			body: []byte(payload),
+			want: want{statusCode: 200, body: payload},
+		},
+		{
+			name:            "gzip",
+			contentEncoding: "gzip",
+			body:            encode(t, encodingGzip, payload),
+			want:            want{statusCode: 200, body: payload},
+		},
+		{
+			name:            "deflate",
+			contentEncoding: "deflate",
+			body:            encode(t, encodingDeflate, payload),
+			want:            want{statusCode: 200, body: payload},
+		},
+		{
+			name:            "brotli",
+			contentEncoding: "br",
+			body:            encode(t, encodingBrotli, payload),
+			want:            want{statusCode: 200, body: payload},
+		},
+		{
+			name:            "stacked encodings",
+			contentEncoding: "deflate, gzip",
+			body:            encodeBytes(t, encodingGzip, encode(t, encodingDeflate, payload)),
+			want:            want{statusCode: 200, body: payload},
+		},
+		{
+			name:            "bad gzip header",
+			contentEncoding: "gzip",
+			body:            []byte(payload),
+			want:            want{statusCode: 400},
+		},
+		{
+			name:            "corrupt deflate stream",
+			contentEncoding: "deflate",
+			body:            []byte{0xff, 0xff, 0xff, 0xff},
+			want:            want{statusCode: 400, err: errs.ErrMalformedEncoding},
+		},
+		{
+			name:            "truncated gzip",
+			contentEncoding: "gzip",
+			body:            encode(t, encodingGzip, payload)[:20],
+			want:            want{statusCode: 400, err: errs.ErrMalformedEncoding},
+		},
+		{
+			name:            "unsupported encoding",
+			contentEncoding: "compress",
+			body:            []byte(payload),
+			want:            want{statusCode: 415},
+		},
+		{
+			name: "plain body too large",
+			body: []byte(strings.Repeat("a", maxSize+1)),
+			want: want{statusCode: 413, err: errs.ErrBodyTooLarge},
+		},
+		{
+			name:            "decompressed body too large",
+			contentEncoding: "gzip",
+			body:            encode(t, encodingGzip, incompressible),
+			want:            want{statusCode: 413, err: errs.ErrBodyTooLarge},
+		},
+		{
+			name:            "compression ratio too high",
+			contentEncoding: "br",
+			body:            encode(t, encodingBrotli, bomb),
+			want:            want{statusCode: 413, err: errs.ErrCompressionRatio},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var readErr error
+			handler := NewDecompressor(maxSize, 100).Decompressing(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Empty(t, r.Header.Get("Content-Encoding"))
+
+				body, err := ioutil.ReadAll(r.Body)
+				if err != nil {
+					readErr = err
+					switch {
+					case errors.Is(err, errs.ErrBodyTooLarge), errors.Is(err, errs.ErrCompressionRatio):
+						w.WriteHeader(http.StatusRequestEntityTooLarge)
+					default:
+						w.WriteHeader(http.StatusBadRequest)
+					}
+					return
+				}
+
+				_, _ = w.Write(body)
+			}))
+
+			request := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(tt.body))
+			request.Header.Set("Content-Encoding", tt.contentEncoding)
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, request)
+			result := w.Result()
+			defer result.Body.Close()
+
+			assert.Equal(t, tt.want.statusCode, result.StatusCode)
+			if tt.want.err != nil {
+				assert.ErrorIs(t, readErr, tt.want.err)
+			}
+			if tt.want.body != "" {
+				body, err := ioutil.ReadAll(result.Body)
+				require.NoError(t, err)
+				assert.Equal(t, tt.want.body, string(body))
+			}
+		})
+	}
+}
+
+func TestBodyLimit(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxSize  int64
+		body     string
+		expError error
+	}{
+		{name: "raised", maxSize: 16, body: strings.Repeat("a", 16)},
+		{name: "lowered", maxSize: 4, body: strings.Repeat("a", 5), expError: errs.ErrBodyTooLarge},
+		{name: "disabled", body: strings.Repeat("a", 64)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var readErr error
+			handler := NewDecompressor(8, 100).Decompressing(BodyLimit(tt.maxSize)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, readErr = ioutil.ReadAll(r.Body)
+			})))
+
+			request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			handler.ServeHTTP(httptest.NewRecorder(), request)
+
+			if tt.expError != nil {
+				assert.ErrorIs(t, readErr, tt.expError)
+				return
+			}
+			assert.NoError(t, readErr)
+		})
+	}
+}
+
+func encode(t *testing.T, encoding, body string) []byte {
+	return encodeBytes(t, encoding, []byte(body))
+}
+
+func encodeBytes(t *testing.T, encoding string, body []byte) []byte {
+	var buf bytes.Buffer
+
+	var w io.WriteCloser
+	switch encoding {
+	case encodingGzip:
+		w = gzip.NewWriter(&buf)
+	case encodingDeflate:
+		fl, err := flate.NewWriter(&buf, flate.DefaultCompression)
+		require.NoError(t, err)
+		w = fl
+	case encodingBrotli:
+		w = brotli.NewWriter(&buf)
+	}
+
+	_, err := w.Write(body)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	return buf.Bytes()
+}
@@ -0,0 +1,50 @@
+package middlewares
+
+import (
+	"github.com/ChristinaFomenko/shortener/pkg/metrics"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const unmatchedRoute = "unmatched"
+
+type httpMetrics struct {
+	requests *metrics.CounterVec
+	latency  *metrics.HistogramVec
+}
+
+func NewHTTPMetrics(registry *metrics.Registry) *httpMetrics {
+	return &httpMetrics{
+		requests: registry.NewCounterVec("shortener_http_requests_total",
+			"HTTP requests served, by chi route pattern and status.", "method", "route", "status"),
+		latency: registry.NewHistogramVec("shortener_http_request_duration_seconds",
+			"HTTP request latency, by chi route pattern and status.", metrics.DefBuckets, "method", "route", "status"),
+	}
+}
+
+// Measure records every request by the route pattern it matched, so ids don't blow up the series
+func (m *httpMetrics) Measure(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		route := unmatchedRoute
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		labels := []string{r.Method, route, strconv.Itoa(status)}
+		m.requests.Inc(labels...)
+		m.latency.Observe(time.Since(start).Seconds(), labels...)
+	})
+}
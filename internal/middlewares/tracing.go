@@ -0,0 +1,91 @@
+package middlewares
+
+import (
+	"context"
+	"fmt"
+	"github.com/ChristinaFomenko/shortener/pkg/tracing"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"net/http"
+	"strconv"
+)
+
+type middlewareSpanKey struct{}
+
+type middlewareSpan struct {
+	span   *tracing.Span
+	parent *tracing.Span
+}
+
+// Tracing starts the server span of a request, continuing the trace of an incoming traceparent header
+func Tracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		opts := []tracing.StartOption{tracing.WithKind(tracing.KindServer)}
+		if remote, ok := tracing.Extract(r.Header); ok {
+			opts = append(opts, tracing.WithRemoteParent(remote))
+		}
+
+		ctx, span := tracing.Start(r.Context(), "HTTP "+r.Method, opts...)
+		defer span.End()
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		route := unmatchedRoute
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		span.SetName(fmt.Sprintf("%s %s", r.Method, route))
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.route", route)
+		span.SetAttribute("http.target", r.URL.RequestURI())
+		span.SetAttribute("http.status_code", strconv.Itoa(status))
+		if status >= http.StatusInternalServerError {
+			span.RecordError(fmt.Errorf("http status %d", status))
+		}
+	})
+}
+
+// TraceMiddleware wraps a middleware into a span that lasts until it calls the next handler,
+// so the span shows the time spent in the middleware itself
+func TraceMiddleware(name string, mw func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			if ms, ok := ctx.Value(middlewareSpanKey{}).(middlewareSpan); ok {
+				ms.span.End()
+				ctx = tracing.ContextWithSpan(ctx, ms.parent)
+			}
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+
+		wrapped := mw(inner)
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			parent := tracing.SpanFromContext(r.Context())
+
+			ctx, span := tracing.Start(r.Context(), "middleware."+name)
+			defer span.End()
+
+			ctx = context.WithValue(ctx, middlewareSpanKey{}, middlewareSpan{span: span, parent: parent})
+			wrapped.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// TraceHandler wraps a handler into a span
+func TraceHandler(name string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := tracing.Start(r.Context(), "handler."+name)
+		defer span.End()
+
+		h(w, r.WithContext(ctx))
+	}
+}
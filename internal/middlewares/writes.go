@@ -0,0 +1,99 @@
+package middlewares
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"github.com/ChristinaFomenko/shortener/internal/app/consistency"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const lastWriteCookieName = "last-write"
+
+// LastWrite hands the time of the user's last write kept in a cookie down to the storage and renews the cookie
+// when the request writes, so reads of the user go to the primary whichever instance serves them
+func LastWrite(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := consistency.WithLastWrite(r.Context(), lastWriteAt(r))
+		lw := &lastWriteWriter{ResponseWriter: w, ctx: ctx}
+
+		next.ServeHTTP(lw, r.WithContext(ctx))
+
+		// a handler writing nothing gets its headers sent after it returns
+		lw.setCookie()
+	})
+}
+
+// lastWriteAt reads the cookie, a time in the future is cut to now so it can't keep reads off the replicas
+func lastWriteAt(r *http.Request) time.Time {
+	cookie, err := r.Cookie(lastWriteCookieName)
+	if err != nil {
+		return time.Time{}
+	}
+
+	ms, err := strconv.ParseInt(cookie.Value, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+
+	at, now := time.UnixMilli(ms), time.Now()
+	if at.After(now) {
+		return now
+	}
+
+	return at
+}
+
+// lastWriteWriter sets the cookie right before the headers go out, the handler has written by then
+type lastWriteWriter struct {
+	http.ResponseWriter
+	ctx         context.Context
+	wroteHeader bool
+}
+
+func (lw *lastWriteWriter) WriteHeader(statusCode int) {
+	lw.setCookie()
+	lw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (lw *lastWriteWriter) Write(b []byte) (int, error) {
+	lw.setCookie()
+	return lw.ResponseWriter.Write(b)
+}
+
+func (lw *lastWriteWriter) Flush() {
+	lw.setCookie()
+	if flusher, ok := lw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (lw *lastWriteWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := lw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+
+	return hijacker.Hijack()
+}
+
+func (lw *lastWriteWriter) setCookie() {
+	if lw.wroteHeader {
+		return
+	}
+	lw.wroteHeader = true
+
+	at, ok := consistency.Written(lw.ctx)
+	if !ok {
+		return
+	}
+
+	http.SetCookie(lw.ResponseWriter, &http.Cookie{
+		Name:  lastWriteCookieName,
+		Value: strconv.FormatInt(at.UnixMilli(), 10),
+		Path:  "/",
+	})
+}
@@ -0,0 +1,103 @@
+package middlewares
+
+import (
+	"github.com/ChristinaFomenko/shortener/internal/app/consistency"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestLastWrite(t *testing.T) {
+	past := time.Now().Add(-time.Minute).Truncate(time.Millisecond)
+
+	tests := []struct {
+		name      string
+		cookie    string
+		write     bool
+		body      bool
+		wantSeen  func(at time.Time) bool
+		wantSetAt bool
+	}{
+		{
+			name:     "no cookie, no write",
+			wantSeen: time.Time.IsZero,
+		},
+		{
+			name:     "cookie passed to the request",
+			cookie:   strconv.FormatInt(past.UnixMilli(), 10),
+			body:     true,
+			wantSeen: past.Equal,
+		},
+		{
+			name:     "future cookie cut to now",
+			cookie:   strconv.FormatInt(time.Now().Add(time.Hour).UnixMilli(), 10),
+			wantSeen: func(at time.Time) bool { return !at.After(time.Now()) },
+		},
+		{
+			name:     "malformed cookie",
+			cookie:   "yesterday",
+			wantSeen: time.Time.IsZero,
+		},
+		{
+			name:      "write renews the cookie",
+			cookie:    strconv.FormatInt(past.UnixMilli(), 10),
+			write:     true,
+			body:      true,
+			wantSeen:  past.Equal,
+			wantSetAt: true,
+		},
+		{
+			name:      "write without a body",
+			write:     true,
+			wantSeen:  time.Time.IsZero,
+			wantSetAt: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen time.Time
+			handler := LastWrite(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = consistency.LastWrite(r.Context())
+				if tt.write {
+					consistency.Wrote(r.Context())
+				}
+				if tt.body {
+					w.WriteHeader(http.StatusCreated)
+					_, _ = w.Write([]byte("ok"))
+				}
+			}))
+
+			r := httptest.NewRequest(http.MethodPost, "/", nil)
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: lastWriteCookieName, Value: tt.cookie})
+			}
+			w := httptest.NewRecorder()
+			start := time.Now().Truncate(time.Millisecond)
+
+			handler.ServeHTTP(w, r)
+
+			assert.True(t, tt.wantSeen(seen), seen)
+
+			var set *http.Cookie
+			for _, cookie := range w.Result().Cookies() {
+				if cookie.Name == lastWriteCookieName {
+					set = cookie
+				}
+			}
+			if !tt.wantSetAt {
+				assert.Nil(t, set)
+				return
+			}
+
+			require.NotNil(t, set)
+			ms, err := strconv.ParseInt(set.Value, 10, 64)
+			require.NoError(t, err)
+			assert.False(t, time.UnixMilli(ms).Before(start))
+		})
+	}
+}
@@ -3,11 +3,32 @@ package errors
 import (
 	"errors"
 	"fmt"
+	"time"
 )
 
 var (
-	ErrURLNotFound  = errors.New("url not found")
-	ErrNotUniqueURL = errors.New("url not unique error")
+	ErrURLNotFound   = errors.New("url not found")
+	ErrNotUniqueURL  = errors.New("url not unique error")
+	ErrURLIDConflict = errors.New("url id is taken by another url")
+	ErrInvalidURL    = errors.New("url not valid")
+
+	ErrURLSettingsConflict = errors.New("url is already shortened with other settings")
+
+	ErrInvalidRedirectCode = errors.New("redirect code must be one of 301, 302, 307, 308")
+	ErrInvalidTargetRule   = errors.New("targeting rule needs a known os or device class")
+	ErrTooManyTargetRules  = errors.New("too many targeting rules")
+	ErrInvalidVariants     = errors.New("variants not valid")
+
+	ErrInvalidStorageURL  = errors.New("invalid storage url")
+	ErrStorageUnavailable = errors.New("storage unavailable")
+
+	ErrWebhookNotFound  = errors.New("webhook not found")
+	ErrDeliveryNotFound = errors.New("delivery not found")
+	ErrInvalidWebhook   = errors.New("webhook not valid")
+
+	ErrBodyTooLarge      = errors.New("request body too large")
+	ErrCompressionRatio  = errors.New("request body compression ratio too high")
+	ErrMalformedEncoding = errors.New("malformed request body encoding")
 )
 
 type NotUniqueURLErr struct {
@@ -27,3 +48,40 @@ func NewNotUniqueURLErr(urlID, originalURL string, err error) error {
 func (e *NotUniqueURLErr) Error() string {
 	return fmt.Sprintf("url not unique: urlID %v, originalURL: %v, error: %v ", e.URLID, e.OriginalURL, e.Err)
 }
+
+// BlockedURLErr is returned for urls refused by the blocklist, Reason is safe to show to the user
+type BlockedURLErr struct {
+	URL    string
+	Reason string
+}
+
+func NewBlockedURLErr(url, reason string) error {
+	return &BlockedURLErr{
+		URL:    url,
+		Reason: reason,
+	}
+}
+
+func (e *BlockedURLErr) Error() string {
+	return fmt.Sprintf("url is blocked: %s", e.Reason)
+}
+
+// UnavailableErr is returned without trying the storage while it is considered down,
+// it is tried again after RetryAfter
+type UnavailableErr struct {
+	RetryAfter time.Duration
+}
+
+func NewUnavailableErr(retryAfter time.Duration) error {
+	return &UnavailableErr{
+		RetryAfter: retryAfter,
+	}
+}
+
+func (e *UnavailableErr) Error() string {
+	return fmt.Sprintf("storage unavailable, retry after %s", e.RetryAfter)
+}
+
+func (e *UnavailableErr) Is(target error) bool {
+	return target == ErrStorageUnavailable
+}
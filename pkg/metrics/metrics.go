@@ -0,0 +1,355 @@
+// Package metrics is a small dependency free metrics registry rendered in the
+// Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefBuckets are latency buckets in seconds suited for http requests and storage calls
+var DefBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+type Registry struct {
+	mu         sync.Mutex
+	names      map[string]struct{}
+	collectors []collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		names: map[string]struct{}{},
+	}
+}
+
+func (r *Registry) register(name string, c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.names[name]; ok {
+		panic(fmt.Sprintf("metrics: %s registered twice", name))
+	}
+
+	r.names[name] = struct{}{}
+	r.collectors = append(r.collectors, c)
+}
+
+// NewCounterVec registers a counter partitioned by the given labels
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		desc:   desc{name: name, help: help, labels: labels},
+		series: map[string]*counterSeries{},
+	}
+	r.register(name, c)
+
+	return c
+}
+
+// NewHistogramVec registers a histogram partitioned by the given labels, buckets must be sorted
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{
+		desc:    desc{name: name, help: help, labels: labels},
+		buckets: buckets,
+		series:  map[string]*histogramSeries{},
+	}
+	r.register(name, h)
+
+	return h
+}
+
+// NewGaugeFunc registers a gauge whose value is read on every scrape
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(name, &gaugeFunc{desc: desc{name: name, help: help}, fn: fn})
+}
+
+// WriteTo renders all metrics in the text exposition format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	collectors := make([]collector, len(r.collectors))
+	copy(collectors, r.collectors)
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, c := range collectors {
+		c.write(bw)
+	}
+	err := bw.Flush()
+
+	return cw.n, err
+}
+
+// Handler serves the registry for scraping
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		_, _ = r.WriteTo(w)
+	})
+}
+
+type desc struct {
+	name   string
+	help   string
+	labels []string
+}
+
+func (d desc) writeHeader(w *bufio.Writer, kind string) {
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, escapeHelp(d.help), d.name, kind)
+}
+
+func (d desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", d.name, len(d.labels), len(values)))
+	}
+
+	return strings.Join(values, "\xff")
+}
+
+// labelPairs renders {a="x",b="y"} with an optional extra pair such as le
+func (d desc) labelPairs(values []string, extraName, extraValue string) string {
+	if len(d.labels) == 0 && extraName == "" {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for idx, label := range d.labels {
+		if idx > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(label)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(values[idx]))
+		b.WriteByte('"')
+	}
+	if extraName != "" {
+		if len(d.labels) > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(extraName)
+		b.WriteString(`="`)
+		b.WriteString(extraValue)
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+
+	return b.String()
+}
+
+type CounterVec struct {
+	desc
+	mu     sync.RWMutex
+	series map[string]*counterSeries
+}
+
+type counterSeries struct {
+	values []string
+	bits   uint64
+}
+
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add increases the counter, negative values are ignored
+func (c *CounterVec) Add(v float64, values ...string) {
+	if v < 0 {
+		return
+	}
+
+	s := c.get(values)
+	for {
+		old := atomic.LoadUint64(&s.bits)
+		next := math.Float64bits(math.Float64frombits(old) + v)
+		if atomic.CompareAndSwapUint64(&s.bits, old, next) {
+			return
+		}
+	}
+}
+
+// Value returns the current counter value
+func (c *CounterVec) Value(values ...string) float64 {
+	return math.Float64frombits(atomic.LoadUint64(&c.get(values).bits))
+}
+
+func (c *CounterVec) get(values []string) *counterSeries {
+	key := c.key(values)
+
+	c.mu.RLock()
+	s, ok := c.series[key]
+	c.mu.RUnlock()
+	if ok {
+		return s
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if s, ok = c.series[key]; !ok {
+		s = &counterSeries{values: append([]string(nil), values...)}
+		c.series[key] = s
+	}
+
+	return s
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.writeHeader(w, "counter")
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	keys := make([]string, 0, len(c.series))
+	for key := range c.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := c.series[key]
+		_, _ = fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelPairs(s.values, "", ""),
+			formatFloat(math.Float64frombits(atomic.LoadUint64(&s.bits))))
+	}
+}
+
+type HistogramVec struct {
+	desc
+	buckets []float64
+	mu      sync.RWMutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	values []string
+	mu     sync.Mutex
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	s := h.get(values)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for idx, upper := range h.buckets {
+		if v <= upper {
+			s.counts[idx]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+func (h *HistogramVec) get(values []string) *histogramSeries {
+	key := h.key(values)
+
+	h.mu.RLock()
+	s, ok := h.series[key]
+	h.mu.RUnlock()
+	if ok {
+		return s
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if s, ok = h.series[key]; !ok {
+		s = &histogramSeries{
+			values: append([]string(nil), values...),
+			counts: make([]uint64, len(h.buckets)),
+		}
+		h.series[key] = s
+	}
+
+	return s
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.writeHeader(w, "histogram")
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := h.series[key]
+
+		s.mu.Lock()
+		for idx, upper := range h.buckets {
+			_, _ = fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(s.values, "le", formatFloat(upper)), s.counts[idx])
+		}
+		_, _ = fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(s.values, "le", "+Inf"), s.count)
+		_, _ = fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelPairs(s.values, "", ""), formatFloat(s.sum))
+		_, _ = fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelPairs(s.values, "", ""), s.count)
+		s.mu.Unlock()
+	}
+}
+
+type gaugeFunc struct {
+	desc
+	fn func() float64
+}
+
+func (g *gaugeFunc) write(w *bufio.Writer) {
+	g.writeHeader(w, "gauge")
+	_, _ = fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.fn()))
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func escapeHelp(v string) string {
+	return helpEscaper.Replace(v)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+
+	return n, err
+}
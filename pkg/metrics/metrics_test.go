@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRegistry_WriteTo(t *testing.T) {
+	registry := NewRegistry()
+
+	requests := registry.NewCounterVec("http_requests_total", "Requests served.", "route", "status")
+	requests.Inc("/{id}", "307")
+	requests.Inc("/{id}", "307")
+	requests.Add(3, "/api/shorten", "201")
+	requests.Inc("/\"quoted\"\n", "500")
+
+	latency := registry.NewHistogramVec("latency_seconds", "Latency.", []float64{0.1, 1}, "route")
+	latency.Observe(0.05, "/")
+	latency.Observe(0.5, "/")
+	latency.Observe(5, "/")
+
+	registry.NewGaugeFunc("queue_depth", "Queued items.", func() float64 { return 7 })
+
+	var buff bytes.Buffer
+	_, err := registry.WriteTo(&buff)
+	require.NoError(t, err)
+
+	exp := `# HELP http_requests_total Requests served.
+# TYPE http_requests_total counter
+http_requests_total{route="/\"quoted\"\n",status="500"} 1
+http_requests_total{route="/api/shorten",status="201"} 3
+http_requests_total{route="/{id}",status="307"} 2
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/",le="0.1"} 1
+latency_seconds_bucket{route="/",le="1"} 2
+latency_seconds_bucket{route="/",le="+Inf"} 3
+latency_seconds_sum{route="/"} 5.55
+latency_seconds_count{route="/"} 3
+# HELP queue_depth Queued items.
+# TYPE queue_depth gauge
+queue_depth 7
+`
+	assert.Equal(t, exp, buff.String())
+}
+
+func TestRegistry_Handler(t *testing.T) {
+	registry := NewRegistry()
+	registry.NewCounterVec("links_created_total", "Links created.").Inc()
+
+	w := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	assert.Equal(t, ContentType, w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), "links_created_total 1\n")
+}
+
+func TestRegistry_DuplicateName(t *testing.T) {
+	registry := NewRegistry()
+	registry.NewCounterVec("links_created_total", "Links created.")
+
+	assert.Panics(t, func() {
+		registry.NewCounterVec("links_created_total", "Links created.")
+	})
+}
@@ -0,0 +1,188 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	otlpTracesPath = "/v1/traces"
+	scopeName      = "github.com/ChristinaFomenko/shortener"
+
+	statusCodeError = 2
+)
+
+// OTLP/HTTP json payload, see opentelemetry-proto trace/v1
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		Name              string         `json:"name"`
+		Kind              SpanKind       `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Status            *otlpStatus    `json:"status,omitempty"`
+	}
+
+	otlpKeyValue struct {
+		Key   string       `json:"key"`
+		Value otlpAnyValue `json:"value"`
+	}
+
+	otlpAnyValue struct {
+		StringValue string `json:"stringValue"`
+	}
+
+	otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+)
+
+type otlpExporter struct {
+	url         string
+	serviceName string
+	client      *http.Client
+	headers     map[string]string
+}
+
+// NewOTLPExporter posts spans as OTLP/HTTP json to the collector, endpoint is its base url
+// such as http://localhost:4318
+func NewOTLPExporter(endpoint, serviceName string, headers map[string]string) *otlpExporter {
+	return &otlpExporter{
+		url:         strings.TrimRight(endpoint, "/") + otlpTracesPath,
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: exportTimeout},
+		headers:     headers,
+	}
+}
+
+func (e *otlpExporter) ExportSpans(ctx context.Context, spans []SpanData) error {
+	body, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return fmt.Errorf("marshal spans error: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range e.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("send spans error: %w", err)
+	}
+
+	defer func(body io.ReadCloser) {
+		_, _ = io.Copy(ioutil.Discard, body)
+		_ = body.Close()
+	}(resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("collector responded with %s", resp.Status)
+	}
+
+	return nil
+}
+
+func (e *otlpExporter) Shutdown(_ context.Context) error {
+	e.client.CloseIdleConnections()
+
+	return nil
+}
+
+func (e *otlpExporter) request(spans []SpanData) otlpRequest {
+	converted := make([]otlpSpan, len(spans))
+	for idx := range spans {
+		converted[idx] = toOTLPSpan(spans[idx])
+	}
+
+	return otlpRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{
+				Attributes: []otlpKeyValue{{Key: "service.name", Value: otlpAnyValue{StringValue: e.serviceName}}},
+			},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: scopeName},
+				Spans: converted,
+			}},
+		}},
+	}
+}
+
+func toOTLPSpan(span SpanData) otlpSpan {
+	converted := otlpSpan{
+		TraceID:           span.SpanContext.TraceID.String(),
+		SpanID:            span.SpanContext.SpanID.String(),
+		Name:              span.Name,
+		Kind:              span.Kind,
+		StartTimeUnixNano: unixNano(span.Start),
+		EndTimeUnixNano:   unixNano(span.End),
+	}
+
+	if span.ParentSpanID.IsValid() {
+		converted.ParentSpanID = span.ParentSpanID.String()
+	}
+
+	keys := make([]string, 0, len(span.Attributes))
+	for key := range span.Attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		converted.Attributes = append(converted.Attributes, otlpKeyValue{
+			Key:   key,
+			Value: otlpAnyValue{StringValue: span.Attributes[key]},
+		})
+	}
+
+	if span.Error != "" {
+		converted.Status = &otlpStatus{Code: statusCodeError, Message: span.Error}
+	}
+
+	return converted
+}
+
+// unixNano renders the time as a string, OTLP json encodes 64 bit integers as strings
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
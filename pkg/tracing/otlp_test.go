@@ -0,0 +1,83 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// collector is a local stand-in for an OTLP/HTTP collector
+type collector struct {
+	requests chan otlpRequest
+	headers  chan http.Header
+	status   int
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != otlpTracesPath || r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var req otlpRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	c.requests <- req
+	c.headers <- r.Header
+	w.WriteHeader(c.status)
+}
+
+func TestOTLPExporter_ExportSpans(t *testing.T) {
+	c := &collector{requests: make(chan otlpRequest, 1), headers: make(chan http.Header, 1), status: http.StatusOK}
+	server := httptest.NewServer(c)
+	defer server.Close()
+
+	exporter := NewOTLPExporter(server.URL+"/", "shortener", map[string]string{"Authorization": "Bearer token"})
+	provider := NewProvider(NewBatchProcessor(exporter))
+
+	ctx, parent := provider.Start(context.Background(), "GET /{id}", WithKind(KindServer))
+	_, child := provider.Start(ctx, "repository.Get")
+	child.SetAttribute("backend", "database")
+	child.End()
+	parent.End()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, provider.Shutdown(shutdownCtx))
+
+	req := <-c.requests
+	headers := <-c.headers
+	assert.Equal(t, "application/json", headers.Get("Content-Type"))
+	assert.Equal(t, "Bearer token", headers.Get("Authorization"))
+
+	require.Len(t, req.ResourceSpans, 1)
+	assert.Equal(t, []otlpKeyValue{{Key: "service.name", Value: otlpAnyValue{StringValue: "shortener"}}}, req.ResourceSpans[0].Resource.Attributes)
+
+	spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+	require.Len(t, spans, 2)
+	assert.Equal(t, "repository.Get", spans[0].Name)
+	assert.Equal(t, parent.SpanContext().TraceID.String(), spans[0].TraceID)
+	assert.Equal(t, parent.SpanContext().SpanID.String(), spans[0].ParentSpanID)
+	assert.Equal(t, []otlpKeyValue{{Key: "backend", Value: otlpAnyValue{StringValue: "database"}}}, spans[0].Attributes)
+	assert.Equal(t, KindServer, spans[1].Kind)
+	assert.Empty(t, spans[1].ParentSpanID)
+}
+
+func TestOTLPExporter_CollectorError(t *testing.T) {
+	c := &collector{requests: make(chan otlpRequest, 1), headers: make(chan http.Header, 1), status: http.StatusServiceUnavailable}
+	server := httptest.NewServer(c)
+	defer server.Close()
+
+	exporter := NewOTLPExporter(server.URL, "shortener", nil)
+
+	err := exporter.ExportSpans(context.Background(), []SpanData{{Name: "span", Start: time.Now(), End: time.Now()}})
+	assert.Error(t, err)
+}
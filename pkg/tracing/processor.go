@@ -0,0 +1,133 @@
+package tracing
+
+import (
+	"context"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultQueueSize     = 2048
+	defaultBatchSize     = 256
+	defaultFlushInterval = 5 * time.Second
+	exportTimeout        = 10 * time.Second
+)
+
+// Exporter sends finished spans to a backend, it must not keep the slice after returning
+type Exporter interface {
+	ExportSpans(ctx context.Context, spans []SpanData) error
+	Shutdown(ctx context.Context) error
+}
+
+// BatchProcessor queues finished spans and exports them in batches from a background goroutine,
+// spans are dropped rather than blocking requests when the queue is full
+type BatchProcessor struct {
+	exporter      Exporter
+	queue         chan SpanData
+	batchSize     int
+	flushInterval time.Duration
+	dropped       uint64
+	stop          chan struct{}
+	done          chan struct{}
+	stopOnce      sync.Once
+}
+
+func NewBatchProcessor(exporter Exporter) *BatchProcessor {
+	p := &BatchProcessor{
+		exporter:      exporter,
+		queue:         make(chan SpanData, defaultQueueSize),
+		batchSize:     defaultBatchSize,
+		flushInterval: defaultFlushInterval,
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+
+	go p.run()
+
+	return p
+}
+
+func (p *BatchProcessor) OnEnd(span SpanData) {
+	select {
+	case p.queue <- span:
+	default:
+		atomic.AddUint64(&p.dropped, 1)
+	}
+}
+
+// QueueLen returns the number of spans waiting for export
+func (p *BatchProcessor) QueueLen() int {
+	return len(p.queue)
+}
+
+// QueueCap returns the capacity of the export queue
+func (p *BatchProcessor) QueueCap() int {
+	return cap(p.queue)
+}
+
+// Dropped returns the number of spans lost to a full queue
+func (p *BatchProcessor) Dropped() uint64 {
+	return atomic.LoadUint64(&p.dropped)
+}
+
+// Shutdown exports the queued spans and stops the processor
+func (p *BatchProcessor) Shutdown(ctx context.Context) error {
+	p.stopOnce.Do(func() {
+		close(p.stop)
+	})
+
+	select {
+	case <-p.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	return p.exporter.Shutdown(ctx)
+}
+
+func (p *BatchProcessor) run() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]SpanData, 0, p.batchSize)
+	for {
+		select {
+		case span := <-p.queue:
+			batch = append(batch, span)
+			if len(batch) >= p.batchSize {
+				batch = p.export(batch)
+			}
+		case <-ticker.C:
+			batch = p.export(batch)
+		case <-p.stop:
+			for {
+				select {
+				case span := <-p.queue:
+					batch = append(batch, span)
+				default:
+					p.export(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+func (p *BatchProcessor) export(batch []SpanData) []SpanData {
+	if len(batch) == 0 {
+		return batch
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+
+	if err := p.exporter.ExportSpans(ctx, batch); err != nil {
+		log.WithError(err).WithField("spans", len(batch)).Error("export spans error")
+	}
+
+	return batch[:0]
+}
@@ -0,0 +1,87 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	TraceparentHeader = "traceparent"
+	TracestateHeader  = "tracestate"
+
+	traceparentVersion = "00"
+	flagSampled        = 0x01
+)
+
+// ParseTraceparent reads a W3C traceparent header value
+func ParseTraceparent(value string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 {
+		return SpanContext{}, false
+	}
+
+	version := parts[0]
+	// version ff is forbidden, version 00 has exactly four fields, later versions may append more
+	if len(version) != 2 || version == "ff" || (version == traceparentVersion && len(parts) != 4) {
+		return SpanContext{}, false
+	}
+	if _, err := hex.DecodeString(version); err != nil {
+		return SpanContext{}, false
+	}
+
+	var sc SpanContext
+	if !decodeHex(parts[1], sc.TraceID[:]) || !decodeHex(parts[2], sc.SpanID[:]) {
+		return SpanContext{}, false
+	}
+
+	var flags [1]byte
+	if !decodeHex(parts[3], flags[:]) {
+		return SpanContext{}, false
+	}
+	sc.Sampled = flags[0]&flagSampled != 0
+
+	if !sc.IsValid() {
+		return SpanContext{}, false
+	}
+
+	return sc, true
+}
+
+// FormatTraceparent renders the span context as a W3C traceparent header value
+func FormatTraceparent(sc SpanContext) string {
+	var flags byte
+	if sc.Sampled {
+		flags = flagSampled
+	}
+
+	return fmt.Sprintf("%s-%s-%s-%02x", traceparentVersion, sc.TraceID, sc.SpanID, flags)
+}
+
+// Extract returns the remote span context carried by the headers
+func Extract(header http.Header) (SpanContext, bool) {
+	return ParseTraceparent(header.Get(TraceparentHeader))
+}
+
+// Inject writes the span of ctx into the headers of an outgoing request
+func Inject(ctx context.Context, header http.Header) {
+	sc := SpanFromContext(ctx).SpanContext()
+	if !sc.IsValid() {
+		return
+	}
+
+	header.Set(TraceparentHeader, FormatTraceparent(sc))
+}
+
+// decodeHex accepts lowercase hex of exactly the destination size
+func decodeHex(value string, dst []byte) bool {
+	if len(value) != hex.EncodedLen(len(dst)) || strings.ToLower(value) != value {
+		return false
+	}
+
+	_, err := hex.Decode(dst, []byte(value))
+
+	return err == nil
+}
@@ -0,0 +1,89 @@
+package tracing
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		ok      bool
+		sampled bool
+	}{
+		{
+			name:    "sampled",
+			value:   "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			ok:      true,
+			sampled: true,
+		},
+		{
+			name:  "not sampled",
+			value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+			ok:    true,
+		},
+		{
+			name:    "future version with extra fields",
+			value:   "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+			ok:      true,
+			sampled: true,
+		},
+		{
+			name:  "version 00 with extra fields",
+			value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		},
+		{
+			name:  "forbidden version",
+			value: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		},
+		{
+			name:  "zero trace id",
+			value: "00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		},
+		{
+			name:  "uppercase",
+			value: "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		},
+		{
+			name:  "short span id",
+			value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902-01",
+		},
+		{
+			name: "empty",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, ok := ParseTraceparent(tt.value)
+
+			assert.Equal(t, tt.ok, ok)
+			if ok {
+				assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+				assert.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+				assert.Equal(t, tt.sampled, sc.Sampled)
+			}
+		})
+	}
+}
+
+func TestInjectExtract(t *testing.T) {
+	provider := NewProvider(&recorder{})
+
+	remote, ok := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	assert.True(t, ok)
+
+	ctx, span := provider.Start(context.Background(), "outgoing", WithRemoteParent(remote))
+	defer span.End()
+
+	header := http.Header{}
+	Inject(ctx, header)
+
+	sc, ok := Extract(header)
+	assert.True(t, ok)
+	assert.Equal(t, remote.TraceID, sc.TraceID)
+	assert.Equal(t, span.SpanContext().SpanID, sc.SpanID)
+	assert.True(t, sc.Sampled)
+}
@@ -0,0 +1,64 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+type stdoutSpan struct {
+	TraceID      string            `json:"trace_id"`
+	SpanID       string            `json:"span_id"`
+	ParentSpanID string            `json:"parent_span_id,omitempty"`
+	Name         string            `json:"name"`
+	Kind         SpanKind          `json:"kind"`
+	Start        time.Time         `json:"start"`
+	DurationMS   float64           `json:"duration_ms"`
+	Attributes   map[string]string `json:"attributes,omitempty"`
+	Error        string            `json:"error,omitempty"`
+}
+
+type stdoutExporter struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+// NewStdoutExporter writes every span as a json line, usually to os.Stdout
+func NewStdoutExporter(w io.Writer) *stdoutExporter {
+	return &stdoutExporter{
+		encoder: json.NewEncoder(w),
+	}
+}
+
+func (e *stdoutExporter) ExportSpans(_ context.Context, spans []SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for idx := range spans {
+		span := stdoutSpan{
+			TraceID:    spans[idx].SpanContext.TraceID.String(),
+			SpanID:     spans[idx].SpanContext.SpanID.String(),
+			Name:       spans[idx].Name,
+			Kind:       spans[idx].Kind,
+			Start:      spans[idx].Start,
+			DurationMS: float64(spans[idx].End.Sub(spans[idx].Start).Microseconds()) / 1000,
+			Attributes: spans[idx].Attributes,
+			Error:      spans[idx].Error,
+		}
+		if spans[idx].ParentSpanID.IsValid() {
+			span.ParentSpanID = spans[idx].ParentSpanID.String()
+		}
+
+		if err := e.encoder.Encode(&span); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *stdoutExporter) Shutdown(_ context.Context) error {
+	return nil
+}
@@ -0,0 +1,274 @@
+// Package tracing records OpenTelemetry style spans, propagates them with W3C
+// traceparent headers and hands finished spans to a pluggable exporter.
+package tracing
+
+import (
+	"context"
+	crypto "crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+type SpanKind int
+
+// span kinds use the OTLP numbering
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+)
+
+type (
+	TraceID [16]byte
+	SpanID  [8]byte
+)
+
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+func (t TraceID) IsValid() bool {
+	return t != TraceID{}
+}
+
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+func (s SpanID) IsValid() bool {
+	return s != SpanID{}
+}
+
+// SpanContext identifies a span across process boundaries
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// SpanData is a finished span handed to exporters
+type SpanData struct {
+	Name         string
+	Kind         SpanKind
+	SpanContext  SpanContext
+	ParentSpanID SpanID
+	Start        time.Time
+	End          time.Time
+	Attributes   map[string]string
+	Error        string
+}
+
+type Span struct {
+	mu       sync.Mutex
+	data     SpanData
+	provider *Provider
+	ended    bool
+}
+
+// SpanContext returns the identity of the span, also for a non-recording span
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+
+	return s.data.SpanContext
+}
+
+func (s *Span) SetName(name string) {
+	if !s.recording() {
+		return
+	}
+
+	s.mu.Lock()
+	s.data.Name = name
+	s.mu.Unlock()
+}
+
+func (s *Span) SetAttribute(key, value string) {
+	if !s.recording() {
+		return
+	}
+
+	s.mu.Lock()
+	s.data.Attributes[key] = value
+	s.mu.Unlock()
+}
+
+// RecordError marks the span as failed, nil errors are ignored
+func (s *Span) RecordError(err error) {
+	if err == nil || !s.recording() {
+		return
+	}
+
+	s.mu.Lock()
+	s.data.Error = err.Error()
+	s.mu.Unlock()
+}
+
+// End finishes the span, calling it more than once is a no-op
+func (s *Span) End() {
+	if !s.recording() {
+		return
+	}
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+
+	s.provider.processor.OnEnd(data)
+}
+
+func (s *Span) recording() bool {
+	return s != nil && s.provider != nil
+}
+
+type processor interface {
+	OnEnd(span SpanData)
+	Shutdown(ctx context.Context) error
+}
+
+// Provider creates spans and passes finished ones to the processor
+type Provider struct {
+	processor processor
+}
+
+// NewProvider records spans through the processor, a nil processor disables recording
+func NewProvider(processor processor) *Provider {
+	return &Provider{
+		processor: processor,
+	}
+}
+
+type startOptions struct {
+	kind   SpanKind
+	parent *SpanContext
+}
+
+type StartOption func(o *startOptions)
+
+func WithKind(kind SpanKind) StartOption {
+	return func(o *startOptions) {
+		o.kind = kind
+	}
+}
+
+// WithRemoteParent makes the span a child of a span received from another process
+func WithRemoteParent(parent SpanContext) StartOption {
+	return func(o *startOptions) {
+		o.parent = &parent
+	}
+}
+
+// Start begins a span that is a child of the span in ctx or of the remote parent.
+// The returned span may be nil, all span methods accept a nil receiver.
+func (p *Provider) Start(ctx context.Context, name string, opts ...StartOption) (context.Context, *Span) {
+	o := startOptions{kind: KindInternal}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	parent := SpanFromContext(ctx).SpanContext()
+	if o.parent != nil && o.parent.IsValid() {
+		parent = *o.parent
+	}
+
+	if p == nil || p.processor == nil {
+		// nothing is recorded, only the incoming trace is passed through
+		if !parent.IsValid() {
+			return ctx, nil
+		}
+		span := &Span{data: SpanData{SpanContext: parent}}
+		return ContextWithSpan(ctx, span), span
+	}
+
+	sc := SpanContext{SpanID: newSpanID(), Sampled: true}
+	if parent.IsValid() {
+		sc.TraceID = parent.TraceID
+		sc.Sampled = parent.Sampled
+	} else {
+		sc.TraceID = newTraceID()
+	}
+
+	span := &Span{data: SpanData{SpanContext: sc}}
+	if sc.Sampled {
+		span.provider = p
+		span.data = SpanData{
+			Name:         name,
+			Kind:         o.kind,
+			SpanContext:  sc,
+			ParentSpanID: parent.SpanID,
+			Start:        time.Now(),
+			Attributes:   map[string]string{},
+		}
+	}
+
+	return ContextWithSpan(ctx, span), span
+}
+
+// Shutdown flushes the spans still queued
+func (p *Provider) Shutdown(ctx context.Context) error {
+	if p == nil || p.processor == nil {
+		return nil
+	}
+
+	return p.processor.Shutdown(ctx)
+}
+
+var (
+	globalMu       sync.RWMutex
+	globalProvider = NewProvider(nil)
+)
+
+// SetProvider replaces the provider used by Start
+func SetProvider(p *Provider) {
+	globalMu.Lock()
+	globalProvider = p
+	globalMu.Unlock()
+}
+
+// Start begins a span with the provider set by SetProvider
+func Start(ctx context.Context, name string, opts ...StartOption) (context.Context, *Span) {
+	globalMu.RLock()
+	p := globalProvider
+	globalMu.RUnlock()
+
+	return p.Start(ctx, name, opts...)
+}
+
+type spanKey struct{}
+
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns the current span or nil
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+
+	return span
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	_, _ = crypto.Read(id[:])
+
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	_, _ = crypto.Read(id[:])
+
+	return id
+}
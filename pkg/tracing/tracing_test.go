@@ -0,0 +1,74 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+)
+
+type recorder struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func (r *recorder) OnEnd(span SpanData) {
+	r.mu.Lock()
+	r.spans = append(r.spans, span)
+	r.mu.Unlock()
+}
+
+func (r *recorder) Shutdown(_ context.Context) error {
+	return nil
+}
+
+func TestProvider_Start(t *testing.T) {
+	rec := &recorder{}
+	provider := NewProvider(rec)
+
+	ctx, parent := provider.Start(context.Background(), "parent", WithKind(KindServer))
+	_, child := provider.Start(ctx, "child")
+	child.SetAttribute("backend", "memory")
+	child.RecordError(errors.New("boom"))
+	child.End()
+	child.End()
+	parent.End()
+
+	require.Len(t, rec.spans, 2)
+	assert.Equal(t, "child", rec.spans[0].Name)
+	assert.Equal(t, KindInternal, rec.spans[0].Kind)
+	assert.Equal(t, parent.SpanContext().TraceID, rec.spans[0].SpanContext.TraceID)
+	assert.Equal(t, parent.SpanContext().SpanID, rec.spans[0].ParentSpanID)
+	assert.Equal(t, "memory", rec.spans[0].Attributes["backend"])
+	assert.Equal(t, "boom", rec.spans[0].Error)
+	assert.Equal(t, KindServer, rec.spans[1].Kind)
+	assert.False(t, rec.spans[1].ParentSpanID.IsValid())
+}
+
+func TestProvider_Start_NotRecording(t *testing.T) {
+	provider := NewProvider(nil)
+
+	ctx, span := provider.Start(context.Background(), "span")
+	assert.Nil(t, span)
+	span.SetAttribute("key", "value")
+	span.End()
+
+	remote, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, span = provider.Start(ctx, "span", WithRemoteParent(remote))
+	assert.Equal(t, remote, SpanFromContext(ctx).SpanContext())
+	span.End()
+}
+
+func TestProvider_Start_NotSampled(t *testing.T) {
+	rec := &recorder{}
+	provider := NewProvider(rec)
+
+	remote, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	_, span := provider.Start(context.Background(), "span", WithRemoteParent(remote))
+	span.End()
+
+	assert.Empty(t, rec.spans)
+	assert.Equal(t, remote.TraceID, span.SpanContext().TraceID)
+}